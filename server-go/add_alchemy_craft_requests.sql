@@ -0,0 +1,15 @@
+-- 创建迁移文件：add_alchemy_craft_requests.sql
+-- 这个脚本应该在生产环境中执行以创建炼制请求幂等记录表
+-- 幂等记录与炼制在同一数据库事务内写入，取代原先的 Redis 处理中标记
+
+CREATE TABLE IF NOT EXISTS "alchemy_craft_requests" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(128) NOT NULL,
+    recipe_id VARCHAR(255) NOT NULL,
+    result JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_alchemy_craft_requests_created_at ON "alchemy_craft_requests"(created_at);
@@ -0,0 +1,52 @@
+-- 创建迁移文件：add_alchemy_exp.sql
+-- 这个脚本应该在生产环境中执行以添加炼丹经验字段，并为已有玩家补齐炼丹等级
+-- 炼丹等级门槛上线前已解锁（丹方记录或残页已集齐）的丹方不应被锁定，
+-- 因此将老玩家的炼丹等级提升到其已解锁丹方中最高品阶所需的等级
+
+ALTER TABLE "user_alchemy_data" ADD COLUMN IF NOT EXISTS alchemy_exp INTEGER DEFAULT 0;
+
+-- 丹方 -> (所需炼丹等级, 所需残页数)，与 internal/alchemy/service.go 中的丹方及品阶配置保持一致
+WITH recipe_levels(recipe_id, required_level, fragments_needed) AS (
+    VALUES
+        ('spirit_gathering', 1, 10),
+        ('cultivation_boost', 3, 15),
+        ('spirit_recovery', 3, 15),
+        ('enlightenment_pill', 3, 15),
+        ('fortune_pill', 3, 15),
+        ('purification_pill', 3, 15),
+        ('thunder_power', 6, 20),
+        ('essence_condensation', 6, 20),
+        ('du_jie_pill', 6, 20),
+        ('battle_fury_pill', 6, 20),
+        ('tiger_might_pill', 6, 20),
+        ('mind_clarity', 6, 20),
+        ('immortal_essence', 10, 25),
+        ('fire_essence', 10, 25),
+        ('five_elements_pill', 14, 30),
+        ('celestial_essence_pill', 18, 35),
+        ('sun_moon_pill', 22, 40),
+        ('phoenix_rebirth_pill', 26, 45)
+),
+unlocked_levels AS (
+    SELECT d.user_id, MAX(r.required_level) AS required_level
+    FROM "user_alchemy_data" d
+    JOIN recipe_levels r ON d.recipes_unlocked ? r.recipe_id
+    GROUP BY d.user_id
+    UNION ALL
+    SELECT f.user_id, MAX(r.required_level) AS required_level
+    FROM "pill_fragments" f
+    JOIN recipe_levels r ON r.recipe_id = f.recipe_id AND f.count >= r.fragments_needed
+    GROUP BY f.user_id
+),
+target_levels AS (
+    SELECT user_id, MAX(required_level) AS required_level
+    FROM unlocked_levels
+    GROUP BY user_id
+)
+UPDATE "user_alchemy_data" d
+SET alchemy_level = t.required_level,
+    alchemy_exp = 0,
+    alchemy_rate = 1.0 + 0.03 * (t.required_level - 1)
+FROM target_levels t
+WHERE d.user_id = t.user_id
+  AND COALESCE(d.alchemy_level, 1) < t.required_level;
@@ -0,0 +1,15 @@
+-- 创建迁移文件：add_gems.sql
+-- 这个脚本应该在生产环境中执行以添加宝石镶嵌相关的表和字段
+
+ALTER TABLE "equipment" ADD COLUMN IF NOT EXISTS gems JSONB DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS "gems" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    gem_id VARCHAR(100) NOT NULL,
+    name VARCHAR(255),
+    stat VARCHAR(100),
+    tier INTEGER NOT NULL DEFAULT 1,
+    count INTEGER DEFAULT 0,
+    UNIQUE(user_id, gem_id, tier)
+);
@@ -0,0 +1,5 @@
+-- 创建迁移文件：add_quality_to_pills.sql
+-- 这个脚本应该在生产环境中执行以添加丹药品质字段，需在 stack_inventory.sql 之前执行
+
+ALTER TABLE "pills" ADD COLUMN IF NOT EXISTS quality VARCHAR(50) DEFAULT 'common';
+UPDATE "pills" SET quality = 'common' WHERE quality IS NULL OR quality = '';
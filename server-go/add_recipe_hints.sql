@@ -0,0 +1,5 @@
+-- 创建迁移文件：add_recipe_hints.sql
+-- 这个脚本应该在生产环境中执行以添加丹方试炼提示字段
+
+ALTER TABLE "user_alchemy_data" ADD COLUMN IF NOT EXISTS recipe_hints TEXT DEFAULT '{}';
+UPDATE "user_alchemy_data" SET recipe_hints = '{}' WHERE recipe_hints IS NULL OR recipe_hints = '';
@@ -0,0 +1,4 @@
+-- 创建迁移文件：add_set_id_to_equipment.sql
+-- 这个脚本应该在生产环境中执行以添加装备套装字段
+
+ALTER TABLE "equipment" ADD COLUMN IF NOT EXISTS set_id VARCHAR(100) DEFAULT '';
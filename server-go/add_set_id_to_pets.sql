@@ -0,0 +1,4 @@
+-- 创建迁移文件：add_set_id_to_pets.sql
+-- 这个脚本应该在生产环境中执行以添加灵宠套装字段，出战灵宠计入套装件数
+
+ALTER TABLE "pets" ADD COLUMN IF NOT EXISTS set_id VARCHAR(100) DEFAULT '';
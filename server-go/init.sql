@@ -52,7 +52,8 @@ CREATE TABLE IF NOT EXISTS "herbs" (
     herb_id VARCHAR(255),
     name VARCHAR(255),
     count INTEGER DEFAULT 0,
-    quality VARCHAR(50) DEFAULT 'common'
+    quality VARCHAR(50) DEFAULT 'common',
+    UNIQUE(user_id, herb_id, quality)
 );
 
 -- pills 表
@@ -62,7 +63,10 @@ CREATE TABLE IF NOT EXISTS "pills" (
     pill_id VARCHAR(255),
     name VARCHAR(255),
     description TEXT,
-    effect JSONB
+    effect JSONB,
+    quality VARCHAR(50) DEFAULT 'common',
+    count INTEGER DEFAULT 1,
+    UNIQUE(user_id, pill_id, quality)
 );
 
 -- pill_fragments 表
@@ -70,7 +74,8 @@ CREATE TABLE IF NOT EXISTS "pill_fragments" (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES "users"(id),
     recipe_id VARCHAR(255),
-    count INTEGER DEFAULT 0
+    count INTEGER DEFAULT 0,
+    UNIQUE(user_id, recipe_id)
 );
 
 -- user_alchemy_data 表
@@ -81,7 +86,9 @@ CREATE TABLE IF NOT EXISTS "user_alchemy_data" (
     pills_crafted INTEGER DEFAULT 0,   -- 总炼制次数
     pills_consumed INTEGER DEFAULT 0,  -- 总服用次数
     alchemy_level INTEGER DEFAULT 1,   -- 炼丹等级
-    alchemy_rate DOUBLE PRECISION DEFAULT 1.0  -- 炼丹加成率
+    alchemy_exp INTEGER DEFAULT 0,     -- 当前等级的炼丹经验
+    alchemy_rate DOUBLE PRECISION DEFAULT 1.0,  -- 炼丹加成率
+    recipe_hints TEXT DEFAULT '{}'     -- 试炼获得的丹方提示 {recipeId: [herbId]}
 );
 
 -- pets 表
@@ -101,7 +108,8 @@ CREATE TABLE IF NOT EXISTS "pets" (
     is_active BOOLEAN DEFAULT FALSE,
     attack_bonus DOUBLE PRECISION DEFAULT 0,
     defense_bonus DOUBLE PRECISION DEFAULT 0,
-    health_bonus DOUBLE PRECISION DEFAULT 0
+    health_bonus DOUBLE PRECISION DEFAULT 0,
+    set_id VARCHAR(100) DEFAULT ''
 );
 
 -- equipment 表
@@ -121,7 +129,9 @@ CREATE TABLE IF NOT EXISTS "equipment" (
     equipped BOOLEAN DEFAULT FALSE,
     description TEXT,
     required_realm INTEGER DEFAULT 1,
-    level INTEGER DEFAULT 1
+    level INTEGER DEFAULT 1,
+    set_id VARCHAR(100) DEFAULT '',
+    gems JSONB DEFAULT '[]'
 );
 
 -- dungeon_progress 表 (秘境进度追踪)
@@ -165,6 +175,164 @@ CREATE TABLE IF NOT EXISTS "battle_records" (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- pve_clears 表 (妖兽/除魔卫道通关记录)
+CREATE TABLE IF NOT EXISTS "pve_clears" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    monster_id INTEGER NOT NULL,
+    monster_name VARCHAR(255),
+    difficulty VARCHAR(50),
+    best_stars INTEGER DEFAULT 0,
+    best_rounds INTEGER DEFAULT 0,
+    best_health_percent DOUBLE PRECISION DEFAULT 0,
+    clear_count INTEGER DEFAULT 0,
+    first_cleared_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_cleared_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, monster_id)
+);
+
+-- pve_star_chests 表 (章节星级宝箱领取记录)
+CREATE TABLE IF NOT EXISTS "pve_star_chests" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    chapter_id VARCHAR(100) NOT NULL,
+    stars INTEGER NOT NULL,
+    claimed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, chapter_id, stars)
+);
+
+-- user_techniques 表 (玩家功法)
+CREATE TABLE IF NOT EXISTS "user_techniques" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    technique_id VARCHAR(100) NOT NULL,
+    level INTEGER NOT NULL DEFAULT 1,
+    exp INTEGER NOT NULL DEFAULT 0,
+    slot VARCHAR(20) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, technique_id)
+);
+
+-- user_formations 表 (玩家阵法等级)
+CREATE TABLE IF NOT EXISTS "user_formations" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    formation_id VARCHAR(100) NOT NULL,
+    level INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, formation_id)
+);
+
+-- active_formations 表 (已布下的阵法)
+CREATE TABLE IF NOT EXISTS "active_formations" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    formation_id VARCHAR(100) NOT NULL,
+    effect VARCHAR(50) NOT NULL,
+    level INTEGER NOT NULL DEFAULT 1,
+    value DOUBLE PRECISION NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- dual_cultivation_bonds 表 (双修羁绊)
+CREATE TABLE IF NOT EXISTS "dual_cultivation_bonds" (
+    id SERIAL PRIMARY KEY,
+    user_a_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    user_b_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    sessions INTEGER NOT NULL DEFAULT 0,
+    last_session_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_a_id, user_b_id)
+);
+
+-- active_pill_buffs 表 (丹药限时效果)
+CREATE TABLE IF NOT EXISTS "active_pill_buffs" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    buff_id VARCHAR(100) NOT NULL,
+    effect VARCHAR(50) NOT NULL,
+    value DOUBLE PRECISION NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- garden_plots 表 (药园灵田)
+CREATE TABLE IF NOT EXISTS "garden_plots" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    plot_index INTEGER NOT NULL,
+    level INTEGER NOT NULL DEFAULT 1,
+    herb_id VARCHAR(100) DEFAULT '',
+    fertilizer VARCHAR(50) DEFAULT '',
+    planted_at TIMESTAMP WITH TIME ZONE,
+    ready_at TIMESTAMP WITH TIME ZONE,
+    watered_by INTEGER DEFAULT 0,
+    protected_by INTEGER DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, plot_index)
+);
+
+-- alchemy_experiments 表 (丹方试炼记录)
+CREATE TABLE IF NOT EXISTS "alchemy_experiments" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    herbs JSONB NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    recipe_id VARCHAR(255) DEFAULT '',
+    hint_herb_id VARCHAR(255) DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- recipe_discoveries 表 (全服首次悟出丹方)
+CREATE TABLE IF NOT EXISTS "recipe_discoveries" (
+    recipe_id VARCHAR(255) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    player_name VARCHAR(255),
+    discovered_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- gems 表 (宝石，按种类与阶数堆叠)
+CREATE TABLE IF NOT EXISTS "gems" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    gem_id VARCHAR(100) NOT NULL,
+    name VARCHAR(255),
+    stat VARCHAR(100),
+    tier INTEGER NOT NULL DEFAULT 1,
+    count INTEGER DEFAULT 0,
+    UNIQUE(user_id, gem_id, tier)
+);
+
+-- world_boss_rewards 表 (世界首领活动奖励发放记录)
+CREATE TABLE IF NOT EXISTS "world_boss_rewards" (
+    id SERIAL PRIMARY KEY,
+    event_id VARCHAR(100) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    rank INTEGER NOT NULL,
+    damage BIGINT DEFAULT 0,
+    last_hit BOOLEAN DEFAULT FALSE,
+    spirit_stones INTEGER DEFAULT 0,
+    cultivation INTEGER DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(event_id, user_id)
+);
+
+-- alchemy_craft_requests 表 (炼制请求幂等记录)
+CREATE TABLE IF NOT EXISTS "alchemy_craft_requests" (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES "users"(id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(128) NOT NULL,
+    recipe_id VARCHAR(255) NOT NULL,
+    result JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, idempotency_key)
+);
+
 -- 创建索引以提高查询性能
 CREATE INDEX IF NOT EXISTS idx_users_username ON "users"(username);
 CREATE INDEX IF NOT EXISTS idx_users_last_spirit_gain_time ON "users"(last_spirit_gain_time);
@@ -182,3 +350,13 @@ CREATE INDEX IF NOT EXISTS idx_dungeon_buffs_run_id ON "dungeon_buffs"(dungeon_r
 CREATE INDEX IF NOT EXISTS idx_battle_records_player_id ON "battle_records"(player_id);
 CREATE INDEX IF NOT EXISTS idx_battle_records_opponent_id ON "battle_records"(opponent_id);
 CREATE INDEX IF NOT EXISTS idx_battle_records_created_at ON "battle_records"(created_at);
+CREATE INDEX IF NOT EXISTS idx_pve_clears_user_id ON "pve_clears"(user_id);
+CREATE INDEX IF NOT EXISTS idx_pve_star_chests_user_id ON "pve_star_chests"(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_techniques_user_id ON "user_techniques"(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_formations_user_id ON "user_formations"(user_id);
+CREATE INDEX IF NOT EXISTS idx_active_formations_user_id_expires_at ON "active_formations"(user_id, expires_at);
+CREATE INDEX IF NOT EXISTS idx_dual_cultivation_bonds_user_b_id ON "dual_cultivation_bonds"(user_b_id);
+CREATE INDEX IF NOT EXISTS idx_active_pill_buffs_user_id_expires_at ON "active_pill_buffs"(user_id, expires_at);
+CREATE INDEX IF NOT EXISTS idx_alchemy_experiments_user_id_created_at ON "alchemy_experiments"(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_world_boss_rewards_user_id ON "world_boss_rewards"(user_id);
+CREATE INDEX IF NOT EXISTS idx_alchemy_craft_requests_created_at ON "alchemy_craft_requests"(created_at);
@@ -0,0 +1,400 @@
+package alchemy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/announcement"
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/inventory"
+	"xiuxian/server-go/internal/models"
+)
+
+const (
+	MaxExperimentHerbKinds = 5   // 单次试炼最多投入的灵草种数
+	MaxExperimentHerbCount = 10  // 单种灵草最多投入的数量
+	experimentHintChance   = 0.2 // 配伍失败时获得丹方提示的基础概率（受幸运加成）
+	experimentDiscoverRate = 0.3 // 配伍与丹方一致时悟出丹方的基础概率（受幸运加成），未悟出则获得提示
+	experimentLogLimit     = 20  // 总览中返回的试炼记录条数
+
+	ExperimentDiscovered = "discovered"
+	ExperimentHint       = "hint"
+	ExperimentWaste      = "waste"
+
+	// WastePillID 配伍失败产出的废丹，无任何药效，不可服用
+	WastePillID = "waste_pill"
+)
+
+// normalizeExperimentHerbs 合并重复灵草并校验配伍
+func normalizeExperimentHerbs(herbs []MaterialRequire) ([]MaterialRequire, error) {
+	counts := make(map[string]int)
+	var order []string
+	for _, h := range herbs {
+		if _, ok := herbConfigs[h.HerbID]; !ok {
+			return nil, fmt.Errorf("灵草不存在: %s", h.HerbID)
+		}
+		if h.Count <= 0 {
+			return nil, fmt.Errorf("灵草数量必须大于0")
+		}
+		if _, ok := counts[h.HerbID]; !ok {
+			order = append(order, h.HerbID)
+		}
+		counts[h.HerbID] += h.Count
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("请至少投入一种灵草")
+	}
+	if len(order) > MaxExperimentHerbKinds {
+		return nil, fmt.Errorf("单次试炼最多投入 %d 种灵草", MaxExperimentHerbKinds)
+	}
+	merged := make([]MaterialRequire, 0, len(order))
+	for _, herbID := range order {
+		if counts[herbID] > MaxExperimentHerbCount {
+			return nil, fmt.Errorf("单种灵草最多投入 %d 株", MaxExperimentHerbCount)
+		}
+		merged = append(merged, MaterialRequire{HerbID: herbID, Count: counts[herbID]})
+	}
+	return merged, nil
+}
+
+// matchRecipe 查找材料种类与数量完全一致的丹方
+func matchRecipe(herbs []MaterialRequire) *RecipeConfig {
+	counts := make(map[string]int, len(herbs))
+	for _, h := range herbs {
+		counts[h.HerbID] = h.Count
+	}
+	for i := range recipes {
+		if len(recipes[i].Materials) != len(counts) {
+			continue
+		}
+		matched := true
+		for _, m := range recipes[i].Materials {
+			if counts[m.HerbID] != m.Count {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &recipes[i]
+		}
+	}
+	return nil
+}
+
+// loadRecipeHints 解析已获得的丹方提示
+func loadRecipeHints(data *models.UserAlchemyDataDB) map[string][]string {
+	hints := make(map[string][]string)
+	if data.RecipeHints != "" {
+		if err := json.Unmarshal([]byte(data.RecipeHints), &hints); err != nil || hints == nil {
+			hints = make(map[string][]string)
+		}
+	}
+	return hints
+}
+
+// pickHintRecipe 选出与本次配伍最接近且仍有未揭示材料的未掌握丹方，返回丹方与要揭示的灵草
+func pickHintRecipe(herbs []MaterialRequire, unlocked map[string]bool, hints map[string][]string) (*RecipeConfig, string) {
+	used := make(map[string]bool, len(herbs))
+	for _, h := range herbs {
+		used[h.HerbID] = true
+	}
+
+	var best *RecipeConfig
+	var bestHerb string
+	bestOverlap := -1
+	for i := range recipes {
+		recipe := &recipes[i]
+		if unlocked[recipe.ID] {
+			continue
+		}
+		revealed := make(map[string]bool)
+		for _, herbID := range hints[recipe.ID] {
+			revealed[herbID] = true
+		}
+		overlap := 0
+		candidate := ""
+		for _, m := range recipe.Materials {
+			if used[m.HerbID] {
+				overlap++
+			}
+			// 优先揭示本次配伍中用到的材料，让玩家知道方向对了
+			if !revealed[m.HerbID] && (candidate == "" || used[m.HerbID] && !used[candidate]) {
+				candidate = m.HerbID
+			}
+		}
+		if candidate != "" && overlap > bestOverlap {
+			best, bestHerb, bestOverlap = recipe, candidate, overlap
+		}
+	}
+	return best, bestHerb
+}
+
+// visibleMaterials 已掌握或残页已集齐的丹方显示全部材料，尚未悟出的丹方只显示试炼揭示过的材料
+func visibleMaterials(recipe *RecipeConfig, known bool, revealedHerbs []string) []MaterialRequire {
+	if known {
+		return recipe.Materials
+	}
+	revealed := make(map[string]bool, len(revealedHerbs))
+	for _, herbID := range revealedHerbs {
+		revealed[herbID] = true
+	}
+	materials := []MaterialRequire{}
+	for _, m := range recipe.Materials {
+		if revealed[m.HerbID] {
+			materials = append(materials, m)
+		}
+	}
+	return materials
+}
+
+// GetRecipeConfigs 获取对玩家可见的丹方配置，未悟出丹方的材料按提示隐藏
+func (s *AlchemyService) GetRecipeConfigs() ([]RecipeConfig, error) {
+	data, err := s.loadAlchemyData()
+	if err != nil {
+		return nil, err
+	}
+	unlocked, err := loadUnlockedRecipes(db.DB, s.userID, data)
+	if err != nil {
+		return nil, err
+	}
+	hints := loadRecipeHints(data)
+
+	configs := make([]RecipeConfig, len(recipes))
+	for i := range recipes {
+		configs[i] = recipes[i]
+		configs[i].Materials = visibleMaterials(&recipes[i], unlocked[recipes[i].ID], hints[recipes[i].ID])
+	}
+	return configs, nil
+}
+
+// buildRecipeHint 构建丹方提示视图
+func buildRecipeHint(recipe *RecipeConfig, revealedHerbs []string) RecipeHint {
+	revealed := make(map[string]bool, len(revealedHerbs))
+	for _, herbID := range revealedHerbs {
+		revealed[herbID] = true
+	}
+	hint := RecipeHint{
+		RecipeID:       recipe.ID,
+		RecipeName:     recipe.Name,
+		GradeName:      GetPillGradeName(recipe.Grade),
+		Revealed:       []MaterialInfo{},
+		TotalMaterials: len(recipe.Materials),
+	}
+	for _, m := range recipe.Materials {
+		if revealed[m.HerbID] {
+			hint.Revealed = append(hint.Revealed, MaterialInfo{
+				HerbID:   m.HerbID,
+				HerbName: GetHerbNameByID(m.HerbID),
+				Count:    m.Count,
+			})
+		}
+	}
+	return hint
+}
+
+// nextHintHerb 返回丹方中下一味尚未揭示的材料，全部揭示时返回空
+func nextHintHerb(recipe *RecipeConfig, revealedHerbs []string) string {
+	revealed := make(map[string]bool, len(revealedHerbs))
+	for _, herbID := range revealedHerbs {
+		revealed[herbID] = true
+	}
+	for _, m := range recipe.Materials {
+		if !revealed[m.HerbID] {
+			return m.HerbID
+		}
+	}
+	return ""
+}
+
+// Experiment 丹方试炼：消耗投入的灵草，配伍与未掌握丹方完全一致时按概率悟出该丹方，未悟出则获得该丹方的提示；
+// 配伍不符则产出一枚废丹，并有小概率获得最接近丹方的一味材料提示
+func (s *AlchemyService) Experiment(herbs []MaterialRequire) (*ExperimentResult, error) {
+	herbs, err := normalizeExperimentHerbs(herbs)
+	if err != nil {
+		return nil, err
+	}
+	matched := matchRecipe(herbs)
+
+	// 确保炼丹数据存在，避免在事务内处理首次创建
+	if _, err := s.loadAlchemyData(); err != nil {
+		return nil, err
+	}
+
+	var result *ExperimentResult
+	var user models.User
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, s.userID).Error; err != nil {
+			return fmt.Errorf("用户不存在: %w", err)
+		}
+		var data models.UserAlchemyDataDB
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", s.userID).First(&data).Error; err != nil {
+			return fmt.Errorf("获取用户炼丹数据失败: %w", err)
+		}
+		unlocked, err := loadUnlockedRecipes(tx, s.userID, &data)
+		if err != nil {
+			return err
+		}
+		if matched != nil && unlocked[matched.ID] {
+			return fmt.Errorf("该配伍即已掌握的丹方「%s」，请直接炼制", matched.Name)
+		}
+
+		// 试炼无论结果如何都会消耗投入的灵草，优先消耗低品质
+		stacks, err := lockRecipeHerbs(tx, s.userID, &RecipeConfig{Materials: herbs}, HerbSelection{})
+		if err != nil {
+			return err
+		}
+		for _, h := range herbs {
+			if _, err := consumeHerbs(tx, stacks[h.HerbID], h.HerbID, h.Count); err != nil {
+				return err
+			}
+		}
+
+		herbsJSON, _ := json.Marshal(herbs)
+		record := models.AlchemyExperiment{UserID: s.userID, Herbs: herbsJSON}
+		hints := loadRecipeHints(&data)
+
+		discoverRate := math.Min(1, experimentDiscoverRate*userLuck(&user))
+		if matched != nil && rand.Float64() < discoverRate {
+			// 悟出丹方：写入已掌握列表（保留按残页解锁的丹方），清除其提示
+			unlocked[matched.ID] = true
+			recipesJSON, _ := json.Marshal(unlocked)
+			data.RecipesUnlocked = string(recipesJSON)
+			delete(hints, matched.ID)
+
+			discovery := models.RecipeDiscovery{
+				RecipeID:     matched.ID,
+				UserID:       s.userID,
+				PlayerName:   user.PlayerName,
+				DiscoveredAt: time.Now(),
+			}
+			created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&discovery)
+			if created.Error != nil {
+				return fmt.Errorf("记录丹方发现失败: %w", created.Error)
+			}
+
+			record.Outcome = ExperimentDiscovered
+			record.RecipeID = matched.ID
+			result = &ExperimentResult{
+				Outcome:        ExperimentDiscovered,
+				Message:        fmt.Sprintf("灵光一现，悟出丹方「%s」", matched.Name),
+				RecipeID:       matched.ID,
+				RecipeName:     matched.Name,
+				FirstDiscovery: created.RowsAffected == 1,
+			}
+		} else if matched != nil {
+			// 配伍正确但火候未到：不产出废丹，揭示该丹方的一味材料
+			if herbID := nextHintHerb(matched, hints[matched.ID]); herbID != "" {
+				hints[matched.ID] = append(hints[matched.ID], herbID)
+				record.HintHerbID = herbID
+			}
+			hint := buildRecipeHint(matched, hints[matched.ID])
+			record.Outcome = ExperimentHint
+			record.RecipeID = matched.ID
+			result = &ExperimentResult{
+				Outcome: ExperimentHint,
+				Message: fmt.Sprintf("药性隐隐相合，似与「%s」有关，却未能参透，不妨再试", matched.Name),
+				Hint:    &hint,
+			}
+		} else {
+			effectJSON, _ := json.Marshal(PillEffect{Type: "waste"})
+			wastePill := models.Pill{
+				UserID:      s.userID,
+				PillID:      WastePillID,
+				Name:        "废丹",
+				Description: "配伍失当炼出的废丹，毫无药效",
+				Effect:      effectJSON,
+				Count:       1,
+			}
+			if err := inventory.AddPills(tx, &wastePill); err != nil {
+				return fmt.Errorf("创建废丹失败: %w", err)
+			}
+
+			record.Outcome = ExperimentWaste
+			result = &ExperimentResult{
+				Outcome:     ExperimentWaste,
+				Message:     "配伍失当，炼出一枚废丹",
+				WastePillID: fmt.Sprintf("%d", wastePill.ID),
+			}
+			if rand.Float64() < experimentHintChance*userLuck(&user) {
+				if recipe, herbID := pickHintRecipe(herbs, unlocked, hints); recipe != nil {
+					hints[recipe.ID] = append(hints[recipe.ID], herbID)
+					hint := buildRecipeHint(recipe, hints[recipe.ID])
+					record.Outcome = ExperimentHint
+					record.RecipeID = recipe.ID
+					record.HintHerbID = herbID
+					result.Outcome = ExperimentHint
+					result.Message = fmt.Sprintf("炼出一枚废丹，但隐约领悟到「%s」需要%s", recipe.Name, GetHerbNameByID(herbID))
+					result.Hint = &hint
+				}
+			}
+		}
+
+		hintsJSON, _ := json.Marshal(hints)
+		data.RecipeHints = string(hintsJSON)
+		if err := tx.Save(&data).Error; err != nil {
+			return fmt.Errorf("更新用户炼丹数据失败: %w", err)
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.FirstDiscovery {
+		if err := announcement.Publish(announcement.Announcement{
+			Type:       announcement.TypeRecipeDiscovery,
+			Message:    fmt.Sprintf("%s 首位悟出丹方「%s」", user.PlayerName, result.RecipeName),
+			UserID:     s.userID,
+			PlayerName: user.PlayerName,
+		}); err != nil {
+			log.Printf("[Alchemy] 发布丹方发现公告失败: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// GetDiscoveryOverview 获取玩家的丹方提示、最近试炼记录与全服首次悟出公告
+func (s *AlchemyService) GetDiscoveryOverview() (*DiscoveryOverview, error) {
+	data, err := s.loadAlchemyData()
+	if err != nil {
+		return nil, err
+	}
+	unlocked, err := loadUnlockedRecipes(db.DB, s.userID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	overview := &DiscoveryOverview{Hints: []RecipeHint{}}
+	hints := loadRecipeHints(data)
+	for i := range recipes {
+		if revealed := hints[recipes[i].ID]; len(revealed) > 0 && !unlocked[recipes[i].ID] {
+			overview.Hints = append(overview.Hints, buildRecipeHint(&recipes[i], revealed))
+		}
+	}
+
+	if err := db.DB.Where("user_id = ?", s.userID).Order("created_at DESC").
+		Limit(experimentLogLimit).Find(&overview.Experiments).Error; err != nil {
+		return nil, fmt.Errorf("获取试炼记录失败: %w", err)
+	}
+	if overview.FirstDiscoveries, err = GetRecentDiscoveries(experimentLogLimit); err != nil {
+		return nil, err
+	}
+	return overview, nil
+}
+
+// GetRecentDiscoveries 获取全服最近的首次悟出丹方公告
+func GetRecentDiscoveries(limit int) ([]models.RecipeDiscovery, error) {
+	var discoveries []models.RecipeDiscovery
+	if err := db.DB.Order("discovered_at DESC").Limit(limit).Find(&discoveries).Error; err != nil {
+		return nil, fmt.Errorf("获取丹方发现公告失败: %w", err)
+	}
+	return discoveries, nil
+}
@@ -0,0 +1,60 @@
+package alchemy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/models"
+)
+
+// MaxIdempotencyKeyLength 幂等键最大长度，与 alchemy_craft_requests.idempotency_key 一致
+const MaxIdempotencyKeyLength = 128
+
+// ErrIdempotencyKeyReused 幂等键已用于炼制其他丹方
+var ErrIdempotencyKeyReused = errors.New("幂等键已用于炼制其他丹方")
+
+// findCraftRequest 查询已完成的炼制结果，调用方需已锁定用户行以串行化同一用户的请求
+func findCraftRequest(tx *gorm.DB, userID uint, idempotencyKey, recipeID string) (*CraftResult, error) {
+	var record models.AlchemyCraftRequest
+	err := tx.Where("user_id = ? AND idempotency_key = ?", userID, idempotencyKey).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("检查炼制请求失败: %w", err)
+	}
+	if record.RecipeID != recipeID {
+		return nil, ErrIdempotencyKeyReused
+	}
+
+	var result CraftResult
+	if err := json.Unmarshal(record.Result, &result); err != nil {
+		return nil, fmt.Errorf("解析炼制结果失败: %w", err)
+	}
+	return &result, nil
+}
+
+// saveCraftRequest 在炼制事务内记录结果，事务回滚时记录一并撤销
+func saveCraftRequest(tx *gorm.DB, userID uint, idempotencyKey, recipeID string, result *CraftResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("序列化炼制结果失败: %w", err)
+	}
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.AlchemyCraftRequest{
+		UserID:         userID,
+		IdempotencyKey: idempotencyKey,
+		RecipeID:       recipeID,
+		Result:         data,
+	})
+	if res.Error != nil {
+		return fmt.Errorf("保存炼制请求失败: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("炼制请求已处理，请勿重复提交")
+	}
+	return nil
+}
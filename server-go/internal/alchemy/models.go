@@ -1,6 +1,10 @@
 package alchemy
 
-import "gorm.io/datatypes"
+import (
+	"gorm.io/datatypes"
+
+	"xiuxian/server-go/internal/models"
+)
 
 // 丹方请求
 type AlchemyRequest struct {
@@ -26,6 +30,7 @@ type PillGrade struct {
 	Difficulty      float64 `json:"difficulty"`      // 难度系数
 	SuccessRate     float64 `json:"successRate"`     // 基础成功率
 	FragmentsNeeded int     `json:"fragmentsNeeded"` // 所需残页数
+	RequiredLevel   int     `json:"requiredLevel"`   // 炼制所需炼丹等级
 }
 
 // 丹药类型倍数
@@ -37,9 +42,24 @@ type PillType struct {
 
 // 灵草品质等级
 type HerbQuality struct {
-	ID       string  `json:"id"`       // common, fine, rare, epic, immortal
-	Name     string  `json:"name"`     // 普通、精品、珍品、仙品
+	ID       string  `json:"id"`       // common, uncommon, rare, epic, legendary
+	Name     string  `json:"name"`     // 普通、优质、稀有、极品、仙品
 	Modifier float64 `json:"modifier"` // 品质倍数 (1.0, 1.5, 2.0, 3.0, 5.0)
+	Rank     int     `json:"rank"`     // 品质排序，越大越好
+}
+
+// 丹药品质等级
+type PillQuality struct {
+	ID               string  `json:"id"`               // common, uncommon, rare, epic, legendary
+	Name             string  `json:"name"`             // 凡品、良品、上品、极品、仙品
+	MinModifier      float64 `json:"minModifier"`      // 所需灵草平均品质倍数
+	EffectMultiplier float64 `json:"effectMultiplier"` // 丹药效果倍数
+}
+
+// 炼制时的灵草选择
+type HerbSelection struct {
+	Strategy  string            `json:"strategy"`  // cheapest（默认）或 best
+	Qualities map[string]string `json:"qualities"` // 指定灵草品质 {herbId: quality}，优先于策略
 }
 
 // 灵草配置
@@ -106,24 +126,37 @@ type PillEffectResult struct {
 }
 
 // 炼制请求
+// 玩家等级、丹方、灵草、幸运与炼丹加成均由服务端读取，旧字段保留仅为兼容客户端
 type CraftRequest struct {
 	RecipeID        string         `json:"recipeId"`
-	PlayerLevel     int            `json:"playerLevel"`
-	UnlockedRecipes []string       `json:"unlockedRecipes"` // 已掌握的丹方ID列表
-	InventoryHerbs  map[string]int `json:"inventoryHerbs"`  // 灵草库存 {herbId: count}
-	Luck            float64        `json:"luck"`            // 幸运值
-	AlchemyRate     float64        `json:"alchemyRate"`     // 炼丹加成率
+	Quantity        int            `json:"quantity"`        // 炼制次数，默认 1
+	Max             bool           `json:"max"`             // 按现有灵草炼制最大次数
+	HerbSelection   HerbSelection  `json:"herbSelection"`   // 灵草品质选择
+	IdempotencyKey  string         `json:"idempotencyKey"`  // 幂等键，重试时携带相同值
+	PlayerLevel     int            `json:"playerLevel"`     // 已弃用
+	UnlockedRecipes []string       `json:"unlockedRecipes"` // 已弃用
+	InventoryHerbs  map[string]int `json:"inventoryHerbs"`  // 已弃用
+	Luck            float64        `json:"luck"`            // 已弃用
+	AlchemyRate     float64        `json:"alchemyRate"`     // 已弃用
 }
 
 // 炼制响应
 type CraftResult struct {
-	Success       bool              `json:"success"`
-	Message       string            `json:"message"`
-	PillID        string            `json:"pillId,omitempty"`        // 新创建的丹药ID
-	PillName      string            `json:"pillName,omitempty"`      // 丹药名称
-	SuccessRate   float64           `json:"successRate,omitempty"`   // 成功率
-	ConsumedHerbs map[string]int    `json:"consumedHerbs,omitempty"` // 消耗的灵草
-	PillEffect    *PillEffectResult `json:"pillEffect,omitempty"`    // 丹药效果
+	Success       bool                      `json:"success"`
+	Message       string                    `json:"message"`
+	PillID        string                    `json:"pillId,omitempty"`        // 成丹所在的丹药堆叠ID
+	PillName      string                    `json:"pillName,omitempty"`      // 丹药名称
+	SuccessRate   float64                   `json:"successRate,omitempty"`   // 成功率
+	ConsumedHerbs map[string]int            `json:"consumedHerbs,omitempty"` // 消耗的灵草
+	PillEffect    *PillEffectResult         `json:"pillEffect,omitempty"`    // 丹药效果
+	Proficiency   *ProficiencyGain          `json:"proficiency,omitempty"`   // 炼丹熟练度变化
+	Quantity      int                       `json:"quantity"`                // 炼制次数
+	SuccessCount  int                       `json:"successCount"`            // 成功次数
+	FailCount     int                       `json:"failCount"`               // 失败次数
+	PillIDs       []string                  `json:"pillIds,omitempty"`       // 已弃用：丹药按品质堆叠，与 PillID 相同
+	PillQuality   string                    `json:"pillQuality,omitempty"`   // 丹药品质
+	QualityName   string                    `json:"qualityName,omitempty"`   // 丹药品质名称
+	HerbQualities map[string]map[string]int `json:"herbQualities,omitempty"` // 各灵草按品质的消耗 {herbId: {quality: count}}
 }
 
 // 购买残页请求
@@ -157,6 +190,8 @@ type UserAlchemyData struct {
 	PillsCrafted    int             `json:"pillsCrafted"`    // 总炼制次数
 	PillsConsumed   int             `json:"pillsConsumed"`   // 总服用次数
 	AlchemyLevel    int             `json:"alchemyLevel"`    // 炼丹等级
+	AlchemyExp      int             `json:"alchemyExp"`      // 当前等级经验
+	AlchemyNextExp  int             `json:"alchemyNextExp"`  // 升级所需经验，满级为 0
 	AlchemyRate     float64         `json:"alchemyRate"`     // 炼丹加成率
 }
 
@@ -180,6 +215,7 @@ type RecipeDetailResponse struct {
 	Type             string           `json:"type"`
 	TypeName         string           `json:"typeName"`
 	SuccessRate      float64          `json:"successRate"`
+	RequiredLevel    int              `json:"requiredLevel"` // 炼制所需炼丹等级
 	Materials        []MaterialInfo   `json:"materials"`
 	FragmentsNeeded  int              `json:"fragmentsNeeded"`
 	CurrentFragments int              `json:"currentFragments"` // 用户当前拥有的残页
@@ -195,3 +231,45 @@ type AllRecipesResponse struct {
 	Recipes     []RecipeDetailResponse `json:"recipes"`     // 丹方列表
 	PlayerStats UserAlchemyData        `json:"playerStats"` // 玩家统计
 }
+
+// ProficiencyGain 单次炼制获得的炼丹熟练度
+type ProficiencyGain struct {
+	ExpGained    int     `json:"expGained"`    // 获得经验
+	AlchemyLevel int     `json:"alchemyLevel"` // 炼制后炼丹等级
+	AlchemyExp   int     `json:"alchemyExp"`   // 炼制后当前等级经验
+	NextExp      int     `json:"nextExp"`      // 升级所需经验，满级为 0
+	AlchemyRate  float64 `json:"alchemyRate"`  // 炼制后炼丹加成率
+	LevelUp      bool    `json:"levelUp"`      // 是否升级
+}
+
+// 丹方试炼请求：自由配伍灵草，配伍与未掌握的丹方一致即可悟出该丹方
+type ExperimentRequest struct {
+	Herbs []MaterialRequire `json:"herbs"`
+}
+
+// 丹方试炼结果
+type ExperimentResult struct {
+	Outcome        string      `json:"outcome"` // discovered, hint, waste
+	Message        string      `json:"message"`
+	RecipeID       string      `json:"recipeId,omitempty"`       // 悟出的丹方
+	RecipeName     string      `json:"recipeName,omitempty"`     // 悟出的丹方名称
+	FirstDiscovery bool        `json:"firstDiscovery,omitempty"` // 是否为全服首位悟出
+	WastePillID    string      `json:"wastePillId,omitempty"`    // 配伍失败产出的废丹ID
+	Hint           *RecipeHint `json:"hint,omitempty"`           // 本次获得的提示
+}
+
+// 丹方提示：已揭示的部分材料
+type RecipeHint struct {
+	RecipeID       string         `json:"recipeId"`
+	RecipeName     string         `json:"recipeName"`
+	GradeName      string         `json:"gradeName"`
+	Revealed       []MaterialInfo `json:"revealed"`       // 已揭示的材料
+	TotalMaterials int            `json:"totalMaterials"` // 丹方所需材料种数
+}
+
+// 丹方试炼总览
+type DiscoveryOverview struct {
+	Hints            []RecipeHint               `json:"hints"`            // 尚未悟出的丹方提示
+	Experiments      []models.AlchemyExperiment `json:"experiments"`      // 最近的试炼记录
+	FirstDiscoveries []models.RecipeDiscovery   `json:"firstDiscoveries"` // 全服最近的首次悟出
+}
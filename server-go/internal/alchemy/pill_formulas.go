@@ -122,6 +122,41 @@ var pillFormulas = map[string]PillFormulaConfig{
 			return 880 * math.Pow(1.2, float64(level-1))
 		},
 	},
+	// 悟道丹：限时效果，固定比例加成
+	"enlightenment_pill": {
+		PillID:    "enlightenment_pill",
+		Name:      "悟道丹",
+		EffectKey: "cultivationRate",
+		Calculate: fixedPillValue,
+	},
+	// 寻宝丹：限时效果，固定比例加成
+	"fortune_pill": {
+		PillID:    "fortune_pill",
+		Name:      "寻宝丹",
+		EffectKey: "explorationLuck",
+		Calculate: fixedPillValue,
+	},
+	// 破军丹：限时效果，固定比例加成
+	"battle_fury_pill": {
+		PillID:    "battle_fury_pill",
+		Name:      "破军丹",
+		EffectKey: "critRate",
+		Calculate: fixedPillValue,
+	},
+	// 虎威丹：限时效果，固定比例加成
+	"tiger_might_pill": {
+		PillID:    "tiger_might_pill",
+		Name:      "虎威丹",
+		EffectKey: "attackBoost",
+		Calculate: fixedPillValue,
+	},
+	// 清毒丹：化解固定数值的丹毒
+	"purification_pill": {
+		PillID:    "purification_pill",
+		Name:      "清毒丹",
+		EffectKey: "detox",
+		Calculate: fixedPillValue,
+	},
 	// 渡劫丹：效果类型duJieRate，服用后增加渡劫成功率
 	"du_jie_pill": {
 		PillID:    "du_jie_pill",
@@ -134,6 +169,11 @@ var pillFormulas = map[string]PillFormulaConfig{
 	},
 }
 
+// fixedPillValue 效果值不随境界成长，直接使用丹药基础值
+func fixedPillValue(baseValue float64, level int) float64 {
+	return baseValue
+}
+
 // GetPillFormula 根据丹药ID获取对应的计算公式
 // 如果丹药不在配置中，返回默认的统一公式
 func GetPillFormula(pillID string) *PillFormulaConfig {
@@ -0,0 +1,70 @@
+package alchemy
+
+import (
+	"math"
+
+	"xiuxian/server-go/internal/models"
+)
+
+// 炼丹熟练度参数
+const (
+	MaxAlchemyLevel      = 30   // 炼丹等级上限
+	alchemyExpPerCraft   = 10   // 每次炼制基础经验（乘以品阶难度）
+	alchemyFailExpFactor = 0.3  // 炼制失败时获得的经验比例
+	alchemyExpPerLevel   = 50   // 每级所需经验 = 50 × 当前等级
+	alchemyRatePerLevel  = 0.03 // 每级提升的炼丹加成率
+)
+
+// AlchemyExpToNext 当前等级升级所需经验，满级返回 0
+func AlchemyExpToNext(level int) int {
+	if level >= MaxAlchemyLevel {
+		return 0
+	}
+	return alchemyExpPerLevel * level
+}
+
+// AlchemyRateForLevel 指定炼丹等级对应的炼丹加成率
+func AlchemyRateForLevel(level int) float64 {
+	if level < 1 {
+		level = 1
+	}
+	return 1.0 + alchemyRatePerLevel*float64(level-1)
+}
+
+// craftExp 计算单次炼制获得的经验
+func craftExp(grade PillGrade, success bool) int {
+	exp := float64(alchemyExpPerCraft) * grade.Difficulty
+	if !success {
+		exp *= alchemyFailExpFactor
+	}
+	return int(math.Max(1, math.Round(exp)))
+}
+
+// addAlchemyExp 为炼丹数据增加经验并处理升级，同步刷新炼丹加成率
+func addAlchemyExp(data *models.UserAlchemyDataDB, exp int) *ProficiencyGain {
+	if data.AlchemyLevel < 1 {
+		data.AlchemyLevel = 1
+	}
+	startLevel := data.AlchemyLevel
+
+	if data.AlchemyLevel < MaxAlchemyLevel {
+		data.AlchemyExp += exp
+		for data.AlchemyLevel < MaxAlchemyLevel && data.AlchemyExp >= AlchemyExpToNext(data.AlchemyLevel) {
+			data.AlchemyExp -= AlchemyExpToNext(data.AlchemyLevel)
+			data.AlchemyLevel++
+		}
+		if data.AlchemyLevel >= MaxAlchemyLevel {
+			data.AlchemyExp = 0
+		}
+	}
+	data.AlchemyRate = AlchemyRateForLevel(data.AlchemyLevel)
+
+	return &ProficiencyGain{
+		ExpGained:    exp,
+		AlchemyLevel: data.AlchemyLevel,
+		AlchemyExp:   data.AlchemyExp,
+		NextExp:      AlchemyExpToNext(data.AlchemyLevel),
+		AlchemyRate:  data.AlchemyRate,
+		LevelUp:      data.AlchemyLevel > startLevel,
+	}
+}
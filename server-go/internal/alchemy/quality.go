@@ -0,0 +1,114 @@
+package alchemy
+
+import (
+	"fmt"
+	"sort"
+
+	"xiuxian/server-go/internal/models"
+)
+
+// 灵草选择策略
+const (
+	HerbStrategyCheapest = "cheapest" // 优先消耗低品质灵草（默认）
+	HerbStrategyBest     = "best"     // 优先消耗高品质灵草
+)
+
+// 灵草品质配置，由 models.HerbQualities 派生，ID 与 herbs.quality 列保持一致
+var herbQualities = buildHerbQualities()
+
+// buildHerbQualities 将共用的灵草品质表转换为炼丹使用的结构，排序取品质顺序
+func buildHerbQualities() map[string]HerbQuality {
+	qualities := make(map[string]HerbQuality, len(models.HerbQualityOrder))
+	for i, id := range models.HerbQualityOrder {
+		info := models.HerbQualities[id]
+		name, _ := info["name"].(string)
+		modifier, _ := info["value"].(float64)
+		qualities[id] = HerbQuality{ID: id, Name: name, Modifier: modifier, Rank: i + 1}
+	}
+	return qualities
+}
+
+// 丹药品质配置，按所用灵草的平均品质倍数决定
+var pillQualities = []PillQuality{
+	{ID: "common", Name: "凡品", MinModifier: 1.0, EffectMultiplier: 1.0},
+	{ID: "uncommon", Name: "良品", MinModifier: 1.5, EffectMultiplier: 1.2},
+	{ID: "rare", Name: "上品", MinModifier: 2.0, EffectMultiplier: 1.5},
+	{ID: "epic", Name: "极品", MinModifier: 3.0, EffectMultiplier: 2.0},
+	{ID: "legendary", Name: "仙品", MinModifier: 5.0, EffectMultiplier: 3.0},
+}
+
+// getHerbQuality 获取灵草品质，未知或为空按普通处理
+func getHerbQuality(quality string) HerbQuality {
+	if q, ok := herbQualities[quality]; ok {
+		return q
+	}
+	return herbQualities["common"]
+}
+
+// GetPillQuality 根据 ID 获取丹药品质，未知按凡品处理
+func GetPillQuality(id string) PillQuality {
+	for _, q := range pillQualities {
+		if q.ID == id {
+			return q
+		}
+	}
+	return pillQualities[0]
+}
+
+// validateHerbSelection 校验灵草选择参数
+func validateHerbSelection(selection HerbSelection) error {
+	switch selection.Strategy {
+	case "", HerbStrategyCheapest, HerbStrategyBest:
+	default:
+		return fmt.Errorf("未知的灵草选择策略: %s", selection.Strategy)
+	}
+	for herbID, quality := range selection.Qualities {
+		if _, ok := herbQualities[quality]; !ok {
+			return fmt.Errorf("未知的灵草品质: %s(%s)", herbID, quality)
+		}
+	}
+	return nil
+}
+
+// sortHerbsByStrategy 按策略排列灵草消耗顺序
+func sortHerbsByStrategy(herbs []models.Herb, strategy string) {
+	sort.SliceStable(herbs, func(i, j int) bool {
+		ri, rj := getHerbQuality(herbs[i].Quality).Rank, getHerbQuality(herbs[j].Quality).Rank
+		if ri == rj {
+			return herbs[i].ID < herbs[j].ID
+		}
+		if strategy == HerbStrategyBest {
+			return ri > rj
+		}
+		return ri < rj
+	})
+}
+
+// pillQualityFor 按消耗灵草的数量加权平均品质倍数，得到丹药品质
+func pillQualityFor(consumed map[string]int) PillQuality {
+	total, weighted := 0, 0.0
+	for quality, count := range consumed {
+		total += count
+		weighted += getHerbQuality(quality).Modifier * float64(count)
+	}
+	result := pillQualities[0]
+	if total == 0 {
+		return result
+	}
+	avg := weighted / float64(total)
+	for _, q := range pillQualities {
+		if avg >= q.MinModifier {
+			result = q
+		}
+	}
+	return result
+}
+
+// applyPillQuality 按丹药品质放大效果，渡劫丹固定效果不受影响
+func applyPillQuality(effect PillEffectResult, quality PillQuality) PillEffectResult {
+	if effect.Type == "duJieRate" {
+		return effect
+	}
+	effect.Value *= quality.EffectMultiplier
+	return effect
+}
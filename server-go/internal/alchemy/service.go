@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"math/rand"
 
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
 	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/formation"
+	"xiuxian/server-go/internal/inventory"
 	"xiuxian/server-go/internal/models"
 )
 
@@ -23,15 +28,15 @@ func NewAlchemyService(userID uint) *AlchemyService {
 
 // 品阶配置
 var pillGrades = map[string]PillGrade{
-	"grade1": {ID: "grade1", Name: "一品", Difficulty: 1, SuccessRate: 0.8, FragmentsNeeded: 10},
-	"grade2": {ID: "grade2", Name: "二品", Difficulty: 1.2, SuccessRate: 0.6, FragmentsNeeded: 15},
-	"grade3": {ID: "grade3", Name: "三品", Difficulty: 1.5, SuccessRate: 0.5, FragmentsNeeded: 20},
-	"grade4": {ID: "grade4", Name: "四品", Difficulty: 2, SuccessRate: 0.1, FragmentsNeeded: 25},
-	"grade5": {ID: "grade5", Name: "五品", Difficulty: 2.5, SuccessRate: 0.1, FragmentsNeeded: 30},
-	"grade6": {ID: "grade6", Name: "六品", Difficulty: 3, SuccessRate: 0.1, FragmentsNeeded: 35},
-	"grade7": {ID: "grade7", Name: "七品", Difficulty: 4, SuccessRate: 0.1, FragmentsNeeded: 40},
-	"grade8": {ID: "grade8", Name: "八品", Difficulty: 5, SuccessRate: 0.1, FragmentsNeeded: 45},
-	"grade9": {ID: "grade9", Name: "九品", Difficulty: 6, SuccessRate: 0.1, FragmentsNeeded: 50},
+	"grade1": {ID: "grade1", Name: "一品", Difficulty: 1, SuccessRate: 0.8, FragmentsNeeded: 10, RequiredLevel: 1},
+	"grade2": {ID: "grade2", Name: "二品", Difficulty: 1.2, SuccessRate: 0.6, FragmentsNeeded: 15, RequiredLevel: 3},
+	"grade3": {ID: "grade3", Name: "三品", Difficulty: 1.5, SuccessRate: 0.5, FragmentsNeeded: 20, RequiredLevel: 6},
+	"grade4": {ID: "grade4", Name: "四品", Difficulty: 2, SuccessRate: 0.1, FragmentsNeeded: 25, RequiredLevel: 10},
+	"grade5": {ID: "grade5", Name: "五品", Difficulty: 2.5, SuccessRate: 0.1, FragmentsNeeded: 30, RequiredLevel: 14},
+	"grade6": {ID: "grade6", Name: "六品", Difficulty: 3, SuccessRate: 0.1, FragmentsNeeded: 35, RequiredLevel: 18},
+	"grade7": {ID: "grade7", Name: "七品", Difficulty: 4, SuccessRate: 0.1, FragmentsNeeded: 40, RequiredLevel: 22},
+	"grade8": {ID: "grade8", Name: "八品", Difficulty: 5, SuccessRate: 0.1, FragmentsNeeded: 45, RequiredLevel: 26},
+	"grade9": {ID: "grade9", Name: "九品", Difficulty: 6, SuccessRate: 0.1, FragmentsNeeded: 50, RequiredLevel: 30},
 }
 
 // 丹药类型配置
@@ -61,7 +66,7 @@ var herbConfigs = map[string]HerbConfig{
 	"celestial_dew_grass":  {ID: "celestial_dew_grass", Name: "天露草"},
 }
 
-// 丹方配置 (12个丹方)
+// 丹方配置
 var recipes = []RecipeConfig{
 	{
 		ID:          "spirit_gathering",
@@ -144,6 +149,72 @@ var recipes = []RecipeConfig{
 		FragmentsNeeded: 20,
 		BaseEffect:      PillEffect{Type: "duJieRate", Value: 0.05, Duration: 0},
 	},
+	{
+		ID:          "enlightenment_pill",
+		Name:        "悟道丹",
+		Description: "百年灵草炼制，服用后三十分钟内修炼速度提升",
+		Grade:       "grade2",
+		Type:        "cultivation",
+		Materials: []MaterialRequire{
+			{HerbID: "cloud_flower", Count: 2},
+			{HerbID: "frost_lotus", Count: 1},
+		},
+		FragmentsNeeded: 15,
+		BaseEffect:      PillEffect{Type: "cultivationRate", Value: 0.2, Duration: 1800},
+	},
+	{
+		ID:          "fortune_pill",
+		Name:        "寻宝丹",
+		Description: "百年灵草炼制，服用后三十分钟内历练幸运提升，可叠加时长",
+		Grade:       "grade2",
+		Type:        "special",
+		Materials: []MaterialRequire{
+			{HerbID: "spirit_grass", Count: 3},
+			{HerbID: "dark_yin_grass", Count: 1},
+		},
+		FragmentsNeeded: 15,
+		BaseEffect:      PillEffect{Type: "explorationLuck", Value: 0.3, Duration: 1800},
+	},
+	{
+		ID:          "battle_fury_pill",
+		Name:        "破军丹",
+		Description: "千年灵草炼制，服用后一小时内战斗暴击率提升",
+		Grade:       "grade3",
+		Type:        "attribute",
+		Materials: []MaterialRequire{
+			{HerbID: "thunder_root", Count: 2},
+			{HerbID: "dragon_breath_herb", Count: 1},
+		},
+		FragmentsNeeded: 20,
+		BaseEffect:      PillEffect{Type: "critRate", Value: 0.1, Duration: 3600},
+	},
+	{
+		ID:          "tiger_might_pill",
+		Name:        "虎威丹",
+		Description: "千年灵草炼制，服用后一小时内战斗攻击提升，最多叠加三层",
+		Grade:       "grade3",
+		Type:        "attribute",
+		Materials: []MaterialRequire{
+			{HerbID: "thunder_root", Count: 1},
+			{HerbID: "dragon_breath_herb", Count: 1},
+			{HerbID: "purple_ginseng", Count: 1},
+		},
+		FragmentsNeeded: 20,
+		BaseEffect:      PillEffect{Type: "attackBoost", Value: 0.05, Duration: 3600},
+	},
+	{
+		ID:          "purification_pill",
+		Name:        "清毒丹",
+		Description: "寒霜莲为主药炼制，服用后化解体内积累的丹毒",
+		Grade:       "grade2",
+		Type:        "special",
+		Materials: []MaterialRequire{
+			{HerbID: "frost_lotus", Count: 2},
+			{HerbID: "spirit_grass", Count: 2},
+		},
+		FragmentsNeeded: 15,
+		BaseEffect:      PillEffect{Type: "detox", Value: 40, Duration: 0},
+	},
 	{
 		ID:          "mind_clarity",
 		Name:        "清心丹",
@@ -287,12 +358,13 @@ func (s *AlchemyService) GetAllRecipes(playerLevel int) (*AllRecipesResponse, er
 		}
 	}
 
-	// 构建丹方详情列表
+	// 构建丹方详情列表，未悟出丹方的材料按提示隐藏
+	hints := loadRecipeHints(&userAlchemyData)
 	var recipeDetails []RecipeDetailResponse
 	for _, recipe := range recipes {
 		isUnlocked := unlockedRecipes[recipe.ID]
 		fmt.Printf("[DEBUG] Recipe: %s (ID=%s), isUnlocked=%v\n", recipe.Name, recipe.ID, isUnlocked)
-		detail := s.buildRecipeDetail(&recipe, &user, isUnlocked, fragments[recipe.ID], playerLevel)
+		detail := s.buildRecipeDetail(&recipe, &user, isUnlocked, fragments[recipe.ID], playerLevel, hints[recipe.ID])
 		recipeDetails = append(recipeDetails, detail)
 	}
 
@@ -329,20 +401,25 @@ func (s *AlchemyService) GetRecipeDetail(recipeID string, playerLevel int, unloc
 		return nil, fmt.Errorf("丹方不存在: %s", recipeID)
 	}
 
+	data, err := s.loadAlchemyData()
+	if err != nil {
+		return nil, err
+	}
 	isUnlocked := unlockedRecipes[recipeID]
-	detail := s.buildRecipeDetail(recipe, &user, isUnlocked, fragments[recipeID], playerLevel)
+	detail := s.buildRecipeDetail(recipe, &user, isUnlocked, fragments[recipeID], playerLevel, loadRecipeHints(data)[recipeID])
 	return &detail, nil
 }
 
-// buildRecipeDetail 构建丹方详情
-func (s *AlchemyService) buildRecipeDetail(recipe *RecipeConfig, user *models.User, isUnlocked bool, fragmentsOwned int, playerLevel int) RecipeDetailResponse {
+// buildRecipeDetail 构建丹方详情，revealedHerbs 为试炼揭示的材料
+func (s *AlchemyService) buildRecipeDetail(recipe *RecipeConfig, user *models.User, isUnlocked bool, fragmentsOwned int, playerLevel int, revealedHerbs []string) RecipeDetailResponse {
 	// 获取品阶和类型信息
 	grade := pillGrades[recipe.Grade]
 	recipeType := pillTypes[recipe.Type]
 
 	// 计算材料信息
 	var materials []MaterialInfo
-	for _, req := range recipe.Materials {
+	known := isUnlocked || fragmentsOwned >= recipe.FragmentsNeeded
+	for _, req := range visibleMaterials(recipe, known, revealedHerbs) {
 		herbName := "未知灵草"
 		if herb, ok := herbConfigs[req.HerbID]; ok {
 			herbName = herb.Name
@@ -367,6 +444,7 @@ func (s *AlchemyService) buildRecipeDetail(recipe *RecipeConfig, user *models.Us
 		Type:             recipe.Type,
 		TypeName:         recipeType.Name,
 		SuccessRate:      grade.SuccessRate,
+		RequiredLevel:    grade.RequiredLevel,
 		Materials:        materials,
 		FragmentsNeeded:  recipe.FragmentsNeeded,
 		CurrentFragments: fragmentsOwned,
@@ -386,6 +464,9 @@ func (s *AlchemyService) calculatePillEffect(recipe *RecipeConfig, playerLevel i
 	// ✅ 特殊处理：渡劫丹效果固定为 5%，不受等级和类型倍数影响
 	if recipe.BaseEffect.Type == "duJieRate" {
 		effectValue = recipe.BaseEffect.Value // 固定 0.05 (5%)
+	} else if recipe.BaseEffect.Duration > 0 || recipe.BaseEffect.Type == "detox" {
+		// 限时丹药为比例加成、清毒丹为固定化解量，不随境界成长
+		effectValue = recipe.BaseEffect.Value
 	} else {
 		// 基础效果随境界提升
 		levelMultiplier := 1.0 + float64(playerLevel-1)*0.1
@@ -400,146 +481,332 @@ func (s *AlchemyService) calculatePillEffect(recipe *RecipeConfig, playerLevel i
 	}
 }
 
+// MaxCraftBatch 单次批量炼制次数上限
+const MaxCraftBatch = 100
+
 // CraftPill 炼制丹药
-func (s *AlchemyService) CraftPill(recipeID string, playerLevel int, unlockedRecipes map[string]bool, inventoryHerbs map[string]int, luck float64, alchemyRate float64) (*CraftResult, error) {
-	var user models.User
-	if err := db.DB.First(&user, s.userID).Error; err != nil {
-		return nil, fmt.Errorf("用户不存在: %w", err)
+// 丹方、灵草、幸运与炼丹加成均从数据库读取，整个炼制过程在一个事务内完成并对相关行加锁；
+// selection 指定各灵草品质或消耗策略，丹药品质由所用灵草的平均品质决定；
+// quantity 为炼制次数，<= 0 表示按现有灵草炼制最大次数（上限 MaxCraftBatch）；
+// idempotencyKey 非空时，同一个 key 的重复请求直接返回首次结果，不会重复消耗材料
+func (s *AlchemyService) CraftPill(recipeID string, quantity int, selection HerbSelection, idempotencyKey string) (*CraftResult, error) {
+	if quantity > MaxCraftBatch {
+		return nil, fmt.Errorf("单次最多炼制 %d 枚", MaxCraftBatch)
+	}
+	if err := validateHerbSelection(selection); err != nil {
+		return nil, err
 	}
 
-	// 查找丹方
-	var recipe *RecipeConfig
-	for i := range recipes {
-		if recipes[i].ID == recipeID {
-			recipe = &recipes[i]
-			break
-		}
+	if len(idempotencyKey) > MaxIdempotencyKeyLength {
+		return nil, fmt.Errorf("幂等键过长，最多 %d 个字符", MaxIdempotencyKeyLength)
 	}
 
+	return s.craftPillTx(recipeID, quantity, selection, idempotencyKey)
+}
+
+// craftPillTx 在事务内完成批量炼制，每次炼制独立判定成败；
+// 幂等记录与材料扣除在同一事务内提交，重复请求在锁定用户行后直接返回首次结果
+func (s *AlchemyService) craftPillTx(recipeID string, quantity int, selection HerbSelection, idempotencyKey string) (*CraftResult, error) {
+	recipe := GetRecipeByID(recipeID)
 	if recipe == nil {
 		return nil, fmt.Errorf("丹方不存在: %s", recipeID)
 	}
+	grade := pillGrades[recipe.Grade]
 
-	// 检查是否掌握该丹方
-	if !unlockedRecipes[recipeID] {
-		return nil, fmt.Errorf("未掌握该丹方")
+	// 确保炼丹数据存在，避免在事务内处理首次创建
+	if _, err := s.loadAlchemyData(); err != nil {
+		return nil, err
 	}
-
-	// 检查材料是否足够
-	for _, material := range recipe.Materials {
-		if inventoryHerbs[material.HerbID] < material.Count {
-			return nil, fmt.Errorf("材料不足: %s", material.HerbID)
+	alchemyBoost := formation.GetActiveEffect(s.userID, formation.EffectAlchemyBoost)
+
+	var result *CraftResult
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// 锁定用户行，串行化同一用户的并发炼制
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, s.userID).Error; err != nil {
+			return fmt.Errorf("用户不存在: %w", err)
 		}
-	}
 
-	// 计算成功率
-	grade := pillGrades[recipe.Grade]
+		if idempotencyKey != "" {
+			cached, err := findCraftRequest(tx, s.userID, idempotencyKey, recipeID)
+			if err != nil {
+				return err
+			}
+			if cached != nil {
+				result = cached
+				return nil
+			}
+		}
 
-	// 确保 luck 和 alchemyRate 有效
-	if luck <= 0 {
-		luck = 1.0
-	}
-	if alchemyRate <= 0 {
-		alchemyRate = 1.0
-	}
+		var userAlchemyData models.UserAlchemyDataDB
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", s.userID).First(&userAlchemyData).Error; err != nil {
+			return fmt.Errorf("获取用户炼丹数据失败: %w", err)
+		}
 
-	successRate := grade.SuccessRate * luck * alchemyRate
+		// 检查是否掌握该丹方
+		unlockedRecipes, err := loadUnlockedRecipes(tx, s.userID, &userAlchemyData)
+		if err != nil {
+			return err
+		}
+		if !unlockedRecipes[recipeID] {
+			return fmt.Errorf("未掌握该丹方")
+		}
 
-	// 计算消耗的材料
-	consumedHerbs := make(map[string]int)
-	for _, material := range recipe.Materials {
-		consumedHerbs[material.HerbID] = material.Count
-	}
+		// 检查炼丹等级是否足以炼制该品阶
+		if userAlchemyData.AlchemyLevel < grade.RequiredLevel {
+			return fmt.Errorf("炼丹等级不足: %s丹药需要炼丹等级 %d", grade.Name, grade.RequiredLevel)
+		}
 
-	// ✅ 先扣除灵草材料（无论成功还是失败都要消耗）
-	for herbID, count := range consumedHerbs {
-		// 查询该herbId的所有记录
-		var herbs []models.Herb
-		if err := db.DB.Where("user_id = ? AND herb_id = ?", s.userID, herbID).Find(&herbs).Error; err != nil {
-			return nil, fmt.Errorf("查询灵草失败: %w", err)
+		// 锁定所选灵草，先统一校验全部次数所需的材料
+		herbStacks, err := lockRecipeHerbs(tx, s.userID, recipe, selection)
+		if err != nil {
+			return err
+		}
+		maxCraftable := maxCraftableCount(recipe, herbStacks)
+		if quantity <= 0 {
+			quantity = maxCraftable
+			if quantity > MaxCraftBatch {
+				quantity = MaxCraftBatch
+			}
+			if quantity == 0 {
+				return fmt.Errorf("材料不足，无法炼制")
+			}
+		} else if quantity > maxCraftable {
+			return fmt.Errorf("材料不足: 最多可炼制 %d 枚", maxCraftable)
 		}
 
-		// 计算总数
-		totalCount := 0
-		for _, h := range herbs {
-			totalCount += h.Count
+		// 扣除灵草材料（无论成功还是失败都要消耗），同时统计所用品质
+		consumedHerbs := make(map[string]int)
+		herbQualitiesUsed := make(map[string]map[string]int)
+		consumedQualities := make(map[string]int)
+		for _, material := range recipe.Materials {
+			count := material.Count * quantity
+			used, err := consumeHerbs(tx, herbStacks[material.HerbID], material.HerbID, count)
+			if err != nil {
+				return err
+			}
+			consumedHerbs[material.HerbID] += count
+			herbQualitiesUsed[material.HerbID] = used
+			for quality, n := range used {
+				consumedQualities[quality] += n
+			}
 		}
+		pillQuality := pillQualityFor(consumedQualities)
 
-		// 如果总数小于需要扣除的数量，则报错
-		if totalCount < count {
-			return nil, fmt.Errorf("材料不足: %s, 拥有: %d, 需要: %d", herbID, totalCount, count)
+		// 计算成功率：品阶基础 × 幸运 × 炼丹加成 × 地火阵，整批使用炼制前的加成
+		alchemyRate := userAlchemyData.AlchemyRate
+		if alchemyRate <= 0 {
+			alchemyRate = AlchemyRateForLevel(userAlchemyData.AlchemyLevel)
+		}
+		successRate := grade.SuccessRate * userLuck(&user) * alchemyRate * (1 + alchemyBoost)
+
+		// 逐次判定成败，失败也能积累少量熟练度
+		effect := applyPillQuality(s.calculatePillEffect(recipe, user.Level), pillQuality)
+		effectJSON, _ := json.Marshal(effect)
+		successCount := 0
+		exp := 0
+		for i := 0; i < quantity; i++ {
+			if rand.Float64() > successRate {
+				exp += craftExp(grade, false)
+				continue
+			}
+			exp += craftExp(grade, true)
+			successCount++
 		}
 
-		// 逐个扣除，直到扣除足够的数量
-		remaining := count
-		for _, herb := range herbs {
-			if remaining <= 0 {
-				break
+		// 成丹按丹药与品质堆叠入库
+		pill := models.Pill{
+			UserID:      s.userID,
+			PillID:      recipeID,
+			Name:        recipe.Name,
+			Description: recipe.Description,
+			Effect:      effectJSON,
+			Quality:     pillQuality.ID,
+			Count:       successCount,
+		}
+		if successCount > 0 {
+			if err := inventory.AddPills(tx, &pill); err != nil {
+				return fmt.Errorf("创建丹药失败: %w", err)
 			}
+		}
 
-			if herb.Count >= remaining {
-				// 当前记录足以扣除剩余数量
-				if err := db.DB.Model(&herb).Update("count", herb.Count-remaining).Error; err != nil {
-					return nil, fmt.Errorf("扣除材料失败: %w", err)
-				}
-				remaining = 0
+		// 更新用户炼丹统计数据与熟练度
+		userAlchemyData.PillsCrafted += successCount
+		proficiency := addAlchemyExp(&userAlchemyData, exp)
+		if err := tx.Save(&userAlchemyData).Error; err != nil {
+			return fmt.Errorf("更新用户炼丹数据失败: %w", err)
+		}
+
+		result = &CraftResult{
+			Success:       successCount > 0,
+			SuccessRate:   successRate,
+			ConsumedHerbs: consumedHerbs,
+			Proficiency:   proficiency,
+			Quantity:      quantity,
+			SuccessCount:  successCount,
+			FailCount:     quantity - successCount,
+			HerbQualities: herbQualitiesUsed,
+		}
+		switch {
+		case successCount == 0:
+			result.Message = "炼制失败，材料已消耗"
+		case quantity == 1:
+			result.Message = "炼制成功"
+		default:
+			result.Message = fmt.Sprintf("炼制 %d 次，成功 %d 枚，失败 %d 次", quantity, result.SuccessCount, result.FailCount)
+		}
+		if successCount > 0 {
+			result.PillID = fmt.Sprintf("%d", pill.ID)
+			result.PillIDs = []string{result.PillID}
+			result.PillName = recipe.Name
+			result.PillEffect = &effect
+			result.PillQuality = pillQuality.ID
+			result.QualityName = pillQuality.Name
+		}
+		if idempotencyKey != "" {
+			return saveCraftRequest(tx, s.userID, idempotencyKey, recipeID, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lockRecipeHerbs 锁定丹方所需的灵草，按选择过滤品质并排好消耗顺序
+func lockRecipeHerbs(tx *gorm.DB, userID uint, recipe *RecipeConfig, selection HerbSelection) (map[string][]models.Herb, error) {
+	stacks := make(map[string][]models.Herb)
+	for _, material := range recipe.Materials {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND herb_id = ? AND count > 0", userID, material.HerbID)
+		if quality, ok := selection.Qualities[material.HerbID]; ok {
+			// 旧数据品质可能为空，视为普通
+			if quality == "common" {
+				query = query.Where("(quality = ? OR quality IS NULL OR quality = '')", quality)
 			} else {
-				// 当前记录不足，全部扣除
-				remaining -= herb.Count
-				if err := db.DB.Model(&herb).Update("count", 0).Error; err != nil {
-					return nil, fmt.Errorf("扣除材料失败: %w", err)
-				}
+				query = query.Where("quality = ?", quality)
 			}
 		}
+		var herbs []models.Herb
+		if err := query.Order("id").Find(&herbs).Error; err != nil {
+			return nil, fmt.Errorf("查询灵草失败: %w", err)
+		}
+		sortHerbsByStrategy(herbs, selection.Strategy)
+		stacks[material.HerbID] = herbs
 	}
+	return stacks, nil
+}
 
-	// 尝试炼制（灵草已消耗，现在判断成功与否）
-	if rand.Float64() > successRate {
-		return &CraftResult{
-			Success:       false,
-			Message:       "炼制失败，材料已消耗",
-			SuccessRate:   successRate,
-			ConsumedHerbs: consumedHerbs, // ✅ 返回消耗的灵草信息
-		}, nil
+// maxCraftableCount 返回当前材料可炼制的最大次数
+func maxCraftableCount(recipe *RecipeConfig, stacks map[string][]models.Herb) int {
+	maxCount := -1
+	for _, material := range recipe.Materials {
+		if material.Count <= 0 {
+			continue
+		}
+		total := 0
+		for _, h := range stacks[material.HerbID] {
+			total += h.Count
+		}
+		if n := total / material.Count; maxCount < 0 || n < maxCount {
+			maxCount = n
+		}
 	}
+	if maxCount < 0 {
+		maxCount = 0
+	}
+	return maxCount
+}
 
-	// 创建丹药记录 (保存到数据库)
-	pill := models.Pill{
-		UserID:      s.userID,
-		PillID:      recipeID,
-		Name:        recipe.Name,
-		Description: recipe.Description,
+// consumeHerbs 按顺序从已锁定的灵草中扣除指定数量，返回各品质的消耗数量
+func consumeHerbs(tx *gorm.DB, herbs []models.Herb, herbID string, count int) (map[string]int, error) {
+	totalCount := 0
+	for _, h := range herbs {
+		totalCount += h.Count
+	}
+	if totalCount < count {
+		return nil, fmt.Errorf("材料不足: %s, 拥有: %d, 需要: %d", herbID, totalCount, count)
 	}
 
-	// 计算并保存丹药效果到JSON字段
-	effect := s.calculatePillEffect(recipe, playerLevel)
-	effectJSON, _ := json.Marshal(effect)
-	pill.Effect = effectJSON
+	// 逐条扣除，直到扣除足够的数量
+	used := make(map[string]int)
+	remaining := count
+	for _, herb := range herbs {
+		if remaining <= 0 {
+			break
+		}
+		deduct := herb.Count
+		if deduct > remaining {
+			deduct = remaining
+		}
+		if err := tx.Model(&models.Herb{}).Where("id = ?", herb.ID).
+			Update("count", herb.Count-deduct).Error; err != nil {
+			return nil, fmt.Errorf("扣除材料失败: %w", err)
+		}
+		used[getHerbQuality(herb.Quality).ID] += deduct
+		remaining -= deduct
+	}
+	return used, nil
+}
 
-	if err := db.DB.Create(&pill).Error; err != nil {
-		return nil, fmt.Errorf("创建丹药失败: %w", err)
+// loadUnlockedRecipes 解析已掌握的丹方，recipes_unlocked 为空时按残页数量判断
+func loadUnlockedRecipes(tx *gorm.DB, userID uint, data *models.UserAlchemyDataDB) (map[string]bool, error) {
+	unlockedRecipes := make(map[string]bool)
+	if data.RecipesUnlocked != "" {
+		var recipesMap map[string]bool
+		if err := json.Unmarshal([]byte(data.RecipesUnlocked), &recipesMap); err == nil && recipesMap != nil {
+			unlockedRecipes = recipesMap
+		}
+	}
+	if len(unlockedRecipes) > 0 {
+		return unlockedRecipes, nil
 	}
 
-	// 更新用户炼丹统计数据
-	var userAlchemyData models.UserAlchemyDataDB
-	if err := db.DB.Where("user_id = ?", s.userID).First(&userAlchemyData).Error; err != nil {
-		return nil, fmt.Errorf("获取用户炼丹数据失败: %w", err)
+	var fragments []models.PillFragment
+	if err := tx.Where("user_id = ?", userID).Find(&fragments).Error; err != nil {
+		return nil, fmt.Errorf("获取残页数据失败: %w", err)
 	}
+	for _, fragment := range fragments {
+		if recipe := GetRecipeByID(fragment.RecipeID); recipe != nil && fragment.Count >= recipe.FragmentsNeeded {
+			unlockedRecipes[recipe.ID] = true
+		}
+	}
+	return unlockedRecipes, nil
+}
 
-	userAlchemyData.PillsCrafted++
-	if err := db.DB.Save(&userAlchemyData).Error; err != nil {
-		return nil, fmt.Errorf("更新用户炼丹数据失败: %w", err)
+// userLuck 幸运倍率 = 1 + SpecialAttributes 中的幸运加成
+func userLuck(user *models.User) float64 {
+	if user.SpecialAttributes == nil {
+		return 1.0
+	}
+	var attrs map[string]interface{}
+	if err := json.Unmarshal(user.SpecialAttributes, &attrs); err != nil {
+		return 1.0
+	}
+	luck, _ := attrs["luck"].(float64)
+	if luck < 0 {
+		luck = 0
 	}
+	return 1.0 + luck
+}
 
-	return &CraftResult{
-		Success:       true,
-		Message:       "炼制成功",
-		PillID:        fmt.Sprintf("%d", pill.ID),
-		PillName:      recipe.Name,
-		SuccessRate:   successRate,
-		ConsumedHerbs: consumedHerbs,
-		PillEffect:    &effect,
-	}, nil
+// loadAlchemyData 读取用户炼丹数据，不存在时创建默认记录
+func (s *AlchemyService) loadAlchemyData() (*models.UserAlchemyDataDB, error) {
+	var data models.UserAlchemyDataDB
+	if err := db.DB.Where("user_id = ?", s.userID).First(&data).Error; err != nil {
+		data = models.UserAlchemyDataDB{
+			UserID:          s.userID,
+			RecipesUnlocked: "{}",
+			AlchemyLevel:    1,
+			AlchemyRate:     1.0,
+		}
+		if err := db.DB.Create(&data).Error; err != nil {
+			return nil, fmt.Errorf("获取用户炼丹数据失败: %w", err)
+		}
+	}
+	return &data, nil
 }
 
 // BuyFragment 购买丹方残页
@@ -584,28 +851,13 @@ func (s *AlchemyService) BuyFragment(recipeID string, quantity int, currentFragm
 		return nil, fmt.Errorf("更新灵石失败: %w", err)
 	}
 
-	// 更新残页数量到数据库
-	var fragment models.PillFragment
-	if err := db.DB.Where("user_id = ? AND recipe_id = ?", s.userID, recipeID).First(&fragment).Error; err != nil {
-		// 如果不存在，则创建新记录
-		fragment = models.PillFragment{
-			UserID:   s.userID,
-			RecipeID: recipeID,
-			Count:    quantity,
-		}
-		if err := db.DB.Create(&fragment).Error; err != nil {
-			return nil, fmt.Errorf("创建残页记录失败: %w", err)
-		}
-	} else {
-		// 更新现有记录
-		fragment.Count += quantity
-		if err := db.DB.Save(&fragment).Error; err != nil {
-			return nil, fmt.Errorf("更新残页记录失败: %w", err)
-		}
+	// 更新残页数量到数据库（按丹方堆叠）
+	newFragmentCount, err := inventory.AddPillFragments(db.DB, s.userID, recipeID, quantity)
+	if err != nil {
+		return nil, err
 	}
 
 	// 检查是否可以合成完整丹方
-	newFragmentCount := fragment.Count
 	recipeUnlocked := false
 	newFragmentsAfterCraft := newFragmentCount
 
@@ -617,8 +869,8 @@ func (s *AlchemyService) BuyFragment(recipeID string, quantity int, currentFragm
 		recipeUnlocked = true
 
 		// 更新残页数量（扣除用于合成的数量）
-		fragment.Count = newFragmentsAfterCraft
-		if err := db.DB.Save(&fragment).Error; err != nil {
+		if err := db.DB.Model(&models.PillFragment{}).Where("user_id = ? AND recipe_id = ?", s.userID, recipeID).
+			Update("count", newFragmentsAfterCraft).Error; err != nil {
 			return nil, fmt.Errorf("更新残页记录失败: %w", err)
 		}
 
@@ -657,7 +909,7 @@ func (s *AlchemyService) GetUnlockedRecipes(unlockedRecipes map[string]bool, pla
 	var result []RecipeDetailResponse
 	for _, recipe := range recipes {
 		if unlockedRecipes[recipe.ID] {
-			detail := s.buildRecipeDetail(&recipe, &user, true, 0, playerLevel)
+			detail := s.buildRecipeDetail(&recipe, &user, true, 0, playerLevel, nil)
 			result = append(result, detail)
 		}
 	}
@@ -718,6 +970,16 @@ func GetAllHerbs() map[string]HerbConfig {
 	return herbConfigs
 }
 
+// GetAllHerbQualities 获取所有灵草品质配置
+func GetAllHerbQualities() map[string]HerbQuality {
+	return herbQualities
+}
+
+// GetAllPillQualities 获取所有丹药品质配置
+func GetAllPillQualities() []PillQuality {
+	return pillQualities
+}
+
 // GetUserAlchemyData 获取用户炼丹数据
 func (s *AlchemyService) GetUserAlchemyData() (*UserAlchemyData, error) {
 	var user models.User
@@ -779,6 +1041,8 @@ func (s *AlchemyService) GetUserAlchemyData() (*UserAlchemyData, error) {
 		PillsCrafted:    userAlchemyData.PillsCrafted,
 		PillsConsumed:   userAlchemyData.PillsConsumed,
 		AlchemyLevel:    userAlchemyData.AlchemyLevel,
+		AlchemyExp:      userAlchemyData.AlchemyExp,
+		AlchemyNextExp:  AlchemyExpToNext(userAlchemyData.AlchemyLevel),
 		AlchemyRate:     userAlchemyData.AlchemyRate,
 	}, nil
 }
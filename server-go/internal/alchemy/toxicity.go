@@ -0,0 +1,107 @@
+package alchemy
+
+import (
+	"math"
+	"time"
+)
+
+// 丹毒参数，数值存于 BaseAttributes 的 pillToxicity，上次结算时间存于 pillToxicityAt（Unix 秒）
+const (
+	MaxPillToxicity          = 100.0 // 丹毒上限
+	pillToxicityPerGrade     = 4.0   // 每点品阶难度累积的丹毒
+	PillToxicityDecayPerHour = 5.0   // 每小时自然消退的丹毒
+	RetreatDetoxPerHour      = 10.0  // 闭关每小时额外化解的丹毒
+
+	attrPillToxicity   = "pillToxicity"
+	attrPillToxicityAt = "pillToxicityAt"
+)
+
+// 不同类型丹药的丹毒倍数，属性类丹药毒性最重
+var pillToxicityTypeFactor = map[string]float64{
+	"spirit":      0.5,
+	"cultivation": 1.0,
+	"attribute":   2.0,
+	"special":     1.0,
+}
+
+// ToxicityStage 丹毒阶段
+type ToxicityStage struct {
+	ID                 string  `json:"id"`
+	Name               string  `json:"name"`
+	Threshold          float64 `json:"threshold"`          // 达到该丹毒值进入此阶段
+	EffectMultiplier   float64 `json:"effectMultiplier"`   // 服用丹药的效果倍数
+	TribulationPenalty float64 `json:"tribulationPenalty"` // 渡劫成功率惩罚
+}
+
+var toxicityStages = []ToxicityStage{
+	{ID: "clean", Name: "丹毒轻微", Threshold: 0, EffectMultiplier: 1.0, TribulationPenalty: 0},
+	{ID: "mild", Name: "丹毒淤积", Threshold: 30, EffectMultiplier: 0.8, TribulationPenalty: 0},
+	{ID: "heavy", Name: "丹毒深重", Threshold: 60, EffectMultiplier: 0.5, TribulationPenalty: 0.05},
+	{ID: "severe", Name: "丹毒攻心", Threshold: 90, EffectMultiplier: 0.2, TribulationPenalty: 0.15},
+}
+
+// ToxicityState 当前丹毒状态
+type ToxicityState struct {
+	Value float64 `json:"value"`
+	Max   float64 `json:"max"`
+	ToxicityStage
+}
+
+// PillToxicity 计算服用一枚丹药累积的丹毒，清毒类丹药不产生丹毒
+func PillToxicity(pillID string) float64 {
+	recipe := GetRecipeByID(pillID)
+	if recipe == nil || recipe.BaseEffect.Type == "detox" {
+		return 0
+	}
+	factor, ok := pillToxicityTypeFactor[recipe.Type]
+	if !ok {
+		factor = 1.0
+	}
+	return math.Round(pillGrades[recipe.Grade].Difficulty*pillToxicityPerGrade*factor*10) / 10
+}
+
+// CurrentToxicity 读取丹毒值并按上次结算以来的时长自然消退
+func CurrentToxicity(attrs map[string]interface{}, now time.Time) float64 {
+	value, _ := attrs[attrPillToxicity].(float64)
+	at, _ := attrs[attrPillToxicityAt].(float64)
+	if value <= 0 {
+		return 0
+	}
+	if at > 0 {
+		hours := now.Sub(time.Unix(int64(at), 0)).Hours()
+		if hours > 0 {
+			value -= hours * PillToxicityDecayPerHour
+		}
+	}
+	return math.Max(0, math.Round(value*10)/10)
+}
+
+// GetToxicityState 获取当前丹毒值及所处阶段
+func GetToxicityState(attrs map[string]interface{}, now time.Time) ToxicityState {
+	value := CurrentToxicity(attrs, now)
+	stage := toxicityStages[0]
+	for _, s := range toxicityStages {
+		if value >= s.Threshold {
+			stage = s
+		}
+	}
+	return ToxicityState{Value: value, Max: MaxPillToxicity, ToxicityStage: stage}
+}
+
+// CopyToxicity 重建属性时保留丹毒记录
+func CopyToxicity(dst, src map[string]interface{}) {
+	for _, key := range []string{attrPillToxicity, attrPillToxicityAt} {
+		if v, ok := src[key]; ok {
+			dst[key] = v
+		}
+	}
+}
+
+// AdjustToxicity 结算自然消退后增减丹毒（delta 为负表示化解），写回 attrs 并返回新值
+func AdjustToxicity(attrs map[string]interface{}, delta float64, now time.Time) float64 {
+	value := CurrentToxicity(attrs, now) + delta
+	value = math.Min(MaxPillToxicity, math.Max(0, math.Round(value*10)/10))
+	attrs[attrPillToxicity] = value
+	attrs[attrPillToxicityAt] = float64(now.Unix())
+	return value
+}
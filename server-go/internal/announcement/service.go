@@ -0,0 +1,72 @@
+package announcement
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"xiuxian/server-go/internal/redis"
+)
+
+const (
+	// 全服公告列表（最新在前），供客户端轮询
+	RecentKey = "announcement:recent"
+	// 全服公告发布频道，供推送服务订阅
+	Channel = "announcement:channel"
+	// 公告列表保留条数
+	MaxRecent = 50
+)
+
+// 公告类型
+const (
+	TypeRecipeDiscovery = "recipe_discovery" // 全服首位悟出丹方
+)
+
+// Announcement 全服公告
+type Announcement struct {
+	Type       string `json:"type"`
+	Message    string `json:"message"`
+	UserID     uint   `json:"userId,omitempty"`
+	PlayerName string `json:"playerName,omitempty"`
+	CreatedAt  int64  `json:"createdAt"` // Unix 毫秒
+}
+
+// Publish 写入公告列表并发布到公告频道
+func Publish(a Announcement) error {
+	if a.CreatedAt == 0 {
+		a.CreatedAt = time.Now().UnixMilli()
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("序列化公告失败: %w", err)
+	}
+
+	pipe := redis.Client.TxPipeline()
+	pipe.LPush(redis.Ctx, RecentKey, data)
+	pipe.LTrim(redis.Ctx, RecentKey, 0, MaxRecent-1)
+	pipe.Publish(redis.Ctx, Channel, data)
+	if _, err := pipe.Exec(redis.Ctx); err != nil {
+		return fmt.Errorf("发布公告失败: %w", err)
+	}
+	return nil
+}
+
+// GetRecent 获取最近的全服公告，最新在前
+func GetRecent(limit int) ([]Announcement, error) {
+	if limit <= 0 || limit > MaxRecent {
+		limit = MaxRecent
+	}
+	items, err := redis.Client.LRange(redis.Ctx, RecentKey, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取公告失败: %w", err)
+	}
+	announcements := make([]Announcement, 0, len(items))
+	for _, item := range items {
+		var a Announcement
+		if err := json.Unmarshal([]byte(item), &a); err != nil {
+			continue
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, nil
+}
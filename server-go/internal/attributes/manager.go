@@ -1,10 +1,37 @@
-package player
+package attributes
 
 import (
+	"encoding/json"
 	"math"
+
+	"gorm.io/datatypes"
+
 	"xiuxian/server-go/internal/models"
 )
 
+// 属性类别，对应 users 表的四个属性 JSON 列
+const (
+	CategoryBase       = "base"       // base_attributes
+	CategoryCombat     = "combat"     // combat_attributes
+	CategoryResistance = "resistance" // combat_resistance
+	CategorySpecial    = "special"    // special_attributes
+)
+
+// StatCategory 返回装备、宝石、灵宠、套装等属性键所属的类别：
+// attack/health/defense/speed 为基础属性，各类触发率为战斗属性，各类抗性为战斗抗性，其余为特殊属性
+func StatCategory(key string) string {
+	switch key {
+	case "attack", "health", "defense", "speed":
+		return CategoryBase
+	case "critRate", "comboRate", "counterRate", "stunRate", "dodgeRate", "vampireRate":
+		return CategoryCombat
+	case "critResist", "comboResist", "counterResist", "stunResist", "dodgeResist", "vampireResist":
+		return CategoryResistance
+	default:
+		return CategorySpecial
+	}
+}
+
 // AttributeManager 统一管理玩家属性变更
 type AttributeManager struct {
 	BaseAttrs    map[string]float64
@@ -30,16 +57,8 @@ func (am *AttributeManager) ApplyEquipmentStats(equipStats map[string]float64) {
 		if v == 0 {
 			continue
 		}
-		switch stat {
-		case "attack", "health", "defense", "speed":
-			am.BaseAttrs[stat] = am.BaseAttrs[stat] + v
-		case "critRate", "comboRate", "counterRate", "stunRate", "dodgeRate", "vampireRate":
-			am.CombatAttrs[stat] = am.CombatAttrs[stat] + v
-		case "critResist", "comboResist", "counterResist", "stunResist", "dodgeResist", "vampireResist":
-			am.CombatRes[stat] = am.CombatRes[stat] + v
-		default:
-			am.SpecialAttrs[stat] = am.SpecialAttrs[stat] + v
-		}
+		m := am.category(stat)
+		m[stat] = m[stat] + v
 	}
 }
 
@@ -49,17 +68,7 @@ func (am *AttributeManager) RemoveEquipmentStats(equipStats map[string]float64)
 		if v == 0 {
 			continue
 		}
-		var m map[string]float64
-		switch stat {
-		case "attack", "health", "defense", "speed":
-			m = am.BaseAttrs
-		case "critRate", "comboRate", "counterRate", "stunRate", "dodgeRate", "vampireRate":
-			m = am.CombatAttrs
-		case "critResist", "comboResist", "counterResist", "stunResist", "dodgeResist", "vampireResist":
-			m = am.CombatRes
-		default:
-			m = am.SpecialAttrs
-		}
+		m := am.category(stat)
 		m[stat] = m[stat] - v
 		if m[stat] < 0 {
 			m[stat] = 0
@@ -197,6 +206,20 @@ func (am *AttributeManager) RemovePetBonuses(pet *models.Pet, petCombat map[stri
 	}
 }
 
+// category 返回属性键所属类别的属性表
+func (am *AttributeManager) category(stat string) map[string]float64 {
+	switch StatCategory(stat) {
+	case CategoryBase:
+		return am.BaseAttrs
+	case CategoryCombat:
+		return am.CombatAttrs
+	case CategoryResistance:
+		return am.CombatRes
+	default:
+		return am.SpecialAttrs
+	}
+}
+
 // applyRateAttribute 应用速率属性（战斗属性、战斗抗性）
 // 这些属性有 0-1 的上限
 func (am *AttributeManager) applyRateAttribute(attrs map[string]float64, key string, petCombat map[string]float64) {
@@ -231,3 +254,39 @@ func (am *AttributeManager) RemoveEquipmentAndApplyPet(equipStats map[string]flo
 	am.RemoveEquipmentStats(equipStats)
 	am.ApplyPetBonuses(pet, petCombat)
 }
+
+// ParseStats 解析属性 JSON，解析失败返回空表
+func ParseStats(j datatypes.JSON) map[string]float64 {
+	m := map[string]float64{}
+	if len(j) == 0 {
+		return m
+	}
+	if err := json.Unmarshal(j, &m); err != nil {
+		return map[string]float64{}
+	}
+	return m
+}
+
+// FromUser 以玩家当前的四类属性创建属性管理器
+func FromUser(user *models.User) *AttributeManager {
+	return NewAttributeManager(
+		ParseStats(user.BaseAttributes),
+		ParseStats(user.CombatAttributes),
+		ParseStats(user.CombatResistance),
+		ParseStats(user.SpecialAttributes),
+	)
+}
+
+// Updates 返回写回 users 表的属性列
+func (am *AttributeManager) Updates() map[string]interface{} {
+	base, _ := json.Marshal(am.BaseAttrs)
+	combat, _ := json.Marshal(am.CombatAttrs)
+	res, _ := json.Marshal(am.CombatRes)
+	special, _ := json.Marshal(am.SpecialAttrs)
+	return map[string]interface{}{
+		"base_attributes":    datatypes.JSON(base),
+		"combat_attributes":  datatypes.JSON(combat),
+		"combat_resistance":  datatypes.JSON(res),
+		"special_attributes": datatypes.JSON(special),
+	}
+}
@@ -0,0 +1,483 @@
+package cultivation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/redis"
+)
+
+const (
+	// 双修邀请键（被邀请者, 邀请者）
+	DualInviteKeyFormat = "cultivation:dual:invite:%d:%d"
+	// 玩家收到的邀请集合（有序集合：邀请者ID -> 过期时间）
+	DualInvitesKeyFormat = "cultivation:dual:invites:%d"
+	// 双修会话键（较小ID, 较大ID）
+	DualSessionKeyFormat = "cultivation:dual:session:%d:%d"
+	// 玩家当前双修对象键
+	DualPartnerKeyFormat = "cultivation:dual:partner:%d"
+	// 每日双修次数键
+	DualDailyCountKeyFormat = "cultivation:dual:daily:%d:%s"
+
+	DualInviteTTL      = 5 * time.Minute
+	DualDuration       = 30 * time.Minute // 单次双修时长
+	DualClaimWindow    = 24 * time.Hour   // 期满后的领取期限
+	DualDailyLimit     = 3                // 每日双修次数上限
+	DualGainBonus      = 2.0              // 相对挂机收益的倍率
+	DualGapPerLevel    = 0.05             // 每相差一级的收益修正
+	DualMinGapFactor   = 0.5
+	DualMaxGapFactor   = 2.0
+	DualBondPerSession = 0.02 // 每次双修提升的羁绊加成
+	DualMaxBondBonus   = 0.5
+)
+
+// 中国时区 (UTC+8)，每日双修次数与斗法使用相同的日期边界
+var chinaTimezone *time.Location
+
+func init() {
+	var err error
+	chinaTimezone, err = time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		chinaTimezone = time.FixedZone("CST", 8*60*60)
+		log.Printf("[Dual] 使用固定时区 CST (UTC+8)")
+	}
+}
+
+// ChinaToday 中国时区的今日日期（2006-01-02），每日次数限制统一以此为日期边界
+func ChinaToday() string {
+	return time.Now().In(chinaTimezone).Format("2006-01-02")
+}
+
+// dualBondTitles 羁绊称号（每5次双修提升一级）
+var dualBondTitles = []string{"萍水相逢", "同道中人", "知己", "道侣", "神仙眷侣"}
+
+// DualSession 双修会话（保存在Redis中）
+type DualSession struct {
+	UserAID   uint  `json:"userAId"`
+	UserBID   uint  `json:"userBId"`
+	LevelA    int   `json:"levelA"`
+	LevelB    int   `json:"levelB"`
+	Sessions  int   `json:"sessions"` // 开始时的累计双修次数
+	StartedAt int64 `json:"startedAt"`
+	EndsAt    int64 `json:"endsAt"`
+}
+
+// DualBondInfo 羁绊信息
+type DualBondInfo struct {
+	PartnerID   uint    `json:"partnerId"`
+	PartnerName string  `json:"partnerName,omitempty"`
+	Sessions    int     `json:"sessions"`
+	Title       string  `json:"title"`
+	Bonus       float64 `json:"bonus"`
+}
+
+// DualStatus 双修状态
+type DualStatus struct {
+	Session          *DualSession   `json:"session,omitempty"`
+	RemainingSeconds int64          `json:"remainingSeconds"`
+	Invites          []uint         `json:"invites"` // 待处理的邀请者
+	DailyUsed        int            `json:"dailyUsed"`
+	DailyLimit       int            `json:"dailyLimit"`
+	Bonds            []DualBondInfo `json:"bonds"`
+}
+
+// DualPlayerResult 单个玩家的双修收益
+type DualPlayerResult struct {
+	UserID             uint    `json:"userId"`
+	CultivationGain    float64 `json:"cultivationGain"`
+	CurrentCultivation float64 `json:"currentCultivation"`
+	CultivationFull    bool    `json:"cultivationFull"`
+	GapFactor          float64 `json:"gapFactor"`
+}
+
+// DualResult 双修结算结果
+type DualResult struct {
+	Players []DualPlayerResult `json:"players"`
+	Bond    DualBondInfo       `json:"bond"`
+	Message string             `json:"message"`
+}
+
+// dualPair 按ID大小排列一对玩家
+func dualPair(a, b uint) (uint, uint) {
+	if a > b {
+		return b, a
+	}
+	return a, b
+}
+
+// dualBondBonus 羁绊加成
+func dualBondBonus(sessions int) float64 {
+	return math.Min(DualMaxBondBonus, float64(sessions)*DualBondPerSession)
+}
+
+// dualBondTitle 羁绊称号
+func dualBondTitle(sessions int) string {
+	idx := sessions / 5
+	if idx >= len(dualBondTitles) {
+		idx = len(dualBondTitles) - 1
+	}
+	return dualBondTitles[idx]
+}
+
+// dualGapFactor 境界差修正：与高境界者双修收益提升，与低境界者双修收益降低
+func dualGapFactor(level, partnerLevel int) float64 {
+	factor := 1 + DualGapPerLevel*float64(partnerLevel-level)
+	return math.Max(DualMinGapFactor, math.Min(DualMaxGapFactor, factor))
+}
+
+// dualDailyKey 今日双修次数键
+func dualDailyKey(userID uint) string {
+	return fmt.Sprintf(DualDailyCountKeyFormat, userID, ChinaToday())
+}
+
+// getDualBond 获取两名玩家的羁绊，不存在时返回零值
+func getDualBond(tx *gorm.DB, a, b uint) (*models.DualCultivationBond, error) {
+	a, b = dualPair(a, b)
+	bond := &models.DualCultivationBond{UserAID: a, UserBID: b}
+	err := tx.Where("user_a_id = ? AND user_b_id = ?", a, b).First(bond).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get bond: %w", err)
+	}
+	return bond, nil
+}
+
+// loadDualSession 读取玩家当前的双修会话，不存在时返回 nil
+func loadDualSession(userID uint) (*DualSession, error) {
+	partnerKey := fmt.Sprintf(DualPartnerKeyFormat, userID)
+	partnerID, err := redis.Client.Get(redis.Ctx, partnerKey).Uint64()
+	if errors.Is(err, redisv9.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dual partner: %w", err)
+	}
+
+	a, b := dualPair(userID, uint(partnerID))
+	data, err := redis.Client.Get(redis.Ctx, fmt.Sprintf(DualSessionKeyFormat, a, b)).Result()
+	if errors.Is(err, redisv9.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dual session: %w", err)
+	}
+
+	var session DualSession
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("failed to parse dual session: %w", err)
+	}
+	return &session, nil
+}
+
+// GetDualStatus 获取双修状态、待处理邀请与羁绊
+func (s *CultivationService) GetDualStatus() (*DualStatus, error) {
+	session, err := loadDualSession(s.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &DualStatus{Session: session, DailyLimit: DualDailyLimit, Invites: []uint{}, Bonds: []DualBondInfo{}}
+	if session != nil {
+		status.RemainingSeconds = int64(math.Max(0, float64(session.EndsAt-time.Now().Unix())))
+	}
+	status.DailyUsed, _ = redis.Client.Get(redis.Ctx, dualDailyKey(s.userID)).Int()
+
+	// 先清理已过期的邀请，再读取邀请者
+	invitesKey := fmt.Sprintf(DualInvitesKeyFormat, s.userID)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	redis.Client.ZRemRangeByScore(redis.Ctx, invitesKey, "-inf", now)
+	inviters, err := redis.Client.ZRangeByScore(redis.Ctx, invitesKey, &redisv9.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err == nil {
+		for _, member := range inviters {
+			if inviter, err := strconv.ParseUint(member, 10, 64); err == nil {
+				status.Invites = append(status.Invites, uint(inviter))
+			}
+		}
+	}
+
+	var bonds []models.DualCultivationBond
+	if err := db.DB.Where("user_a_id = ? OR user_b_id = ?", s.userID, s.userID).
+		Order("sessions DESC").Find(&bonds).Error; err != nil {
+		return nil, fmt.Errorf("failed to get bonds: %w", err)
+	}
+	for _, bond := range bonds {
+		partnerID := bond.UserAID
+		if partnerID == s.userID {
+			partnerID = bond.UserBID
+		}
+		var partner models.User
+		db.DB.Select("id", "player_name").First(&partner, partnerID)
+		status.Bonds = append(status.Bonds, DualBondInfo{
+			PartnerID:   partnerID,
+			PartnerName: partner.PlayerName,
+			Sessions:    bond.Sessions,
+			Title:       dualBondTitle(bond.Sessions),
+			Bonus:       dualBondBonus(bond.Sessions),
+		})
+	}
+	return status, nil
+}
+
+// checkDualAvailable 检查玩家能否开始双修
+func checkDualAvailable(userID uint, who string) error {
+	if !isPlayerOnline(userID) {
+		return fmt.Errorf("%s不在线", who)
+	}
+	if IsInRetreat(userID) {
+		return fmt.Errorf("%s正在闭关", who)
+	}
+	if session, err := loadDualSession(userID); err != nil {
+		return err
+	} else if session != nil {
+		return fmt.Errorf("%s正在双修", who)
+	}
+	if used, _ := redis.Client.Get(redis.Ctx, dualDailyKey(userID)).Int(); used >= DualDailyLimit {
+		return fmt.Errorf("%s今日双修次数已用完", who)
+	}
+	return nil
+}
+
+// InviteDualCultivation 邀请在线玩家双修
+func (s *CultivationService) InviteDualCultivation(targetID uint) error {
+	if targetID == s.userID {
+		return fmt.Errorf("不能邀请自己双修")
+	}
+	var target models.User
+	if err := db.DB.First(&target, targetID).Error; err != nil {
+		return fmt.Errorf("对方不存在")
+	}
+	if err := checkDualAvailable(s.userID, "道友"); err != nil {
+		return err
+	}
+	if err := checkDualAvailable(targetID, "对方"); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf(DualInviteKeyFormat, targetID, s.userID)
+	if err := redis.Client.Set(redis.Ctx, key, time.Now().Unix(), DualInviteTTL).Err(); err != nil {
+		return err
+	}
+	invitesKey := fmt.Sprintf(DualInvitesKeyFormat, targetID)
+	expiresAt := time.Now().Add(DualInviteTTL).Unix()
+	redis.Client.ZAdd(redis.Ctx, invitesKey, redisv9.Z{Score: float64(expiresAt), Member: s.userID})
+	redis.Client.Expire(redis.Ctx, invitesKey, DualInviteTTL)
+	return nil
+}
+
+// AcceptDualCultivation 接受双修邀请，开始双修会话
+func (s *CultivationService) AcceptDualCultivation(inviterID uint) (*DualSession, error) {
+	inviteKey := fmt.Sprintf(DualInviteKeyFormat, s.userID, inviterID)
+	redis.Client.ZRem(redis.Ctx, fmt.Sprintf(DualInvitesKeyFormat, s.userID), inviterID)
+	if err := redis.Client.GetDel(redis.Ctx, inviteKey).Err(); errors.Is(err, redisv9.Nil) {
+		return nil, fmt.Errorf("邀请不存在或已过期")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load invite: %w", err)
+	}
+
+	if err := checkDualAvailable(s.userID, "道友"); err != nil {
+		return nil, err
+	}
+	if err := checkDualAvailable(inviterID, "对方"); err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	if err := db.DB.Where("id IN ?", []uint{s.userID, inviterID}).Find(&users).Error; err != nil || len(users) != 2 {
+		return nil, fmt.Errorf("获取双修玩家失败")
+	}
+	levels := map[uint]int{users[0].ID: users[0].Level, users[1].ID: users[1].Level}
+
+	a, b := dualPair(s.userID, inviterID)
+	bond, err := getDualBond(db.DB, a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &DualSession{
+		UserAID:   a,
+		UserBID:   b,
+		LevelA:    levels[a],
+		LevelB:    levels[b],
+		Sessions:  bond.Sessions,
+		StartedAt: now.Unix(),
+		EndsAt:    now.Add(DualDuration).Unix(),
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := DualDuration + DualClaimWindow
+	// 先占用双方的双修对象，任一方已在双修时整体回滚，防止同时进行多场双修
+	var claimed []string
+	rollback := func() {
+		if len(claimed) > 0 {
+			redis.Client.Del(redis.Ctx, claimed...)
+		}
+	}
+	for _, pair := range [][2]uint{{a, b}, {b, a}} {
+		partnerKey := fmt.Sprintf(DualPartnerKeyFormat, pair[0])
+		ok, err := redis.Client.SetNX(redis.Ctx, partnerKey, pair[1], ttl).Result()
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to save dual partner: %w", err)
+		}
+		if !ok {
+			rollback()
+			return nil, fmt.Errorf("双修已在进行中")
+		}
+		claimed = append(claimed, partnerKey)
+	}
+
+	// 以自增结果判断每日次数，超出上限时撤销
+	var counted []string
+	for _, id := range []uint{a, b} {
+		key := dualDailyKey(id)
+		used, err := redis.Client.Incr(redis.Ctx, key).Result()
+		if err == nil {
+			redis.Client.Expire(redis.Ctx, key, 48*time.Hour)
+			counted = append(counted, key)
+		}
+		if err != nil || used > DualDailyLimit {
+			for _, k := range counted {
+				redis.Client.Decr(redis.Ctx, k)
+			}
+			rollback()
+			if err != nil {
+				return nil, fmt.Errorf("failed to update dual count: %w", err)
+			}
+			return nil, fmt.Errorf("今日双修次数已用完")
+		}
+	}
+
+	sessionKey := fmt.Sprintf(DualSessionKeyFormat, a, b)
+	if ok, err := redis.Client.SetNX(redis.Ctx, sessionKey, string(data), ttl).Result(); err != nil || !ok {
+		for _, k := range counted {
+			redis.Client.Decr(redis.Ctx, k)
+		}
+		rollback()
+		if err != nil {
+			return nil, fmt.Errorf("failed to save dual session: %w", err)
+		}
+		return nil, fmt.Errorf("双修已在进行中")
+	}
+
+	log.Printf("[Dual] 玩家 %d 与 %d 开始双修", a, b)
+	return session, nil
+}
+
+// calculateDualGain 计算双修修为：挂机收益 × 双修倍率 × 境界差修正 × (1 + 羁绊加成)
+func calculateDualGain(level, partnerLevel int, cultivationRate float64, technique *TechniqueBonus, sessions int) float64 {
+	gain := calculateIdleGain(level, cultivationRate, technique, DualDuration.Seconds()) *
+		DualGainBonus * dualGapFactor(level, partnerLevel) * (1 + dualBondBonus(sessions))
+	return math.Round(gain*10) / 10
+}
+
+// ClaimDualCultivation 双修期满后结算：在同一事务中为双方增加修为并提升羁绊
+func (s *CultivationService) ClaimDualCultivation() (*DualResult, error) {
+	session, err := loadDualSession(s.userID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("道友当前没有双修")
+	}
+	if remaining := session.EndsAt - time.Now().Unix(); remaining > 0 {
+		return nil, fmt.Errorf("双修尚未圆满，还需%d分钟", (remaining+59)/60)
+	}
+
+	// 原子取出会话，防止双方重复结算
+	sessionKey := fmt.Sprintf(DualSessionKeyFormat, session.UserAID, session.UserBID)
+	if err := redis.Client.GetDel(redis.Ctx, sessionKey).Err(); errors.Is(err, redisv9.Nil) {
+		return nil, fmt.Errorf("双修已结算")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load dual session: %w", err)
+	}
+	redis.Client.Del(redis.Ctx,
+		fmt.Sprintf(DualPartnerKeyFormat, session.UserAID),
+		fmt.Sprintf(DualPartnerKeyFormat, session.UserBID))
+
+	result := &DualResult{}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var users []models.User
+		if err := tx.Where("id IN ?", []uint{session.UserAID, session.UserBID}).
+			Order("id").Find(&users).Error; err != nil {
+			return fmt.Errorf("failed to get users: %w", err)
+		}
+		if len(users) != 2 {
+			return fmt.Errorf("双修玩家不存在")
+		}
+
+		levels := [2]int{session.LevelA, session.LevelB}
+		for i := range users {
+			user := &users[i]
+			attrs := s.getPlayerAttributes(user)
+			gain := calculateDualGain(levels[i], levels[1-i], getAttrFloat(attrs, "cultivationRate", 1.0),
+				GetTechniqueBonus(user.ID, user.Level), session.Sessions)
+
+			// 修为不超过当前境界上限，突破仍需手动修炼
+			player := DualPlayerResult{UserID: user.ID, GapFactor: dualGapFactor(levels[i], levels[1-i])}
+			if room := user.MaxCultivation - user.Cultivation; gain > room {
+				gain = math.Max(0, math.Round(room*10)/10)
+				player.CultivationFull = true
+			}
+			if gain > 0 {
+				if err := tx.Model(user).Update("cultivation",
+					gorm.Expr("LEAST(cultivation + ?, max_cultivation)", gain)).Error; err != nil {
+					return fmt.Errorf("failed to update user: %w", err)
+				}
+			}
+			player.CultivationGain = gain
+			player.CurrentCultivation = math.Min(user.MaxCultivation, user.Cultivation+gain)
+			result.Players = append(result.Players, player)
+		}
+
+		bond, err := getDualBond(tx, session.UserAID, session.UserBID)
+		if err != nil {
+			return err
+		}
+		bond.Sessions++
+		bond.LastSessionAt = time.Now()
+		if err := tx.Save(bond).Error; err != nil {
+			return fmt.Errorf("failed to update bond: %w", err)
+		}
+
+		partnerID := session.UserAID
+		if partnerID == s.userID {
+			partnerID = session.UserBID
+		}
+		result.Bond = DualBondInfo{
+			PartnerID: partnerID,
+			Sessions:  bond.Sessions,
+			Title:     dualBondTitle(bond.Sessions),
+			Bonus:     dualBondBonus(bond.Sessions),
+		}
+		return nil
+	})
+	if err != nil {
+		// 结算失败：恢复会话以便重试（NX，不覆盖期间新开始的双修）
+		data, _ := json.Marshal(session)
+		redis.Client.SetNX(redis.Ctx, sessionKey, string(data), DualClaimWindow)
+		redis.Client.SetNX(redis.Ctx, fmt.Sprintf(DualPartnerKeyFormat, session.UserAID), session.UserBID, DualClaimWindow)
+		redis.Client.SetNX(redis.Ctx, fmt.Sprintf(DualPartnerKeyFormat, session.UserBID), session.UserAID, DualClaimWindow)
+		return nil, err
+	}
+
+	for _, p := range result.Players {
+		if p.UserID == s.userID {
+			result.Message = fmt.Sprintf("阴阳调和，双修圆满，修为增加%.1f，羁绊「%s」", p.CultivationGain, result.Bond.Title)
+		}
+	}
+	log.Printf("[Dual] 玩家 %d 与 %d 双修结算完成，累计 %d 次", session.UserAID, session.UserBID, result.Bond.Sessions)
+	return result, nil
+}
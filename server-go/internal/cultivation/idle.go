@@ -0,0 +1,274 @@
+package cultivation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/redis"
+)
+
+// 挂机修炼相关常量
+const (
+	IdleOfflineKeyFormat = "cultivation:idle:offline:%d" // 离线挂机记录（哈希：since 离线时间毫秒，claimed 已结算的有效秒数）
+	IdleMaxDuration      = 12 * time.Hour                // 单次离线最多结算时长
+	IdleMinDuration      = time.Minute                   // 少于该时长不结算
+	IdleEfficiency       = 0.5                           // 挂机效率（相对于手动打坐）
+	idleKeyTTL           = 30 * 24 * time.Hour
+)
+
+// idleDecayTiers 挂机收益递减档位：超过 Until 之前的时长按 Rate 计算
+var idleDecayTiers = []struct {
+	Until time.Duration
+	Rate  float64
+}{
+	{Until: 2 * time.Hour, Rate: 1.0},
+	{Until: 6 * time.Hour, Rate: 0.6},
+	{Until: IdleMaxDuration, Rate: 0.3},
+}
+
+// effectiveIdleSeconds 按递减档位折算有效挂机秒数（超过上限的部分不计）
+func effectiveIdleSeconds(elapsed time.Duration) float64 {
+	if elapsed > IdleMaxDuration {
+		elapsed = IdleMaxDuration
+	}
+	effective := 0.0
+	var prev time.Duration
+	for _, tier := range idleDecayTiers {
+		if elapsed <= prev {
+			break
+		}
+		span := tier.Until - prev
+		if elapsed < tier.Until {
+			span = elapsed - prev
+		}
+		effective += span.Seconds() * tier.Rate
+		prev = tier.Until
+	}
+	return effective
+}
+
+// calculateIdleGain 计算挂机修为：挂机期间积攒的灵力按打坐消耗折算为打坐次数
+func calculateIdleGain(level int, cultivationRate float64, technique *TechniqueBonus, effectiveSeconds float64) float64 {
+	spiritGained := CalculateSpiritRateByLevel(level) * effectiveSeconds
+	times := spiritGained / getCurrentCultivationCost(level)
+	gain := times * getCurrentCultivationGain(level) * cultivationRate * (1 + technique.CultivationRate) * IdleEfficiency
+	return math.Round(gain*10) / 10
+}
+
+// idleSession 一次离线挂机：从离线时刻起按总离线时长递减，已结算的有效秒数不再重复计算
+type idleSession struct {
+	Since   time.Time
+	Claimed int64
+}
+
+// loadIdleSession 读取离线挂机记录，不存在时返回 nil
+func loadIdleSession(userID uint) (*idleSession, error) {
+	data, err := redis.Client.HGetAll(redis.Ctx, fmt.Sprintf(IdleOfflineKeyFormat, userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load idle session: %w", err)
+	}
+	since, err := strconv.ParseInt(data["since"], 10, 64)
+	if err != nil || since <= 0 {
+		return nil, nil
+	}
+	claimed, _ := strconv.ParseInt(data["claimed"], 10, 64)
+	return &idleSession{Since: time.UnixMilli(since), Claimed: claimed}, nil
+}
+
+// MarkIdleOffline 记录玩家离线时刻，开始累计挂机修为（登出或心跳超时时调用）
+// 已有离线记录时保留原时刻，重复登出不会重置递减；闭关中则从闭关期满时刻起算
+func MarkIdleOffline(userID uint, at time.Time) {
+	if retreat, err := loadRetreat(userID); err != nil {
+		log.Printf("[Idle] 读取玩家 %d 闭关状态失败: %v", userID, err)
+		return
+	} else if retreat != nil && retreat.EndsAt > at.Unix() {
+		at = time.Unix(retreat.EndsAt, 0)
+	}
+	key := fmt.Sprintf(IdleOfflineKeyFormat, userID)
+	if ok, err := redis.Client.HSetNX(redis.Ctx, key, "since", at.UnixMilli()).Result(); err != nil {
+		log.Printf("[Idle] 记录玩家 %d 离线时间失败: %v", userID, err)
+		return
+	} else if ok {
+		redis.Client.HSet(redis.Ctx, key, "claimed", 0)
+	}
+	redis.Client.Expire(redis.Ctx, key, idleKeyTTL)
+}
+
+// endIdleSession 玩家重新上线，结束本次离线挂机
+func endIdleSession(userID uint) {
+	redis.Client.Del(redis.Ctx, fmt.Sprintf(IdleOfflineKeyFormat, userID))
+}
+
+// isPlayerOnline 玩家是否在线
+func isPlayerOnline(userID uint) bool {
+	n, err := redis.Client.Exists(redis.Ctx, fmt.Sprintf("player:online:%d", userID)).Result()
+	return err == nil && n > 0
+}
+
+// buildIdleSummary 生成挂机收益汇总（不修改数据），effective 为本次结算的有效秒数
+func (s *CultivationService) buildIdleSummary(user *models.User, elapsed time.Duration, effective float64) *IdleCultivationSummary {
+	attrs := s.getPlayerAttributes(user)
+	cultivationRate := attrs["cultivationRate"].(float64)
+
+	gain := calculateIdleGain(user.Level, cultivationRate, GetTechniqueBonus(user.ID, user.Level), effective)
+
+	// 修为不超过当前境界上限，突破仍需手动修炼
+	capped := false
+	if room := user.MaxCultivation - user.Cultivation; gain > room {
+		gain = math.Max(0, math.Round(room*10)/10)
+		capped = true
+	}
+
+	return &IdleCultivationSummary{
+		OfflineSeconds:   int64(elapsed.Seconds()),
+		EffectiveSeconds: int64(effective),
+		DurationCapped:   elapsed > IdleMaxDuration,
+		CultivationGain:  gain,
+		CultivationFull:  capped,
+		Message:          buildIdleMessage(elapsed, gain, capped),
+	}
+}
+
+// buildIdleMessage 构建挂机收益提示
+func buildIdleMessage(elapsed time.Duration, gain float64, capped bool) string {
+	if elapsed > IdleMaxDuration {
+		elapsed = IdleMaxDuration
+	}
+	hours := int(elapsed.Hours())
+	minutes := int(elapsed.Minutes()) % 60
+	msg := fmt.Sprintf("道友闭目调息%d小时%d分钟，周天运转，修为增加%.1f", hours, minutes, gain)
+	if capped {
+		msg += "，修为已至瓶颈，需亲自修炼方可突破"
+	}
+	return msg
+}
+
+// pendingIdleSeconds 本次离线尚未结算的有效秒数：按总离线时长递减后扣除已结算部分
+func pendingIdleSeconds(session *idleSession, now time.Time) (time.Duration, int64) {
+	elapsed := now.Sub(session.Since)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return elapsed, int64(effectiveIdleSeconds(elapsed)) - session.Claimed
+}
+
+// retreatBlocksIdle 闭关未满期间不累计挂机修为；读取失败时按闭关中处理
+func retreatBlocksIdle(userID uint, now time.Time) (bool, error) {
+	retreat, err := loadRetreat(userID)
+	if err != nil {
+		return true, err
+	}
+	return retreat != nil && now.Unix() < retreat.EndsAt, nil
+}
+
+// PreviewIdleCultivation 预览当前可领取的挂机修为
+func (s *CultivationService) PreviewIdleCultivation() (*IdleCultivationSummary, error) {
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	session, err := loadIdleSession(s.userID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return &IdleCultivationSummary{Message: "道友离线后方可挂机修炼"}, nil
+	}
+
+	now := time.Now()
+	if blocked, err := retreatBlocksIdle(s.userID, now); err != nil {
+		return nil, err
+	} else if blocked {
+		return &IdleCultivationSummary{Message: "道友闭关中，挂机修为暂停累计"}, nil
+	}
+
+	elapsed, pending := pendingIdleSeconds(session, now)
+	if elapsed < IdleMinDuration || pending <= 0 {
+		return &IdleCultivationSummary{OfflineSeconds: int64(elapsed.Seconds())}, nil
+	}
+	return s.buildIdleSummary(&user, elapsed, float64(pending)), nil
+}
+
+// ErrIdleWhileOnline 在线期间不能领取离线挂机修为
+var ErrIdleWhileOnline = errors.New("道友在线中，离线挂机修为将在上线时自动结算")
+
+// ClaimIdleCultivation 结算离线期间的挂机修为
+// 收益按本次离线的总时长递减计算，多次领取只结算新增部分；玩家在线时拒绝领取
+func (s *CultivationService) ClaimIdleCultivation() (*IdleCultivationSummary, error) {
+	if isPlayerOnline(s.userID) {
+		return nil, ErrIdleWhileOnline
+	}
+	session, err := loadIdleSession(s.userID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return &IdleCultivationSummary{Message: "道友离线后方可挂机修炼"}, nil
+	}
+	return s.claimIdleSession(session)
+}
+
+// claimIdleSession 结算离线挂机记录中尚未结算的修为
+func (s *CultivationService) claimIdleSession(session *idleSession) (*IdleCultivationSummary, error) {
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	now := time.Now()
+	if blocked, err := retreatBlocksIdle(s.userID, now); err != nil {
+		return nil, err
+	} else if blocked {
+		return &IdleCultivationSummary{Message: "道友闭关中，挂机修为暂停累计"}, nil
+	}
+
+	elapsed, pending := pendingIdleSeconds(session, now)
+	if elapsed < IdleMinDuration || pending <= 0 {
+		return &IdleCultivationSummary{OfflineSeconds: int64(elapsed.Seconds())}, nil
+	}
+
+	// 原子累加已结算秒数，结果与预期不符说明有并发领取，撤销本次累加
+	key := fmt.Sprintf(IdleOfflineKeyFormat, s.userID)
+	claimed, err := redis.Client.HIncrBy(redis.Ctx, key, "claimed", pending).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update idle session: %w", err)
+	}
+	redis.Client.Expire(redis.Ctx, key, idleKeyTTL)
+	if claimed != session.Claimed+pending {
+		redis.Client.HIncrBy(redis.Ctx, key, "claimed", -pending)
+		return &IdleCultivationSummary{OfflineSeconds: int64(elapsed.Seconds())}, nil
+	}
+
+	summary := s.buildIdleSummary(&user, elapsed, float64(pending))
+	if summary.CultivationGain > 0 {
+		if err := db.DB.Model(&user).Update("cultivation",
+			gorm.Expr("LEAST(cultivation + ?, max_cultivation)", summary.CultivationGain)).Error; err != nil {
+			redis.Client.HIncrBy(redis.Ctx, key, "claimed", -pending)
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+	summary.CurrentCultivation = math.Min(user.MaxCultivation, user.Cultivation+summary.CultivationGain)
+
+	return summary, nil
+}
+
+// SettleOfflineCultivation 玩家上线（登录、重新标记在线或心跳）时结算离线挂机修为并结束本次离线
+// 没有离线记录时返回 nil
+func (s *CultivationService) SettleOfflineCultivation() (*IdleCultivationSummary, error) {
+	session, err := loadIdleSession(s.userID)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	summary, err := s.claimIdleSession(session)
+	endIdleSession(s.userID)
+	return summary, err
+}
@@ -1,21 +1,12 @@
 package cultivation
 
-// 修炼相关常量
+import "xiuxian/server-go/internal/alchemy"
+
+// 修炼相关常量（等级相关的消耗与成长见境界表 realms.json）
 const (
-	BaseCultivationCost       = 10  // 基础修炼消耗灵力
-	BaseCultivationGain       = 1   // 基础修炼获得修为
-	CultivationCostMultiplier = 1.5 // 等级消耗倍率
-	CultivationGainMultiplier = 1.2 // 等级获得倍率
-	BaseGainRate              = 1   // 基础灵力获取速率
-	BreakthroughReward        = 100 // 突破奖励倍数
-	BreakthroughBonus         = 1.2 // 突破灵力获取倍率提升
-	ExtraCultivationChance    = 0.3 // 额外修为概率基数
-	// ✅ 新增：聚灵阵相关常量
-	BaseFormationCost       = 10   // 基础聚灵阵消耗灵石
-	BaseFormationGain       = 5    // 基础聚灵阵获得修为
-	FormationCostMultiplier = 1.25 // 聚灵阵消耗倍率
-	FormationGainMultiplier = 1.12 // 聚灵阵获得倍率
-	FormationInterval       = 1000 // 聚灵阵间隔时间（毫秒）
+	BaseGainRate           = 1    // 基础灵力获取速率
+	ExtraCultivationChance = 0.3  // 额外修为概率基数
+	FormationInterval      = 1000 // 聚灵阵间隔时间（毫秒）
 )
 
 // 修炼请求
@@ -72,6 +63,17 @@ type AutoCultivationResponse struct {
 	Error                string        `json:"error,omitempty"`
 }
 
+// IdleCultivationSummary 挂机修炼收益汇总
+type IdleCultivationSummary struct {
+	OfflineSeconds     int64   `json:"offlineSeconds"`     // 本次离线的总时长（秒）
+	EffectiveSeconds   int64   `json:"effectiveSeconds"`   // 本次结算的有效时长（秒，按总离线时长递减）
+	DurationCapped     bool    `json:"durationCapped"`     // 是否超过单次结算上限
+	CultivationGain    float64 `json:"cultivationGain"`    // 获得的修为
+	CultivationFull    bool    `json:"cultivationFull"`    // 修为是否已达当前境界上限
+	CurrentCultivation float64 `json:"currentCultivation"` // 结算后修为
+	Message            string  `json:"message,omitempty"`
+}
+
 // 修炼数据
 type CultivationData struct {
 	ID              uint    `json:"id"`         // 用户ID
@@ -97,13 +99,23 @@ type CultivationData struct {
 	CombatResistance  map[string]interface{} `json:"combatResistance,omitempty"`  // 战斗抗性
 	SpecialAttributes map[string]interface{} `json:"specialAttributes,omitempty"` // 特殊属性
 	// ✅ 修改：聚灵阵相关数据通过baseAttributes返回，不新增单独字段
+	Toxicity alchemy.ToxicityState `json:"toxicity"` // 当前丹毒（已按时间消退）
 }
 
-// 境界信息
+// 境界信息（由境界表按等级展开）
 type RealmInfo struct {
-	Level          int     `json:"level"`
-	Name           string  `json:"name"`
-	MaxCultivation float64 `json:"maxCultivation"`
+	Level           int                `json:"level"`
+	Name            string             `json:"name"`
+	MajorRealm      string             `json:"majorRealm"`
+	Layer           int                `json:"layer"`
+	MaxCultivation  float64            `json:"maxCultivation"`
+	BaseAttributes  map[string]float64 `json:"baseAttributes"`  // 基础属性：speed/attack/health/defense
+	SpiritRate      float64            `json:"spiritRate"`      // 灵力倍率
+	CultivationCost float64            `json:"cultivationCost"` // 打坐消耗灵力
+	CultivationGain float64            `json:"cultivationGain"` // 打坐获得修为
+	FormationCost   int                `json:"formationCost"`   // 聚灵阵消耗灵石
+	FormationGain   float64            `json:"formationGain"`   // 聚灵阵获得修为
+	Tribulation     *TribulationConfig `json:"tribulation,omitempty"`
 }
 
 // 玩家修炼统计
@@ -0,0 +1,364 @@
+package cultivation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/alchemy"
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/redis"
+)
+
+const (
+	// 闭关状态键
+	RetreatKeyFormat = "cultivation:retreat:%d"
+	// 闭关状态在结束后的保留时间，超时未出关视为放弃
+	RetreatClaimWindow = 7 * 24 * time.Hour
+	// 提前出关损失的修为比例
+	RetreatEarlyExitPenalty = 0.5
+	// 顿悟时修为收益倍率
+	RetreatEnlightenmentMultiplier = 1.5
+)
+
+// RetreatOption 闭关时长选项
+type RetreatOption struct {
+	ID                  string        `json:"id"`
+	Name                string        `json:"name"`
+	Duration            time.Duration `json:"-"`
+	DurationSeconds     int64         `json:"durationSeconds"`
+	SpiritCostTimes     float64       `json:"spiritCostTimes"`     // 入关灵力消耗 = 打坐消耗 × 该值
+	Bonus               float64       `json:"bonus"`               // 相对挂机收益的倍率（不受挂机递减影响）
+	EnlightenmentChance float64       `json:"enlightenmentChance"` // 出关时顿悟概率
+}
+
+// retreatOptions 闭关时长配置
+var retreatOptions = []RetreatOption{
+	{ID: "1h", Name: "小闭关", Duration: time.Hour, SpiritCostTimes: 10, Bonus: 1.3, EnlightenmentChance: 0.05},
+	{ID: "4h", Name: "闭关", Duration: 4 * time.Hour, SpiritCostTimes: 30, Bonus: 1.5, EnlightenmentChance: 0.08},
+	{ID: "8h", Name: "死关", Duration: 8 * time.Hour, SpiritCostTimes: 50, Bonus: 1.8, EnlightenmentChance: 0.12},
+}
+
+func init() {
+	for i := range retreatOptions {
+		retreatOptions[i].DurationSeconds = int64(retreatOptions[i].Duration.Seconds())
+	}
+}
+
+// GetRetreatOptions 获取闭关时长选项
+func GetRetreatOptions() []RetreatOption {
+	return retreatOptions
+}
+
+// getRetreatOption 根据ID获取闭关时长选项
+func getRetreatOption(optionID string) *RetreatOption {
+	for i := range retreatOptions {
+		if retreatOptions[i].ID == optionID {
+			return &retreatOptions[i]
+		}
+	}
+	return nil
+}
+
+// RetreatSession 闭关状态（保存在Redis中）
+type RetreatSession struct {
+	OptionID   string  `json:"optionId"`
+	Level      int     `json:"level"` // 入关时的等级，收益按此计算
+	SpiritCost float64 `json:"spiritCost"`
+	StartedAt  int64   `json:"startedAt"` // 秒
+	EndsAt     int64   `json:"endsAt"`    // 秒
+}
+
+// RetreatStatus 闭关状态查询结果
+type RetreatStatus struct {
+	InRetreat        bool            `json:"inRetreat"`
+	Session          *RetreatSession `json:"session,omitempty"`
+	Completed        bool            `json:"completed"`
+	RemainingSeconds int64           `json:"remainingSeconds"`
+	Options          []RetreatOption `json:"options"`
+}
+
+// RetreatResult 出关结果
+type RetreatResult struct {
+	OptionID           string  `json:"optionId"`
+	Completed          bool    `json:"completed"` // false 表示提前出关
+	ElapsedSeconds     int64   `json:"elapsedSeconds"`
+	CultivationGain    float64 `json:"cultivationGain"`
+	Forfeited          float64 `json:"forfeited,omitempty"` // 提前出关损失的修为
+	Enlightenment      bool    `json:"enlightenment"`
+	CultivationFull    bool    `json:"cultivationFull"`
+	CurrentCultivation float64 `json:"currentCultivation"`
+	Detoxified         float64 `json:"detoxified,omitempty"` // 闭关化解的丹毒
+	Message            string  `json:"message"`
+}
+
+// loadRetreat 读取闭关状态，不存在时返回 nil
+func loadRetreat(userID uint) (*RetreatSession, error) {
+	key := fmt.Sprintf(RetreatKeyFormat, userID)
+	data, err := redis.Client.Get(redis.Ctx, key).Result()
+	if errors.Is(err, redisv9.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retreat: %w", err)
+	}
+
+	var session RetreatSession
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("failed to parse retreat: %w", err)
+	}
+	return &session, nil
+}
+
+// IsInRetreat 玩家是否处于闭关中（闭关期间无法探索与斗法，需出关后解除）
+// 读取失败时按闭关中处理，避免 Redis 异常时绕过限制
+func IsInRetreat(userID uint) bool {
+	session, err := loadRetreat(userID)
+	if err != nil {
+		log.Printf("[Retreat] %v", err)
+		return true
+	}
+	return session != nil
+}
+
+// GetRetreatStatus 获取闭关状态
+func (s *CultivationService) GetRetreatStatus() (*RetreatStatus, error) {
+	session, err := loadRetreat(s.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &RetreatStatus{Options: retreatOptions}
+	if session == nil {
+		return status, nil
+	}
+	status.InRetreat = true
+	status.Session = session
+	status.RemainingSeconds = int64(math.Max(0, float64(session.EndsAt-time.Now().Unix())))
+	status.Completed = status.RemainingSeconds == 0
+	return status, nil
+}
+
+// StartRetreat 开始闭关：按时长扣除灵力，并先结算已有的挂机修为
+func (s *CultivationService) StartRetreat(optionID string) (*RetreatSession, error) {
+	option := getRetreatOption(optionID)
+	if option == nil {
+		return nil, fmt.Errorf("无效的闭关时长")
+	}
+	if existing, err := loadRetreat(s.userID); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, fmt.Errorf("道友已在闭关中")
+	}
+	if session, err := loadDualSession(s.userID); err != nil {
+		return nil, err
+	} else if session != nil {
+		return nil, fmt.Errorf("道友正在双修，无法闭关")
+	}
+	if battle, err := s.LoadTribulationBattle(); err != nil {
+		return nil, err
+	} else if battle != nil {
+		return nil, fmt.Errorf("天劫之战进行中，无法闭关")
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Cultivation >= user.MaxCultivation {
+		return nil, fmt.Errorf("修为已至瓶颈，请先突破再行闭关")
+	}
+
+	// 结算入关前的挂机修为并结束本次离线，避免与闭关收益重叠
+	if _, err := s.SettleOfflineCultivation(); err != nil {
+		return nil, err
+	}
+
+	spiritCost := math.Round(getCurrentCultivationCost(user.Level) * option.SpiritCostTimes)
+	result := db.DB.Model(&models.User{}).
+		Where("id = ? AND spirit >= ?", s.userID, spiritCost).
+		Update("spirit", gorm.Expr("spirit - ?", spiritCost))
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("灵力不足，闭关需要%.0f灵力", spiritCost)
+	}
+
+	now := time.Now()
+	session := &RetreatSession{
+		OptionID:   option.ID,
+		Level:      user.Level,
+		SpiritCost: spiritCost,
+		StartedAt:  now.Unix(),
+		EndsAt:     now.Add(option.Duration).Unix(),
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf(RetreatKeyFormat, s.userID)
+	ok, err := redis.Client.SetNX(redis.Ctx, key, string(data), option.Duration+RetreatClaimWindow).Result()
+	if err != nil || !ok {
+		// 写入失败或并发重复入关：退还灵力
+		db.DB.Model(&models.User{}).Where("id = ?", s.userID).Update("spirit", gorm.Expr("spirit + ?", spiritCost))
+		if err != nil {
+			return nil, fmt.Errorf("failed to save retreat: %w", err)
+		}
+		return nil, fmt.Errorf("道友已在闭关中")
+	}
+
+	log.Printf("[Retreat] 玩家 %d 开始%s（%s），消耗灵力 %.0f", s.userID, option.Name, option.ID, spiritCost)
+	return session, nil
+}
+
+// calculateRetreatGain 计算闭关期满的修为：挂机收益 × 闭关倍率，不受挂机递减影响
+func calculateRetreatGain(level int, cultivationRate float64, technique *TechniqueBonus, option *RetreatOption) float64 {
+	gain := calculateIdleGain(level, cultivationRate, technique, option.Duration.Seconds()) * option.Bonus
+	return math.Round(gain*10) / 10
+}
+
+// ClaimRetreat 出关：期满领取全部收益并有几率顿悟；未满期则按已闭关时长折算并损失部分修为
+func (s *CultivationService) ClaimRetreat() (*RetreatResult, error) {
+	key := fmt.Sprintf(RetreatKeyFormat, s.userID)
+	// 原子取出闭关状态，防止重复领取
+	data, err := redis.Client.GetDel(redis.Ctx, key).Result()
+	if errors.Is(err, redisv9.Nil) {
+		return nil, fmt.Errorf("道友尚未闭关")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retreat: %w", err)
+	}
+	var session RetreatSession
+	if err := json.Unmarshal([]byte(data), &session); err != nil {
+		return nil, fmt.Errorf("failed to parse retreat: %w", err)
+	}
+	option := getRetreatOption(session.OptionID)
+	if option == nil {
+		return nil, fmt.Errorf("无效的闭关时长")
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	attrs := s.getPlayerAttributes(&user)
+	cultivationRate := getAttrFloat(attrs, "cultivationRate", 1.0)
+
+	now := time.Now()
+	elapsed := now.Unix() - session.StartedAt
+	fullGain := calculateRetreatGain(session.Level, cultivationRate, GetTechniqueBonus(user.ID, user.Level), option)
+
+	result := &RetreatResult{
+		OptionID:       option.ID,
+		Completed:      now.Unix() >= session.EndsAt,
+		ElapsedSeconds: elapsed,
+	}
+	if result.Completed {
+		result.ElapsedSeconds = int64(option.Duration.Seconds())
+		result.CultivationGain = fullGain
+		if rand.Float64() < option.EnlightenmentChance {
+			result.Enlightenment = true
+			result.CultivationGain = math.Round(fullGain*RetreatEnlightenmentMultiplier*10) / 10
+		}
+	} else {
+		earned := fullGain * float64(elapsed) / option.Duration.Seconds()
+		result.CultivationGain = math.Round(earned*(1-RetreatEarlyExitPenalty)*10) / 10
+		result.Forfeited = math.Round((earned-result.CultivationGain)*10) / 10
+	}
+
+	// 修为不超过当前境界上限，突破仍需手动修炼
+	if room := user.MaxCultivation - user.Cultivation; result.CultivationGain > room {
+		result.CultivationGain = math.Max(0, math.Round(room*10)/10)
+		result.CultivationFull = true
+	}
+	if result.CultivationGain > 0 {
+		if err := db.DB.Model(&user).Update("cultivation",
+			gorm.Expr("LEAST(cultivation + ?, max_cultivation)", result.CultivationGain)).Error; err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	}
+	result.CurrentCultivation = math.Min(user.MaxCultivation, user.Cultivation+result.CultivationGain)
+
+	// 闭关运功化解丹毒，按实际闭关时长计算
+	detoxified, err := retreatDetox(s.userID, float64(result.ElapsedSeconds)/3600*alchemy.RetreatDetoxPerHour, now)
+	if err != nil {
+		log.Printf("[Retreat] 玩家 %d 更新丹毒失败: %v", s.userID, err)
+	}
+	result.Detoxified = detoxified
+
+	// 闭关期间离线的挂机修为从期满时刻起算，提前出关则改为从出关时刻起算
+	if idle, err := loadIdleSession(s.userID); err == nil && idle != nil && idle.Since.After(now) {
+		idleKey := fmt.Sprintf(IdleOfflineKeyFormat, s.userID)
+		redis.Client.HSet(redis.Ctx, idleKey, "since", now.UnixMilli(), "claimed", 0)
+	}
+
+	result.Message = buildRetreatMessage(option, result)
+	log.Printf("[Retreat] 玩家 %d 出关（%s），完成=%v，修为+%.1f，顿悟=%v",
+		s.userID, option.ID, result.Completed, result.CultivationGain, result.Enlightenment)
+	return result, nil
+}
+
+// retreatDetox 锁定玩家行后重新读取基础属性并化解丹毒，只改动丹毒字段，返回实际化解量
+func retreatDetox(userID uint, detox float64, now time.Time) (float64, error) {
+	var detoxified float64
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+		baseAttrs := make(map[string]interface{})
+		if len(user.BaseAttributes) > 0 {
+			if err := json.Unmarshal(user.BaseAttributes, &baseAttrs); err != nil {
+				return fmt.Errorf("failed to parse base attributes: %w", err)
+			}
+		}
+		before := alchemy.CurrentToxicity(baseAttrs, now)
+		if before <= 0 {
+			return nil
+		}
+		after := alchemy.AdjustToxicity(baseAttrs, -detox, now)
+		attrsJSON, err := json.Marshal(baseAttrs)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			Update("base_attributes", attrsJSON).Error; err != nil {
+			return err
+		}
+		detoxified = math.Round((before-after)*10) / 10
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return detoxified, nil
+}
+
+// buildRetreatMessage 构建出关提示
+func buildRetreatMessage(option *RetreatOption, result *RetreatResult) string {
+	var msg string
+	switch {
+	case !result.Completed:
+		msg = fmt.Sprintf("道友强行出关，功行未满，修为增加%.1f，损失%.1f", result.CultivationGain, result.Forfeited)
+	case result.Enlightenment:
+		msg = fmt.Sprintf("%s期满，闭关之中灵光乍现，顿悟大道，修为增加%.1f", option.Name, result.CultivationGain)
+	default:
+		msg = fmt.Sprintf("%s期满，周天圆满，修为增加%.1f", option.Name, result.CultivationGain)
+	}
+	if result.CultivationFull {
+		msg += "，修为已至瓶颈，需亲自修炼方可突破"
+	}
+	if result.Detoxified > 0 {
+		msg += fmt.Sprintf("，化解丹毒%.1f", result.Detoxified)
+	}
+	return msg
+}
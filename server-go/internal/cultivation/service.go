@@ -7,11 +7,18 @@ import (
 	"math/rand"
 	"time"
 
+	"xiuxian/server-go/internal/alchemy"
 	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/formation"
+	"xiuxian/server-go/internal/gacha"
+	"xiuxian/server-go/internal/gem"
 	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/pillbuff"
 	"xiuxian/server-go/internal/redis"
 
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CultivationService 修炼服务
@@ -75,17 +82,17 @@ func (s *CultivationService) setPlayerAttributes(user *models.User, attrs map[st
 
 // getCurrentCultivationCost 计算当前等级的修炼消耗
 func getCurrentCultivationCost(level int) float64 {
-	return float64(BaseCultivationCost) * math.Pow(CultivationCostMultiplier, float64(level-1))
+	return getRealmClamped(level).CultivationCost
 }
 
 // getCurrentCultivationGain 计算当前等级的修炼获得
 func getCurrentCultivationGain(level int) float64 {
-	return float64(BaseCultivationGain) * math.Pow(CultivationGainMultiplier, float64(level-1))
+	return getRealmClamped(level).CultivationGain
 }
 
-// calculateCultivationGain 计算实际获得的修为（包含幸运暴击）
-func calculateCultivationGain(level int, cultivationRate float64) float64 {
-	gain := getCurrentCultivationGain(level) * cultivationRate
+// calculateCultivationGain 计算实际获得的修为（包含功法加成与幸运暴击）
+func calculateCultivationGain(level int, cultivationRate float64, technique *TechniqueBonus) float64 {
+	gain := getCurrentCultivationGain(level) * cultivationRate * (1 + technique.CultivationRate)
 	r := rand.Float64() // [0,1)
 
 	switch {
@@ -108,11 +115,6 @@ func calculateCultivationGain(level int, cultivationRate float64) float64 {
 
 // SingleCultivate 单次打坐修炼
 func (s *CultivationService) SingleCultivate() (*CultivationResponse, error) {
-	var user models.User
-	if err := db.DB.First(&user, s.userID).Error; err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
-	}
-
 	// 检查打坐间隔（3秒一次）
 	lastCultivateKey := fmt.Sprintf("cultivation:lasttime:%d", s.userID)
 	lastCultivateTime, err := redis.Client.Get(redis.Ctx, lastCultivateKey).Int64()
@@ -131,72 +133,92 @@ func (s *CultivationService) SingleCultivate() (*CultivationResponse, error) {
 	// 记录本次打坐的时间
 	redis.Client.Set(redis.Ctx, lastCultivateKey, time.Now().UnixMilli(), 24*time.Hour)
 
-	// 获取玩家属性
-	attrs := s.getPlayerAttributes(&user)
-	cultivationRate := attrs["cultivationRate"].(float64)
+	var resp *CultivationResponse
+	// 锁定玩家行，功法经验与属性变更同事务写入
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, s.userID).Error; err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
 
-	// 计算当前等级的修炼消耗
-	cultivationCost := getCurrentCultivationCost(user.Level)
+		// 获取玩家属性
+		attrs := s.getPlayerAttributes(&user)
+		cultivationRate := attrs["cultivationRate"].(float64)
 
-	// ✅ 检查灵力时，优先使用缓存值
-	currentSpirit := s.getSpiritValue()
-	if currentSpirit < cultivationCost {
-		return &CultivationResponse{
-			Success: false,
-			Error:   fmt.Sprintf("灵力不足，需要%.0f，当前%.0f", cultivationCost, currentSpirit),
-		}, nil
-	}
+		// 计算当前等级的修炼消耗
+		cultivationCost := getCurrentCultivationCost(user.Level)
 
-	// 消耗灵力
-	user.Spirit -= cultivationCost
+		// ✅ 检查灵力时，优先使用缓存值
+		currentSpirit := s.getSpiritValue()
+		if currentSpirit < cultivationCost {
+			resp = &CultivationResponse{
+				Success: false,
+				Error:   fmt.Sprintf("灵力不足，需要%.0f，当前%.0f", cultivationCost, currentSpirit),
+			}
+			return nil
+		}
 
-	// 计算修为获得（包含幸运暴击）
-	cultivationGain := calculateCultivationGain(user.Level, cultivationRate)
-	user.Cultivation = math.Round((user.Cultivation+cultivationGain)*10) / 10
-	// 检查是否需要突破
-	// Level 27（金丹期九层）不自动突破，需要手动突破。
-	var breakthroughResult *BreakthroughResponse
-	if user.Cultivation >= user.MaxCultivation && user.Level != 27 {
-		breakthroughResult = s.performBreakthrough(&user, &attrs)
-	}
+		// 消耗灵力
+		user.Spirit -= cultivationCost
+
+		// 计算修为获得（包含悟道阵、悟道丹加成与幸运暴击）
+		cultivationRate *= 1 + formation.GetActiveEffect(user.ID, formation.EffectCultivationBoost) +
+			pillbuff.GetActiveEffect(user.ID, pillbuff.EffectCultivationRate)
+		cultivationGain := calculateCultivationGain(user.Level, cultivationRate, GetTechniqueBonus(user.ID, user.Level))
+		user.Cultivation = math.Round((user.Cultivation+cultivationGain)*10) / 10
+		// 主修功法参悟
+		if err := s.gainMainTechniqueExp(tx, &user, attrs, TechniqueExpPerCultivate); err != nil {
+			return err
+		}
 
-	// 保存属性
-	s.setPlayerAttributes(&user, attrs)
+		// 检查是否需要突破
+		// 大境界九层不自动突破，需要渡劫突破。
+		var breakthroughResult *BreakthroughResponse
+		if user.Cultivation >= user.MaxCultivation && !IsTribulationLevel(user.Level) {
+			breakthroughResult = s.performBreakthrough(&user, &attrs)
+		}
 
-	// 保存数据
-	if err := db.DB.Model(&user).Updates(map[string]interface{}{
-		"spirit":             user.Spirit,
-		"cultivation":        user.Cultivation,
-		"level":              user.Level,
-		"realm":              user.Realm,
-		"max_cultivation":    user.MaxCultivation,
-		"base_attributes":    user.BaseAttributes,
-		"combat_attributes":  user.CombatAttributes,
-		"combat_resistance":  user.CombatResistance,
-		"special_attributes": user.SpecialAttributes,
-	}).Error; err != nil {
-		return nil, fmt.Errorf("failed to update user: %w", err)
-	}
+		// 保存属性
+		s.setPlayerAttributes(&user, attrs)
 
-	resp := &CultivationResponse{
-		Success:            true,
-		CultivationGain:    cultivationGain,
-		SpiritCost:         cultivationCost,
-		CurrentCultivation: user.Cultivation,
-	}
+		// 保存数据
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"spirit":             user.Spirit,
+			"cultivation":        user.Cultivation,
+			"level":              user.Level,
+			"realm":              user.Realm,
+			"max_cultivation":    user.MaxCultivation,
+			"base_attributes":    user.BaseAttributes,
+			"combat_attributes":  user.CombatAttributes,
+			"combat_resistance":  user.CombatResistance,
+			"special_attributes": user.SpecialAttributes,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
 
-	if breakthroughResult != nil {
-		resp.Breakthrough = map[string]interface{}{
-			"newLevel":          breakthroughResult.NewLevel,
-			"newRealm":          breakthroughResult.NewRealm,
-			"newMaxCultivation": breakthroughResult.NewMaxCultivation,
-			"spiritReward":      breakthroughResult.SpiritReward,
-			"newSpiritRate":     breakthroughResult.NewSpiritRate,
-			"message":           breakthroughResult.Message,
+		resp = &CultivationResponse{
+			Success:            true,
+			CultivationGain:    cultivationGain,
+			SpiritCost:         cultivationCost,
+			CurrentCultivation: user.Cultivation,
 		}
-		resp.Message = breakthroughResult.Message
-	}
 
+		if breakthroughResult != nil {
+			resp.Breakthrough = map[string]interface{}{
+				"newLevel":          breakthroughResult.NewLevel,
+				"newRealm":          breakthroughResult.NewRealm,
+				"newMaxCultivation": breakthroughResult.NewMaxCultivation,
+				"spiritReward":      breakthroughResult.SpiritReward,
+				"newSpiritRate":     breakthroughResult.NewSpiritRate,
+				"message":           breakthroughResult.Message,
+			}
+			resp.Message = breakthroughResult.Message
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return resp, nil
 }
 
@@ -215,19 +237,20 @@ func (s *CultivationService) performBreakthrough(user *models.User, attrs *map[s
 	user.Cultivation = 0.0 // 重置修为
 
 	// 突破奖励：灵力奖励
-	spiritReward := float64(BreakthroughReward * user.Level)
+	rule := realmCatalog.Breakthrough
+	spiritReward := rule.SpiritRewardPerLevel * float64(user.Level)
 	user.Spirit += spiritReward
 
 	// ✅ 新增：升级时重新计算玩家属性
-	s.reinitializePlayerAttributes(user, attrs)
+	s.reinitializePlayerAttributes(db.DB, user, attrs)
 
 	// 突破奖励：提升灵力获取速率
 	newSpiritRate := 1.0
 	if spiritRate, ok := (*attrs)["spiritRate"].(float64); ok {
-		newSpiritRate = spiritRate * BreakthroughBonus
+		newSpiritRate = spiritRate * rule.SpiritRateBonus
 		(*attrs)["spiritRate"] = newSpiritRate
 	} else {
-		newSpiritRate = BreakthroughBonus
+		newSpiritRate = rule.SpiritRateBonus
 		(*attrs)["spiritRate"] = newSpiritRate
 	}
 
@@ -250,21 +273,16 @@ func (s *CultivationService) performBreakthrough(user *models.User, attrs *map[s
 // 1. 重新计算基础属性（基于新等级）
 // 2. 重新应用装备加成
 // 3. 重新应用灵宠加成
-func (s *CultivationService) reinitializePlayerAttributes(user *models.User, attrs *map[string]interface{}) {
+// 4. 重新应用功法加成
+// tx 用于读取装备与灵宠，渡劫时需与消耗装备灵宠处于同一事务
+func (s *CultivationService) reinitializePlayerAttributes(tx *gorm.DB, user *models.User, attrs *map[string]interface{}) {
 	level := user.Level
 
 	// 步骤1：重新计算基础属性
-	baseAttrs := map[string]interface{}{
-		"speed":   float64(10 * level),
-		"attack":  float64(10 * level),
-		"health":  float64(100 * level),
-		"defense": float64(5 * level),
-	}
+	baseAttrs := CalculateBaseAttributesByLevel(level)
 
 	// 计算灵力速率
-	spiritRate := 1.0 * math.Pow(1.2, float64(level-1))
-	spiritRate = math.Round(spiritRate*100) / 100
-	baseAttrs["spiritRate"] = spiritRate
+	baseAttrs["spiritRate"] = CalculateSpiritRateByLevel(level)
 
 	// 保留cultivationRate
 	if cr, ok := (*attrs)["cultivationRate"]; ok {
@@ -278,6 +296,11 @@ func (s *CultivationService) reinitializePlayerAttributes(user *models.User, att
 		baseAttrs["unlockedRealms"] = ur
 	}
 
+	// 保留丹毒，突破不会清除丹毒
+	alchemy.CopyToxicity(baseAttrs, *attrs)
+	// 保留渡劫丹加成与失败累计
+	CopyTribulationState(baseAttrs, *attrs)
+
 	// 初始化战斗属性
 	combatAttrs := map[string]float64{
 		"critRate":     0,
@@ -302,20 +325,36 @@ func (s *CultivationService) reinitializePlayerAttributes(user *models.User, att
 		"spiritBonus": 0,
 	}
 
+	// 出战灵宠同时计入套装件数
+	var pets []models.Pet
+	if err := tx.Where("user_id = ? AND is_active = ?", user.ID, true).Find(&pets).Error; err != nil {
+		pets = nil
+	}
+	var setPet *models.Pet
+	if len(pets) > 0 {
+		setPet = &pets[0]
+	}
+
 	// 步骤2：重新应用装备加成
 	var equipments []models.Equipment
-	if err := db.DB.Where("user_id = ? AND equipped = ?", user.ID, true).Find(&equipments).Error; err == nil {
+	if err := tx.Where("user_id = ? AND equipped = ?", user.ID, true).Find(&equipments).Error; err == nil {
 		for _, equipment := range equipments {
 			s.applyEquipmentStats(&baseAttrs, &combatAttrs, &combatRes, &specialAttrs, &equipment)
 		}
+		// 套装加成
+		for key, value := range gacha.SetBonusStats(equipments, setPet) {
+			applyTechniqueStat(baseAttrs, combatAttrs, combatRes, specialAttrs, key, value)
+		}
 	}
 
 	// 步骣3：重新应用灵宠加成
-	var pets []models.Pet
-	if err := db.DB.Where("user_id = ? AND is_active = ?", user.ID, true).Find(&pets).Error; err == nil {
-		for _, pet := range pets {
-			s.applyPetStats(&baseAttrs, &combatAttrs, &combatRes, &specialAttrs, &pet)
-		}
+	for _, pet := range pets {
+		s.applyPetStats(&baseAttrs, &combatAttrs, &combatRes, &specialAttrs, &pet)
+	}
+
+	// 步骤4：重新应用功法加成
+	for key, value := range GetTechniqueBonus(user.ID, level).Stats {
+		applyTechniqueStat(baseAttrs, combatAttrs, combatRes, specialAttrs, key, value)
 	}
 
 	// 更新用户属性JSON
@@ -335,26 +374,9 @@ func (s *CultivationService) reinitializePlayerAttributes(user *models.User, att
 
 // applyEquipmentStats 应用装备属性加成
 func (s *CultivationService) applyEquipmentStats(baseAttrs *map[string]interface{}, combatAttrs, combatRes, specialAttrs *map[string]float64, equipment *models.Equipment) {
-	var stats map[string]interface{}
-	if equipment.Stats != nil {
-		json.Unmarshal(equipment.Stats, &stats)
-	}
-
-	for key, value := range stats {
-		if v, ok := value.(float64); ok {
-			switch key {
-			case "speed", "attack", "health", "defense":
-				if current, ok := (*baseAttrs)[key].(float64); ok {
-					(*baseAttrs)[key] = current + v
-				}
-			case "critRate", "critDamage", "comboRate", "stunRate", "lifesteal", "attackBonus", "defenseBonus":
-				(*combatAttrs)[key] += v
-			case "critResist", "comboResist", "stunResist":
-				(*combatRes)[key] += v
-			case "luck", "spiritBonus":
-				(*specialAttrs)[key] += v
-			}
-		}
+	// 有效属性包含已镶嵌宝石的加成
+	for key, v := range gem.EffectiveStats(equipment) {
+		applyTechniqueStat(*baseAttrs, *combatAttrs, *combatRes, *specialAttrs, key, v)
 	}
 }
 
@@ -417,12 +439,12 @@ func (s *CultivationService) unlockRealm(user *models.User, attrs *map[string]in
 
 // getCurrentFormationCost 计算当前等级的聚灵阵消耗
 func getCurrentFormationCost(level int) int {
-	return int(float64(BaseFormationCost) * math.Pow(FormationCostMultiplier, float64(level-1)))
+	return getRealmClamped(level).FormationCost
 }
 
 // getCurrentFormationGain 计算当前等级的聚灵阵获得
 func getCurrentFormationGain(level int) float64 {
-	return float64(BaseFormationGain) * math.Pow(FormationGainMultiplier, float64(level-1))
+	return getRealmClamped(level).FormationGain
 }
 
 // UseFormation 使用聚灵阵
@@ -488,8 +510,10 @@ func (s *CultivationService) UseFormation() (*FormationResponse, error) {
 	// 消耗灵石
 	user.SpiritStones -= formationCost
 
-	// 计算修为获得（包含多档幸运暴击）
-	formationGain := getCurrentFormationGain(user.Level) * cultivationRate
+	// 计算修为获得（包含悟道阵、悟道丹加成与多档幸运暴击）
+	formationGain := getCurrentFormationGain(user.Level) * cultivationRate *
+		(1 + formation.GetActiveEffect(user.ID, formation.EffectCultivationBoost) +
+			pillbuff.GetActiveEffect(user.ID, pillbuff.EffectCultivationRate))
 
 	r := rand.Float64() // [0,1)
 
@@ -511,9 +535,9 @@ func (s *CultivationService) UseFormation() (*FormationResponse, error) {
 	user.Cultivation = math.Round((user.Cultivation+formationGain)*10) / 10
 
 	// 检查是否需要突破
-	// Level 27（金丹期九层）不自动突破，需要手动突破。
+	// 大境界九层不自动突破，需要渡劫突破。
 	var breakthroughResult *BreakthroughResponse
-	if user.Cultivation >= user.MaxCultivation && user.Level != 27 {
+	if user.Cultivation >= user.MaxCultivation && !IsTribulationLevel(user.Level) {
 		breakthroughResult = s.performBreakthrough(&user, &attrs)
 	}
 
@@ -698,51 +722,35 @@ func (s *CultivationService) GetCultivationData() (*CultivationData, error) {
 		CombatAttributes:  combatAttributes,
 		CombatResistance:  combatResistance,
 		SpecialAttributes: specialAttributes,
+		Toxicity:          alchemy.GetToxicityState(attrs, time.Now()),
 	}, nil
 }
 
 // ✅ 新增：InitializePlayerAttributesOnLevel 根据等级初始化玩家属性
 // 用于登录时重新计算属性
 func InitializePlayerAttributesOnLevel(level int) map[string]interface{} {
-	return map[string]interface{}{
-		"speed":           float64(10 * level),
-		"attack":          float64(10 * level),
-		"health":          float64(100 * level),
-		"defense":         float64(5 * level),
-		"spiritRate":      CalculateSpiritRateByLevel(level), // 使用公共函数
-		"cultivationRate": 1.0,
-	}
+	attrs := CalculateBaseAttributesByLevel(level)
+	attrs["spiritRate"] = CalculateSpiritRateByLevel(level)
+	attrs["cultivationRate"] = 1.0
+	return attrs
 }
 
-// ✅ 新增：CalculateSpiritRateByLevel 计算基于等级的灵力倍率
-// 导出函数供其他模块使用
-// 公式：spiritRate = 1.0 * (1.2)^(Level-1)
+// CalculateSpiritRateByLevel 获取等级对应的灵力倍率（来自境界表）
 func CalculateSpiritRateByLevel(level int) float64 {
-	if level < 1 {
-		level = 1
-	}
-	spiritRate := 1.0 * math.Pow(1.2, float64(level-1))
-	// 保留两位小数
-	return math.Round(spiritRate*100) / 100
+	return getRealmClamped(level).SpiritRate
 }
 
-// ✅ 新增：CalculateBaseAttributesByLevel 计算基于等级的基础属性
+// CalculateBaseAttributesByLevel 获取等级对应的基础属性（来自境界表）
 // 导出函数供其他模块使用
-// 公式：
-// speed = 10 * Level
-// attack = 10 * Level
-// health = 100 * Level
-// defense = 5 * Level
 func CalculateBaseAttributesByLevel(level int) map[string]interface{} {
-	return map[string]interface{}{
-		"speed":   float64(10 * level),
-		"attack":  float64(10 * level),
-		"health":  float64(100 * level),
-		"defense": float64(5 * level),
+	attrs := make(map[string]interface{})
+	for key, value := range getRealmClamped(level).BaseAttributes {
+		attrs[key] = value
 	}
+	return attrs
 }
 
-// BreakthroughJieYing 结婴突破处理
+// BreakthroughJieYing 结婴突破处理（金丹期九层渡劫）
 func (s *CultivationService) BreakthroughJieYing() (map[string]interface{}, error) {
 	var user models.User
 	if err := db.DB.First(&user, s.userID).Error; err != nil {
@@ -757,106 +765,24 @@ func (s *CultivationService) BreakthroughJieYing() (map[string]interface{}, erro
 		}, nil
 	}
 
-	// 从 BaseAttributes 中读取 duJieRate
-	attrs := s.getPlayerAttributes(&user)
-	duJieRate := 0.05 // 默认值
-	if rate, ok := attrs["duJieRate"].(float64); ok {
-		duJieRate = rate
+	result, err := s.AttemptTribulation()
+	if err != nil {
+		return nil, err
 	}
 
-	// 判断渡劫是否成功
-	randomVal := rand.Float64()
-	success := randomVal < duJieRate
-
-	// ✅ 渡劫消耗：无论成功或失败都消耗品质最高的装备和灵宠
-	consumedEquipment, consumedPet, consumeErr := s.consumeHighestQualityItems(&user)
-	if consumeErr != nil {
-		return nil, fmt.Errorf("failed to consume items: %w", consumeErr)
+	resp := map[string]interface{}{
+		"success":           result.Success,
+		"message":           result.Message,
+		"consumedEquipment": result.ConsumedEquipment,
+		"consumedPet":       result.ConsumedPet,
+		"consumeMessage":    result.ConsumeMessage,
 	}
-
-	if success {
-		// 结婴成功，晋升为元婴期
-		nextRealm := GetNextRealm(user.Level)
-		if nextRealm == nil {
-			return map[string]interface{}{
-				"success": false,
-				"message": "已是最高境界",
-			}, nil
-		}
-
-		user.Level = nextRealm.Level
-		user.Realm = nextRealm.Name
-		user.MaxCultivation = nextRealm.MaxCultivation
-		user.Cultivation = 0.0
-		// 重置 duJieRate 为 5%
-		attrs["duJieRate"] = 0.05
-
-		// ✅ 升级时重新计算玩家属性
-		s.reinitializePlayerAttributes(&user, &attrs)
-
-		// 保存属性
-		s.setPlayerAttributes(&user, attrs)
-
-		// 保存数据
-		if err := db.DB.Model(&user).Updates(map[string]interface{}{
-			"level":              user.Level,
-			"realm":              user.Realm,
-			"cultivation":        user.Cultivation,
-			"max_cultivation":    user.MaxCultivation,
-			"base_attributes":    user.BaseAttributes,
-			"combat_attributes":  user.CombatAttributes,
-			"combat_resistance":  user.CombatResistance,
-			"special_attributes": user.SpecialAttributes,
-		}).Error; err != nil {
-			return nil, fmt.Errorf("failed to update user: %w", err)
-		}
-
-		// 构建消耗提示消息
-		consumeMessage := buildConsumeMessage(consumedEquipment, consumedPet)
-
-		return map[string]interface{}{
-			"success":           true,
-			"message":           "突破成功，恭喜道友修炼千年，成为元婴道君",
-			"newRealm":          user.Realm,
-			"newLevel":          user.Level,
-			"duJieRate":         0.05,
-			"consumedEquipment": consumedEquipment,
-			"consumedPet":       consumedPet,
-			"consumeMessage":    consumeMessage,
-		}, nil
+	if result.Success {
+		resp["newRealm"] = result.NewRealm
+		resp["newLevel"] = result.NewLevel
+		resp["duJieRate"] = result.DuJieRate
 	}
-
-	// 结婴失败，金丹破碎
-	user.Cultivation = 0.0
-	// 重置 duJieRate 为 0
-	attrs["duJieRate"] = 0.0
-
-	// ✅ 失败时也要重新计算玩家属性（因为消耗了装备和灵宠）
-	s.reinitializePlayerAttributes(&user, &attrs)
-
-	// 保存属性
-	s.setPlayerAttributes(&user, attrs)
-
-	if err := db.DB.Model(&user).Updates(map[string]interface{}{
-		"cultivation":        user.Cultivation,
-		"base_attributes":    user.BaseAttributes,
-		"combat_attributes":  user.CombatAttributes,
-		"combat_resistance":  user.CombatResistance,
-		"special_attributes": user.SpecialAttributes,
-	}).Error; err != nil {
-		return nil, fmt.Errorf("failed to update user: %w", err)
-	}
-
-	// 构建消耗提示消息
-	consumeMessage := buildConsumeMessage(consumedEquipment, consumedPet)
-
-	return map[string]interface{}{
-		"success":           false,
-		"message":           "突破失败，金丹破碎，修为骤降，请道友夯实修为，磨炼道心后再行突破",
-		"consumedEquipment": consumedEquipment,
-		"consumedPet":       consumedPet,
-		"consumeMessage":    consumeMessage,
-	}, nil
+	return resp, nil
 }
 
 // buildConsumeMessage 构建消耗提示消息
@@ -873,7 +799,7 @@ func buildConsumeMessage(equipmentName, petName string) string {
 
 // consumeHighestQualityItems 消耗品质最高的装备和灵宠
 // 返回被消耗的装备名称、灵宠名称和错误
-func (s *CultivationService) consumeHighestQualityItems(user *models.User) (string, string, error) {
+func (s *CultivationService) consumeHighestQualityItems(tx *gorm.DB, user *models.User) (string, string, error) {
 	// 品质优先级：mythic > legendary > epic > rare > uncommon > common
 	qualityOrder := []string{"mythic", "legendary", "epic", "rare", "uncommon", "common"}
 
@@ -883,7 +809,8 @@ func (s *CultivationService) consumeHighestQualityItems(user *models.User) (stri
 	// 查找品质最高的装备（不区分是否已装备）
 	var equipment models.Equipment
 	for _, quality := range qualityOrder {
-		err := db.DB.Where("user_id = ? AND quality = ?", user.ID, quality).
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND quality = ?", user.ID, quality).
 			First(&equipment).Error
 		if err == nil {
 			// 找到品质最高的装备
@@ -891,7 +818,7 @@ func (s *CultivationService) consumeHighestQualityItems(user *models.User) (stri
 
 			// 如果装备已穿戴，先卸下
 			if equipment.Equipped {
-				if err := db.DB.Model(&equipment).Updates(map[string]interface{}{
+				if err := tx.Model(&equipment).Updates(map[string]interface{}{
 					"equipped": false,
 					"slot":     nil,
 				}).Error; err != nil {
@@ -900,7 +827,7 @@ func (s *CultivationService) consumeHighestQualityItems(user *models.User) (stri
 			}
 
 			// 删除装备
-			if err := db.DB.Delete(&equipment).Error; err != nil {
+			if err := tx.Delete(&equipment).Error; err != nil {
 				return "", "", fmt.Errorf("failed to delete equipment: %w", err)
 			}
 			break
@@ -910,7 +837,8 @@ func (s *CultivationService) consumeHighestQualityItems(user *models.User) (stri
 	// 查找稀有度最高的灵宠（不区分是否已出战）
 	var pet models.Pet
 	for _, rarity := range qualityOrder {
-		err := db.DB.Where("user_id = ? AND rarity = ?", user.ID, rarity).
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND rarity = ?", user.ID, rarity).
 			First(&pet).Error
 		if err == nil {
 			// 找到稀有度最高的灵宠
@@ -918,13 +846,13 @@ func (s *CultivationService) consumeHighestQualityItems(user *models.User) (stri
 
 			// 如果灵宠已出战，先召回
 			if pet.IsActive {
-				if err := db.DB.Model(&pet).Update("is_active", false).Error; err != nil {
+				if err := tx.Model(&pet).Update("is_active", false).Error; err != nil {
 					return consumedEquipmentName, "", fmt.Errorf("failed to recall pet: %w", err)
 				}
 			}
 
 			// 删除灵宠
-			if err := db.DB.Delete(&pet).Error; err != nil {
+			if err := tx.Delete(&pet).Error; err != nil {
 				return consumedEquipmentName, "", fmt.Errorf("failed to delete pet: %w", err)
 			}
 			break
@@ -933,3 +861,17 @@ func (s *CultivationService) consumeHighestQualityItems(user *models.User) (stri
 
 	return consumedEquipmentName, consumedPetName, nil
 }
+
+// SyncRealmCap 按境界表校正玩家的修为上限（境界表调整后，已有玩家登录时同步），修为不超过新上限
+func SyncRealmCap(user *models.User) error {
+	realm := GetRealmByLevel(user.Level)
+	if realm == nil || user.MaxCultivation == realm.MaxCultivation {
+		return nil
+	}
+	user.MaxCultivation = realm.MaxCultivation
+	user.Cultivation = math.Min(user.Cultivation, realm.MaxCultivation)
+	return db.DB.Model(user).Updates(map[string]interface{}{
+		"max_cultivation": realm.MaxCultivation,
+		"cultivation":     gorm.Expr("LEAST(cultivation, ?)", realm.MaxCultivation),
+	}).Error
+}
@@ -0,0 +1,545 @@
+package cultivation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/attributes"
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+)
+
+// 功法运转槽位
+const (
+	TechniqueSlotMain = "main" // 主修：提供修炼速度与全额属性
+	TechniqueSlotAux  = "aux"  // 辅修：提供部分属性
+)
+
+const (
+	// 辅修功法槽位数量
+	MaxAuxTechniques = 2
+	// 辅修功法属性生效比例
+	AuxTechniqueRatio = 0.5
+	// 每部同属性辅修功法为主修带来的五行共鸣加成
+	ElementResonanceBonus = 0.1
+	// 主修功法每次打坐获得的功法经验
+	TechniqueExpPerCultivate = 1
+)
+
+// TechniqueGrade 功法品阶
+type TechniqueGrade struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	MaxLevel     int     `json:"maxLevel"`
+	ExpPerLevel  int     `json:"expPerLevel"`  // 升级所需经验 = 当前层数 × ExpPerLevel
+	DuplicateExp int     `json:"duplicateExp"` // 重复获得时转化的经验
+	DropWeight   float64 `json:"dropWeight"`   // 探索掉落权重
+}
+
+// techniqueGrades 功法品阶配置
+var techniqueGrades = map[string]TechniqueGrade{
+	"huang": {ID: "huang", Name: "黄阶", MaxLevel: 10, ExpPerLevel: 10, DuplicateExp: 30, DropWeight: 60},
+	"xuan":  {ID: "xuan", Name: "玄阶", MaxLevel: 15, ExpPerLevel: 20, DuplicateExp: 80, DropWeight: 30},
+	"di":    {ID: "di", Name: "地阶", MaxLevel: 20, ExpPerLevel: 40, DuplicateExp: 200, DropWeight: 9},
+	"tian":  {ID: "tian", Name: "天阶", MaxLevel: 30, ExpPerLevel: 80, DuplicateExp: 500, DropWeight: 1},
+}
+
+// TechniqueConfig 功法配置
+type TechniqueConfig struct {
+	ID              string             `json:"id"`
+	Name            string             `json:"name"`
+	Description     string             `json:"description"`
+	Grade           string             `json:"grade"`
+	Element         string             `json:"element,omitempty"` // 五行亲和：metal/wood/water/fire/earth
+	MinLevel        int                `json:"minLevel"`          // 运转所需境界等级
+	CultivationRate float64            `json:"cultivationRate"`   // 每层修炼速度加成（仅主修生效）
+	StatBonuses     map[string]float64 `json:"statBonuses"`       // 每层属性加成：attack/health/defense/speed 为基础属性百分比，其余为数值
+	ShopPrice       int                `json:"shopPrice"`         // 坊市售价（灵石），0 表示不在坊市出售
+}
+
+// techniqueConfigs 功法配置表
+var techniqueConfigs = []TechniqueConfig{
+	{ID: "qi_guiding_art", Name: "引气诀", Description: "入门吐纳之法，引天地灵气入体", Grade: "huang", MinLevel: 1,
+		CultivationRate: 0.03, StatBonuses: map[string]float64{"health": 0.01}, ShopPrice: 500},
+	{ID: "golden_light_art", Name: "金光诀", Description: "以庚金之气护体，出手凌厉", Grade: "huang", Element: "metal", MinLevel: 5,
+		CultivationRate: 0.02, StatBonuses: map[string]float64{"attack": 0.015, "critRate": 0.002}, ShopPrice: 1500},
+	{ID: "green_wood_sutra", Name: "青木长生功", Description: "取乙木生机，绵延不绝", Grade: "xuan", Element: "wood", MinLevel: 10,
+		CultivationRate: 0.05, StatBonuses: map[string]float64{"health": 0.02}, ShopPrice: 8000},
+	{ID: "water_mirror_art", Name: "玄水镜心诀", Description: "心如止水，身若游鱼", Grade: "xuan", Element: "water", MinLevel: 15,
+		CultivationRate: 0.04, StatBonuses: map[string]float64{"defense": 0.01, "dodgeRate": 0.003}, ShopPrice: 10000},
+	{ID: "flame_heart_art", Name: "离火焚心诀", Description: "以离火淬炼心神，攻势连绵", Grade: "xuan", Element: "fire", MinLevel: 19,
+		CultivationRate: 0.04, StatBonuses: map[string]float64{"attack": 0.02, "comboRate": 0.003}, ShopPrice: 15000},
+	{ID: "thick_earth_art", Name: "厚土镇岳功", Description: "身如山岳，万法难侵", Grade: "di", Element: "earth", MinLevel: 28,
+		CultivationRate: 0.06, StatBonuses: map[string]float64{"defense": 0.03, "health": 0.02}},
+	{ID: "thunder_sword_art", Name: "九霄雷剑诀", Description: "引九霄神雷入剑，一剑破万法", Grade: "di", Element: "metal", MinLevel: 37,
+		CultivationRate: 0.06, StatBonuses: map[string]float64{"attack": 0.03, "critRate": 0.004}},
+	{ID: "great_dao_sutra", Name: "太上大道经", Description: "大道至简，万法归一", Grade: "tian", MinLevel: 55,
+		CultivationRate: 0.1, StatBonuses: map[string]float64{"attack": 0.02, "health": 0.02, "defense": 0.02, "speed": 0.02}},
+}
+
+// percentStats 按基础属性百分比计算的功法加成
+var percentStats = map[string]bool{"attack": true, "health": true, "defense": true, "speed": true}
+
+// TechniqueBonus 已运转功法的加成汇总
+type TechniqueBonus struct {
+	CultivationRate float64            `json:"cultivationRate"` // 修炼速度加成（0.1 表示 +10%）
+	Stats           map[string]float64 `json:"stats"`           // 换算为数值的属性加成
+}
+
+// TechniqueView 玩家功法详情
+type TechniqueView struct {
+	models.UserTechnique
+	Config    TechniqueConfig `json:"config"`
+	GradeName string          `json:"gradeName"`
+	MaxLevel  int             `json:"maxLevel"`
+	NextExp   int             `json:"nextExp"` // 升至下一层所需经验，满级为 0
+}
+
+// TechniqueGrantResult 获得功法结果
+type TechniqueGrantResult struct {
+	TechniqueID string `json:"techniqueId"`
+	Name        string `json:"name"`
+	Duplicate   bool   `json:"duplicate"` // 已习得，转化为功法经验
+	ExpGained   int    `json:"expGained,omitempty"`
+	Level       int    `json:"level"`
+	Message     string `json:"message"`
+}
+
+// GetTechniqueConfig 根据ID获取功法配置
+func GetTechniqueConfig(techniqueID string) *TechniqueConfig {
+	for i := range techniqueConfigs {
+		if techniqueConfigs[i].ID == techniqueID {
+			return &techniqueConfigs[i]
+		}
+	}
+	return nil
+}
+
+// GetTechniqueShop 获取坊市出售的功法
+func GetTechniqueShop() []TechniqueConfig {
+	var shop []TechniqueConfig
+	for _, config := range techniqueConfigs {
+		if config.ShopPrice > 0 {
+			shop = append(shop, config)
+		}
+	}
+	return shop
+}
+
+// RollTechniqueDrop 按品阶权重随机一部玩家境界可运转的功法，无可掉落功法时返回 nil
+func RollTechniqueDrop(level int, r *rand.Rand) *TechniqueConfig {
+	var candidates []*TechniqueConfig
+	totalWeight := 0.0
+	for i := range techniqueConfigs {
+		config := &techniqueConfigs[i]
+		if config.MinLevel > level {
+			continue
+		}
+		candidates = append(candidates, config)
+		totalWeight += techniqueGrades[config.Grade].DropWeight
+	}
+	if totalWeight <= 0 {
+		return nil
+	}
+
+	rnd := r.Float64() * totalWeight
+	acc := 0.0
+	for _, config := range candidates {
+		acc += techniqueGrades[config.Grade].DropWeight
+		if rnd <= acc {
+			return config
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// techniqueNextExp 升至下一层所需经验，满级返回 0
+func techniqueNextExp(config *TechniqueConfig, level int) int {
+	grade := techniqueGrades[config.Grade]
+	if level >= grade.MaxLevel {
+		return 0
+	}
+	return level * grade.ExpPerLevel
+}
+
+// addTechniqueExp 增加功法经验并自动升层，返回是否升层
+func addTechniqueExp(record *models.UserTechnique, exp int) bool {
+	config := GetTechniqueConfig(record.TechniqueID)
+	if config == nil {
+		return false
+	}
+	leveled := false
+	record.Exp += exp
+	for {
+		need := techniqueNextExp(config, record.Level)
+		if need == 0 {
+			record.Exp = 0
+			break
+		}
+		if record.Exp < need {
+			break
+		}
+		record.Exp -= need
+		record.Level++
+		leveled = true
+	}
+	return leveled
+}
+
+// calculateTechniqueBonus 计算已运转功法的加成：主修全额，辅修按比例，同属性辅修与主修产生五行共鸣
+func calculateTechniqueBonus(records []models.UserTechnique, level int) *TechniqueBonus {
+	bonus := &TechniqueBonus{Stats: map[string]float64{}}
+
+	var main *TechniqueConfig
+	for _, record := range records {
+		if record.Slot == TechniqueSlotMain {
+			main = GetTechniqueConfig(record.TechniqueID)
+		}
+	}
+	resonance := 1.0
+	if main != nil && main.Element != "" {
+		for _, record := range records {
+			if record.Slot != TechniqueSlotAux {
+				continue
+			}
+			if config := GetTechniqueConfig(record.TechniqueID); config != nil && config.Element == main.Element {
+				resonance += ElementResonanceBonus
+			}
+		}
+	}
+
+	baseAttrs := getRealmClamped(level).BaseAttributes
+	for _, record := range records {
+		config := GetTechniqueConfig(record.TechniqueID)
+		if config == nil || record.Slot == "" {
+			continue
+		}
+		ratio := AuxTechniqueRatio
+		if record.Slot == TechniqueSlotMain {
+			ratio = resonance
+			bonus.CultivationRate += config.CultivationRate * float64(record.Level) * ratio
+		}
+		for key, perLevel := range config.StatBonuses {
+			value := perLevel * float64(record.Level) * ratio
+			if percentStats[key] {
+				value *= baseAttrs[key]
+			}
+			bonus.Stats[key] += value
+		}
+	}
+	return bonus
+}
+
+// getEquippedTechniques 获取玩家已运转的功法
+func getEquippedTechniques(tx *gorm.DB, userID uint) ([]models.UserTechnique, error) {
+	var records []models.UserTechnique
+	if err := tx.Where("user_id = ? AND slot <> ''", userID).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to get techniques: %w", err)
+	}
+	return records, nil
+}
+
+// GetTechniqueBonus 获取玩家已运转功法的加成
+func GetTechniqueBonus(userID uint, level int) *TechniqueBonus {
+	records, err := getEquippedTechniques(db.DB, userID)
+	if err != nil {
+		log.Printf("[Technique] %v", err)
+		return &TechniqueBonus{Stats: map[string]float64{}}
+	}
+	return calculateTechniqueBonus(records, level)
+}
+
+// applyTechniqueStats 将功法属性加成按 sign（1 加 / -1 减）写入玩家属性
+// 基础属性写入 attrs，其余属性直接写入 user 的属性 JSON
+func applyTechniqueStats(user *models.User, attrs map[string]interface{}, stats map[string]float64, sign float64) {
+	combatAttrs := jsonFloatMap(user.CombatAttributes)
+	combatRes := jsonFloatMap(user.CombatResistance)
+	specialAttrs := jsonFloatMap(user.SpecialAttributes)
+
+	for key, value := range stats {
+		applyTechniqueStat(attrs, combatAttrs, combatRes, specialAttrs, key, value*sign)
+	}
+
+	user.CombatAttributes = floatMapJSON(combatAttrs)
+	user.CombatResistance = floatMapJSON(combatRes)
+	user.SpecialAttributes = floatMapJSON(specialAttrs)
+}
+
+// applyTechniqueStat 按属性类别累加单项加成，类别划分与 attributes.StatCategory 一致
+func applyTechniqueStat(baseAttrs map[string]interface{}, combatAttrs, combatRes, specialAttrs map[string]float64, key string, value float64) {
+	switch attributes.StatCategory(key) {
+	case attributes.CategoryBase:
+		current, _ := baseAttrs[key].(float64)
+		baseAttrs[key] = current + value
+	case attributes.CategoryCombat:
+		combatAttrs[key] += value
+	case attributes.CategoryResistance:
+		combatRes[key] += value
+	default:
+		specialAttrs[key] += value
+	}
+}
+
+// jsonFloatMap 解析属性 JSON（忽略非数值字段）
+func jsonFloatMap(j datatypes.JSON) map[string]float64 {
+	result := map[string]float64{}
+	if len(j) == 0 {
+		return result
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(j, &m); err != nil {
+		return result
+	}
+	for k, v := range m {
+		if f, ok := v.(float64); ok {
+			result[k] = f
+		}
+	}
+	return result
+}
+
+// floatMapJSON 将属性 map 序列化为 JSON
+func floatMapJSON(m map[string]float64) datatypes.JSON {
+	data, _ := json.Marshal(m)
+	return datatypes.JSON(data)
+}
+
+// updateTechniques 在锁定玩家行的事务中修改功法并同步属性加成（按修改前后的加成差值更新玩家属性）
+func (s *CultivationService) updateTechniques(change func(tx *gorm.DB, user *models.User) error) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, s.userID).Error; err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		before, err := getEquippedTechniques(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if err := change(tx, &user); err != nil {
+			return err
+		}
+		after, err := getEquippedTechniques(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		attrs := s.getPlayerAttributes(&user)
+		applyTechniqueStats(&user, attrs, calculateTechniqueBonus(before, user.Level).Stats, -1)
+		applyTechniqueStats(&user, attrs, calculateTechniqueBonus(after, user.Level).Stats, 1)
+		s.setPlayerAttributes(&user, attrs)
+
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"base_attributes":    user.BaseAttributes,
+			"combat_attributes":  user.CombatAttributes,
+			"combat_resistance":  user.CombatResistance,
+			"special_attributes": user.SpecialAttributes,
+		}).Error
+	})
+}
+
+// spendSpiritStones 扣除灵石，不足时返回错误
+func spendSpiritStones(tx *gorm.DB, userID uint, cost int) error {
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND spirit_stones >= ?", userID, cost).
+		Update("spirit_stones", gorm.Expr("spirit_stones - ?", cost))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("灵石不足，需要%d", cost)
+	}
+	return nil
+}
+
+// grantTechnique 习得功法，已习得则转化为功法经验
+func grantTechnique(tx *gorm.DB, userID uint, config *TechniqueConfig) (*TechniqueGrantResult, error) {
+	result := &TechniqueGrantResult{TechniqueID: config.ID, Name: config.Name}
+
+	var record models.UserTechnique
+	err := tx.Where("user_id = ? AND technique_id = ?", userID, config.ID).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		record = models.UserTechnique{UserID: userID, TechniqueID: config.ID, Level: 1}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, fmt.Errorf("failed to create technique: %w", err)
+		}
+		result.Level = 1
+		result.Message = fmt.Sprintf("习得%s《%s》", techniqueGrades[config.Grade].Name, config.Name)
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get technique: %w", err)
+	}
+
+	result.Duplicate = true
+	result.ExpGained = techniqueGrades[config.Grade].DuplicateExp
+	addTechniqueExp(&record, result.ExpGained)
+	if err := tx.Model(&record).Updates(map[string]interface{}{"level": record.Level, "exp": record.Exp}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update technique: %w", err)
+	}
+	result.Level = record.Level
+	result.Message = fmt.Sprintf("再得《%s》，参悟后功法经验+%d", config.Name, result.ExpGained)
+	return result, nil
+}
+
+// GrantTechnique 习得功法（探索等来源），已运转的功法升层时同步属性
+func (s *CultivationService) GrantTechnique(techniqueID string) (*TechniqueGrantResult, error) {
+	config := GetTechniqueConfig(techniqueID)
+	if config == nil {
+		return nil, fmt.Errorf("功法不存在")
+	}
+
+	var result *TechniqueGrantResult
+	err := s.updateTechniques(func(tx *gorm.DB, user *models.User) error {
+		var err error
+		result, err = grantTechnique(tx, user.ID, config)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BuyTechnique 在坊市购买功法
+func (s *CultivationService) BuyTechnique(techniqueID string) (*TechniqueGrantResult, error) {
+	config := GetTechniqueConfig(techniqueID)
+	if config == nil || config.ShopPrice <= 0 {
+		return nil, fmt.Errorf("坊市未出售该功法")
+	}
+
+	var result *TechniqueGrantResult
+	err := s.updateTechniques(func(tx *gorm.DB, user *models.User) error {
+		if user.Level < config.MinLevel {
+			return fmt.Errorf("境界不足，需达到%s", GetRealmByLevel(config.MinLevel).Name)
+		}
+		if err := spendSpiritStones(tx, user.ID, config.ShopPrice); err != nil {
+			return err
+		}
+
+		var err error
+		result, err = grantTechnique(tx, user.ID, config)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// EquipTechnique 运转功法：slot 为 main 时替换原主修，为 aux 时占用辅修槽位
+func (s *CultivationService) EquipTechnique(techniqueID, slot string) error {
+	if slot != TechniqueSlotMain && slot != TechniqueSlotAux {
+		return fmt.Errorf("无效的功法槽位")
+	}
+
+	return s.updateTechniques(func(tx *gorm.DB, user *models.User) error {
+		var record models.UserTechnique
+		if err := tx.Where("user_id = ? AND technique_id = ?", user.ID, techniqueID).First(&record).Error; err != nil {
+			return fmt.Errorf("尚未习得该功法")
+		}
+		config := GetTechniqueConfig(techniqueID)
+		if config == nil {
+			return fmt.Errorf("功法不存在")
+		}
+		if user.Level < config.MinLevel {
+			return fmt.Errorf("境界不足，需达到%s", GetRealmByLevel(config.MinLevel).Name)
+		}
+		if record.Slot == slot {
+			return nil
+		}
+
+		if slot == TechniqueSlotMain {
+			// 原主修功法停止运转
+			if err := tx.Model(&models.UserTechnique{}).
+				Where("user_id = ? AND slot = ?", user.ID, TechniqueSlotMain).
+				Update("slot", "").Error; err != nil {
+				return fmt.Errorf("failed to update technique: %w", err)
+			}
+		} else {
+			var auxCount int64
+			if err := tx.Model(&models.UserTechnique{}).
+				Where("user_id = ? AND slot = ?", user.ID, TechniqueSlotAux).
+				Count(&auxCount).Error; err != nil {
+				return fmt.Errorf("failed to count techniques: %w", err)
+			}
+			if auxCount >= MaxAuxTechniques {
+				return fmt.Errorf("辅修功法最多运转%d部", MaxAuxTechniques)
+			}
+		}
+		return tx.Model(&record).Update("slot", slot).Error
+	})
+}
+
+// UnequipTechnique 停止运转功法
+func (s *CultivationService) UnequipTechnique(techniqueID string) error {
+	return s.updateTechniques(func(tx *gorm.DB, user *models.User) error {
+		return tx.Model(&models.UserTechnique{}).
+			Where("user_id = ? AND technique_id = ?", user.ID, techniqueID).
+			Update("slot", "").Error
+	})
+}
+
+// GetTechniques 获取玩家已习得的功法与当前加成
+func (s *CultivationService) GetTechniques() ([]TechniqueView, *TechniqueBonus, error) {
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var records []models.UserTechnique
+	if err := db.DB.Where("user_id = ?", s.userID).Order("id").Find(&records).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to get techniques: %w", err)
+	}
+
+	views := make([]TechniqueView, 0, len(records))
+	for _, record := range records {
+		config := GetTechniqueConfig(record.TechniqueID)
+		if config == nil {
+			continue
+		}
+		grade := techniqueGrades[config.Grade]
+		views = append(views, TechniqueView{
+			UserTechnique: record,
+			Config:        *config,
+			GradeName:     grade.Name,
+			MaxLevel:      grade.MaxLevel,
+			NextExp:       techniqueNextExp(config, record.Level),
+		})
+	}
+	return views, calculateTechniqueBonus(records, user.Level), nil
+}
+
+// gainMainTechniqueExp 主修功法获得经验，升层时同步属性加成（在调用方锁定玩家行的事务内执行，调用方负责保存 user 与 attrs）
+func (s *CultivationService) gainMainTechniqueExp(tx *gorm.DB, user *models.User, attrs map[string]interface{}, exp int) error {
+	equipped, err := getEquippedTechniques(tx, user.ID)
+	if err != nil {
+		return err
+	}
+	for i := range equipped {
+		record := &equipped[i]
+		if record.Slot != TechniqueSlotMain {
+			continue
+		}
+
+		before := calculateTechniqueBonus(equipped, user.Level).Stats
+		leveled := addTechniqueExp(record, exp)
+		if err := tx.Model(record).Updates(map[string]interface{}{"level": record.Level, "exp": record.Exp}).Error; err != nil {
+			return fmt.Errorf("failed to update technique: %w", err)
+		}
+		if leveled {
+			applyTechniqueStats(user, attrs, before, -1)
+			applyTechniqueStats(user, attrs, calculateTechniqueBonus(equipped, user.Level).Stats, 1)
+			log.Printf("[Technique] 玩家 %d 主修功法 %s 升至第%d层", user.ID, record.TechniqueID, record.Level)
+		}
+		return nil
+	}
+	return nil
+}
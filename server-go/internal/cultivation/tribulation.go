@@ -0,0 +1,403 @@
+package cultivation
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/alchemy"
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/inventory"
+	"xiuxian/server-go/internal/models"
+)
+
+// 渡劫所需物品类型
+const (
+	TribulationItemSpiritStones = "spirit_stones" // 灵石
+	TribulationItemHerb         = "herb"          // 灵草（按灵草ID，不限品质）
+	TribulationItemPill         = "pill"          // 丹药（按丹药ID）
+)
+
+const (
+	// 新角色及渡劫成功后的默认渡劫丹加成
+	DefaultDuJieRate = 0.05
+)
+
+// TribulationItem 渡劫所需物品
+type TribulationItem struct {
+	Type   string `json:"type"`
+	ItemID string `json:"itemId,omitempty"`
+	Name   string `json:"name"`
+	Count  int    `json:"count"`
+}
+
+// TribulationPenalty 渡劫失败惩罚
+type TribulationPenalty struct {
+	CultivationLoss float64 `json:"cultivationLoss"` // 损失当前修为的比例
+	LevelDrop       int     `json:"levelDrop"`       // 跌落层数
+}
+
+// TribulationConfig 大境界渡劫配置（FromLevel 为大境界最后一层，加载境界表时填充）
+type TribulationConfig struct {
+	FromLevel       int                `json:"fromLevel"`
+	Name            string             `json:"name"`
+	BaseSuccessRate float64            `json:"baseSuccessRate"` // 基础成功率
+	PityIncrement   float64            `json:"pityIncrement"`   // 每次失败累计的成功率
+	MaxPity         float64            `json:"maxPity"`         // 累计成功率上限
+	RequiredItems   []TribulationItem  `json:"requiredItems"`   // 渡劫消耗（成败均消耗）
+	SacrificeItems  bool               `json:"sacrificeItems"`  // 是否以最高品质装备与灵宠抵挡天劫
+	FailurePenalty  TribulationPenalty `json:"failurePenalty"`
+	// 天劫之战：需抵挡的雷劫波数，每抵挡一波额外提升的成功率
+	LightningWaves   int     `json:"lightningWaves"`
+	WaveSuccessBonus float64 `json:"waveSuccessBonus"`
+	SuccessMessage   string  `json:"successMessage"`
+	FailureMessage   string  `json:"failureMessage"`
+}
+
+// TribulationResult 渡劫结果
+type TribulationResult struct {
+	Success           bool    `json:"success"`
+	Message           string  `json:"message"`
+	SuccessRate       float64 `json:"successRate"`
+	NewLevel          int     `json:"newLevel,omitempty"`
+	NewRealm          string  `json:"newRealm,omitempty"`
+	DuJieRate         float64 `json:"duJieRate"`
+	Pity              float64 `json:"pity"`
+	CultivationLost   float64 `json:"cultivationLost,omitempty"`
+	LevelDropped      int     `json:"levelDropped,omitempty"`
+	ConsumedEquipment string  `json:"consumedEquipment,omitempty"`
+	ConsumedPet       string  `json:"consumedPet,omitempty"`
+	ConsumeMessage    string  `json:"consumeMessage,omitempty"`
+}
+
+// TribulationStatus 当前渡劫信息
+type TribulationStatus struct {
+	Config      *TribulationConfig    `json:"config"`
+	Ready       bool                  `json:"ready"` // 修为是否已满
+	SuccessRate float64               `json:"successRate"`
+	DuJieRate   float64               `json:"duJieRate"`
+	Pity        float64               `json:"pity"`
+	Toxicity    alchemy.ToxicityState `json:"toxicity"` // 丹毒过深会降低渡劫成功率
+	MissingItem []TribulationItem     `json:"missingItems"`
+	Aids        []TribulationAid      `json:"aids,omitempty"`   // 雷劫间隙可用的丹药与符箓
+	Battle      *TribulationBattle    `json:"battle,omitempty"` // 进行中的天劫之战
+}
+
+// GetTribulationConfig 获取当前等级的渡劫配置（来自境界表），非大境界最后一层返回 nil
+func GetTribulationConfig(level int) *TribulationConfig {
+	realm := GetRealmByLevel(level)
+	if realm == nil {
+		return nil
+	}
+	return realm.Tribulation
+}
+
+// IsTribulationLevel 该等级突破是否需要渡劫（不自动突破）
+func IsTribulationLevel(level int) bool {
+	return GetTribulationConfig(level) != nil
+}
+
+// getAttrFloat 读取属性中的浮点数
+func getAttrFloat(attrs map[string]interface{}, key string, defaultValue float64) float64 {
+	if v, ok := attrs[key].(float64); ok {
+		return v
+	}
+	return defaultValue
+}
+
+// calculateTribulationRate 计算渡劫成功率 = 基础成功率 + 渡劫丹加成 + 失败累计 - 丹毒惩罚
+func calculateTribulationRate(config *TribulationConfig, attrs map[string]interface{}) float64 {
+	duJieRate := getAttrFloat(attrs, "duJieRate", DefaultDuJieRate)
+	pity := getAttrFloat(attrs, "tribulationPity", 0)
+	penalty := alchemy.GetToxicityState(attrs, time.Now()).TribulationPenalty
+	return math.Max(0, math.Min(1, config.BaseSuccessRate+duJieRate+pity-penalty))
+}
+
+// CopyTribulationState 重建属性时保留渡劫丹加成与失败累计
+func CopyTribulationState(dst, src map[string]interface{}) {
+	for _, key := range []string{"duJieRate", "tribulationPity"} {
+		if v, ok := src[key]; ok {
+			dst[key] = v
+		}
+	}
+}
+
+// lockUser 在事务内锁定玩家行，渡劫相关的扣除与结算都以锁定后的数据为准
+func lockUser(tx *gorm.DB, userID uint) (*models.User, error) {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// findMissingItems 检查渡劫物品是否齐全
+func findMissingItems(tx *gorm.DB, user *models.User, items []TribulationItem) ([]TribulationItem, error) {
+	missing := []TribulationItem{}
+	for _, item := range items {
+		var owned int64
+		switch item.Type {
+		case TribulationItemSpiritStones:
+			owned = int64(user.SpiritStones)
+		case TribulationItemHerb:
+			if err := tx.Model(&models.Herb{}).Where("user_id = ? AND herb_id = ?", user.ID, item.ItemID).
+				Select("COALESCE(SUM(count), 0)").Scan(&owned).Error; err != nil {
+				return nil, fmt.Errorf("failed to count herbs: %w", err)
+			}
+		case TribulationItemPill:
+			if err := tx.Model(&models.Pill{}).Where("user_id = ? AND pill_id = ?", user.ID, item.ItemID).
+				Select("COALESCE(SUM(count), 0)").Scan(&owned).Error; err != nil {
+				return nil, fmt.Errorf("failed to count pills: %w", err)
+			}
+		}
+		if owned < int64(item.Count) {
+			missing = append(missing, item)
+		}
+	}
+	return missing, nil
+}
+
+// consumeTribulationItems 扣除渡劫物品
+func consumeTribulationItems(tx *gorm.DB, user *models.User, items []TribulationItem) error {
+	for _, item := range items {
+		switch item.Type {
+		case TribulationItemSpiritStones:
+			result := tx.Model(&models.User{}).
+				Where("id = ? AND spirit_stones >= ?", user.ID, item.Count).
+				Update("spirit_stones", gorm.Expr("spirit_stones - ?", item.Count))
+			if result.Error != nil {
+				return fmt.Errorf("failed to consume spirit stones: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("灵石不足，需要%d", item.Count)
+			}
+			user.SpiritStones -= item.Count
+		case TribulationItemHerb:
+			// 灵草按品质分行存储，依次扣除
+			var herbs []models.Herb
+			if err := tx.Where("user_id = ? AND herb_id = ? AND count > 0", user.ID, item.ItemID).
+				Order("id").Find(&herbs).Error; err != nil {
+				return fmt.Errorf("failed to query herbs: %w", err)
+			}
+			remaining := item.Count
+			for _, herb := range herbs {
+				if remaining <= 0 {
+					break
+				}
+				used := herb.Count
+				if used > remaining {
+					used = remaining
+				}
+				if used == herb.Count {
+					if err := tx.Delete(&herb).Error; err != nil {
+						return fmt.Errorf("failed to consume herb: %w", err)
+					}
+				} else if err := tx.Model(&herb).Update("count", herb.Count-used).Error; err != nil {
+					return fmt.Errorf("failed to consume herb: %w", err)
+				}
+				remaining -= used
+			}
+		case TribulationItemPill:
+			// 丹药按品质堆叠，依次扣除
+			var pills []models.Pill
+			if err := tx.Where("user_id = ? AND pill_id = ? AND count > 0", user.ID, item.ItemID).
+				Order("id").Find(&pills).Error; err != nil {
+				return fmt.Errorf("failed to query pills: %w", err)
+			}
+			remaining := item.Count
+			for i := range pills {
+				if remaining <= 0 {
+					break
+				}
+				used := pills[i].Count
+				if used > remaining {
+					used = remaining
+				}
+				if err := inventory.TakePills(tx, &pills[i], used); err != nil {
+					return fmt.Errorf("failed to consume pills: %w", err)
+				}
+				remaining -= used
+			}
+		}
+	}
+	return nil
+}
+
+// GetTribulationStatus 获取当前渡劫信息（成功率、所需物品）
+func (s *CultivationService) GetTribulationStatus() (*TribulationStatus, error) {
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	config := GetTribulationConfig(user.Level)
+	if config == nil {
+		return &TribulationStatus{MissingItem: []TribulationItem{}}, nil
+	}
+
+	attrs := s.getPlayerAttributes(&user)
+	duJieRate := getAttrFloat(attrs, "duJieRate", DefaultDuJieRate)
+	pity := getAttrFloat(attrs, "tribulationPity", 0)
+
+	missing, err := findMissingItems(db.DB, &user, config.RequiredItems)
+	if err != nil {
+		return nil, err
+	}
+	battle, err := s.LoadTribulationBattle()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TribulationStatus{
+		Config:      config,
+		Ready:       user.Cultivation >= user.MaxCultivation,
+		SuccessRate: calculateTribulationRate(config, attrs),
+		DuJieRate:   duJieRate,
+		Pity:        pity,
+		Toxicity:    alchemy.GetToxicityState(attrs, time.Now()),
+		MissingItem: missing,
+		Aids:        tribulationAids,
+		Battle:      battle,
+	}, nil
+}
+
+// AttemptTribulation 渡劫突破大境界
+func (s *CultivationService) AttemptTribulation() (*TribulationResult, error) {
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	config := GetTribulationConfig(user.Level)
+	if config == nil || user.Cultivation < user.MaxCultivation {
+		return &TribulationResult{Success: false, Message: "不满足渡劫条件"}, nil
+	}
+	if GetNextRealm(user.Level) == nil {
+		return &TribulationResult{Success: false, Message: "已是最高境界"}, nil
+	}
+
+	attrs := s.getPlayerAttributes(&user)
+	successRate := calculateTribulationRate(config, attrs)
+
+	if active, _ := s.LoadTribulationBattle(); active != nil {
+		return &TribulationResult{Success: false, Message: "天劫之战进行中，请先完成雷劫"}, nil
+	}
+
+	result := &TribulationResult{SuccessRate: successRate}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// 锁定玩家后重新校验，防止并发渡劫重复扣除与结算
+		locked, err := lockUser(tx, s.userID)
+		if err != nil {
+			return err
+		}
+		if locked.Level != user.Level || locked.Cultivation < locked.MaxCultivation {
+			result.Message = "不满足渡劫条件"
+			return nil
+		}
+		attrs = s.getPlayerAttributes(locked)
+		result.SuccessRate = calculateTribulationRate(config, attrs)
+
+		missing, err := findMissingItems(tx, locked, config.RequiredItems)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			result.Message = fmt.Sprintf("渡劫所需物品不足：%s×%d", missing[0].Name, missing[0].Count)
+			return nil
+		}
+
+		// 渡劫消耗：成败均扣除
+		if err := consumeTribulationItems(tx, locked, config.RequiredItems); err != nil {
+			return err
+		}
+		return s.resolveTribulation(tx, locked, attrs, config, result)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attempt tribulation: %w", err)
+	}
+
+	return result, nil
+}
+
+// resolveTribulation 按 result.SuccessRate 判定渡劫成败并保存（渡劫物品已由调用方扣除）
+func (s *CultivationService) resolveTribulation(tx *gorm.DB, user *models.User, attrs map[string]interface{}, config *TribulationConfig, result *TribulationResult) error {
+	nextRealm := GetNextRealm(user.Level)
+	if nextRealm == nil {
+		result.Message = "已是最高境界"
+		return nil
+	}
+
+	if config.SacrificeItems {
+		var err error
+		result.ConsumedEquipment, result.ConsumedPet, err = s.consumeHighestQualityItems(tx, user)
+		if err != nil {
+			return fmt.Errorf("failed to consume items: %w", err)
+		}
+		result.ConsumeMessage = buildConsumeMessage(result.ConsumedEquipment, result.ConsumedPet)
+	}
+
+	pity := getAttrFloat(attrs, "tribulationPity", 0)
+	if rand.Float64() < result.SuccessRate {
+		user.Level = nextRealm.Level
+		user.Realm = nextRealm.Name
+		user.MaxCultivation = nextRealm.MaxCultivation
+		user.Cultivation = 0.0
+		attrs["duJieRate"] = DefaultDuJieRate
+		attrs["tribulationPity"] = 0.0
+
+		result.Success = true
+		result.Message = config.SuccessMessage
+		result.NewLevel = user.Level
+		result.NewRealm = user.Realm
+	} else {
+		s.applyTribulationPenalty(user, config.FailurePenalty, result)
+		attrs["duJieRate"] = 0.0
+		attrs["tribulationPity"] = math.Min(config.MaxPity, pity+config.PityIncrement)
+
+		result.Message = config.FailureMessage
+	}
+	result.DuJieRate = attrs["duJieRate"].(float64)
+	result.Pity = attrs["tribulationPity"].(float64)
+
+	// 境界变化或消耗装备灵宠后重新计算属性
+	s.reinitializePlayerAttributes(tx, user, &attrs)
+	if result.Success {
+		s.unlockRealm(user, &attrs)
+	}
+	s.setPlayerAttributes(user, attrs)
+
+	return tx.Model(user).Updates(map[string]interface{}{
+		"level":              user.Level,
+		"realm":              user.Realm,
+		"cultivation":        user.Cultivation,
+		"max_cultivation":    user.MaxCultivation,
+		"base_attributes":    user.BaseAttributes,
+		"combat_attributes":  user.CombatAttributes,
+		"combat_resistance":  user.CombatResistance,
+		"special_attributes": user.SpecialAttributes,
+	}).Error
+}
+
+// applyTribulationPenalty 应用渡劫失败惩罚
+func (s *CultivationService) applyTribulationPenalty(user *models.User, penalty TribulationPenalty, result *TribulationResult) {
+	if penalty.LevelDrop > 0 {
+		targetLevel := user.Level - penalty.LevelDrop
+		if targetLevel < 1 {
+			targetLevel = 1
+		}
+		if realm := GetRealmByLevel(targetLevel); realm != nil {
+			result.LevelDropped = user.Level - realm.Level
+			user.Level = realm.Level
+			user.Realm = realm.Name
+			user.MaxCultivation = realm.MaxCultivation
+		}
+	}
+
+	lost := math.Round(user.Cultivation*penalty.CultivationLoss*10) / 10
+	user.Cultivation = math.Max(0, math.Min(user.Cultivation-lost, user.MaxCultivation))
+	result.CultivationLost = lost
+}
@@ -0,0 +1,503 @@
+package cultivation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/dungeon"
+	"xiuxian/server-go/internal/dungeon/battle"
+	"xiuxian/server-go/internal/dungeon/battle/engine"
+	"xiuxian/server-go/internal/dungeon/battle/resolver"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/redis"
+)
+
+const (
+	// 天劫之战状态键
+	TribulationBattleKeyFormat = "cultivation:tribulation:battle:%d"
+	// 天劫之战时限，超时未结算按渡劫失败处理
+	TribulationBattleTTL = time.Hour
+	// 超时后状态继续保留的时长，玩家回来时据此判定失败，避免渡劫物品被静默吞掉
+	tribulationBattleKeepAlive = 7 * 24 * time.Hour
+	// 天劫之战操作锁，串行化同一玩家的读取、扣费与保存
+	TribulationBattleLockKeyFormat = "cultivation:tribulation:battle:lock:%d"
+	tribulationBattleLockTTL       = 10 * time.Second
+)
+
+// TribulationAid 雷劫间隙可使用的丹药或符箓（以灵石购置，每场天劫限用一次）
+type TribulationAid struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description"`
+	CostPerLevel int                    `json:"costPerLevel"`      // 灵石消耗 = 等级 × CostPerLevel
+	Heal         float64                `json:"heal,omitempty"`    // 回复最大生命的比例
+	Effects      map[string]interface{} `json:"effects,omitempty"` // 交由 resolver.ApplyBuffEffectsToStats 处理
+}
+
+// tribulationAids 天劫辅助道具
+var tribulationAids = []TribulationAid{
+	{ID: "rejuvenation_pill", Name: "回春丹", Description: "回复40%生命", CostPerLevel: 50, Heal: 0.4},
+	{ID: "thunder_ward_talisman", Name: "避雷符", Description: "最终减伤提升15%", CostPerLevel: 80, Effects: map[string]interface{}{"finalDamageReduce": 0.15}},
+	{ID: "golden_body_talisman", Name: "金身符", Description: "防御提升20%", CostPerLevel: 60, Effects: map[string]interface{}{"defense": 0.2}},
+	{ID: "thunder_break_talisman", Name: "破雷符", Description: "攻击提升20%", CostPerLevel: 60, Effects: map[string]interface{}{"damage": 0.2}},
+}
+
+// GetTribulationAid 根据ID获取天劫辅助道具
+func GetTribulationAid(aidID string) *TribulationAid {
+	for i := range tribulationAids {
+		if tribulationAids[i].ID == aidID {
+			return &tribulationAids[i]
+		}
+	}
+	return nil
+}
+
+// TribulationBattle 天劫之战状态（保存在Redis中）
+type TribulationBattle struct {
+	FromLevel       int      `json:"fromLevel"`
+	Wave            int      `json:"wave"` // 下一波雷劫（从1开始）
+	TotalWaves      int      `json:"totalWaves"`
+	WavesSurvived   int      `json:"wavesSurvived"`
+	PlayerHealth    float64  `json:"playerHealth"`
+	PlayerMaxHealth float64  `json:"playerMaxHealth"`
+	UsedAids        []string `json:"usedAids"`
+	SuccessRate     float64  `json:"successRate"` // 按已抵挡波数计算的当前成功率
+	StartedAt       int64    `json:"startedAt"`
+}
+
+// TribulationWaveResult 单波雷劫结果
+type TribulationWaveResult struct {
+	Wave         int                `json:"wave"`
+	EnemyName    string             `json:"enemyName"`
+	Victory      bool               `json:"victory"`
+	Rounds       int                `json:"rounds"`
+	PlayerHealth float64            `json:"playerHealth"`
+	Logs         []string           `json:"logs"`
+	Battle       *TribulationBattle `json:"battle,omitempty"`
+	Result       *TribulationResult `json:"result,omitempty"` // 雷劫结束后的渡劫结果
+}
+
+// LoadTribulationBattle 读取进行中的天劫之战，不存在时返回 nil
+// 已超过时限的天劫之战在此按渡劫失败结算
+func (s *CultivationService) LoadTribulationBattle() (*TribulationBattle, error) {
+	state, err := s.loadTribulationBattleState()
+	if err != nil || state == nil {
+		return state, err
+	}
+	if time.Now().Unix() < state.StartedAt+int64(TribulationBattleTTL.Seconds()) {
+		return state, nil
+	}
+	if err := s.expireTribulationBattle(state); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// expireTribulationBattle 天劫之战超时：按渡劫失败结算
+func (s *CultivationService) expireTribulationBattle(state *TribulationBattle) error {
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	result, err := s.finishTribulationBattle(&user, state, true)
+	if errors.Is(err, errTribulationBattleFinished) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("[Tribulation] 玩家 %d 天劫之战超时，按渡劫失败结算: %s", s.userID, result.Message)
+	return nil
+}
+
+// loadTribulationBattleState 读取天劫之战状态，不判断是否超时
+func (s *CultivationService) loadTribulationBattleState() (*TribulationBattle, error) {
+	key := fmt.Sprintf(TribulationBattleKeyFormat, s.userID)
+	data, err := redis.Client.Get(redis.Ctx, key).Result()
+	if errors.Is(err, redisv9.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tribulation battle: %w", err)
+	}
+
+	var state TribulationBattle
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return nil, fmt.Errorf("failed to parse tribulation battle: %w", err)
+	}
+	return &state, nil
+}
+
+// errTribulationBattleFinished 天劫之战已被其他请求结算
+var errTribulationBattleFinished = errors.New("天劫之战已结束")
+
+// createTribulationBattle 创建天劫之战状态，已存在时返回 false
+func (s *CultivationService) createTribulationBattle(state *TribulationBattle) (bool, error) {
+	key := fmt.Sprintf(TribulationBattleKeyFormat, s.userID)
+	data, err := json.Marshal(state)
+	if err != nil {
+		return false, err
+	}
+	return redis.Client.SetNX(redis.Ctx, key, string(data), TribulationBattleTTL+tribulationBattleKeepAlive).Result()
+}
+
+// saveTribulationBattle 保存天劫之战进度，状态已被结算清除时返回 errTribulationBattleFinished
+func (s *CultivationService) saveTribulationBattle(state *TribulationBattle) error {
+	key := fmt.Sprintf(TribulationBattleKeyFormat, s.userID)
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	ok, err := redis.Client.SetXX(redis.Ctx, key, string(data), TribulationBattleTTL+tribulationBattleKeepAlive).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errTribulationBattleFinished
+	}
+	return nil
+}
+
+// clearTribulationBattle 清除天劫之战状态，只有真正删除了状态的请求才能结算
+func (s *CultivationService) clearTribulationBattle() error {
+	key := fmt.Sprintf(TribulationBattleKeyFormat, s.userID)
+	deleted, err := redis.Client.Del(redis.Ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return errTribulationBattleFinished
+	}
+	return nil
+}
+
+// withTribulationBattleLock 持有玩家天劫之战操作锁执行 fn
+func (s *CultivationService) withTribulationBattleLock(fn func() error) error {
+	key := fmt.Sprintf(TribulationBattleLockKeyFormat, s.userID)
+	ok, err := redis.Client.SetNX(redis.Ctx, key, time.Now().Unix(), tribulationBattleLockTTL).Result()
+	if err != nil {
+		return fmt.Errorf("failed to lock tribulation battle: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("天劫之战操作进行中，请稍后再试")
+	}
+	defer redis.Client.Del(redis.Ctx, key)
+	return fn()
+}
+
+// calculateBattleSuccessRate 天劫之战成功率 = 渡劫成功率 + 已抵挡波数 × 每波加成
+func calculateBattleSuccessRate(config *TribulationConfig, attrs map[string]interface{}, wavesSurvived int) float64 {
+	rate := calculateTribulationRate(config, attrs) + float64(wavesSurvived)*config.WaveSuccessBonus
+	return math.Min(1, rate)
+}
+
+// buildLightningWave 生成第 wave 波天雷，波数越高威力越大
+func buildLightningWave(level, wave, totalWaves int) (string, *battle.CombatStats) {
+	if level < 1 {
+		level = 1
+	}
+	l := float64(level)
+	scale := 0.6 + float64(wave-1)*0.2
+	name := fmt.Sprintf("第%d道天雷", wave)
+	if wave == totalWaves {
+		scale *= 1.3
+		name = "灭世神雷"
+	}
+
+	rate := math.Min(0.3, 0.05+0.02*float64(wave))
+	return name, battle.ToCombatStats(
+		80*l*scale, 12*l*scale, 4*l*scale, 12*l,
+		rate, rate*0.5, 0, rate, 0, 0,
+		rate*0.5, rate*0.5, rate*0.5, rate*0.5, rate*0.5, rate*0.5,
+		0, 0, 0, 0, 0, 0, 0,
+	)
+}
+
+// StartTribulationBattle 开始天劫之战：扣除渡劫物品，之后逐波抵挡雷劫
+func (s *CultivationService) StartTribulationBattle() (*TribulationBattle, error) {
+	if existing, err := s.LoadTribulationBattle(); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, fmt.Errorf("天劫之战进行中，请先完成雷劫")
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	config := GetTribulationConfig(user.Level)
+	if config == nil || config.LightningWaves <= 0 || user.Cultivation < user.MaxCultivation {
+		return nil, fmt.Errorf("不满足渡劫条件")
+	}
+	if GetNextRealm(user.Level) == nil {
+		return nil, fmt.Errorf("已是最高境界")
+	}
+
+	playerStats := dungeon.BuildPlayerCombatStats(&user)
+	state := &TribulationBattle{
+		FromLevel:       user.Level,
+		Wave:            1,
+		TotalWaves:      config.LightningWaves,
+		PlayerHealth:    playerStats.MaxHealth,
+		PlayerMaxHealth: playerStats.MaxHealth,
+		UsedAids:        []string{},
+		SuccessRate:     calculateBattleSuccessRate(config, s.getPlayerAttributes(&user), 0),
+		StartedAt:       time.Now().Unix(),
+	}
+	// 先占位再扣除物品，并发开启时只有一个请求能扣除
+	created, err := s.createTribulationBattle(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tribulation battle: %w", err)
+	}
+	if !created {
+		return nil, fmt.Errorf("天劫之战进行中，请先完成雷劫")
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockUser(tx, s.userID)
+		if err != nil {
+			return err
+		}
+		if locked.Level != user.Level || locked.Cultivation < locked.MaxCultivation {
+			return fmt.Errorf("不满足渡劫条件")
+		}
+		missing, err := findMissingItems(tx, locked, config.RequiredItems)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("渡劫所需物品不足：%s×%d", missing[0].Name, missing[0].Count)
+		}
+		return consumeTribulationItems(tx, locked, config.RequiredItems)
+	})
+	if err != nil {
+		s.clearTribulationBattle()
+		return nil, err
+	}
+
+	log.Printf("[Tribulation] 玩家 %d 开始%s，共 %d 波雷劫", s.userID, config.Name, state.TotalWaves)
+	return state, nil
+}
+
+// UseTribulationAid 雷劫间隙使用丹药或符箓
+func (s *CultivationService) UseTribulationAid(aidID string) (*TribulationBattle, error) {
+	var result *TribulationBattle
+	err := s.withTribulationBattleLock(func() error {
+		var err error
+		result, err = s.useTribulationAid(aidID)
+		return err
+	})
+	return result, err
+}
+
+// useTribulationAid 雷劫间隙使用丹药或符箓，调用方需持有天劫之战操作锁
+func (s *CultivationService) useTribulationAid(aidID string) (*TribulationBattle, error) {
+	state, err := s.LoadTribulationBattle()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("没有进行中的天劫之战")
+	}
+
+	aid := GetTribulationAid(aidID)
+	if aid == nil {
+		return nil, fmt.Errorf("无效的道具")
+	}
+	for _, used := range state.UsedAids {
+		if used == aidID {
+			return nil, fmt.Errorf("%s本次天劫已使用过", aid.Name)
+		}
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	cost := aid.CostPerLevel * user.Level
+	spent := db.DB.Model(&models.User{}).
+		Where("id = ? AND spirit_stones >= ?", s.userID, cost).
+		Update("spirit_stones", gorm.Expr("spirit_stones - ?", cost))
+	if spent.Error != nil {
+		return nil, fmt.Errorf("failed to update user: %w", spent.Error)
+	}
+	if spent.RowsAffected == 0 {
+		return nil, fmt.Errorf("灵石不足，需要%d", cost)
+	}
+
+	if aid.Heal > 0 {
+		state.PlayerHealth = math.Min(state.PlayerMaxHealth, state.PlayerHealth+state.PlayerMaxHealth*aid.Heal)
+	}
+	state.UsedAids = append(state.UsedAids, aidID)
+	if err := s.saveTribulationBattle(state); err != nil {
+		// 天劫之战已结束或保存失败：退还灵石
+		db.DB.Model(&models.User{}).Where("id = ?", s.userID).Update("spirit_stones", gorm.Expr("spirit_stones + ?", cost))
+		return nil, fmt.Errorf("failed to save tribulation battle: %w", err)
+	}
+	return state, nil
+}
+
+// FightTribulationWave 抵挡下一波雷劫，全部抵挡或战败后结算渡劫
+func (s *CultivationService) FightTribulationWave() (*TribulationWaveResult, error) {
+	var result *TribulationWaveResult
+	err := s.withTribulationBattleLock(func() error {
+		var err error
+		result, err = s.fightTribulationWave()
+		return err
+	})
+	return result, err
+}
+
+// fightTribulationWave 抵挡下一波雷劫，全部抵挡或战败后结算渡劫，调用方需持有天劫之战操作锁
+func (s *CultivationService) fightTribulationWave() (*TribulationWaveResult, error) {
+	state, err := s.LoadTribulationBattle()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("没有进行中的天劫之战")
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Level != state.FromLevel {
+		s.clearTribulationBattle()
+		return nil, fmt.Errorf("境界已变化，天劫之战已失效")
+	}
+
+	// 玩家属性叠加已使用的符箓
+	playerStats := dungeon.BuildPlayerCombatStats(&user)
+	for _, aidID := range state.UsedAids {
+		if aid := GetTribulationAid(aidID); aid != nil && len(aid.Effects) > 0 {
+			resolver.ApplyBuffEffectsToStats(playerStats, aid.Effects)
+		}
+	}
+	enemyName, enemyStats := buildLightningWave(user.Level, state.Wave, state.TotalWaves)
+
+	battleEngine := engine.NewBattleEngine(playerStats, enemyStats)
+	battleEngine.SetPlayerHealth(state.PlayerHealth)
+	rounds := 0
+	for !battleEngine.IsFinished() {
+		battleEngine.ExecuteRound()
+		rounds++
+	}
+	victory, playerHealth, _ := battleEngine.GetFinalResult()
+
+	result := &TribulationWaveResult{
+		Wave:         state.Wave,
+		EnemyName:    enemyName,
+		Victory:      victory,
+		Rounds:       rounds,
+		PlayerHealth: playerHealth,
+		Logs:         battleEngine.GetBattleLog(),
+	}
+
+	if victory {
+		state.WavesSurvived++
+		state.Wave++
+		state.PlayerHealth = playerHealth
+	}
+	config := GetTribulationConfig(state.FromLevel)
+	state.SuccessRate = calculateBattleSuccessRate(config, s.getPlayerAttributes(&user), state.WavesSurvived)
+
+	// 尚有雷劫未抵挡：保存进度，等待下一波
+	if victory && state.Wave <= state.TotalWaves {
+		if err := s.saveTribulationBattle(state); err != nil {
+			return nil, fmt.Errorf("failed to save tribulation battle: %w", err)
+		}
+		result.Battle = state
+		return result, nil
+	}
+
+	result.Result, err = s.finishTribulationBattle(&user, state, false)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// EndTribulationBattle 提前结束天劫之战，按已抵挡的波数结算
+func (s *CultivationService) EndTribulationBattle() (*TribulationResult, error) {
+	var result *TribulationResult
+	err := s.withTribulationBattleLock(func() error {
+		var err error
+		result, err = s.endTribulationBattle()
+		return err
+	})
+	return result, err
+}
+
+// endTribulationBattle 提前结束天劫之战，按已抵挡的波数结算，调用方需持有天劫之战操作锁
+func (s *CultivationService) endTribulationBattle() (*TribulationResult, error) {
+	state, err := s.LoadTribulationBattle()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("没有进行中的天劫之战")
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.Level != state.FromLevel {
+		s.clearTribulationBattle()
+		return nil, fmt.Errorf("境界已变化，天劫之战已失效")
+	}
+	return s.finishTribulationBattle(&user, state, false)
+}
+
+// finishTribulationBattle 清除天劫之战状态并判定渡劫成败，expired 为超时结算（必定失败）
+// 只有成功删除状态的请求才会结算，防止并发重复结算
+func (s *CultivationService) finishTribulationBattle(user *models.User, state *TribulationBattle, expired bool) (*TribulationResult, error) {
+	if err := s.clearTribulationBattle(); err != nil {
+		if errors.Is(err, errTribulationBattleFinished) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to clear tribulation battle: %w", err)
+	}
+
+	config := GetTribulationConfig(state.FromLevel)
+	result := &TribulationResult{}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockUser(tx, s.userID)
+		if err != nil {
+			return err
+		}
+		if locked.Level != state.FromLevel {
+			result.Message = "境界已变化，天劫之战已失效"
+			return nil
+		}
+		attrs := s.getPlayerAttributes(locked)
+		if !expired {
+			result.SuccessRate = calculateBattleSuccessRate(config, attrs, state.WavesSurvived)
+		}
+		if err := s.resolveTribulation(tx, locked, attrs, config, result); err != nil {
+			return err
+		}
+		*user = *locked
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tribulation: %w", err)
+	}
+	if expired && !result.Success {
+		result.Message = "天劫之战超时未决，" + result.Message
+	}
+
+	log.Printf("[Tribulation] 玩家 %d 抵挡 %d/%d 波雷劫，成功率 %.2f，结果: %v",
+		s.userID, state.WavesSurvived, state.TotalWaves, result.SuccessRate, result.Success)
+	return result, nil
+}
@@ -11,7 +11,10 @@ import (
 	"xiuxian/server-go/internal/dungeon/battle"
 	"xiuxian/server-go/internal/dungeon/battle/formula"
 	"xiuxian/server-go/internal/dungeon/battle/resolver"
+	"xiuxian/server-go/internal/formation"
+	"xiuxian/server-go/internal/gacha"
 	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/pillbuff"
 	"xiuxian/server-go/internal/redis"
 )
 
@@ -70,6 +73,12 @@ type DuelCombatStats struct {
 	StunResist    float64 `json:"stun_resist"`
 	DodgeResist   float64 `json:"dodge_resist"`
 	VampireResist float64 `json:"vampire_resist"`
+	WardReduce    float64 `json:"ward_reduce,omitempty"` // 护体阵法提供的最终减伤
+
+	FinalDamageBoost  float64 `json:"final_damage_boost,omitempty"`  // 在默认值之上额外的最终增伤
+	FinalDamageReduce float64 `json:"final_damage_reduce,omitempty"` // 在默认值之上额外的最终减伤
+
+	SetEffects map[string]float64 `json:"set_effects,omitempty"` // 套装战斗特效
 }
 
 // PvPRoundData 斗法单回合数据
@@ -80,9 +89,16 @@ type PvPRoundData struct {
 	Logs           []string      `json:"logs"`
 	BattleEnded    bool          `json:"battle_ended"`
 	Victory        bool          `json:"victory"`
+	Stars          int           `json:"stars,omitempty"` // PvE 通关星级
 	Rewards        []interface{} `json:"rewards,omitempty"`
 }
 
+// applyPillBuffs 叠加破军丹、虎威丹等限时丹药的战斗加成
+func applyPillBuffs(userID uint, stats *DuelCombatStats) {
+	stats.Attack *= 1 + pillbuff.GetActiveEffect(userID, pillbuff.EffectAttackBoost)
+	stats.CritRate += pillbuff.GetActiveEffect(userID, pillbuff.EffectCritRate)
+}
+
 // convertGinHToStats 将gin.H转换为DuelCombatStats
 func convertGinHToStats(data interface{}) *DuelCombatStats {
 	stats := &DuelCombatStats{}
@@ -151,7 +167,7 @@ func convertGinHToStats(data interface{}) *DuelCombatStats {
 
 // convertDuelStatsToBattleStats 将DuelCombatStats转换为battle.CombatStats
 func convertDuelStatsToBattleStats(stats *DuelCombatStats) *battle.CombatStats {
-	return &battle.CombatStats{
+	result := &battle.CombatStats{
 		Health:            stats.Health,
 		MaxHealth:         stats.Health,
 		Damage:            stats.Attack,
@@ -177,6 +193,12 @@ func convertDuelStatsToBattleStats(stats *DuelCombatStats) *battle.CombatStats {
 		CombatBoost:       0.03, // 默认值
 		ResistanceBoost:   0.03, // 默认值
 	}
+	result.FinalDamageBoost += stats.FinalDamageBoost
+	result.FinalDamageReduce += stats.FinalDamageReduce
+	// 护体阵法减伤
+	result.FinalDamageReduce += stats.WardReduce
+	battle.ApplySetEffects(result, stats.SetEffects)
+	return result
 }
 
 // StartPvPBattle 开始斗法战斗
@@ -195,7 +217,11 @@ func (s *PvPBattleService) StartPvPBattle(playerData, opponentData interface{})
 
 	// 转换玩家属性
 	playerStats := convertGinHToStats(playerData)
+	playerStats.WardReduce = formation.GetActiveEffect(uint(s.playerID), formation.EffectCombatWard)
+	applyPillBuffs(uint(s.playerID), playerStats)
+	playerStats.SetEffects = gacha.GetEquippedSetEffects(uint(s.playerID))
 	opponentStats := convertGinHToStats(opponentData)
+	opponentStats.SetEffects = gacha.GetEquippedSetEffects(uint(s.opponentID))
 
 	// 创建战斗状态并保存到Redis
 	battleStatus := &PvPBattleStatus{
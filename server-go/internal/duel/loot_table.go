@@ -0,0 +1,448 @@
+package duel
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/exploration"
+	"xiuxian/server-go/internal/gacha"
+	"xiuxian/server-go/internal/gem"
+	"xiuxian/server-go/internal/inventory"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/redis"
+)
+
+// 掉落类型
+const (
+	LootTypeHerb            = "herb"
+	LootTypePillFragment    = "pill_fragment"
+	LootTypeReinforceStone  = "reinforce_stone"
+	LootTypeRefinementStone = "refinement_stone"
+	LootTypePetEssence      = "pet_essence"
+	LootTypeEquipment       = "equipment"
+	LootTypeGem             = "gem"
+)
+
+// 保底计数键：pve:loot:pity:玩家ID:掉落表ID
+const lootPityKeyFormat = "pve:loot:pity:%d:%s"
+
+// LootEntry 掉落表条目
+type LootEntry struct {
+	Type     string  `json:"type"`
+	ItemID   string  `json:"itemId,omitempty"` // 灵草ID / 丹方ID
+	Name     string  `json:"name"`
+	Weight   float64 `json:"weight"`
+	MinCount int     `json:"minCount"`
+	MaxCount int     `json:"maxCount"`
+	Tier     int     `json:"tier,omitempty"` // 宝石阶数，宝石种类随机
+	Rare     bool    `json:"rare"`           // 稀有掉落，计入保底
+}
+
+// LootTable 妖兽掉落表
+type LootTable struct {
+	ID            string      `json:"id"`
+	Rolls         int         `json:"rolls"`         // 随机掉落次数
+	DropChance    float64     `json:"dropChance"`    // 每次随机掉落的触发概率
+	Guaranteed    []LootEntry `json:"guaranteed"`    // 必定掉落
+	Entries       []LootEntry `json:"entries"`       // 随机掉落池（按权重）
+	PityThreshold int         `json:"pityThreshold"` // 连续N场未出稀有掉落时必出（0 表示无保底）
+}
+
+// LootDrop 实际掉落结果
+type LootDrop struct {
+	Type     string `json:"type"`
+	ItemID   string `json:"itemId,omitempty"`
+	Name     string `json:"name"`
+	Count    int    `json:"count"`
+	Tier     int    `json:"tier,omitempty"`
+	Rare     bool   `json:"rare"`
+	FromPity bool   `json:"fromPity"`
+}
+
+// LootDropRate 掉落概率公示
+type LootDropRate struct {
+	Type       string  `json:"type"`
+	ItemID     string  `json:"itemId,omitempty"`
+	Name       string  `json:"name"`
+	MinCount   int     `json:"minCount"`
+	MaxCount   int     `json:"maxCount"`
+	Chance     float64 `json:"chance"` // 单场战斗至少掉落一次的概率
+	Guaranteed bool    `json:"guaranteed"`
+	Rare       bool    `json:"rare"`
+}
+
+// LootDisclosure 掉落表公示信息
+type LootDisclosure struct {
+	TableID       string         `json:"tableId"`
+	Rolls         int            `json:"rolls"`
+	PityThreshold int            `json:"pityThreshold"`
+	Drops         []LootDropRate `json:"drops"`
+}
+
+// difficultyLootTables 各境界妖兽的通用掉落表
+var difficultyLootTables = map[string]*LootTable{
+	"lianqi": {
+		ID:         "lianqi",
+		Rolls:      1,
+		DropChance: 0.6,
+		Guaranteed: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", MinCount: 1, MaxCount: 3},
+		},
+		Entries: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", Weight: 40, MinCount: 2, MaxCount: 5},
+			{Type: LootTypeRefinementStone, Name: "洗练石", Weight: 30, MinCount: 1, MaxCount: 3},
+			{Type: LootTypePetEssence, Name: "灵宠精华", Weight: 25, MinCount: 5, MaxCount: 10},
+			{Type: LootTypeGem, Name: "一阶宝石", Tier: 1, Weight: 8, MinCount: 1, MaxCount: 1},
+			{Type: LootTypeEquipment, Name: "随机装备", Weight: 5, MinCount: 1, MaxCount: 1, Rare: true},
+		},
+		PityThreshold: 20,
+	},
+	"zhuji": {
+		ID:         "zhuji",
+		Rolls:      2,
+		DropChance: 0.6,
+		Guaranteed: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", MinCount: 3, MaxCount: 6},
+		},
+		Entries: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", Weight: 35, MinCount: 4, MaxCount: 8},
+			{Type: LootTypeRefinementStone, Name: "洗练石", Weight: 30, MinCount: 2, MaxCount: 5},
+			{Type: LootTypePetEssence, Name: "灵宠精华", Weight: 25, MinCount: 10, MaxCount: 20},
+			{Type: LootTypeGem, Name: "一阶宝石", Tier: 1, Weight: 8, MinCount: 1, MaxCount: 2},
+			{Type: LootTypeGem, Name: "二阶宝石", Tier: 2, Weight: 3, MinCount: 1, MaxCount: 1, Rare: true},
+			{Type: LootTypePillFragment, ItemID: "cultivation_boost", Name: "聚气丹", Weight: 4, MinCount: 1, MaxCount: 1, Rare: true},
+			{Type: LootTypeEquipment, Name: "随机装备", Weight: 6, MinCount: 1, MaxCount: 1, Rare: true},
+		},
+		PityThreshold: 15,
+	},
+	"jindan": {
+		ID:         "jindan",
+		Rolls:      3,
+		DropChance: 0.6,
+		Guaranteed: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", MinCount: 6, MaxCount: 10},
+			{Type: LootTypeRefinementStone, Name: "洗练石", MinCount: 2, MaxCount: 4},
+		},
+		Entries: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", Weight: 30, MinCount: 8, MaxCount: 15},
+			{Type: LootTypeRefinementStone, Name: "洗练石", Weight: 30, MinCount: 4, MaxCount: 8},
+			{Type: LootTypePetEssence, Name: "灵宠精华", Weight: 25, MinCount: 20, MaxCount: 40},
+			{Type: LootTypeGem, Name: "二阶宝石", Tier: 2, Weight: 8, MinCount: 1, MaxCount: 2},
+			{Type: LootTypeGem, Name: "三阶宝石", Tier: 3, Weight: 3, MinCount: 1, MaxCount: 1, Rare: true},
+			{Type: LootTypePillFragment, ItemID: "thunder_power", Name: "雷灵丹", Weight: 7, MinCount: 1, MaxCount: 1, Rare: true},
+			{Type: LootTypeEquipment, Name: "随机装备", Weight: 8, MinCount: 1, MaxCount: 1, Rare: true},
+		},
+		PityThreshold: 10,
+	},
+}
+
+// monsterLootTables 特定妖兽的专属掉落表，键为 "妖兽ID:难度"，优先于通用掉落表
+var monsterLootTables = map[string]*LootTable{
+	// 太阴玉蟾：腹中养殖灵草，额外掉落稀有灵草
+	"9:jindan": {
+		ID:         "monster_9_jindan",
+		Rolls:      3,
+		DropChance: 0.6,
+		Guaranteed: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", MinCount: 6, MaxCount: 10},
+		},
+		Entries: []LootEntry{
+			{Type: LootTypeHerb, ItemID: "nine_leaf_lingzhi", Name: "九叶灵芝", Weight: 25, MinCount: 1, MaxCount: 3},
+			{Type: LootTypeHerb, ItemID: "purple_ginseng", Name: "紫金参", Weight: 20, MinCount: 1, MaxCount: 2},
+			{Type: LootTypeRefinementStone, Name: "洗练石", Weight: 30, MinCount: 4, MaxCount: 8},
+			{Type: LootTypePetEssence, Name: "灵宠精华", Weight: 20, MinCount: 20, MaxCount: 40},
+			{Type: LootTypeHerb, ItemID: "immortal_jade_grass", Name: "仙玉草", Weight: 5, MinCount: 1, MaxCount: 1, Rare: true},
+		},
+		PityThreshold: 12,
+	},
+	// 百炼宗叛徒：额外掉落练器材料
+	"102:lianqi": {
+		ID:         "monster_102_lianqi",
+		Rolls:      2,
+		DropChance: 0.7,
+		Guaranteed: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", MinCount: 3, MaxCount: 6},
+		},
+		Entries: []LootEntry{
+			{Type: LootTypeReinforceStone, Name: "强化石", Weight: 45, MinCount: 3, MaxCount: 8},
+			{Type: LootTypeRefinementStone, Name: "洗练石", Weight: 45, MinCount: 2, MaxCount: 5},
+			{Type: LootTypeEquipment, Name: "随机装备", Weight: 10, MinCount: 1, MaxCount: 1, Rare: true},
+		},
+		PityThreshold: 10,
+	},
+	// 兽王宗叛徒：额外掉落灵宠精华
+	"103:lianqi": {
+		ID:         "monster_103_lianqi",
+		Rolls:      2,
+		DropChance: 0.7,
+		Guaranteed: []LootEntry{
+			{Type: LootTypePetEssence, Name: "灵宠精华", MinCount: 10, MaxCount: 20},
+		},
+		Entries: []LootEntry{
+			{Type: LootTypePetEssence, Name: "灵宠精华", Weight: 70, MinCount: 10, MaxCount: 25},
+			{Type: LootTypeReinforceStone, Name: "强化石", Weight: 30, MinCount: 2, MaxCount: 5},
+		},
+	},
+}
+
+// GetLootTable 获取妖兽掉落表（专属掉落表优先，其次为境界通用掉落表）
+func GetLootTable(monsterID int, difficulty string) *LootTable {
+	if table, ok := monsterLootTables[fmt.Sprintf("%d:%s", monsterID, difficulty)]; ok {
+		return table
+	}
+	return difficultyLootTables[difficulty]
+}
+
+// GetLootDisclosure 获取掉落概率公示
+func GetLootDisclosure(monsterID int, difficulty string) *LootDisclosure {
+	table := GetLootTable(monsterID, difficulty)
+	if table == nil {
+		return nil
+	}
+
+	disclosure := &LootDisclosure{
+		TableID:       table.ID,
+		Rolls:         table.Rolls,
+		PityThreshold: table.PityThreshold,
+		Drops:         []LootDropRate{},
+	}
+
+	for _, entry := range table.Guaranteed {
+		disclosure.Drops = append(disclosure.Drops, LootDropRate{
+			Type:       entry.Type,
+			ItemID:     entry.ItemID,
+			Name:       entry.Name,
+			MinCount:   entry.MinCount,
+			MaxCount:   entry.MaxCount,
+			Chance:     1,
+			Guaranteed: true,
+		})
+	}
+
+	totalWeight := table.totalWeight()
+	for _, entry := range table.Entries {
+		if totalWeight <= 0 {
+			break
+		}
+		// 单次抽取概率 p = 触发概率 × 权重占比，多次抽取至少命中一次的概率为 1-(1-p)^rolls
+		p := table.DropChance * entry.Weight / totalWeight
+		chance := 1 - math.Pow(1-p, float64(table.Rolls))
+		disclosure.Drops = append(disclosure.Drops, LootDropRate{
+			Type:     entry.Type,
+			ItemID:   entry.ItemID,
+			Name:     entry.Name,
+			MinCount: entry.MinCount,
+			MaxCount: entry.MaxCount,
+			Chance:   math.Round(chance*10000) / 10000,
+			Rare:     entry.Rare,
+		})
+	}
+
+	return disclosure
+}
+
+// totalWeight 随机掉落池总权重
+func (t *LootTable) totalWeight() float64 {
+	total := 0.0
+	for _, entry := range t.Entries {
+		total += entry.Weight
+	}
+	return total
+}
+
+// pickEntry 按权重从条目中选择一个
+func pickEntry(entries []LootEntry) *LootEntry {
+	total := 0.0
+	for _, entry := range entries {
+		total += entry.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+	rnd := rand.Float64() * total
+	for i := range entries {
+		rnd -= entries[i].Weight
+		if rnd < 0 {
+			return &entries[i]
+		}
+	}
+	return &entries[len(entries)-1]
+}
+
+// rollCount 在 [min, max] 范围内随机数量
+func rollCount(entry *LootEntry) int {
+	if entry.MaxCount <= entry.MinCount {
+		return entry.MinCount
+	}
+	return entry.MinCount + rand.Intn(entry.MaxCount-entry.MinCount+1)
+}
+
+// RollLoot 按掉落表为玩家抽取掉落，稀有掉落计入保底
+func RollLoot(playerID int64, table *LootTable) []LootDrop {
+	if table == nil {
+		return nil
+	}
+
+	drops := []LootDrop{}
+	for i := range table.Guaranteed {
+		entry := &table.Guaranteed[i]
+		drops = append(drops, LootDrop{Type: entry.Type, ItemID: entry.ItemID, Name: entry.Name, Count: rollCount(entry), Tier: entry.Tier})
+	}
+
+	gotRare := false
+	for r := 0; r < table.Rolls; r++ {
+		if rand.Float64() >= table.DropChance {
+			continue
+		}
+		entry := pickEntry(table.Entries)
+		if entry == nil {
+			continue
+		}
+		gotRare = gotRare || entry.Rare
+		drops = append(drops, LootDrop{Type: entry.Type, ItemID: entry.ItemID, Name: entry.Name, Count: rollCount(entry), Tier: entry.Tier, Rare: entry.Rare})
+	}
+
+	if table.PityThreshold <= 0 {
+		return drops
+	}
+
+	// 保底：连续未出稀有掉落达到阈值时必出一件稀有掉落
+	pityKey := fmt.Sprintf(lootPityKeyFormat, playerID, table.ID)
+	if gotRare {
+		redis.Client.Del(redis.Ctx, pityKey)
+		return drops
+	}
+
+	misses, err := redis.Client.Incr(redis.Ctx, pityKey).Result()
+	if err != nil {
+		log.Printf("[Loot] 更新保底计数失败: %v", err)
+		return drops
+	}
+	redis.Client.Expire(redis.Ctx, pityKey, 30*24*time.Hour)
+
+	if misses >= int64(table.PityThreshold) {
+		var rareEntries []LootEntry
+		for _, entry := range table.Entries {
+			if entry.Rare {
+				rareEntries = append(rareEntries, entry)
+			}
+		}
+		if entry := pickEntry(rareEntries); entry != nil {
+			drops = append(drops, LootDrop{Type: entry.Type, ItemID: entry.ItemID, Name: entry.Name, Count: rollCount(entry), Tier: entry.Tier, Rare: true, FromPity: true})
+			redis.Client.Del(redis.Ctx, pityKey)
+			log.Printf("[Loot] 玩家 %d 触发掉落表 %s 保底: %s", playerID, table.ID, entry.Name)
+		}
+	}
+
+	return drops
+}
+
+// GrantLootToPlayer 发放掉落物品，返回前端展示用的奖励项
+func (rs *RewardService) GrantLootToPlayer(playerID int64, playerLevel int, drops []LootDrop) []interface{} {
+	var rewardItems []interface{}
+
+	// 资源类掉落合并后一次性更新
+	resourceUpdates := map[string]int{}
+	for _, drop := range drops {
+		switch drop.Type {
+		case LootTypeReinforceStone:
+			resourceUpdates["reinforce_stones"] += drop.Count
+		case LootTypeRefinementStone:
+			resourceUpdates["refinement_stones"] += drop.Count
+		case LootTypePetEssence:
+			resourceUpdates["pet_essence"] += drop.Count
+		case LootTypeHerb:
+			herb := &PvERewards{
+				HerbID:  drop.ItemID,
+				Name:    drop.Name,
+				Count:   drop.Count,
+				Quality: exploration.GetRandomQuality(rand.Float64()),
+			}
+			if err := rs.GrantPvERewardsToPlayer(playerID, herb); err != nil {
+				log.Printf("[Loot] 发放灵草掉落失败: %v", err)
+				continue
+			}
+			rewardItems = append(rewardItems, map[string]interface{}{
+				"type":    "herb",
+				"herbId":  herb.HerbID,
+				"name":    herb.Name,
+				"count":   herb.Count,
+				"quality": herb.Quality,
+			})
+			continue
+		case LootTypePillFragment:
+			if err := addPillFragment(playerID, drop.ItemID, drop.Count); err != nil {
+				log.Printf("[Loot] 发放丹方残页掉落失败: %v", err)
+				continue
+			}
+			rewardItems = append(rewardItems, map[string]interface{}{
+				"type":  "pill_fragment",
+				"name":  drop.Name,
+				"count": drop.Count,
+			})
+			continue
+		case LootTypeGem:
+			for i := 0; i < drop.Count; i++ {
+				found, err := gem.GrantRandomGem(db.DB, uint(playerID), drop.Tier, 1)
+				if err != nil {
+					log.Printf("[Loot] 发放宝石掉落失败: %v", err)
+					continue
+				}
+				rewardItems = append(rewardItems, map[string]interface{}{
+					"type":  "gem",
+					"gemId": found.GemID,
+					"name":  found.Name,
+					"tier":  found.Tier,
+					"count": 1,
+				})
+			}
+			continue
+		case LootTypeEquipment:
+			for i := 0; i < drop.Count; i++ {
+				equipment, err := gacha.GenerateEquipment(uint(playerID), playerLevel, zap.NewNop())
+				if err != nil {
+					log.Printf("[Loot] 生成装备掉落失败: %v", err)
+					continue
+				}
+				rewardItems = append(rewardItems, map[string]interface{}{
+					"type":    "equipment",
+					"id":      equipment.ID,
+					"name":    equipment.Name,
+					"quality": equipment.Quality,
+					"stats":   equipment.Stats,
+				})
+			}
+			continue
+		}
+		rewardItems = append(rewardItems, map[string]interface{}{
+			"type":   drop.Type,
+			"name":   drop.Name,
+			"amount": drop.Count,
+		})
+	}
+
+	if len(resourceUpdates) > 0 {
+		updates := map[string]interface{}{}
+		for column, amount := range resourceUpdates {
+			updates[column] = gorm.Expr(column+" + ?", amount)
+		}
+		if err := db.DB.Model(&models.User{}).Where("id = ?", playerID).Updates(updates).Error; err != nil {
+			log.Printf("[Loot] 发放资源掉落失败: %v", err)
+		}
+	}
+
+	return rewardItems
+}
+
+// addPillFragment 增加丹方残页数量
+func addPillFragment(playerID int64, recipeID string, count int) error {
+	_, err := inventory.AddPillFragments(db.DB, uint(playerID), recipeID, count)
+	return err
+}
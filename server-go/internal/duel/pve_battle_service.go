@@ -7,8 +7,10 @@ import (
 	"math"
 	"time"
 	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/dungeon/battle"
 	"xiuxian/server-go/internal/dungeon/battle/formula"
 	"xiuxian/server-go/internal/dungeon/battle/resolver"
+	"xiuxian/server-go/internal/formation"
 	"xiuxian/server-go/internal/gacha"
 	"xiuxian/server-go/internal/models"
 	"xiuxian/server-go/internal/redis"
@@ -22,23 +24,26 @@ type PvEBattleService struct {
 	monsterID      int
 	difficulty     string // 怪物难度: normal, hard, boss
 	rewardService  *RewardService
-	monsterFactory *MonsterFactory // 妖兽工厂
+	monsterFactory *MonsterFactory    // 妖兽工厂
+	bossScript     *battle.BossScript // 首领脚本（可选）
 }
 
 // PvEBattleStatus PvE 战斗状态
 type PvEBattleStatus struct {
-	PlayerID         int64            `json:"player_id"`
-	MonsterID        int              `json:"monster_id"`
-	PlayerName       string           `json:"player_name"`
-	MonsterName      string           `json:"monster_name"`
-	Round            int              `json:"round"`
-	PlayerHealth     float64          `json:"player_health"`
-	PlayerMaxHealth  float64          `json:"player_max_health"`
-	MonsterHealth    float64          `json:"monster_health"`
-	MonsterMaxHealth float64          `json:"monster_max_health"`
-	PlayerStats      *DuelCombatStats `json:"player_stats"`
-	MonsterStats     *DuelCombatStats `json:"monster_stats"`
-	BattleLog        []string         `json:"battle_log"`
+	PlayerID         int64              `json:"player_id"`
+	MonsterID        int                `json:"monster_id"`
+	PlayerName       string             `json:"player_name"`
+	MonsterName      string             `json:"monster_name"`
+	Round            int                `json:"round"`
+	PlayerHealth     float64            `json:"player_health"`
+	PlayerMaxHealth  float64            `json:"player_max_health"`
+	MonsterHealth    float64            `json:"monster_health"`
+	MonsterMaxHealth float64            `json:"monster_max_health"`
+	PlayerStats      *DuelCombatStats   `json:"player_stats"`
+	MonsterStats     *DuelCombatStats   `json:"monster_stats"`
+	BattleLog        []string           `json:"battle_log"`
+	BossScript       *battle.BossScript `json:"boss_script,omitempty"`
+	BossState        battle.BossState   `json:"boss_state"`
 }
 
 // MonsterFactory 妖兽数据工厂
@@ -125,6 +130,11 @@ func NewPvEBattleService(playerID int64, monsterID int, difficulty string) *PvEB
 	}
 }
 
+// SetBossScript 设置妖兽首领脚本（来自服务端妖兽配置）
+func (s *PvEBattleService) SetBossScript(script *battle.BossScript) {
+	s.bossScript = script
+}
+
 // StartPvEBattle 开始 PvE 战斗
 func (s *PvEBattleService) StartPvEBattle(playerData interface{}, monsterData interface{}) (*PvPRoundData, error) {
 	// 获取玩家信息
@@ -135,6 +145,9 @@ func (s *PvEBattleService) StartPvEBattle(playerData interface{}, monsterData in
 
 	// 转换玩家属性
 	playerStats := convertGinHToStats(playerData)
+	playerStats.WardReduce = formation.GetActiveEffect(uint(s.playerID), formation.EffectCombatWard)
+	applyPillBuffs(uint(s.playerID), playerStats)
+	playerStats.SetEffects = gacha.GetEquippedSetEffects(uint(s.playerID))
 
 	// 转换妖兽属性
 	monsterStats, err := s.monsterFactory.GetMonsterBattleStats(monsterData)
@@ -164,6 +177,7 @@ func (s *PvEBattleService) StartPvEBattle(playerData interface{}, monsterData in
 		PlayerStats:      playerStats,
 		MonsterStats:     monsterStats,
 		BattleLog:        []string{},
+		BossScript:       s.bossScript,
 	}
 
 	if err := s.SaveBattleStatusToRedis(battleStatus); err != nil {
@@ -219,8 +233,10 @@ func (s *PvEBattleService) ExecutePvERound() (*PvPRoundData, error) {
 		status.PlayerHealth = 0
 	}
 
+	// 首领脚本：阶段转换与狂暴
+	roundLogs := s.evaluateBossScript(status)
+
 	// 根据速度决定先手和后手
-	roundLogs := []string{}
 	playerSpeed := status.PlayerStats.Speed
 	monsterSpeed := status.MonsterStats.Speed
 
@@ -260,87 +276,9 @@ func (s *PvEBattleService) ExecutePvERound() (*PvPRoundData, error) {
 			roundLogs = append(roundLogs, fmt.Sprintf("%s已被击败！%s获得胜利！", status.MonsterName, status.PlayerName))
 			status.BattleLog = append(status.BattleLog, roundLogs[len(roundLogs)-1])
 
-			// 检查是普通妖兽还是除魔卫道（通过ID区分：101+为除魔卫道）
-			var rewardItems []interface{}
-			if s.monsterID >= 101 {
-				// 除魔卫道奖励：灵石、修为、丹方残页
-				var user models.User
-				if err := db.DB.First(&user, s.playerID).Error; err == nil {
-					demonRewards := s.rewardService.CalculateRewardsForDemonSlaying(status, user.Level, s.difficulty)
-					if demonRewards != nil {
-						if err := s.rewardService.GrantDemonSlayingRewardsToPlayer(s.playerID, demonRewards); err != nil {
-							log.Printf("[PvE] 发放除魔卫道奖励失败: %v", err)
-						}
-						// 拆分为多个奖励项，以便前端正确显示
-						// 灵石奖励
-						rewardItems = append(rewardItems, map[string]interface{}{
-							"type":   "spirit_stone",
-							"amount": demonRewards.SpiritStones,
-						})
-						// 修为奖励
-						rewardItems = append(rewardItems, map[string]interface{}{
-							"type":   "cultivation",
-							"amount": demonRewards.Cultivation,
-						})
-						// 丹方残页奖励（如果有）
-						if demonRewards.PillFragmentName != "" {
-							rewardItems = append(rewardItems, map[string]interface{}{
-								"type":  "pill_fragment",
-								"name":  demonRewards.PillFragmentName,
-								"count": 1,
-							})
-						}
-						// ✅ 装备奖励（百炼宗叛徒特殊奖励）
-						if demonRewards.ShouldGenerateEquipment {
-							logger := zap.NewNop() // 使用空日志
-							equipment, err := gacha.GenerateEquipment(uint(s.playerID), user.Level, logger)
-							if err != nil {
-								log.Printf("[PvE] 生成装备奖励失败: %v", err)
-							} else {
-								rewardItems = append(rewardItems, map[string]interface{}{
-									"type":    "equipment",
-									"id":      equipment.ID,
-									"name":    equipment.Name,
-									"quality": equipment.Quality,
-									"stats":   equipment.Stats,
-								})
-								log.Printf("[PvE] 百炼宗叛徒奖励装备: %s (品质: %s)", equipment.Name, equipment.Quality)
-							}
-						}
-						// ✅ 灵宠奖励（兽王宗叛徒特殊奖励）
-						if demonRewards.ShouldGeneratePet {
-							logger := zap.NewNop() // 使用空日志
-							pet, err := gacha.GeneratePet(uint(s.playerID), user.Level, logger)
-							if err != nil {
-								log.Printf("[PvE] 生成灵宠奖励失败: %v", err)
-							} else {
-								rewardItems = append(rewardItems, map[string]interface{}{
-									"type":   "pet",
-									"id":     pet.ID,
-									"name":   pet.Name,
-									"rarity": pet.Rarity,
-								})
-								log.Printf("[PvE] 兽王宗叛徒奖励灵宠: %s (稀有度: %s)", pet.Name, pet.Rarity)
-							}
-						}
-					}
-				}
-			} else {
-				// 普通妖兽奖励：仅灵草
-				awardRewards := s.rewardService.CalculateRewardsForPvE(status, 0, s.difficulty)
-				if awardRewards != nil {
-					if err := s.rewardService.GrantPvERewardsToPlayer(s.playerID, awardRewards); err != nil {
-						log.Printf("[PvE] 发放奖励失败: %v", err)
-					}
-					rewardItems = append(rewardItems, map[string]interface{}{
-						"type":    "herb",
-						"herbId":  awardRewards.HerbID,
-						"name":    awardRewards.Name,
-						"count":   awardRewards.Count,
-						"quality": awardRewards.Quality,
-					})
-				}
-			}
+			// 记录通关并发放胜利奖励
+			stars, firstClearItems := s.recordClear(status)
+			rewardItems := append(s.grantVictoryRewards(status), firstClearItems...)
 
 			// 清除回合时间标记
 			redis.Client.Del(redis.Ctx, lastRoundKey)
@@ -352,6 +290,7 @@ func (s *PvEBattleService) ExecutePvERound() (*PvPRoundData, error) {
 				Logs:           roundLogs,
 				BattleEnded:    true,
 				Victory:        true,
+				Stars:          stars,
 				Rewards:        rewardItems,
 			}, nil
 		}
@@ -493,87 +432,9 @@ func (s *PvEBattleService) ExecutePvERound() (*PvPRoundData, error) {
 				roundLogs = append(roundLogs, fmt.Sprintf("%s已被击败！%s获得胜利！", status.MonsterName, status.PlayerName))
 				status.BattleLog = append(status.BattleLog, roundLogs[len(roundLogs)-1])
 
-				// 检查是普通妖兽还是除魔卫道（通过ID区分：101+为除魔卫道）
-				var rewardItems []interface{}
-				if s.monsterID >= 101 {
-					// 除魔卫道奖励：灵石、修为、丹方残页
-					var user models.User
-					if err := db.DB.First(&user, s.playerID).Error; err == nil {
-						demonRewards := s.rewardService.CalculateRewardsForDemonSlaying(status, user.Level, s.difficulty)
-						if demonRewards != nil {
-							if err := s.rewardService.GrantDemonSlayingRewardsToPlayer(s.playerID, demonRewards); err != nil {
-								log.Printf("[PvE] 发放除魔卫道奖励失败: %v", err)
-							}
-							// 拆分为多个奖励项，以便前端正确显示
-							// 灵石奖励
-							rewardItems = append(rewardItems, map[string]interface{}{
-								"type":   "spirit_stone",
-								"amount": demonRewards.SpiritStones,
-							})
-							// 修为奖励
-							rewardItems = append(rewardItems, map[string]interface{}{
-								"type":   "cultivation",
-								"amount": demonRewards.Cultivation,
-							})
-							// 丹方残页奖励（如果有）
-							if demonRewards.PillFragmentName != "" {
-								rewardItems = append(rewardItems, map[string]interface{}{
-									"type":  "pill_fragment",
-									"name":  demonRewards.PillFragmentName,
-									"count": 1,
-								})
-							}
-							// ✅ 装备奖励（百炼宗叛徒特殊奖励）
-							if demonRewards.ShouldGenerateEquipment {
-								logger := zap.NewNop() // 使用空日志
-								equipment, err := gacha.GenerateEquipment(uint(s.playerID), user.Level, logger)
-								if err != nil {
-									log.Printf("[PvE] 生成装备奖励失败: %v", err)
-								} else {
-									rewardItems = append(rewardItems, map[string]interface{}{
-										"type":    "equipment",
-										"id":      equipment.ID,
-										"name":    equipment.Name,
-										"quality": equipment.Quality,
-										"stats":   equipment.Stats,
-									})
-									log.Printf("[PvE] 百炼宗叛徒奖励装备: %s (品质: %s)", equipment.Name, equipment.Quality)
-								}
-							}
-							// ✅ 灵宠奖励（兽王宗叛徒特殊奖励）
-							if demonRewards.ShouldGeneratePet {
-								logger := zap.NewNop() // 使用空日志
-								pet, err := gacha.GeneratePet(uint(s.playerID), user.Level, logger)
-								if err != nil {
-									log.Printf("[PvE] 生成灵宠奖励失败: %v", err)
-								} else {
-									rewardItems = append(rewardItems, map[string]interface{}{
-										"type":   "pet",
-										"id":     pet.ID,
-										"name":   pet.Name,
-										"rarity": pet.Rarity,
-									})
-									log.Printf("[PvE] 兽王宗叛徒奖励灵宠: %s (稀有度: %s)", pet.Name, pet.Rarity)
-								}
-							}
-						}
-					}
-				} else {
-					// 普通妖兽奖励：仅灵草
-					awardRewards := s.rewardService.CalculateRewardsForPvE(status, 0, s.difficulty)
-					if awardRewards != nil {
-						if err := s.rewardService.GrantPvERewardsToPlayer(s.playerID, awardRewards); err != nil {
-							log.Printf("[PvE] 发放奖励失败: %v", err)
-						}
-						rewardItems = append(rewardItems, map[string]interface{}{
-							"type":    "herb",
-							"herbId":  awardRewards.HerbID,
-							"name":    awardRewards.Name,
-							"count":   awardRewards.Count,
-							"quality": awardRewards.Quality,
-						})
-					}
-				}
+				// 记录通关并发放胜利奖励
+				stars, firstClearItems := s.recordClear(status)
+				rewardItems := append(s.grantVictoryRewards(status), firstClearItems...)
 
 				// 清除回合时间标记
 				redis.Client.Del(redis.Ctx, lastRoundKey)
@@ -585,6 +446,7 @@ func (s *PvEBattleService) ExecutePvERound() (*PvPRoundData, error) {
 					Logs:           roundLogs,
 					BattleEnded:    true,
 					Victory:        true,
+					Stars:          stars,
 					Rewards:        rewardItems,
 				}, nil
 			}
@@ -628,6 +490,141 @@ func (s *PvEBattleService) ExecutePvERound() (*PvPRoundData, error) {
 	}, nil
 }
 
+// evaluateBossScript 评估首领脚本，将触发的阶段/狂暴事件应用到妖兽属性并记录日志
+func (s *PvEBattleService) evaluateBossScript(status *PvEBattleStatus) []string {
+	logs := []string{}
+	if status.BossScript == nil || status.MonsterMaxHealth <= 0 {
+		return logs
+	}
+
+	healthPercent := status.MonsterHealth / status.MonsterMaxHealth * 100
+	for _, event := range status.BossScript.Evaluate(&status.BossState, status.Round, healthPercent) {
+		applyBossEventToDuelStats(status.MonsterStats, event)
+		if event.Heal > 0 {
+			status.MonsterHealth = math.Min(status.MonsterMaxHealth, status.MonsterHealth+status.MonsterMaxHealth*event.Heal)
+		}
+		logMsg := fmt.Sprintf("第%d回合：【%s】%s", status.Round, event.Name, event.Message)
+		logs = append(logs, logMsg)
+		status.BattleLog = append(status.BattleLog, logMsg)
+	}
+	return logs
+}
+
+// applyBossEventToDuelStats 将首领事件的属性变化应用到斗法属性
+func applyBossEventToDuelStats(stats *DuelCombatStats, event battle.BossEvent) {
+	battleStats := convertDuelStatsToBattleStats(stats)
+	battle.ApplyBossEvent(battleStats, event)
+	stats.Attack = battleStats.Damage
+	stats.Defense = battleStats.Defense
+	stats.Speed = battleStats.Speed
+	stats.CritRate = battleStats.CritRate
+	stats.ComboRate = battleStats.ComboRate
+	stats.CounterRate = battleStats.CounterRate
+	stats.StunRate = battleStats.StunRate
+	stats.DodgeRate = battleStats.DodgeRate
+	stats.VampireRate = battleStats.VampireRate
+	// 最终增伤/减伤在转换时叠加了默认值，只回写事件带来的增量
+	base := convertDuelStatsToBattleStats(stats)
+	stats.FinalDamageBoost += battleStats.FinalDamageBoost - base.FinalDamageBoost
+	stats.FinalDamageReduce += battleStats.FinalDamageReduce - base.FinalDamageReduce
+}
+
+// grantVictoryRewards 计算并发放胜利奖励（普通妖兽/除魔卫道 + 掉落表），返回前端展示用的奖励项
+func (s *PvEBattleService) grantVictoryRewards(status *PvEBattleStatus) []interface{} {
+	// 检查是普通妖兽还是除魔卫道（通过ID区分：101+为除魔卫道）
+	var rewardItems []interface{}
+	if s.monsterID >= 101 {
+		// 除魔卫道奖励：灵石、修为、丹方残页
+		var user models.User
+		if err := db.DB.First(&user, s.playerID).Error; err == nil {
+			demonRewards := s.rewardService.CalculateRewardsForDemonSlaying(status, user.Level, s.difficulty)
+			if demonRewards != nil {
+				if err := s.rewardService.GrantDemonSlayingRewardsToPlayer(s.playerID, demonRewards); err != nil {
+					log.Printf("[PvE] 发放除魔卫道奖励失败: %v", err)
+				}
+				// 拆分为多个奖励项，以便前端正确显示
+				// 灵石奖励
+				rewardItems = append(rewardItems, map[string]interface{}{
+					"type":   "spirit_stone",
+					"amount": demonRewards.SpiritStones,
+				})
+				// 修为奖励
+				rewardItems = append(rewardItems, map[string]interface{}{
+					"type":   "cultivation",
+					"amount": demonRewards.Cultivation,
+				})
+				// 丹方残页奖励（如果有）
+				if demonRewards.PillFragmentName != "" {
+					rewardItems = append(rewardItems, map[string]interface{}{
+						"type":  "pill_fragment",
+						"name":  demonRewards.PillFragmentName,
+						"count": 1,
+					})
+				}
+				// ✅ 装备奖励（百炼宗叛徒特殊奖励）
+				if demonRewards.ShouldGenerateEquipment {
+					logger := zap.NewNop() // 使用空日志
+					equipment, err := gacha.GenerateEquipment(uint(s.playerID), user.Level, logger)
+					if err != nil {
+						log.Printf("[PvE] 生成装备奖励失败: %v", err)
+					} else {
+						rewardItems = append(rewardItems, map[string]interface{}{
+							"type":    "equipment",
+							"id":      equipment.ID,
+							"name":    equipment.Name,
+							"quality": equipment.Quality,
+							"stats":   equipment.Stats,
+						})
+						log.Printf("[PvE] 百炼宗叛徒奖励装备: %s (品质: %s)", equipment.Name, equipment.Quality)
+					}
+				}
+				// ✅ 灵宠奖励（兽王宗叛徒特殊奖励）
+				if demonRewards.ShouldGeneratePet {
+					logger := zap.NewNop() // 使用空日志
+					pet, err := gacha.GeneratePet(uint(s.playerID), user.Level, logger)
+					if err != nil {
+						log.Printf("[PvE] 生成灵宠奖励失败: %v", err)
+					} else {
+						rewardItems = append(rewardItems, map[string]interface{}{
+							"type":   "pet",
+							"id":     pet.ID,
+							"name":   pet.Name,
+							"rarity": pet.Rarity,
+						})
+						log.Printf("[PvE] 兽王宗叛徒奖励灵宠: %s (稀有度: %s)", pet.Name, pet.Rarity)
+					}
+				}
+			}
+		}
+	} else {
+		// 普通妖兽奖励：仅灵草
+		awardRewards := s.rewardService.CalculateRewardsForPvE(status, 0, s.difficulty)
+		if awardRewards != nil {
+			if err := s.rewardService.GrantPvERewardsToPlayer(s.playerID, awardRewards); err != nil {
+				log.Printf("[PvE] 发放奖励失败: %v", err)
+			}
+			rewardItems = append(rewardItems, map[string]interface{}{
+				"type":    "herb",
+				"herbId":  awardRewards.HerbID,
+				"name":    awardRewards.Name,
+				"count":   awardRewards.Count,
+				"quality": awardRewards.Quality,
+			})
+		}
+	}
+
+	// 掉落表奖励
+	var playerLevel int
+	var user models.User
+	if err := db.DB.Select("id, level").First(&user, s.playerID).Error; err == nil {
+		playerLevel = user.Level
+	}
+	drops := RollLoot(s.playerID, GetLootTable(s.monsterID, s.difficulty))
+	rewardItems = append(rewardItems, s.rewardService.GrantLootToPlayer(s.playerID, playerLevel, drops)...)
+
+	return rewardItems
+}
+
 // SaveBattleStatusToRedis 将战斗状态保存到 Redis
 func (s *PvEBattleService) SaveBattleStatusToRedis(status *PvEBattleStatus) error {
 	key := fmt.Sprintf("pve:battle:status:%d:%d", s.playerID, s.monsterID)
@@ -0,0 +1,142 @@
+package duel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+)
+
+const (
+	// 扫荡所需的最低通关星级
+	SweepMinStars = 3
+	// 单次扫荡的最大场数
+	MaxSweepCount = 10
+)
+
+// GetPvEClear 获取玩家对指定妖兽的通关记录，未通关返回 nil
+func GetPvEClear(playerID int64, monsterID int) (*models.PvEClear, error) {
+	var record models.PvEClear
+	err := db.DB.Where("user_id = ? AND monster_id = ?", playerID, monsterID).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询通关记录失败: %w", err)
+	}
+	return &record, nil
+}
+
+// recordClear 记录通关结果，保留最佳星级、最少回合与最高剩余血量
+// 返回本次星级与首通奖励项
+func (s *PvEBattleService) recordClear(status *PvEBattleStatus) (int, []interface{}) {
+	healthPercent := 0.0
+	if status.PlayerMaxHealth > 0 {
+		healthPercent = status.PlayerHealth / status.PlayerMaxHealth * 100
+	}
+	stars := CalculateClearStars(s.monsterID, s.difficulty, status.Round, healthPercent)
+	now := time.Now()
+
+	record, err := GetPvEClear(s.playerID, s.monsterID)
+	if err != nil {
+		log.Printf("[PvE] %v", err)
+		return stars, nil
+	}
+
+	if record == nil {
+		record = &models.PvEClear{
+			UserID:            uint(s.playerID),
+			MonsterID:         s.monsterID,
+			MonsterName:       status.MonsterName,
+			Difficulty:        s.difficulty,
+			BestStars:         stars,
+			BestRounds:        status.Round,
+			BestHealthPercent: healthPercent,
+			ClearCount:        1,
+			FirstClearedAt:    now,
+			LastClearedAt:     now,
+		}
+		// 首次通关：记录与首通奖励同一事务，避免重复发放
+		reward := GetFirstClearReward(s.difficulty)
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(record).Error; err != nil {
+				return fmt.Errorf("创建通关记录失败: %w", err)
+			}
+			return grantStageReward(tx, s.playerID, reward)
+		})
+		if err != nil {
+			log.Printf("[PvE] %v", err)
+			return stars, nil
+		}
+		log.Printf("[PvE] 玩家 %d 首次通关 %s (%d星)", s.playerID, status.MonsterName, stars)
+		return stars, stageRewardItems(reward, "first_clear")
+	}
+
+	updates := map[string]interface{}{
+		"clear_count":     gorm.Expr("clear_count + ?", 1),
+		"last_cleared_at": now,
+	}
+	if stars > record.BestStars {
+		updates["best_stars"] = stars
+	}
+	if status.Round < record.BestRounds {
+		updates["best_rounds"] = status.Round
+	}
+	if healthPercent > record.BestHealthPercent {
+		updates["best_health_percent"] = healthPercent
+	}
+	if err := db.DB.Model(record).Updates(updates).Error; err != nil {
+		log.Printf("[PvE] 更新通关记录失败: %v", err)
+	}
+	return stars, nil
+}
+
+// CheckSweepable 检查玩家是否可以扫荡该妖兽
+func (s *PvEBattleService) CheckSweepable() (*models.PvEClear, error) {
+	record, err := GetPvEClear(s.playerID, s.monsterID)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("尚未击败该妖兽，无法扫荡")
+	}
+	if record.BestStars < SweepMinStars {
+		return nil, fmt.Errorf("需要%d星通关后才可扫荡，当前最佳: %d星", SweepMinStars, record.BestStars)
+	}
+	return record, nil
+}
+
+// Sweep 扫荡：按最佳通关记录直接结算 count 场战斗的奖励
+// 每日次数与灵力消耗由调用方扣除
+func (s *PvEBattleService) Sweep(count int) ([]interface{}, error) {
+	record, err := s.CheckSweepable()
+	if err != nil {
+		return nil, err
+	}
+
+	var rewardItems []interface{}
+	for i := 0; i < count; i++ {
+		status := &PvEBattleStatus{
+			PlayerID:    s.playerID,
+			MonsterID:   s.monsterID,
+			MonsterName: record.MonsterName,
+			Round:       record.BestRounds,
+		}
+		rewardItems = append(rewardItems, s.grantVictoryRewards(status)...)
+	}
+
+	if err := db.DB.Model(record).Updates(map[string]interface{}{
+		"clear_count":     gorm.Expr("clear_count + ?", count),
+		"last_cleared_at": time.Now(),
+	}).Error; err != nil {
+		log.Printf("[PvE] 更新通关记录失败: %v", err)
+	}
+
+	log.Printf("[PvE] 玩家 %d 扫荡 %s x%d", s.playerID, record.MonsterName, count)
+	return rewardItems, nil
+}
@@ -0,0 +1,339 @@
+package duel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+)
+
+// 星级条件类型
+const (
+	StarConditionVictory = "victory" // 获得胜利
+	StarConditionRounds  = "rounds"  // N回合内获胜
+	StarConditionHealth  = "health"  // 剩余血量不低于Y%
+)
+
+// StarCondition 星级条件
+type StarCondition struct {
+	Type        string  `json:"type"`
+	Value       float64 `json:"value"`
+	Description string  `json:"description"`
+}
+
+// StageReward 关卡奖励（首通奖励 / 星级宝箱）
+type StageReward struct {
+	SpiritStones    int `json:"spiritStones"`
+	ReinforceStones int `json:"reinforceStones"`
+	Cultivation     int `json:"cultivation"`
+}
+
+// StarChest 章节星级宝箱
+type StarChest struct {
+	Stars  int         `json:"stars"`
+	Reward StageReward `json:"reward"`
+}
+
+// Chapter 章节（按挑战类型与境界划分）
+type Chapter struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	MonsterIDs []int       `json:"monsterIds"`
+	Chests     []StarChest `json:"chests"`
+}
+
+// StageProgress 玩家单个关卡进度
+type StageProgress struct {
+	MonsterID       int             `json:"monsterId"`
+	Cleared         bool            `json:"cleared"`
+	BestStars       int             `json:"bestStars"`
+	BestRounds      int             `json:"bestRounds"`
+	ClearCount      int             `json:"clearCount"`
+	FirstClearBonus StageReward     `json:"firstClearBonus"`
+	Conditions      []StarCondition `json:"conditions"`
+}
+
+// ChapterProgress 玩家章节进度
+type ChapterProgress struct {
+	Chapter
+	Stars         int   `json:"stars"`
+	MaxStars      int   `json:"maxStars"`
+	ClaimedChests []int `json:"claimedChests"`
+}
+
+// difficultyStarConditions 各境界关卡的默认星级条件（依次对应1-3星）
+var difficultyStarConditions = map[string][]StarCondition{
+	"lianqi": {
+		{Type: StarConditionVictory, Description: "击败妖兽"},
+		{Type: StarConditionRounds, Value: 10, Description: "10回合内获胜"},
+		{Type: StarConditionHealth, Value: 60, Description: "剩余血量不低于60%"},
+	},
+	"zhuji": {
+		{Type: StarConditionVictory, Description: "击败妖兽"},
+		{Type: StarConditionRounds, Value: 15, Description: "15回合内获胜"},
+		{Type: StarConditionHealth, Value: 50, Description: "剩余血量不低于50%"},
+	},
+	"jindan": {
+		{Type: StarConditionVictory, Description: "击败妖兽"},
+		{Type: StarConditionRounds, Value: 20, Description: "20回合内获胜"},
+		{Type: StarConditionHealth, Value: 40, Description: "剩余血量不低于40%"},
+	},
+}
+
+// stageStarConditions 特定关卡的星级条件，优先于默认条件
+var stageStarConditions = map[int][]StarCondition{
+	// 药王宗长老：丹药续航极强，需速战速决
+	106: {
+		{Type: StarConditionVictory, Description: "击败药王宗长老"},
+		{Type: StarConditionRounds, Value: 12, Description: "12回合内获胜"},
+		{Type: StarConditionHealth, Value: 30, Description: "剩余血量不低于30%"},
+	},
+}
+
+// firstClearRewards 各境界关卡的首通奖励
+var firstClearRewards = map[string]StageReward{
+	"lianqi": {SpiritStones: 500, ReinforceStones: 10, Cultivation: 300},
+	"zhuji":  {SpiritStones: 2000, ReinforceStones: 30, Cultivation: 1500},
+	"jindan": {SpiritStones: 8000, ReinforceStones: 80, Cultivation: 6000},
+}
+
+// chapters 章节配置：降服妖兽按境界分章，除魔卫道单独成章
+var chapters = []Chapter{
+	{
+		ID: "monster_lianqi", Name: "万兽山脉·外围", MonsterIDs: []int{1, 2, 3},
+		Chests: []StarChest{
+			{Stars: 3, Reward: StageReward{SpiritStones: 300, ReinforceStones: 5}},
+			{Stars: 6, Reward: StageReward{SpiritStones: 600, ReinforceStones: 10}},
+			{Stars: 9, Reward: StageReward{SpiritStones: 1200, ReinforceStones: 20, Cultivation: 500}},
+		},
+	},
+	{
+		ID: "monster_zhuji", Name: "万兽山脉·深处", MonsterIDs: []int{4, 5, 6},
+		Chests: []StarChest{
+			{Stars: 3, Reward: StageReward{SpiritStones: 1200, ReinforceStones: 15}},
+			{Stars: 6, Reward: StageReward{SpiritStones: 2400, ReinforceStones: 30}},
+			{Stars: 9, Reward: StageReward{SpiritStones: 5000, ReinforceStones: 60, Cultivation: 2500}},
+		},
+	},
+	{
+		ID: "monster_jindan", Name: "万兽山脉·核心", MonsterIDs: []int{7, 8, 9},
+		Chests: []StarChest{
+			{Stars: 3, Reward: StageReward{SpiritStones: 5000, ReinforceStones: 40}},
+			{Stars: 6, Reward: StageReward{SpiritStones: 10000, ReinforceStones: 80}},
+			{Stars: 9, Reward: StageReward{SpiritStones: 20000, ReinforceStones: 150, Cultivation: 10000}},
+		},
+	},
+	{
+		ID: "demon_slaying", Name: "除魔卫道", MonsterIDs: []int{101, 102, 103, 104, 105, 106},
+		Chests: []StarChest{
+			{Stars: 6, Reward: StageReward{SpiritStones: 3000, ReinforceStones: 20}},
+			{Stars: 12, Reward: StageReward{SpiritStones: 8000, ReinforceStones: 50, Cultivation: 3000}},
+			{Stars: 18, Reward: StageReward{SpiritStones: 20000, ReinforceStones: 120, Cultivation: 10000}},
+		},
+	},
+}
+
+// GetStarConditions 获取关卡的星级条件
+func GetStarConditions(monsterID int, difficulty string) []StarCondition {
+	if conditions, ok := stageStarConditions[monsterID]; ok {
+		return conditions
+	}
+	if conditions, ok := difficultyStarConditions[difficulty]; ok {
+		return conditions
+	}
+	return difficultyStarConditions["lianqi"]
+}
+
+// CalculateClearStars 根据关卡星级条件计算通关星级（1-3星）
+func CalculateClearStars(monsterID int, difficulty string, rounds int, healthPercent float64) int {
+	stars := 0
+	for _, condition := range GetStarConditions(monsterID, difficulty) {
+		switch condition.Type {
+		case StarConditionVictory:
+			stars++
+		case StarConditionRounds:
+			if float64(rounds) <= condition.Value {
+				stars++
+			}
+		case StarConditionHealth:
+			if healthPercent >= condition.Value {
+				stars++
+			}
+		}
+	}
+	if stars < 1 {
+		stars = 1
+	}
+	return stars
+}
+
+// GetFirstClearReward 获取关卡首通奖励
+func GetFirstClearReward(difficulty string) StageReward {
+	return firstClearRewards[difficulty]
+}
+
+// GetChapter 根据章节ID获取章节配置
+func GetChapter(chapterID string) *Chapter {
+	for i := range chapters {
+		if chapters[i].ID == chapterID {
+			return &chapters[i]
+		}
+	}
+	return nil
+}
+
+// GetPvEClears 获取玩家全部通关记录（按妖兽ID索引）
+func GetPvEClears(playerID int64) (map[int]*models.PvEClear, error) {
+	var records []models.PvEClear
+	if err := db.DB.Where("user_id = ?", playerID).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("查询通关记录失败: %w", err)
+	}
+	result := make(map[int]*models.PvEClear, len(records))
+	for i := range records {
+		result[records[i].MonsterID] = &records[i]
+	}
+	return result, nil
+}
+
+// BuildStageProgress 根据通关记录生成关卡进度
+func BuildStageProgress(record *models.PvEClear, monsterID int, difficulty string) StageProgress {
+	progress := StageProgress{
+		MonsterID:       monsterID,
+		FirstClearBonus: GetFirstClearReward(difficulty),
+		Conditions:      GetStarConditions(monsterID, difficulty),
+	}
+	if record != nil {
+		progress.Cleared = true
+		progress.BestStars = record.BestStars
+		progress.BestRounds = record.BestRounds
+		progress.ClearCount = record.ClearCount
+	}
+	return progress
+}
+
+// GetChapterProgress 获取玩家全部章节进度
+func GetChapterProgress(playerID int64) ([]ChapterProgress, error) {
+	clears, err := GetPvEClears(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []models.PvEStarChest
+	if err := db.DB.Where("user_id = ?", playerID).Find(&claimed).Error; err != nil {
+		return nil, fmt.Errorf("查询星级宝箱记录失败: %w", err)
+	}
+	claimedMap := map[string][]int{}
+	for _, chest := range claimed {
+		claimedMap[chest.ChapterID] = append(claimedMap[chest.ChapterID], chest.Stars)
+	}
+
+	result := make([]ChapterProgress, 0, len(chapters))
+	for _, chapter := range chapters {
+		progress := ChapterProgress{
+			Chapter:       chapter,
+			MaxStars:      len(chapter.MonsterIDs) * 3,
+			ClaimedChests: claimedMap[chapter.ID],
+		}
+		if progress.ClaimedChests == nil {
+			progress.ClaimedChests = []int{}
+		}
+		for _, monsterID := range chapter.MonsterIDs {
+			if record, ok := clears[monsterID]; ok {
+				progress.Stars += record.BestStars
+			}
+		}
+		result = append(result, progress)
+	}
+	return result, nil
+}
+
+// ClaimStarChest 领取章节星级宝箱
+func ClaimStarChest(playerID int64, chapterID string, stars int) (*StageReward, error) {
+	chapter := GetChapter(chapterID)
+	if chapter == nil {
+		return nil, fmt.Errorf("章节不存在")
+	}
+
+	var chest *StarChest
+	for i := range chapter.Chests {
+		if chapter.Chests[i].Stars == stars {
+			chest = &chapter.Chests[i]
+			break
+		}
+	}
+	if chest == nil {
+		return nil, fmt.Errorf("宝箱不存在")
+	}
+
+	clears, err := GetPvEClears(playerID)
+	if err != nil {
+		return nil, err
+	}
+	totalStars := 0
+	for _, monsterID := range chapter.MonsterIDs {
+		if record, ok := clears[monsterID]; ok {
+			totalStars += record.BestStars
+		}
+	}
+	if totalStars < chest.Stars {
+		return nil, fmt.Errorf("星数不足，需要%d星，当前%d星", chest.Stars, totalStars)
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var existing models.PvEStarChest
+		err := tx.Where("user_id = ? AND chapter_id = ? AND stars = ?", playerID, chapterID, stars).First(&existing).Error
+		if err == nil {
+			return fmt.Errorf("该宝箱已领取")
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("查询星级宝箱记录失败: %w", err)
+		}
+		if err := tx.Create(&models.PvEStarChest{
+			UserID:    uint(playerID),
+			ChapterID: chapterID,
+			Stars:     stars,
+			ClaimedAt: time.Now(),
+		}).Error; err != nil {
+			return fmt.Errorf("记录星级宝箱失败: %w", err)
+		}
+		return grantStageReward(tx, playerID, chest.Reward)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[PvE] 玩家 %d 领取章节 %s 的 %d 星宝箱", playerID, chapterID, stars)
+	return &chest.Reward, nil
+}
+
+// grantStageReward 发放关卡奖励（修为不超过上限）
+func grantStageReward(tx *gorm.DB, playerID int64, reward StageReward) error {
+	if err := tx.Model(&models.User{}).Where("id = ?", playerID).Updates(map[string]interface{}{
+		"spirit_stones":    gorm.Expr("spirit_stones + ?", reward.SpiritStones),
+		"reinforce_stones": gorm.Expr("reinforce_stones + ?", reward.ReinforceStones),
+		"cultivation":      gorm.Expr("LEAST(cultivation + ?, max_cultivation)", reward.Cultivation),
+	}).Error; err != nil {
+		return fmt.Errorf("发放关卡奖励失败: %w", err)
+	}
+	return nil
+}
+
+// stageRewardItems 将关卡奖励转换为前端展示用的奖励项
+func stageRewardItems(reward StageReward, source string) []interface{} {
+	var items []interface{}
+	if reward.SpiritStones > 0 {
+		items = append(items, map[string]interface{}{"type": "spirit_stone", "amount": reward.SpiritStones, "source": source})
+	}
+	if reward.ReinforceStones > 0 {
+		items = append(items, map[string]interface{}{"type": "reinforce_stone", "amount": reward.ReinforceStones, "source": source})
+	}
+	if reward.Cultivation > 0 {
+		items = append(items, map[string]interface{}{"type": "cultivation", "amount": reward.Cultivation, "source": source})
+	}
+	return items
+}
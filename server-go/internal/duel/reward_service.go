@@ -1,16 +1,14 @@
 package duel
 
 import (
-	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
 	"xiuxian/server-go/internal/db"
 	"xiuxian/server-go/internal/exploration"
+	"xiuxian/server-go/internal/inventory"
 	"xiuxian/server-go/internal/models"
-
-	"gorm.io/gorm"
 )
 
 // PvPRewards PvP战斗奖励结果
@@ -405,33 +403,10 @@ func (rs *RewardService) GrantPvERewardsToPlayer(playerID int64, rewards *PvERew
 		return fmt.Errorf("PvE奖励为空")
 	}
 
-	// 查询或创建灵草记录
-	var existingHerb models.Herb
-	queryErr := db.DB.Where("user_id = ? AND herb_id = ?", playerID, rewards.HerbID).First(&existingHerb).Error
-
-	if queryErr == nil {
-		// 灵草已存在，更新数量
-		existingHerb.Count += rewards.Count
-		if err := db.DB.Model(&existingHerb).Update("count", existingHerb.Count).Error; err != nil {
-			log.Printf("[Reward] 更新灵草数量失败: %v", err)
-			return fmt.Errorf("更新灵草数量失败: %w", err)
-		}
-	} else if errors.Is(queryErr, gorm.ErrRecordNotFound) {
-		// 灵草不存在，创建新记录
-		newHerb := models.Herb{
-			UserID:  uint(playerID),
-			HerbID:  rewards.HerbID,
-			Name:    rewards.Name,
-			Count:   rewards.Count,
-			Quality: rewards.Quality,
-		}
-		if err := db.DB.Create(&newHerb).Error; err != nil {
-			log.Printf("[Reward] 创建灵草记录失败: %v", err)
-			return fmt.Errorf("创建灵草记录失败: %w", err)
-		}
-	} else {
-		log.Printf("[Reward] 查询灵草失败: %v", queryErr)
-		return fmt.Errorf("查询灵草失败: %w", queryErr)
+	// 灵草按品质堆叠入库
+	if err := inventory.AddHerbs(db.DB, uint(playerID), rewards.HerbID, rewards.Name, rewards.Quality, rewards.Count); err != nil {
+		log.Printf("[Reward] 发放灵草失败: %v", err)
+		return err
 	}
 
 	log.Printf("[Reward] 玩家 %d 获得PvE奖励 - 灵草: %s(%s), 数量: %d",
@@ -476,30 +451,9 @@ func (rs *RewardService) GrantDemonSlayingRewardsToPlayer(playerID int64, reward
 
 	// 如果有丹方残页，增加残页数量
 	if rewards.PillFragmentID != "" {
-		var fragment models.PillFragment
-		queryErr := db.DB.Where("user_id = ? AND recipe_id = ?", playerID, rewards.PillFragmentID).First(&fragment).Error
-
-		if queryErr == nil {
-			// 残页已存在，更新数量
-			fragment.Count += 1
-			if err := db.DB.Model(&fragment).Update("count", fragment.Count).Error; err != nil {
-				log.Printf("[Reward] 更新丹方残页数量失败: %v", err)
-				return fmt.Errorf("更新丹方残页数量失败: %w", err)
-			}
-		} else if errors.Is(queryErr, gorm.ErrRecordNotFound) {
-			// 残页不存在，创建新记录
-			newFragment := models.PillFragment{
-				UserID:   uint(playerID),
-				RecipeID: rewards.PillFragmentID,
-				Count:    1,
-			}
-			if err := db.DB.Create(&newFragment).Error; err != nil {
-				log.Printf("[Reward] 创建丹方残页记录失败: %v", err)
-				return fmt.Errorf("创建丹方残页记录失败: %w", err)
-			}
-		} else {
-			log.Printf("[Reward] 查询丹方残页失败: %v", queryErr)
-			return fmt.Errorf("查询丹方残页失败: %w", queryErr)
+		if _, err := inventory.AddPillFragments(db.DB, uint(playerID), rewards.PillFragmentID, 1); err != nil {
+			log.Printf("[Reward] 发放丹方残页失败: %v", err)
+			return err
 		}
 
 		log.Printf("[Reward] 玩家 %d 获得除魔卫道奖励 - 灵石: %d, 修为: %d, 丹方残页: %s",
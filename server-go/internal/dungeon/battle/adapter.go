@@ -36,3 +36,23 @@ func ToCombatStats(
 		ResistanceBoost:   resistanceBoost,
 	}
 }
+
+// 套装特效键，与套装配置中的特效 ID 一致
+const (
+	SetEffectArmorPierce  = "armorPierce"
+	SetEffectExecuteBoost = "executeBoost"
+	SetEffectLastStand    = "lastStand"
+)
+
+// LowHealthThreshold 濒危线：生命低于最大生命的该比例时触发斩杀、护体
+const LowHealthThreshold = 0.3
+
+// ApplySetEffects 将已激活的套装特效写入战斗属性
+func ApplySetEffects(stats *CombatStats, effects map[string]float64) {
+	if stats == nil {
+		return
+	}
+	stats.ArmorPierce += effects[SetEffectArmorPierce]
+	stats.ExecuteBoost += effects[SetEffectExecuteBoost]
+	stats.LastStand += effects[SetEffectLastStand]
+}
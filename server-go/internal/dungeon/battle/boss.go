@@ -0,0 +1,119 @@
+package battle
+
+// 首领事件类型
+const (
+	BossEventPhase  = "phase"  // 阶段转换
+	BossEventEnrage = "enrage" // 狂暴
+)
+
+// BossPhase 首领阶段：血量降至阈值时进入，按阈值从高到低排列
+type BossPhase struct {
+	Name            string             `json:"name"`
+	HealthThreshold float64            `json:"healthThreshold"` // 血量百分比阈值（0-100）
+	Message         string             `json:"message"`
+	Multipliers     map[string]float64 `json:"multipliers,omitempty"` // 属性倍率：attack/defense/speed
+	Bonuses         map[string]float64 `json:"bonuses,omitempty"`     // 战斗属性加成：critRate/comboRate/...
+	Heal            float64            `json:"heal,omitempty"`        // 进入阶段时回复最大生命的比例
+}
+
+// BossEnrage 首领狂暴：达到指定回合后触发
+type BossEnrage struct {
+	Round       int                `json:"round"`
+	Message     string             `json:"message"`
+	Multipliers map[string]float64 `json:"multipliers,omitempty"`
+	Bonuses     map[string]float64 `json:"bonuses,omitempty"`
+}
+
+// BossScript 首领战斗脚本
+// 召唤类机制依赖组队战斗，暂不支持
+type BossScript struct {
+	Phases []BossPhase `json:"phases,omitempty"`
+	Enrage *BossEnrage `json:"enrage,omitempty"`
+}
+
+// BossState 首领脚本运行状态
+type BossState struct {
+	Phase   int  `json:"phase"`   // 已进入的阶段数
+	Enraged bool `json:"enraged"` // 是否已狂暴
+}
+
+// BossEvent 首领脚本触发的事件
+type BossEvent struct {
+	Type        string             `json:"type"`
+	Round       int                `json:"round"`
+	Name        string             `json:"name"`
+	Message     string             `json:"message"`
+	Multipliers map[string]float64 `json:"multipliers,omitempty"`
+	Bonuses     map[string]float64 `json:"bonuses,omitempty"`
+	Heal        float64            `json:"heal,omitempty"`
+}
+
+// Evaluate 根据当前回合与血量百分比推进脚本，返回本回合触发的事件
+func (s *BossScript) Evaluate(state *BossState, round int, healthPercent float64) []BossEvent {
+	if s == nil || state == nil {
+		return nil
+	}
+
+	var events []BossEvent
+	for state.Phase < len(s.Phases) && healthPercent <= s.Phases[state.Phase].HealthThreshold {
+		phase := s.Phases[state.Phase]
+		events = append(events, BossEvent{
+			Type:        BossEventPhase,
+			Round:       round,
+			Name:        phase.Name,
+			Message:     phase.Message,
+			Multipliers: phase.Multipliers,
+			Bonuses:     phase.Bonuses,
+			Heal:        phase.Heal,
+		})
+		state.Phase++
+	}
+
+	if s.Enrage != nil && !state.Enraged && round >= s.Enrage.Round {
+		events = append(events, BossEvent{
+			Type:        BossEventEnrage,
+			Round:       round,
+			Name:        "狂暴",
+			Message:     s.Enrage.Message,
+			Multipliers: s.Enrage.Multipliers,
+			Bonuses:     s.Enrage.Bonuses,
+		})
+		state.Enraged = true
+	}
+
+	return events
+}
+
+// ApplyBossEvent 将首领事件的属性变化应用到战斗属性（回复由调用方处理）
+func ApplyBossEvent(stats *CombatStats, event BossEvent) {
+	for key, multiplier := range event.Multipliers {
+		switch key {
+		case "attack":
+			stats.Damage *= multiplier
+		case "defense":
+			stats.Defense *= multiplier
+		case "speed":
+			stats.Speed *= multiplier
+		}
+	}
+	for key, bonus := range event.Bonuses {
+		switch key {
+		case "critRate":
+			stats.CritRate += bonus
+		case "comboRate":
+			stats.ComboRate += bonus
+		case "counterRate":
+			stats.CounterRate += bonus
+		case "stunRate":
+			stats.StunRate += bonus
+		case "dodgeRate":
+			stats.DodgeRate += bonus
+		case "vampireRate":
+			stats.VampireRate += bonus
+		case "finalDamageBoost":
+			stats.FinalDamageBoost += bonus
+		case "finalDamageReduce":
+			stats.FinalDamageReduce += bonus
+		}
+	}
+}
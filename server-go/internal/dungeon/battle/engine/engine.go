@@ -2,6 +2,7 @@ package engine
 
 import (
 	"fmt"
+	"math"
 
 	"xiuxian/server-go/internal/dungeon/battle"
 	"xiuxian/server-go/internal/dungeon/battle/formula"
@@ -17,6 +18,9 @@ type BattleEngine struct {
 	enemyHealth  float64
 	round        int
 	maxRounds    int
+	bossScript   *battle.BossScript // 首领脚本（可选）
+	bossState    battle.BossState
+	bossEvents   []battle.BossEvent
 }
 
 // NewBattleEngine 创建战斗引擎
@@ -57,6 +61,9 @@ func (e *BattleEngine) ExecuteRound() *battle.RoundResult {
 		return result
 	}
 
+	// 首领脚本：阶段转换与狂暴
+	result.Events = e.evaluateBossScript()
+
 	// 根据速度决定先手和后手
 	playerSpeed := e.playerStats.Speed * (1 + e.playerStats.CombatBoost)
 	enemySpeed := e.enemyStats.Speed * (1 + e.enemyStats.CombatBoost)
@@ -232,6 +239,41 @@ func (e *BattleEngine) executeEnemyFirstRound() {
 	}
 }
 
+// SetPlayerHealth 设置玩家当前生命（连续战斗时继承上一场的剩余生命）
+func (e *BattleEngine) SetPlayerHealth(health float64) {
+	e.playerHealth = math.Min(health, e.playerStats.MaxHealth)
+}
+
+// SetBossScript 设置敌方首领脚本，每回合开始时评估
+func (e *BattleEngine) SetBossScript(script *battle.BossScript) {
+	e.bossScript = script
+	e.bossState = battle.BossState{}
+}
+
+// evaluateBossScript 评估首领脚本并应用触发的事件
+func (e *BattleEngine) evaluateBossScript() []battle.BossEvent {
+	if e.bossScript == nil || e.enemyStats.MaxHealth <= 0 {
+		return nil
+	}
+
+	healthPercent := e.enemyHealth / e.enemyStats.MaxHealth * 100
+	events := e.bossScript.Evaluate(&e.bossState, e.round, healthPercent)
+	for _, event := range events {
+		battle.ApplyBossEvent(e.enemyStats, event)
+		if event.Heal > 0 {
+			e.enemyHealth = math.Min(e.enemyStats.MaxHealth, e.enemyHealth+e.enemyStats.MaxHealth*event.Heal)
+		}
+		e.battleLog = append(e.battleLog, fmt.Sprintf("第%d回合：【%s】%s", e.round, event.Name, event.Message))
+	}
+	e.bossEvents = append(e.bossEvents, events...)
+	return events
+}
+
+// GetBossEvents 获取首领脚本触发的全部事件
+func (e *BattleEngine) GetBossEvents() []battle.BossEvent {
+	return e.bossEvents
+}
+
 // GetBattleLog 获取战斗日志
 func (e *BattleEngine) GetBattleLog() []string {
 	return e.battleLog
@@ -14,8 +14,9 @@ import (
 func CalculateDamage(attacker *battle.CombatStats, defender *battle.CombatStats) *battle.DamageResult {
 	result := &battle.DamageResult{}
 
-	// 基础伤害 = A.Damage - B.Defense，最小为1
-	baseDamage := math.Max(1, attacker.Damage-defender.Defense)
+	// 基础伤害 = A.Damage - B.Defense，最小为1；破甲无视部分防御
+	defense := defender.Defense * (1 - math.Max(0, math.Min(1, attacker.ArmorPierce)))
+	baseDamage := math.Max(1, attacker.Damage-defense)
 	result.BaseDamage = baseDamage
 	result.TotalDamage = baseDamage
 
@@ -33,6 +33,11 @@ type CombatStats struct {
 	FinalDamageReduce float64 // 最终减伤
 	CombatBoost       float64 // 战斗属性提升
 	ResistanceBoost   float64 // 战斗抗性提升
+
+	// 套装特效（百分比）
+	ArmorPierce  float64 // 破甲：攻击无视目标部分防御
+	ExecuteBoost float64 // 斩杀：目标生命低于濒危线时伤害提升
+	LastStand    float64 // 护体：自身生命低于濒危线时受到伤害降低
 }
 
 // DamageResult 伤害计算结果
@@ -55,16 +60,19 @@ type TakeDamageResult struct {
 	CurrentHealth float64 // 当前生命值
 	IsDead        bool    // 是否死亡
 	IsCounter     bool    // 是否反击
+	IsExecute     bool    // 是否触发斩杀
+	IsLastStand   bool    // 是否触发护体
 }
 
 // RoundResult 单回合战斗结果
 type RoundResult struct {
-	Round        int      `json:"round"`
-	PlayerHealth float64  `json:"playerHealth"`
-	EnemyHealth  float64  `json:"enemyHealth"`
-	Log          []string `json:"log"`
-	BattleEnded  bool     `json:"battleEnded"`
-	Victory      bool     `json:"victory"`
+	Round        int         `json:"round"`
+	PlayerHealth float64     `json:"playerHealth"`
+	EnemyHealth  float64     `json:"enemyHealth"`
+	Log          []string    `json:"log"`
+	Events       []BossEvent `json:"events,omitempty"` // 首领脚本事件
+	BattleEnded  bool        `json:"battleEnded"`
+	Victory      bool        `json:"victory"`
 }
 
 // FightResult 战斗结果
@@ -30,6 +30,18 @@ func TakeDamage(defender *battle.CombatStats, currentHealth float64, incomingDam
 
 	// 计算实际伤害
 	reducedDamage := formula.CalculateDamageReduction(defender, incomingDamage, source)
+
+	// 套装特效：目标濒危时攻击方斩杀增伤、防守方护体减伤
+	if defender.MaxHealth > 0 && currentHealth < defender.MaxHealth*battle.LowHealthThreshold {
+		if source != nil && source.ExecuteBoost > 0 {
+			reducedDamage *= 1 + source.ExecuteBoost
+			result.IsExecute = true
+		}
+		if defender.LastStand > 0 {
+			reducedDamage *= 1 - math.Min(0.9, defender.LastStand)
+			result.IsLastStand = true
+		}
+	}
 	newHealth := math.Max(0, currentHealth-reducedDamage)
 
 	// 反击判定：finalCounterRate = MAX(0, MIN(0.8, counterRate - source.counterResist))
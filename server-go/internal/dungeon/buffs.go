@@ -0,0 +1,123 @@
+package dungeon
+
+import (
+	"math/rand"
+)
+
+// BuffConfig 秘境增益配置
+type BuffConfig struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Rarity      string                 `json:"rarity"`
+	Description string                 `json:"description"`
+	Effects     map[string]interface{} `json:"effects"` // 交由 resolver.ApplyBuffEffectsToStats 处理
+}
+
+// 增益稀有度
+const (
+	BuffRarityCommon    = "common"
+	BuffRarityRare      = "rare"
+	BuffRarityEpic      = "epic"
+	BuffRarityLegendary = "legendary"
+)
+
+// buffRarityOrder 稀有度顺序（用于加权抽取）
+var buffRarityOrder = []string{BuffRarityCommon, BuffRarityRare, BuffRarityEpic, BuffRarityLegendary}
+
+// buffRarityWeights 各稀有度基础权重
+var buffRarityWeights = map[string]float64{
+	BuffRarityCommon:    60,
+	BuffRarityRare:      28,
+	BuffRarityEpic:      10,
+	BuffRarityLegendary: 2,
+}
+
+// buffPools 按稀有度划分的增益池
+var buffPools = map[string][]BuffConfig{
+	BuffRarityCommon: {
+		{ID: "body_tempering", Name: "淬体", Rarity: BuffRarityCommon, Description: "生命上限提升10%", Effects: map[string]interface{}{"health": 0.1}},
+		{ID: "sharp_blade", Name: "利刃", Rarity: BuffRarityCommon, Description: "攻击提升10%", Effects: map[string]interface{}{"damage": 0.1}},
+		{ID: "iron_skin", Name: "铁皮", Rarity: BuffRarityCommon, Description: "防御提升10%", Effects: map[string]interface{}{"defense": 0.1}},
+		{ID: "light_step", Name: "轻身", Rarity: BuffRarityCommon, Description: "速度提升10%", Effects: map[string]interface{}{"speed": 0.1}},
+		{ID: "keen_eye", Name: "锐目", Rarity: BuffRarityCommon, Description: "暴击率提升3%", Effects: map[string]interface{}{"critRate": 0.03}},
+	},
+	BuffRarityRare: {
+		{ID: "blood_drinker", Name: "饮血", Rarity: BuffRarityRare, Description: "吸血率提升5%", Effects: map[string]interface{}{"vampireRate": 0.05}},
+		{ID: "phantom_step", Name: "幻步", Rarity: BuffRarityRare, Description: "闪避率提升5%", Effects: map[string]interface{}{"dodgeRate": 0.05}},
+		{ID: "chain_strike", Name: "连环击", Rarity: BuffRarityRare, Description: "连击率提升5%", Effects: map[string]interface{}{"comboRate": 0.05}},
+		{ID: "stone_heart", Name: "石心", Rarity: BuffRarityRare, Description: "最终减伤提升5%", Effects: map[string]interface{}{"finalDamageReduce": 0.05}},
+		{ID: "balanced_qi", Name: "调息", Rarity: BuffRarityRare, Description: "生命与防御各提升8%", Effects: map[string]interface{}{"health": 0.08, "defense": 0.08}},
+	},
+	BuffRarityEpic: {
+		{ID: "thunder_shock", Name: "雷震", Rarity: BuffRarityEpic, Description: "眩晕率提升5%，攻击提升10%", Effects: map[string]interface{}{"stunRate": 0.05, "damage": 0.1}},
+		{ID: "deadly_precision", Name: "致命精准", Rarity: BuffRarityEpic, Description: "暴击率提升8%，暴击伤害提升30%", Effects: map[string]interface{}{"critRate": 0.08, "critDamageBoost": 0.3}},
+		{ID: "battle_will", Name: "战意", Rarity: BuffRarityEpic, Description: "战斗属性提升10%", Effects: map[string]interface{}{"combatBoost": 0.1}},
+		{ID: "unyielding", Name: "不屈", Rarity: BuffRarityEpic, Description: "生命上限提升25%，抗性提升5%", Effects: map[string]interface{}{"health": 0.25, "resistanceBoost": 0.05}},
+	},
+	BuffRarityLegendary: {
+		{ID: "immortal_body", Name: "不灭金身", Rarity: BuffRarityLegendary, Description: "生命、防御提升30%，最终减伤提升10%", Effects: map[string]interface{}{"health": 0.3, "defense": 0.3, "finalDamageReduce": 0.1}},
+		{ID: "sword_heart", Name: "剑心通明", Rarity: BuffRarityLegendary, Description: "攻击提升35%，暴击率提升10%", Effects: map[string]interface{}{"damage": 0.35, "critRate": 0.1}},
+		{ID: "blood_demon", Name: "血魔真解", Rarity: BuffRarityLegendary, Description: "吸血率提升15%，连击率提升10%", Effects: map[string]interface{}{"vampireRate": 0.15, "comboRate": 0.1}},
+	},
+}
+
+// GetBuffByID 根据ID获取增益配置
+func GetBuffByID(buffID string) *BuffConfig {
+	for _, pool := range buffPools {
+		for i := range pool {
+			if pool[i].ID == buffID {
+				return &pool[i]
+			}
+		}
+	}
+	return nil
+}
+
+// getRarityWeights 获取指定层数的稀有度权重，层数越高稀有增益越容易出现
+func getRarityWeights(floor int) map[string]float64 {
+	bonus := float64(floor-1) * 0.5
+	if bonus > 15 {
+		bonus = 15
+	}
+	return map[string]float64{
+		BuffRarityCommon:    buffRarityWeights[BuffRarityCommon] - bonus*2,
+		BuffRarityRare:      buffRarityWeights[BuffRarityRare] + bonus,
+		BuffRarityEpic:      buffRarityWeights[BuffRarityEpic] + bonus*0.7,
+		BuffRarityLegendary: buffRarityWeights[BuffRarityLegendary] + bonus*0.3,
+	}
+}
+
+// rollRarity 按权重抽取稀有度
+func rollRarity(weights map[string]float64) string {
+	total := 0.0
+	for _, rarity := range buffRarityOrder {
+		total += weights[rarity]
+	}
+	roll := rand.Float64() * total
+	for _, rarity := range buffRarityOrder {
+		roll -= weights[rarity]
+		if roll < 0 {
+			return rarity
+		}
+	}
+	return BuffRarityCommon
+}
+
+// RollBuffOptions 随机生成增益选项（同一批次内不重复）
+func RollBuffOptions(floor, count int) []BuffConfig {
+	weights := getRarityWeights(floor)
+	picked := make(map[string]bool)
+	options := make([]BuffConfig, 0, count)
+
+	for attempts := 0; len(options) < count && attempts < count*20; attempts++ {
+		pool := buffPools[rollRarity(weights)]
+		candidate := pool[rand.Intn(len(pool))]
+		if picked[candidate.ID] {
+			continue
+		}
+		picked[candidate.ID] = true
+		options = append(options, candidate)
+	}
+
+	return options
+}
@@ -0,0 +1,539 @@
+package dungeon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	redisv9 "github.com/redis/go-redis/v9"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/dungeon/battle"
+	"xiuxian/server-go/internal/dungeon/battle/engine"
+	"xiuxian/server-go/internal/dungeon/battle/resolver"
+	"xiuxian/server-go/internal/formation"
+	"xiuxian/server-go/internal/gacha"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/pillbuff"
+	"xiuxian/server-go/internal/redis"
+)
+
+const (
+	// 秘境运行状态键
+	RunStateKeyFormat = "dungeon:run:%d"
+	// 秘境积分排行榜（有序集合，仅保留最高分）
+	RunLeaderboardKey = "dungeon:leaderboard"
+	// 运行状态过期时间
+	RunStateTTL = 24 * time.Hour
+	// 秘境操作锁，串行化同一玩家的开始、挑战、选择增益与放弃
+	RunLockKeyFormat = "dungeon:run:lock:%d"
+	RunLockTTL       = 10 * time.Second
+	// 每层可选增益数量
+	BuffOptionCount = 3
+	// 首领层间隔
+	BossFloorInterval = 5
+)
+
+// 秘境运行状态
+const (
+	RunStatusFighting = "fighting"  // 等待挑战下一层
+	RunStatusChoosing = "choosing"  // 等待选择增益
+	RunStatusDefeated = "defeated"  // 战败结束
+	RunStatusAbandon  = "abandoned" // 主动放弃
+)
+
+// RunState 秘境运行状态（保存在Redis中）
+type RunState struct {
+	RunID         string       `json:"run_id"`
+	PlayerID      uint         `json:"player_id"`
+	Floor         int          `json:"floor"`          // 当前待挑战层数
+	FloorsCleared int          `json:"floors_cleared"` // 已通关层数
+	Score         int          `json:"score"`
+	Status        string       `json:"status"`
+	Buffs         []BuffConfig `json:"buffs"`        // 已选择的增益
+	BuffOptions   []BuffConfig `json:"buff_options"` // 待选择的增益
+	StartedAt     int64        `json:"started_at"`
+}
+
+// FloorResult 单层战斗结果
+type FloorResult struct {
+	Floor        int          `json:"floor"`
+	EnemyName    string       `json:"enemy_name"`
+	IsBoss       bool         `json:"is_boss"`
+	Victory      bool         `json:"victory"`
+	Rounds       int          `json:"rounds"`
+	PlayerHealth float64      `json:"player_health"`
+	EnemyHealth  float64      `json:"enemy_health"`
+	ScoreGained  int          `json:"score_gained"`
+	Logs         []string     `json:"logs"`
+	BuffOptions  []BuffConfig `json:"buff_options,omitempty"`
+	Run          *RunState    `json:"run"`
+}
+
+// LeaderboardEntry 秘境排行榜条目
+type LeaderboardEntry struct {
+	Rank       int    `json:"rank"`
+	PlayerID   uint   `json:"playerId"`
+	PlayerName string `json:"playerName"`
+	Score      int    `json:"score"`
+}
+
+// RunService 秘境运行服务
+type RunService struct {
+	playerID uint
+}
+
+// NewRunService 创建秘境运行服务
+func NewRunService(playerID uint) *RunService {
+	return &RunService{playerID: playerID}
+}
+
+// withRunLock 持有玩家秘境操作锁执行 fn，读取、修改与保存秘境状态期间不允许并发操作
+func (s *RunService) withRunLock(fn func() error) error {
+	key := fmt.Sprintf(RunLockKeyFormat, s.playerID)
+	ok, err := redis.Client.SetNX(redis.Ctx, key, time.Now().Unix(), RunLockTTL).Result()
+	if err != nil {
+		return fmt.Errorf("获取秘境操作锁失败: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("秘境操作进行中，请稍后再试")
+	}
+	defer redis.Client.Del(redis.Ctx, key)
+	return fn()
+}
+
+// StartRun 开始新的秘境挑战
+func (s *RunService) StartRun() (*RunState, error) {
+	var run *RunState
+	err := s.withRunLock(func() error {
+		var err error
+		run, err = s.startRun()
+		return err
+	})
+	return run, err
+}
+
+// startRun 开始新的秘境挑战，调用方需持有秘境操作锁
+func (s *RunService) startRun() (*RunState, error) {
+	existing, err := s.LoadRun()
+	if err != nil {
+		return nil, fmt.Errorf("读取秘境状态失败: %w", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("已有进行中的秘境挑战，请先完成或放弃")
+	}
+
+	run := &RunState{
+		RunID:       uuid.NewString(),
+		PlayerID:    s.playerID,
+		Floor:       1,
+		Status:      RunStatusFighting,
+		Buffs:       []BuffConfig{},
+		BuffOptions: []BuffConfig{},
+		StartedAt:   time.Now().Unix(),
+	}
+	if err := s.saveRun(run); err != nil {
+		return nil, fmt.Errorf("保存秘境状态失败: %w", err)
+	}
+
+	log.Printf("[Dungeon] 玩家 %d 开始秘境挑战 %s", s.playerID, run.RunID)
+	return run, nil
+}
+
+// LoadRun 读取进行中的秘境挑战，不存在时返回 nil
+func (s *RunService) LoadRun() (*RunState, error) {
+	key := fmt.Sprintf(RunStateKeyFormat, s.playerID)
+	data, err := redis.Client.Get(redis.Ctx, key).Result()
+	if err != nil {
+		if errors.Is(err, redisv9.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var run RunState
+	if err := json.Unmarshal([]byte(data), &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// saveRun 保存秘境状态到 Redis
+func (s *RunService) saveRun(run *RunState) error {
+	key := fmt.Sprintf(RunStateKeyFormat, s.playerID)
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return redis.Client.Set(redis.Ctx, key, string(data), RunStateTTL).Err()
+}
+
+// clearRun 清除 Redis 中的秘境状态
+func (s *RunService) clearRun() error {
+	key := fmt.Sprintf(RunStateKeyFormat, s.playerID)
+	return redis.Client.Del(redis.Ctx, key).Err()
+}
+
+// FightFloor 挑战当前层
+func (s *RunService) FightFloor() (*FloorResult, error) {
+	var result *FloorResult
+	err := s.withRunLock(func() error {
+		var err error
+		result, err = s.fightFloor()
+		return err
+	})
+	return result, err
+}
+
+// fightFloor 挑战当前层，调用方需持有秘境操作锁
+func (s *RunService) fightFloor() (*FloorResult, error) {
+	run, err := s.LoadRun()
+	if err != nil {
+		return nil, fmt.Errorf("读取秘境状态失败: %w", err)
+	}
+	if run == nil {
+		return nil, fmt.Errorf("没有进行中的秘境挑战")
+	}
+	if run.Status == RunStatusChoosing {
+		return nil, fmt.Errorf("请先选择增益")
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.playerID).Error; err != nil {
+		return nil, fmt.Errorf("玩家不存在: %w", err)
+	}
+
+	// 玩家属性叠加已选增益
+	playerStats := BuildPlayerCombatStats(&user)
+	for _, buff := range run.Buffs {
+		resolver.ApplyBuffEffectsToStats(playerStats, buff.Effects)
+	}
+
+	isBoss := run.Floor%BossFloorInterval == 0
+	enemyName, enemyStats := buildFloorEnemy(user.Level, run.Floor, isBoss)
+
+	// 使用战斗引擎自动结算本层战斗
+	battleEngine := engine.NewBattleEngine(playerStats, enemyStats)
+	if isBoss {
+		battleEngine.SetBossScript(floorBossScript)
+	}
+	rounds := 0
+	for !battleEngine.IsFinished() {
+		battleEngine.ExecuteRound()
+		rounds++
+	}
+	victory, playerHealth, enemyHealth := battleEngine.GetFinalResult()
+
+	result := &FloorResult{
+		Floor:        run.Floor,
+		EnemyName:    enemyName,
+		IsBoss:       isBoss,
+		Victory:      victory,
+		Rounds:       rounds,
+		PlayerHealth: playerHealth,
+		EnemyHealth:  enemyHealth,
+		Logs:         battleEngine.GetBattleLog(),
+	}
+
+	if !victory {
+		run.Status = RunStatusDefeated
+		if err := s.finishRun(run); err != nil {
+			return nil, err
+		}
+		result.Run = run
+		log.Printf("[Dungeon] 玩家 %d 在第 %d 层战败，积分 %d", s.playerID, run.Floor, run.Score)
+		return result, nil
+	}
+
+	// 通关积分：层数基础分 + 剩余血量奖励，首领层翻倍
+	scoreGained := run.Floor*100 + int(math.Round(playerHealth/playerStats.MaxHealth*50))
+	if isBoss {
+		scoreGained *= 2
+	}
+	result.ScoreGained = scoreGained
+
+	run.Score += scoreGained
+	run.FloorsCleared++
+	run.Floor++
+	run.Status = RunStatusChoosing
+	run.BuffOptions = RollBuffOptions(run.Floor, BuffOptionCount)
+	if err := s.saveRun(run); err != nil {
+		return nil, fmt.Errorf("保存秘境状态失败: %w", err)
+	}
+
+	result.BuffOptions = run.BuffOptions
+	result.Run = run
+	return result, nil
+}
+
+// SelectBuff 选择增益
+func (s *RunService) SelectBuff(buffID string) (*RunState, error) {
+	var run *RunState
+	err := s.withRunLock(func() error {
+		var err error
+		run, err = s.selectBuff(buffID)
+		return err
+	})
+	return run, err
+}
+
+// selectBuff 选择增益，调用方需持有秘境操作锁
+func (s *RunService) selectBuff(buffID string) (*RunState, error) {
+	run, err := s.LoadRun()
+	if err != nil {
+		return nil, fmt.Errorf("读取秘境状态失败: %w", err)
+	}
+	if run == nil {
+		return nil, fmt.Errorf("没有进行中的秘境挑战")
+	}
+	if run.Status != RunStatusChoosing {
+		return nil, fmt.Errorf("当前无需选择增益")
+	}
+
+	var selected *BuffConfig
+	for i := range run.BuffOptions {
+		if run.BuffOptions[i].ID == buffID {
+			selected = &run.BuffOptions[i]
+			break
+		}
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("无效的增益选项")
+	}
+
+	run.Buffs = append(run.Buffs, *selected)
+	run.BuffOptions = []BuffConfig{}
+	run.Status = RunStatusFighting
+	if err := s.saveRun(run); err != nil {
+		return nil, fmt.Errorf("保存秘境状态失败: %w", err)
+	}
+
+	// 记录增益选择（失败不影响流程）
+	effects, _ := json.Marshal(selected.Effects)
+	record := models.DungeonBuff{
+		UserID:       s.playerID,
+		DungeonRunID: run.RunID,
+		Floor:        run.FloorsCleared,
+		BuffID:       selected.ID,
+		BuffName:     selected.Name,
+		BuffType:     selected.Rarity,
+		BuffEffects:  datatypes.JSON(effects),
+		SelectedAt:   time.Now(),
+	}
+	if err := db.DB.Create(&record).Error; err != nil {
+		log.Printf("[Dungeon] 记录增益选择失败: %v", err)
+	}
+
+	return run, nil
+}
+
+// AbandonRun 放弃当前秘境挑战，按当前积分结算
+func (s *RunService) AbandonRun() (*RunState, error) {
+	var run *RunState
+	err := s.withRunLock(func() error {
+		var err error
+		run, err = s.abandonRun()
+		return err
+	})
+	return run, err
+}
+
+// abandonRun 放弃当前秘境挑战，调用方需持有秘境操作锁
+func (s *RunService) abandonRun() (*RunState, error) {
+	run, err := s.LoadRun()
+	if err != nil {
+		return nil, fmt.Errorf("读取秘境状态失败: %w", err)
+	}
+	if run == nil {
+		return nil, fmt.Errorf("没有进行中的秘境挑战")
+	}
+
+	run.Status = RunStatusAbandon
+	if err := s.finishRun(run); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Dungeon] 玩家 %d 放弃秘境挑战，积分 %d", s.playerID, run.Score)
+	return run, nil
+}
+
+// finishRun 结算秘境挑战：更新排行榜与进度，清除运行状态
+func (s *RunService) finishRun(run *RunState) error {
+	if run.Score > 0 {
+		if err := redis.Client.ZAddGT(redis.Ctx, RunLeaderboardKey, redisv9.Z{
+			Score:  float64(run.Score),
+			Member: s.playerID,
+		}).Err(); err != nil {
+			log.Printf("[Dungeon] 更新排行榜失败: %v", err)
+		}
+	}
+
+	if err := s.updateProgress(run); err != nil {
+		log.Printf("[Dungeon] 更新秘境进度失败: %v", err)
+	}
+
+	if err := s.clearRun(); err != nil {
+		return fmt.Errorf("清除秘境状态失败: %w", err)
+	}
+	return nil
+}
+
+// updateProgress 更新数据库中的秘境进度统计
+func (s *RunService) updateProgress(run *RunState) error {
+	var progress models.DungeonProgress
+	err := db.DB.Where("user_id = ?", s.playerID).First(&progress).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	losses := 0
+	if run.Status == RunStatusDefeated {
+		losses = 1
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		progress = models.DungeonProgress{
+			UserID:          s.playerID,
+			CurrentFloor:    run.Floor,
+			MaxFloorReached: run.Floor,
+			TotalRuns:       1,
+			TotalVictories:  run.FloorsCleared,
+			TotalLosses:     losses,
+		}
+		return db.DB.Create(&progress).Error
+	}
+
+	maxFloor := progress.MaxFloorReached
+	if run.Floor > maxFloor {
+		maxFloor = run.Floor
+	}
+	return db.DB.Model(&progress).Updates(map[string]interface{}{
+		"current_floor":     run.Floor,
+		"max_floor_reached": maxFloor,
+		"total_runs":        gorm.Expr("total_runs + ?", 1),
+		"total_victories":   gorm.Expr("total_victories + ?", run.FloorsCleared),
+		"total_losses":      gorm.Expr("total_losses + ?", losses),
+		"updated_at":        time.Now(),
+	}).Error
+}
+
+// GetLeaderboard 获取秘境积分排行榜
+func GetLeaderboard(limit int64) ([]LeaderboardEntry, error) {
+	members, err := redis.Client.ZRevRangeWithScores(redis.Ctx, RunLeaderboardKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(members))
+	entries := make([]LeaderboardEntry, 0, len(members))
+	for i, m := range members {
+		memberStr, _ := m.Member.(string)
+		parsed, err := strconv.ParseUint(memberStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		id := uint(parsed)
+		ids = append(ids, id)
+		entries = append(entries, LeaderboardEntry{
+			Rank:     i + 1,
+			PlayerID: id,
+			Score:    int(m.Score),
+		})
+	}
+
+	if len(ids) > 0 {
+		var users []models.User
+		if err := db.DB.Select("id, player_name").Where("id IN ?", ids).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		names := make(map[uint]string, len(users))
+		for _, u := range users {
+			names[u.ID] = u.PlayerName
+		}
+		for i := range entries {
+			entries[i].PlayerName = names[entries[i].PlayerID]
+		}
+	}
+
+	return entries, nil
+}
+
+// BuildPlayerCombatStats 从玩家数据构建战斗属性
+func BuildPlayerCombatStats(user *models.User) *battle.CombatStats {
+	base := jsonToFloatMap(user.BaseAttributes)
+	combat := jsonToFloatMap(user.CombatAttributes)
+	resist := jsonToFloatMap(user.CombatResistance)
+	special := jsonToFloatMap(user.SpecialAttributes)
+
+	// 破军丹、虎威丹限时加成
+	attack := base["attack"] * (1 + pillbuff.GetActiveEffect(user.ID, pillbuff.EffectAttackBoost))
+	critRate := combat["critRate"] + pillbuff.GetActiveEffect(user.ID, pillbuff.EffectCritRate)
+
+	stats := battle.ToCombatStats(
+		base["health"], attack, base["defense"], base["speed"],
+		critRate, combat["comboRate"], combat["counterRate"], combat["stunRate"], combat["dodgeRate"], combat["vampireRate"],
+		resist["critResist"], resist["comboResist"], resist["counterResist"], resist["stunResist"], resist["dodgeResist"], resist["vampireResist"],
+		special["healBoost"], special["critDamageBoost"], special["critDamageReduce"], special["finalDamageBoost"],
+		special["finalDamageReduce"]+formation.GetActiveEffect(user.ID, formation.EffectCombatWard), // 金刚护体阵
+		special["combatBoost"], special["resistanceBoost"],
+	)
+	// 套装战斗特效
+	battle.ApplySetEffects(stats, gacha.GetEquippedSetEffects(user.ID))
+	return stats
+}
+
+// floorBossScript 镇守首领脚本：半血暴怒、濒死死战，久战不下则狂暴
+var floorBossScript = &battle.BossScript{
+	Phases: []battle.BossPhase{
+		{Name: "暴怒", HealthThreshold: 50, Message: "镇守首领怒吼一声，攻势愈发凌厉！", Multipliers: map[string]float64{"attack": 1.2}, Bonuses: map[string]float64{"critRate": 0.1}},
+		{Name: "死战", HealthThreshold: 20, Message: "镇守首领燃烧精血，防御大增！", Multipliers: map[string]float64{"defense": 1.5}, Bonuses: map[string]float64{"vampireRate": 0.1}},
+	},
+	Enrage: &battle.BossEnrage{Round: 30, Message: "镇守首领陷入狂暴，攻击与速度大幅提升！", Multipliers: map[string]float64{"attack": 1.5, "speed": 1.3}},
+}
+
+// buildFloorEnemy 根据玩家等级与层数生成守层妖兽
+func buildFloorEnemy(playerLevel, floor int, isBoss bool) (string, *battle.CombatStats) {
+	if playerLevel < 1 {
+		playerLevel = 1
+	}
+	level := float64(playerLevel)
+	scale := 1 + float64(floor-1)*0.15
+	name := fmt.Sprintf("第%d层守卫", floor)
+	if isBoss {
+		scale *= 1.5
+		name = fmt.Sprintf("第%d层镇守首领", floor)
+	}
+
+	rate := math.Min(0.3, 0.02*float64(floor))
+	return name, battle.ToCombatStats(
+		100*level*scale, 10*level*scale, 5*level*scale, 10*level*(1+float64(floor-1)*0.05),
+		rate, rate, rate*0.5, rate*0.5, rate, rate*0.5,
+		rate*0.5, rate*0.5, rate*0.5, rate*0.5, rate*0.5, rate*0.5,
+		0, 0, 0, 0, 0, 0, 0,
+	)
+}
+
+// jsonToFloatMap 将 JSON 转换为 map[string]float64（忽略非数值字段）
+func jsonToFloatMap(j datatypes.JSON) map[string]float64 {
+	result := map[string]float64{}
+	if len(j) == 0 {
+		return result
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(j, &m); err != nil {
+		return result
+	}
+	for k, v := range m {
+		if f, ok := v.(float64); ok {
+			result[k] = f
+		}
+	}
+	return result
+}
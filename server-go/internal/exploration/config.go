@@ -1,18 +1,16 @@
 package exploration
 
-import "math"
+import (
+	"math"
+
+	"xiuxian/server-go/internal/models"
+)
 
 // HerbQualities 灵草品质配置
-var HerbQualities = map[string]map[string]interface{}{
-	"common":    {"name": "普通", "value": 1},
-	"uncommon":  {"name": "优质", "value": 1.5},
-	"rare":      {"name": "稀有", "value": 2},
-	"epic":      {"name": "极品", "value": 3},
-	"legendary": {"name": "仙品", "value": 5},
-}
+var HerbQualities = models.HerbQualities
 
 // HerbQualitiesSlice 品质数组（用于按概率选择）
-var HerbQualitiesSlice = []string{"common", "uncommon", "rare", "epic", "legendary"}
+var HerbQualitiesSlice = models.HerbQualityOrder
 
 // HerbConfigs 灵草配置
 var HerbConfigs = []HerbConfig{
@@ -194,6 +192,51 @@ var PillRecipes = []PillRecipe{
 		FragmentsNeeded: 20,
 		Weight:          10, // 较少
 	},
+	{
+		ID:              "enlightenment_pill",
+		Name:            "悟道丹",
+		Description:     "百年灵草炼制，服用后三十分钟内修炼速度提升",
+		Grade:           "grade2",
+		Type:            "cultivation",
+		FragmentsNeeded: 15,
+		Weight:          10, // 较少
+	},
+	{
+		ID:              "fortune_pill",
+		Name:            "寻宝丹",
+		Description:     "百年灵草炼制，服用后三十分钟内历练幸运提升，可叠加时长",
+		Grade:           "grade2",
+		Type:            "special",
+		FragmentsNeeded: 15,
+		Weight:          10, // 较少
+	},
+	{
+		ID:              "battle_fury_pill",
+		Name:            "破军丹",
+		Description:     "千年灵草炼制，服用后一小时内战斗暴击率提升",
+		Grade:           "grade3",
+		Type:            "attribute",
+		FragmentsNeeded: 20,
+		Weight:          5, // 稀有
+	},
+	{
+		ID:              "tiger_might_pill",
+		Name:            "虎威丹",
+		Description:     "千年灵草炼制，服用后一小时内战斗攻击提升，最多叠加三层",
+		Grade:           "grade3",
+		Type:            "attribute",
+		FragmentsNeeded: 20,
+		Weight:          5, // 稀有
+	},
+	{
+		ID:              "purification_pill",
+		Name:            "清毒丹",
+		Description:     "寒霜莲为主药炼制，服用后化解体内积累的丹毒",
+		Grade:           "grade2",
+		Type:            "special",
+		FragmentsNeeded: 15,
+		Weight:          10, // 较少
+	},
 	{
 		ID:              "mind_clarity",
 		Name:            "清心丹",
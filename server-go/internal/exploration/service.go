@@ -9,7 +9,10 @@ import (
 
 	"xiuxian/server-go/internal/cultivation"
 	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/gem"
+	"xiuxian/server-go/internal/inventory"
 	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/pillbuff"
 
 	"gorm.io/datatypes"
 )
@@ -17,6 +20,7 @@ import (
 // 自定义错误
 var (
 	ErrInsufficientSpirit = errors.New("探索失败灵力不足")
+	ErrInRetreat          = errors.New("道友正在闭关，出关后方可探索")
 )
 
 // ExplorationService 探索服务
@@ -103,6 +107,10 @@ func cultivationRewardByRealm(user *models.User, rate float64) int {
 // StartExploration 开始探索（单次触发）
 // @return 触发的事件列表、日志字符串、错误
 func (s *ExplorationService) StartExploration() ([]ExplorationEvent, string, error) {
+	if cultivation.IsInRetreat(s.userID) {
+		return nil, "", ErrInRetreat
+	}
+
 	// ✅ 先检查修为是否稳定
 	if err := s.CheckCultivationStability(); err != nil {
 		return nil, "", err
@@ -229,12 +237,17 @@ func (s *ExplorationService) triggerRandomEvent(user *models.User, r *rand.Rand)
 		{"丹方残页", 1, s.eventPillRecipeFragment},
 		// 小计 7
 
+		// ===== 功法 =====
+		{"古洞秘籍", 2, s.eventTechniqueScroll},
+		// 小计 2
+
 		// ===== 稀有资源 =====
 		{"获得灵石", 12, s.eventTreasureTrove},
 		{"获得强化石", 6, s.eventReinforceStone},
 		{"获得洗炼石", 6, s.eventRefinementStone},
 		{"获得灵宠精华", 5, s.eventPetEssence},
-		// 小计 29
+		{"获得宝石", 3, s.eventGemFound},
+		// 小计 32
 	}
 
 	// 计算总权重
@@ -426,6 +439,25 @@ func (s *ExplorationService) eventReinforceStone(user *models.User, r *rand.Rand
 	}
 }
 
+// eventGemFound 获得宝石：多为一阶，小概率二阶
+func (s *ExplorationService) eventGemFound(user *models.User, r *rand.Rand) *ExplorationEvent {
+	tier := 1
+	if r.Float64() < 0.1 {
+		tier = 2
+	}
+
+	found, err := gem.GrantRandomGem(db.DB, s.userID, tier, 1)
+	if err != nil {
+		return nil
+	}
+
+	return &ExplorationEvent{
+		Type:        "gem_found",
+		Description: fmt.Sprintf("于山涧碎石中发现一枚%s", found.Name),
+		Amount:      1,
+	}
+}
+
 // eventRefinementStone 获得洗炼石
 func (s *ExplorationService) eventRefinementStone(user *models.User, r *rand.Rand) *ExplorationEvent {
 	amount := 10
@@ -461,6 +493,25 @@ func (s *ExplorationService) eventPetEssence(user *models.User, r *rand.Rand) *E
 	}
 }
 
+// eventTechniqueScroll 古洞秘籍：按品阶权重获得一部当前境界可修炼的功法，已习得则转化为功法经验
+func (s *ExplorationService) eventTechniqueScroll(user *models.User, r *rand.Rand) *ExplorationEvent {
+	config := cultivation.RollTechniqueDrop(user.Level, r)
+	if config == nil {
+		return nil
+	}
+
+	result, err := cultivation.NewCultivationService(user.ID).GrantTechnique(config.ID)
+	if err != nil {
+		return nil
+	}
+
+	return &ExplorationEvent{
+		Type:        "technique_found",
+		Description: fmt.Sprintf("误入上古洞府，于石壁间寻得功法玉简，%s", result.Message),
+		Item:        result,
+	}
+}
+
 // eventHerbDiscovery 灵草发现：获得灵草
 // ✅ 修复：使用 Chance 作为权重进行加权随机，Chance=0 的灵草不会通过探索获得
 func (s *ExplorationService) eventHerbDiscovery(user *models.User, r *rand.Rand) *ExplorationEvent {
@@ -504,14 +555,8 @@ func (s *ExplorationService) eventHerbDiscovery(user *models.User, r *rand.Rand)
 		return nil
 	}
 
-	// 创建灵草记录
-	herb := models.Herb{
-		UserID: s.userID,
-		HerbID: selectedHerb.ID,
-		Name:   selectedHerb.Name,
-		Count:  1,
-	}
-	if err := db.DB.Create(&herb).Error; err != nil {
+	// 灵草按品质堆叠入库
+	if err := inventory.AddHerbs(db.DB, s.userID, selectedHerb.ID, selectedHerb.Name, inventory.DefaultQuality, 1); err != nil {
 		return nil
 	}
 
@@ -561,28 +606,18 @@ func (s *ExplorationService) eventPillRecipeFragment(user *models.User, r *rand.
 		return nil
 	}
 
-	// 创建或更新丹方残页记录
-	var fragment models.PillFragment
-	result := db.DB.Where("user_id = ? AND recipe_id = ?", s.userID, selectedRecipe.ID).First(&fragment)
-
-	if result.Error == nil {
-		fragment.Count++
-		db.DB.Save(&fragment)
-	} else {
-		fragment = models.PillFragment{
-			UserID:   s.userID,
-			RecipeID: selectedRecipe.ID,
-			Count:    1,
-		}
-		db.DB.Create(&fragment)
+	// 丹方残页按丹方堆叠
+	fragmentCount, err := inventory.AddPillFragments(db.DB, s.userID, selectedRecipe.ID, 1)
+	if err != nil {
+		return nil
 	}
 
 	// ✅ 检查是否可以自动合成完整丹方
 	description := fmt.Sprintf("获得%s的残页", selectedRecipe.Name)
 	fmt.Printf("[DEBUG eventPillRecipeFragment] recipeID=%s, currentFragments=%d, needed=%d, weight=%f\n",
-		selectedRecipe.ID, fragment.Count, selectedRecipe.FragmentsNeeded, selectedRecipe.Weight)
+		selectedRecipe.ID, fragmentCount, selectedRecipe.FragmentsNeeded, selectedRecipe.Weight)
 
-	if fragment.Count >= selectedRecipe.FragmentsNeeded {
+	if fragmentCount >= selectedRecipe.FragmentsNeeded {
 		// 加载用户的炼丹数据
 		var userAlchemyData models.UserAlchemyDataDB
 		if err := db.DB.Where("user_id = ?", s.userID).First(&userAlchemyData).Error; err == nil {
@@ -605,8 +640,9 @@ func (s *ExplorationService) eventPillRecipeFragment(user *models.User, r *rand.
 				db.DB.Save(&userAlchemyData)
 
 				// 扣除用于合成的残页
-				fragment.Count -= selectedRecipe.FragmentsNeeded
-				db.DB.Save(&fragment)
+				fragmentCount -= selectedRecipe.FragmentsNeeded
+				db.DB.Model(&models.PillFragment{}).Where("user_id = ? AND recipe_id = ?", s.userID, selectedRecipe.ID).
+					Update("count", fragmentCount)
 
 				description = fmt.Sprintf("获得%s的残页，集齐%d片残页后自动合成完整丹方！", selectedRecipe.Name, selectedRecipe.FragmentsNeeded)
 				fmt.Printf("[DEBUG eventPillRecipeFragment] 合成成功\n")
@@ -619,7 +655,7 @@ func (s *ExplorationService) eventPillRecipeFragment(user *models.User, r *rand.
 	return &ExplorationEvent{
 		Type:        EventTypePillRecipeFragment,
 		Description: description,
-		Fragments:   fragment.Count,
+		Fragments:   fragmentCount,
 	}
 }
 
@@ -670,24 +706,20 @@ func (s *ExplorationService) handleBattleEncounter(user *models.User, choice int
 	}, nil
 }
 
-// calculateLuck 从 BaseAttributes 中计算幸运值
+// calculateLuck 从 BaseAttributes 中计算幸运值，并叠加寻宝丹效果
 // 默认幸运值为 1.0
 func (s *ExplorationService) calculateLuck(user *models.User) float64 {
-	if user.BaseAttributes == nil {
-		return 1.0
-	}
-
-	var attrs map[string]interface{}
-	if err := json.Unmarshal(user.BaseAttributes, &attrs); err != nil {
-		return 1.0
-	}
-
-	luck, ok := attrs["luck"].(float64)
-	if !ok {
-		return 1.0
+	luck := 1.0
+	if user.BaseAttributes != nil {
+		var attrs map[string]interface{}
+		if err := json.Unmarshal(user.BaseAttributes, &attrs); err == nil {
+			if v, ok := attrs["luck"].(float64); ok {
+				luck = v
+			}
+		}
 	}
 
-	return luck
+	return luck + pillbuff.GetActiveEffect(user.ID, pillbuff.EffectExplorationLuck)
 }
 
 // getPlayerAttributes 从 BaseAttributes 中读取玩家成长属性
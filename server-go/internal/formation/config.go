@@ -0,0 +1,86 @@
+package formation
+
+import (
+	"math"
+	"time"
+)
+
+// 阵法效果类型
+const (
+	EffectSpiritBoost      = "spirit_boost"      // 灵力获取速率加成
+	EffectCultivationBoost = "cultivation_boost" // 打坐与聚灵阵修为加成
+	EffectAlchemyBoost     = "alchemy_boost"     // 炼丹成功率加成
+	EffectCombatWard       = "combat_ward"       // 战斗最终减伤
+)
+
+// FormationConfig 阵法配置
+type FormationConfig struct {
+	ID                string        `json:"id"`
+	Name              string        `json:"name"`
+	Description       string        `json:"description"`
+	Effect            string        `json:"effect"`
+	BaseValue         float64       `json:"baseValue"`     // 1级效果数值
+	ValuePerLevel     float64       `json:"valuePerLevel"` // 每级额外效果
+	MaxLevel          int           `json:"maxLevel"`
+	Duration          time.Duration `json:"-"`
+	DurationSeconds   int64         `json:"durationSeconds"`
+	ActivateCost      int           `json:"activateCost"`      // 1级布阵灵石消耗，每级 +50%
+	UpgradeCost       int           `json:"upgradeCost"`       // 1级升2级灵石消耗
+	UpgradeMultiplier float64       `json:"upgradeMultiplier"` // 每级升级消耗倍率
+	MaxConcurrent     int           `json:"maxConcurrent"`     // 同时生效的最大数量，效果叠加
+}
+
+// formationConfigs 阵法配置表
+var formationConfigs = []FormationConfig{
+	{ID: "spirit_gathering", Name: "聚灵阵", Description: "汇聚天地灵气，提升灵力获取速率", Effect: EffectSpiritBoost,
+		BaseValue: 0.1, ValuePerLevel: 0.05, MaxLevel: 10, Duration: 2 * time.Hour,
+		ActivateCost: 500, UpgradeCost: 2000, UpgradeMultiplier: 1.8, MaxConcurrent: 2},
+	{ID: "enlightenment", Name: "悟道阵", Description: "清心凝神，提升打坐与聚灵阵所得修为", Effect: EffectCultivationBoost,
+		BaseValue: 0.08, ValuePerLevel: 0.04, MaxLevel: 10, Duration: time.Hour,
+		ActivateCost: 800, UpgradeCost: 3000, UpgradeMultiplier: 1.8, MaxConcurrent: 2},
+	{ID: "earth_fire", Name: "地火阵", Description: "引地脉之火入丹炉，提升炼丹成功率", Effect: EffectAlchemyBoost,
+		BaseValue: 0.05, ValuePerLevel: 0.025, MaxLevel: 10, Duration: time.Hour,
+		ActivateCost: 1000, UpgradeCost: 4000, UpgradeMultiplier: 2, MaxConcurrent: 1},
+	{ID: "vajra_ward", Name: "金刚护体阵", Description: "以阵纹护住周身，战斗中减免伤害", Effect: EffectCombatWard,
+		BaseValue: 0.05, ValuePerLevel: 0.01, MaxLevel: 10, Duration: 30 * time.Minute,
+		ActivateCost: 1000, UpgradeCost: 5000, UpgradeMultiplier: 2, MaxConcurrent: 1},
+}
+
+func init() {
+	for i := range formationConfigs {
+		formationConfigs[i].DurationSeconds = int64(formationConfigs[i].Duration.Seconds())
+	}
+}
+
+// GetFormationConfig 根据ID获取阵法配置
+func GetFormationConfig(formationID string) *FormationConfig {
+	for i := range formationConfigs {
+		if formationConfigs[i].ID == formationID {
+			return &formationConfigs[i]
+		}
+	}
+	return nil
+}
+
+// GetAllFormationConfigs 获取全部阵法配置
+func GetAllFormationConfigs() []FormationConfig {
+	return formationConfigs
+}
+
+// ValueAt 指定等级的效果数值
+func (c *FormationConfig) ValueAt(level int) float64 {
+	return math.Round((c.BaseValue+c.ValuePerLevel*float64(level-1))*1000) / 1000
+}
+
+// ActivateCostAt 指定等级的布阵消耗
+func (c *FormationConfig) ActivateCostAt(level int) int {
+	return int(math.Round(float64(c.ActivateCost) * (1 + 0.5*float64(level-1))))
+}
+
+// UpgradeCostAt 从指定等级升至下一级的消耗，满级返回 0
+func (c *FormationConfig) UpgradeCostAt(level int) int {
+	if level >= c.MaxLevel {
+		return 0
+	}
+	return int(math.Round(float64(c.UpgradeCost) * math.Pow(c.UpgradeMultiplier, float64(level-1))))
+}
@@ -0,0 +1,258 @@
+package formation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+)
+
+// FormationService 阵法服务
+type FormationService struct {
+	userID uint
+}
+
+// NewFormationService 创建阵法服务
+func NewFormationService(userID uint) *FormationService {
+	return &FormationService{userID: userID}
+}
+
+// FormationView 玩家阵法详情
+type FormationView struct {
+	Config      FormationConfig          `json:"config"`
+	Level       int                      `json:"level"`
+	Value       float64                  `json:"value"`       // 当前等级效果
+	UpgradeCost int                      `json:"upgradeCost"` // 升级消耗，满级为 0
+	Cost        int                      `json:"cost"`        // 当前等级布阵消耗
+	Active      []models.ActiveFormation `json:"active"`      // 生效中的阵法
+}
+
+// FormationOverview 阵法总览
+type FormationOverview struct {
+	Formations []FormationView    `json:"formations"`
+	Effects    map[string]float64 `json:"effects"` // 当前生效的效果汇总
+}
+
+// GetActiveEffects 汇总玩家当前生效的阵法效果
+func GetActiveEffects(userID uint) map[string]float64 {
+	effects := map[string]float64{}
+	var active []models.ActiveFormation
+	if err := db.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Find(&active).Error; err != nil {
+		log.Printf("[Formation] 查询生效阵法失败: %v", err)
+		return effects
+	}
+	for _, a := range active {
+		effects[a.Effect] += a.Value
+	}
+	return effects
+}
+
+// GetActiveEffect 获取玩家某一类阵法的当前效果
+func GetActiveEffect(userID uint, effect string) float64 {
+	var total float64
+	if err := db.DB.Model(&models.ActiveFormation{}).
+		Where("user_id = ? AND effect = ? AND expires_at > ?", userID, effect, time.Now()).
+		Select("COALESCE(SUM(value), 0)").Scan(&total).Error; err != nil {
+		log.Printf("[Formation] 查询阵法效果失败: %v", err)
+		return 0
+	}
+	return total
+}
+
+// GetActiveEffectByUsers 批量获取多个玩家某一类阵法的当前效果，未布阵的玩家不在结果中
+func GetActiveEffectByUsers(userIDs []uint, effect string) map[uint]float64 {
+	effects := make(map[uint]float64)
+	if len(userIDs) == 0 {
+		return effects
+	}
+	var rows []struct {
+		UserID uint
+		Total  float64
+	}
+	if err := db.DB.Model(&models.ActiveFormation{}).
+		Where("user_id IN ? AND effect = ? AND expires_at > ?", userIDs, effect, time.Now()).
+		Select("user_id, COALESCE(SUM(value), 0) AS total").Group("user_id").Scan(&rows).Error; err != nil {
+		log.Printf("[Formation] 批量查询阵法效果失败: %v", err)
+		return effects
+	}
+	for _, r := range rows {
+		effects[r.UserID] = r.Total
+	}
+	return effects
+}
+
+// lockUser 在事务内锁定玩家行，串行化同一玩家的升级与布阵
+func lockUser(tx *gorm.DB, userID uint) error {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, userID).Error; err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	return nil
+}
+
+// getFormationLevel 获取阵法等级，未升级过为 1 级
+func getFormationLevel(tx *gorm.DB, userID uint, formationID string) (*models.UserFormation, error) {
+	record := &models.UserFormation{UserID: userID, FormationID: formationID, Level: 1}
+	err := tx.Where("user_id = ? AND formation_id = ?", userID, formationID).First(record).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get formation: %w", err)
+	}
+	return record, nil
+}
+
+// GetFormations 获取阵法列表、等级与生效状态
+func (s *FormationService) GetFormations() (*FormationOverview, error) {
+	var levels []models.UserFormation
+	if err := db.DB.Where("user_id = ?", s.userID).Find(&levels).Error; err != nil {
+		return nil, fmt.Errorf("failed to get formations: %w", err)
+	}
+	levelMap := make(map[string]int, len(levels))
+	for _, l := range levels {
+		levelMap[l.FormationID] = l.Level
+	}
+
+	var active []models.ActiveFormation
+	if err := db.DB.Where("user_id = ? AND expires_at > ?", s.userID, time.Now()).
+		Order("expires_at").Find(&active).Error; err != nil {
+		return nil, fmt.Errorf("failed to get active formations: %w", err)
+	}
+
+	overview := &FormationOverview{Effects: map[string]float64{}}
+	for _, config := range formationConfigs {
+		level := levelMap[config.ID]
+		if level == 0 {
+			level = 1
+		}
+		view := FormationView{
+			Config:      config,
+			Level:       level,
+			Value:       config.ValueAt(level),
+			UpgradeCost: config.UpgradeCostAt(level),
+			Cost:        config.ActivateCostAt(level),
+			Active:      []models.ActiveFormation{},
+		}
+		for _, a := range active {
+			if a.FormationID == config.ID {
+				view.Active = append(view.Active, a)
+				overview.Effects[a.Effect] += a.Value
+			}
+		}
+		overview.Formations = append(overview.Formations, view)
+	}
+	return overview, nil
+}
+
+// UpgradeFormation 消耗灵石提升阵法等级（已布下的阵法按布阵时的等级生效）
+func (s *FormationService) UpgradeFormation(formationID string) (*models.UserFormation, int, error) {
+	config := GetFormationConfig(formationID)
+	if config == nil {
+		return nil, 0, fmt.Errorf("阵法不存在")
+	}
+
+	var record *models.UserFormation
+	var cost int
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, s.userID); err != nil {
+			return err
+		}
+		var err error
+		record, err = getFormationLevel(tx, s.userID, formationID)
+		if err != nil {
+			return err
+		}
+		cost = config.UpgradeCostAt(record.Level)
+		if cost == 0 {
+			return fmt.Errorf("%s已达最高等级", config.Name)
+		}
+		if err := spendSpiritStones(tx, s.userID, cost); err != nil {
+			return err
+		}
+
+		record.Level++
+		return tx.Save(record).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	log.Printf("[Formation] 玩家 %d 将%s提升至 %d 级，消耗灵石 %d", s.userID, config.Name, record.Level, cost)
+	return record, cost, nil
+}
+
+// ActivateFormation 消耗灵石布阵，在持续时间内生效；同类阵法数量受 MaxConcurrent 限制
+func (s *FormationService) ActivateFormation(formationID string) (*models.ActiveFormation, int, error) {
+	config := GetFormationConfig(formationID)
+	if config == nil {
+		return nil, 0, fmt.Errorf("阵法不存在")
+	}
+
+	var active *models.ActiveFormation
+	var cost int
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// 锁定玩家后再计数，防止并发布阵超过数量上限
+		if err := lockUser(tx, s.userID); err != nil {
+			return err
+		}
+		now := time.Now()
+		// 清理已失效的阵法
+		if err := tx.Where("user_id = ? AND expires_at <= ?", s.userID, now).
+			Delete(&models.ActiveFormation{}).Error; err != nil {
+			return fmt.Errorf("failed to clean formations: %w", err)
+		}
+
+		var count int64
+		if err := tx.Model(&models.ActiveFormation{}).
+			Where("user_id = ? AND formation_id = ?", s.userID, formationID).
+			Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count formations: %w", err)
+		}
+		if int(count) >= config.MaxConcurrent {
+			return fmt.Errorf("%s最多同时布下%d座", config.Name, config.MaxConcurrent)
+		}
+
+		record, err := getFormationLevel(tx, s.userID, formationID)
+		if err != nil {
+			return err
+		}
+		cost = config.ActivateCostAt(record.Level)
+		if err := spendSpiritStones(tx, s.userID, cost); err != nil {
+			return err
+		}
+
+		active = &models.ActiveFormation{
+			UserID:      s.userID,
+			FormationID: config.ID,
+			Effect:      config.Effect,
+			Level:       record.Level,
+			Value:       config.ValueAt(record.Level),
+			ExpiresAt:   now.Add(config.Duration),
+		}
+		return tx.Create(active).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	log.Printf("[Formation] 玩家 %d 布下%s（%d级），消耗灵石 %d", s.userID, config.Name, active.Level, cost)
+	return active, cost, nil
+}
+
+// spendSpiritStones 扣除灵石，不足时返回错误
+func spendSpiritStones(tx *gorm.DB, userID uint, cost int) error {
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND spirit_stones >= ?", userID, cost).
+		Update("spirit_stones", gorm.Expr("spirit_stones - ?", cost))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("灵石不足，需要%d", cost)
+	}
+	return nil
+}
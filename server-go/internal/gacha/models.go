@@ -23,6 +23,7 @@ type GachaEquipment struct {
 	EnhanceLevel  int                    `json:"enhance_level"`    // 强化等级
 	Stats         map[string]float64     `json:"stats"`            // 装备基础属性
 	ExtraAttrs    map[string]interface{} `json:"extra_attributes"` // 装备额外属性
+	SetID         string                 `json:"set_id"`           // 所属套装ID
 }
 
 // GachaPet 抽卡获得的灵宠数据结构
@@ -40,6 +41,7 @@ type GachaPet struct {
 	AttackBonus     float64            `json:"attack_bonus"`      // 攻击加成
 	DefenseBonus    float64            `json:"defense_bonus"`     // 防御加成
 	HealthBonus     float64            `json:"health_bonus"`      // 生命加成
+	SetID           string             `json:"set_id"`            // 所属套装ID，出战时计入套装件数
 	CreatedAtMillis int64              `json:"created_at"`        // 创建时间戳
 }
 
@@ -121,6 +123,7 @@ func GenerateRandomEquipment(level int) GachaEquipment {
 		EnhanceLevel:  0,
 		Stats:         stats,
 		ExtraAttrs:    map[string]interface{}{},
+		SetID:         assignSetID(quality),
 	}
 }
 
@@ -180,6 +183,7 @@ func GenerateRandomPet(level int) GachaPet {
 		AttackBonus:     finalBonus,
 		DefenseBonus:    finalBonus,
 		HealthBonus:     finalBonus,
+		SetID:           assignSetID(rarity),
 		CreatedAtMillis: time.Now().UnixMilli(),
 	}
 }
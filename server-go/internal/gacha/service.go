@@ -35,6 +35,7 @@ func GenerateEquipment(userID uint, level int, logger *zap.Logger) (*models.Equi
 		Stats:           ToJSON(eq.Stats),
 		ExtraAttributes: ToJSON(eq.ExtraAttrs),
 		Equipped:        false,
+		SetID:           eq.SetID,
 	}
 
 	if err := db.DB.Create(&model).Error; err != nil {
@@ -87,6 +88,7 @@ func GeneratePet(userID uint, level int, logger *zap.Logger) (*models.Pet, error
 		AttackBonus:      p.AttackBonus,
 		DefenseBonus:     p.DefenseBonus,
 		HealthBonus:      p.HealthBonus,
+		SetID:            p.SetID,
 		IsActive:         false,
 	}
 
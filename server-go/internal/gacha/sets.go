@@ -0,0 +1,227 @@
+package gacha
+
+import (
+	"math/rand"
+	"sort"
+
+	"gorm.io/gorm"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/dungeon/battle"
+	"xiuxian/server-go/internal/models"
+)
+
+// SetEffect 套装战斗特效，在战斗中满足条件时触发，同 ID 特效累加
+type SetEffect struct {
+	ID    string  `json:"id"` // battle.SetEffect* 之一
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// SetBonus 套装件数加成，达到件数后生效，各档加成累加
+type SetBonus struct {
+	Pieces      int                `json:"pieces"`
+	Stats       map[string]float64 `json:"stats"`
+	Effect      *SetEffect         `json:"effect,omitempty"`
+	Description string             `json:"description"`
+}
+
+// EquipmentSet 装备套装配置
+type EquipmentSet struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	MinQuality string     `json:"min_quality"` // 可出现该套装的最低品质
+	Bonuses    []SetBonus `json:"bonuses"`
+}
+
+// EquipmentSets 套装配置，2/4/6 件套
+// 套装部件为 5 个装备槽位（faqi/guanjin/daopao/yunlv/fabao）加出战灵宠，灵宠抽取时同样可能带有套装，
+// 6 件套需穿齐同套装备并派出同套灵宠
+// 6 件档附带战斗特效：破甲、护体、斩杀，见 battle.ApplySetEffects
+var EquipmentSets = map[string]EquipmentSet{
+	"qingyun": {
+		ID:         "qingyun",
+		Name:       "青云",
+		MinQuality: "uncommon",
+		Bonuses: []SetBonus{
+			{Pieces: 2, Stats: map[string]float64{"attack": 80, "defense": 40}, Description: "攻击+80，防御+40"},
+			{Pieces: 4, Stats: map[string]float64{"critRate": 0.05, "comboRate": 0.05}, Description: "暴击率+5%，连击率+5%"},
+			{Pieces: 6, Stats: map[string]float64{"finalDamageBoost": 0.08},
+				Effect: &SetEffect{ID: battle.SetEffectArmorPierce, Name: "青云剑意", Value: 0.2}, Description: "最终增伤+8%；青云剑意：攻击无视目标20%防御"},
+		},
+	},
+	"xuanwu": {
+		ID:         "xuanwu",
+		Name:       "玄武",
+		MinQuality: "uncommon",
+		Bonuses: []SetBonus{
+			{Pieces: 2, Stats: map[string]float64{"health": 500, "defense": 60}, Description: "生命+500，防御+60"},
+			{Pieces: 4, Stats: map[string]float64{"critResist": 0.06, "stunResist": 0.06}, Description: "抗暴击+6%，抗眩晕+6%"},
+			{Pieces: 6, Stats: map[string]float64{"finalDamageReduce": 0.1},
+				Effect: &SetEffect{ID: battle.SetEffectLastStand, Name: "玄武不动", Value: 0.3}, Description: "最终减伤+10%；玄武不动：生命低于30%时受到伤害降低30%"},
+		},
+	},
+	"xuehe": {
+		ID:         "xuehe",
+		Name:       "血河",
+		MinQuality: "rare",
+		Bonuses: []SetBonus{
+			{Pieces: 2, Stats: map[string]float64{"attack": 200}, Description: "攻击+200"},
+			{Pieces: 4, Stats: map[string]float64{"vampireRate": 0.08}, Description: "吸血率+8%"},
+			{Pieces: 6, Stats: map[string]float64{"critDamageBoost": 0.2},
+				Effect: &SetEffect{ID: battle.SetEffectExecuteBoost, Name: "血河斩", Value: 0.3}, Description: "强化爆伤+20%；血河斩：目标生命低于30%时伤害提升30%"},
+		},
+	},
+	"zhuxian": {
+		ID:         "zhuxian",
+		Name:       "诛仙",
+		MinQuality: "epic",
+		Bonuses: []SetBonus{
+			{Pieces: 2, Stats: map[string]float64{"combatBoost": 0.08}, Description: "战斗强化+8%"},
+			{Pieces: 4, Stats: map[string]float64{"stunRate": 0.08, "finalDamageBoost": 0.08}, Description: "眩晕率+8%，最终增伤+8%"},
+			{Pieces: 6, Stats: map[string]float64{"finalDamageBoost": 0.15, "critDamageBoost": 0.25},
+				Effect: &SetEffect{ID: battle.SetEffectExecuteBoost, Name: "诛仙剑阵", Value: 0.5}, Description: "最终增伤+15%，强化爆伤+25%；诛仙剑阵：目标生命低于30%时伤害提升50%"},
+		},
+	},
+}
+
+// SetPiecePet 出战灵宠作为套装部件时的部位名
+const SetPiecePet = "pet"
+
+// equipmentSetChance 各品质装备、灵宠成为套装部件的概率，凡品不出套装
+var equipmentSetChance = map[string]float64{
+	"common":    0,
+	"uncommon":  0.1,
+	"rare":      0.2,
+	"epic":      0.35,
+	"legendary": 0.5,
+	"mythic":    0.75,
+}
+
+// qualityRank 品质高低排序，用于判断套装最低品质
+var qualityRank = map[string]int{
+	"common":    0,
+	"uncommon":  1,
+	"rare":      2,
+	"epic":      3,
+	"legendary": 4,
+	"mythic":    5,
+}
+
+// assignSetID 按品质（灵宠为稀有度）随机决定所属套装，未命中返回空字符串
+func assignSetID(quality string) string {
+	if rand.Float64() >= equipmentSetChance[quality] {
+		return ""
+	}
+	var candidates []string
+	for id, set := range EquipmentSets {
+		if qualityRank[quality] >= qualityRank[set.MinQuality] {
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Strings(candidates)
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// SetBonusProgress 套装某档加成的生效情况
+type SetBonusProgress struct {
+	SetBonus
+	Active bool `json:"active"`
+}
+
+// SetProgress 玩家已穿戴套装的进度
+type SetProgress struct {
+	SetID      string             `json:"set_id"`
+	Name       string             `json:"name"`
+	Count      int                `json:"count"`
+	EquipTypes []string           `json:"equip_types"`
+	Bonuses    []SetBonusProgress `json:"bonuses"`
+}
+
+// countSetPieces 统计已穿戴装备与出战灵宠中各套装的件数与部位，pet 为 nil 表示没有出战灵宠
+func countSetPieces(equipped []models.Equipment, pet *models.Pet) map[string][]string {
+	pieces := make(map[string][]string)
+	for _, eq := range equipped {
+		if _, ok := EquipmentSets[eq.SetID]; !ok {
+			continue
+		}
+		equipType := ""
+		if eq.EquipType != nil {
+			equipType = *eq.EquipType
+		}
+		pieces[eq.SetID] = append(pieces[eq.SetID], equipType)
+	}
+	if pet != nil {
+		if _, ok := EquipmentSets[pet.SetID]; ok {
+			pieces[pet.SetID] = append(pieces[pet.SetID], SetPiecePet)
+		}
+	}
+	return pieces
+}
+
+// SetBonusStats 计算已穿戴装备与出战灵宠激活的套装属性加成总和
+func SetBonusStats(equipped []models.Equipment, pet *models.Pet) map[string]float64 {
+	stats := make(map[string]float64)
+	for setID, types := range countSetPieces(equipped, pet) {
+		for _, bonus := range EquipmentSets[setID].Bonuses {
+			if len(types) < bonus.Pieces {
+				continue
+			}
+			for k, v := range bonus.Stats {
+				stats[k] += v
+			}
+		}
+	}
+	return stats
+}
+
+// SetBattleEffects 汇总已穿戴装备与出战灵宠激活的套装战斗特效 {effectID: value}
+func SetBattleEffects(equipped []models.Equipment, pet *models.Pet) map[string]float64 {
+	effects := make(map[string]float64)
+	for setID, types := range countSetPieces(equipped, pet) {
+		for _, bonus := range EquipmentSets[setID].Bonuses {
+			if bonus.Effect == nil || len(types) < bonus.Pieces {
+				continue
+			}
+			effects[bonus.Effect.ID] += bonus.Effect.Value
+		}
+	}
+	return effects
+}
+
+// GetActivePet 查询玩家的出战灵宠，没有出战灵宠时返回 nil
+func GetActivePet(tx *gorm.DB, userID uint) *models.Pet {
+	var pet models.Pet
+	if err := tx.Where("user_id = ? AND is_active = ?", userID, true).First(&pet).Error; err != nil {
+		return nil
+	}
+	return &pet
+}
+
+// GetEquippedSetEffects 查询玩家当前穿戴装备与出战灵宠激活的套装战斗特效，查询失败视为无特效
+func GetEquippedSetEffects(userID uint) map[string]float64 {
+	var equipped []models.Equipment
+	if err := db.DB.Where("user_id = ? AND equipped = ?", userID, true).Find(&equipped).Error; err != nil {
+		return map[string]float64{}
+	}
+	return SetBattleEffects(equipped, GetActivePet(db.DB, userID))
+}
+
+// GetSetProgress 获取已穿戴装备与出战灵宠的套装进度，按套装ID排序
+func GetSetProgress(equipped []models.Equipment, pet *models.Pet) []SetProgress {
+	pieces := countSetPieces(equipped, pet)
+	progress := make([]SetProgress, 0, len(pieces))
+	for setID, types := range pieces {
+		set := EquipmentSets[setID]
+		p := SetProgress{SetID: setID, Name: set.Name, Count: len(types), EquipTypes: types}
+		for _, bonus := range set.Bonuses {
+			p.Bonuses = append(p.Bonuses, SetBonusProgress{SetBonus: bonus, Active: len(types) >= bonus.Pieces})
+		}
+		progress = append(progress, p)
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].SetID < progress[j].SetID })
+	return progress
+}
@@ -0,0 +1,98 @@
+package garden
+
+import "time"
+
+// 药园参数
+const (
+	InitialPlots = 2 // 初始灵田数量
+	MaxPlots     = 6 // 灵田数量上限
+	MaxPlotLevel = 5 // 灵田等级上限
+
+	plotQualityBonusPerLevel = 0.05 // 每级灵田提升的品质加成
+	maxQualityBonus          = 0.6  // 品质加成上限
+
+	WaterSpeedup         = 0.2 // 道友浇灌缩短剩余生长时间的比例
+	ProtectQualityBonus  = 0.1 // 道友护法提供的品质加成
+	ProtectYieldBonus    = 1   // 道友护法额外收获数量
+	AssistDailyLimit     = 10  // 每日帮助道友药园次数上限
+	AssistRewardStones   = 20  // 帮助道友获得的灵石
+	AssistDailyKeyFormat = "garden:assist:daily:%d:%s"
+)
+
+// 开垦第 N 块灵田的灵石消耗（下标为灵田序号）
+var plotUnlockCosts = []int{0, 0, 1000, 3000, 8000, 20000}
+
+// SeedConfig 灵草种子配置
+type SeedConfig struct {
+	HerbID       string        `json:"herbId"`
+	Name         string        `json:"name"`
+	Price        int           `json:"price"`        // 种子价格（灵石）
+	GrowDuration time.Duration `json:"growDuration"` // 生长时长
+	Yield        int           `json:"yield"`        // 基础收获数量
+}
+
+// FertilizerConfig 灵肥配置
+type FertilizerConfig struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Price        int     `json:"price"`
+	QualityBonus float64 `json:"qualityBonus"` // 品质加成
+	SpeedBonus   float64 `json:"speedBonus"`   // 缩短生长时长的比例
+}
+
+var seedConfigs = []SeedConfig{
+	{HerbID: "spirit_grass", Name: "灵精草", Price: 20, GrowDuration: 30 * time.Minute, Yield: 3},
+	{HerbID: "cloud_flower", Name: "云雾花", Price: 30, GrowDuration: 45 * time.Minute, Yield: 3},
+	{HerbID: "thunder_root", Name: "雷击根", Price: 80, GrowDuration: 2 * time.Hour, Yield: 2},
+	{HerbID: "dark_yin_grass", Name: "玄阴草", Price: 80, GrowDuration: 2 * time.Hour, Yield: 2},
+	{HerbID: "frost_lotus", Name: "寒霜莲", Price: 120, GrowDuration: 3 * time.Hour, Yield: 2},
+	{HerbID: "dragon_breath_herb", Name: "龙息草", Price: 300, GrowDuration: 6 * time.Hour, Yield: 1},
+	{HerbID: "nine_leaf_lingzhi", Name: "九叶灵芝", Price: 400, GrowDuration: 8 * time.Hour, Yield: 1},
+	{HerbID: "purple_ginseng", Name: "紫金参", Price: 400, GrowDuration: 8 * time.Hour, Yield: 1},
+}
+
+var fertilizerConfigs = []FertilizerConfig{
+	{ID: "spirit_manure", Name: "灵肥", Price: 200, QualityBonus: 0.1},
+	{ID: "immortal_dew", Name: "仙露", Price: 1000, QualityBonus: 0.25, SpeedBonus: 0.2},
+}
+
+// GetSeedConfig 获取种子配置
+func GetSeedConfig(herbID string) *SeedConfig {
+	for i := range seedConfigs {
+		if seedConfigs[i].HerbID == herbID {
+			return &seedConfigs[i]
+		}
+	}
+	return nil
+}
+
+// GetFertilizerConfig 获取灵肥配置，空 ID 表示不施肥
+func GetFertilizerConfig(id string) *FertilizerConfig {
+	for i := range fertilizerConfigs {
+		if fertilizerConfigs[i].ID == id {
+			return &fertilizerConfigs[i]
+		}
+	}
+	return nil
+}
+
+// PlotUnlockCost 开垦指定序号灵田的消耗
+func PlotUnlockCost(index int) int {
+	if index < 0 || index >= len(plotUnlockCosts) {
+		return 0
+	}
+	return plotUnlockCosts[index]
+}
+
+// PlotUpgradeCost 灵田升级消耗，满级返回 0
+func PlotUpgradeCost(level int) int {
+	if level >= MaxPlotLevel {
+		return 0
+	}
+	return 2000 * level
+}
+
+// plotYieldBonus 灵田等级提供的额外收获：3 级 +1，5 级 +2
+func plotYieldBonus(level int) int {
+	return (level - 1) / 2
+}
@@ -0,0 +1,399 @@
+package garden
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/cultivation"
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/exploration"
+	"xiuxian/server-go/internal/inventory"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/redis"
+)
+
+// GardenService 药园服务
+type GardenService struct {
+	userID uint
+}
+
+// NewGardenService 创建药园服务
+func NewGardenService(userID uint) *GardenService {
+	return &GardenService{userID: userID}
+}
+
+// PlotView 灵田详情
+type PlotView struct {
+	models.GardenPlot
+	HerbName         string `json:"herbName,omitempty"`
+	Ready            bool   `json:"ready"`
+	RemainingSeconds int64  `json:"remainingSeconds"`
+	UpgradeCost      int    `json:"upgradeCost"` // 升级消耗，满级为 0
+}
+
+// GardenView 药园总览
+type GardenView struct {
+	OwnerID     uint               `json:"ownerId"`
+	Plots       []PlotView         `json:"plots"`
+	UnlockCost  int                `json:"unlockCost"` // 开垦下一块灵田的消耗，已满为 0
+	Seeds       []SeedConfig       `json:"seeds"`
+	Fertilizers []FertilizerConfig `json:"fertilizers"`
+	AssistUsed  int                `json:"assistUsed"` // 今日已帮助道友次数
+	AssistLimit int                `json:"assistLimit"`
+}
+
+// HarvestResult 收获结果
+type HarvestResult struct {
+	PlotIndex int            `json:"plotIndex"`
+	HerbID    string         `json:"herbId"`
+	HerbName  string         `json:"herbName"`
+	Total     int            `json:"total"`
+	Qualities map[string]int `json:"qualities"` // 各品质收获数量
+}
+
+// AssistResult 帮助道友药园的结果
+type AssistResult struct {
+	Plot         models.GardenPlot `json:"plot"`
+	RewardStones int               `json:"rewardStones"`
+	AssistUsed   int               `json:"assistUsed"`
+}
+
+// ensurePlots 首次进入药园时开垦初始灵田
+func ensurePlots(tx *gorm.DB, userID uint) error {
+	for i := 0; i < InitialPlots; i++ {
+		plot := models.GardenPlot{UserID: userID, PlotIndex: i, Level: 1}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&plot).Error; err != nil {
+			return fmt.Errorf("failed to init garden: %w", err)
+		}
+	}
+	return nil
+}
+
+// lockPlot 在事务内锁定玩家的某块灵田
+func lockPlot(tx *gorm.DB, userID uint, plotIndex int) (*models.GardenPlot, error) {
+	var plot models.GardenPlot
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND plot_index = ?", userID, plotIndex).First(&plot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("灵田尚未开垦")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get plot: %w", err)
+	}
+	return &plot, nil
+}
+
+// spendSpiritStones 扣除灵石，不足时返回错误
+func spendSpiritStones(tx *gorm.DB, userID uint, cost int) error {
+	if cost <= 0 {
+		return nil
+	}
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND spirit_stones >= ?", userID, cost).
+		Update("spirit_stones", gorm.Expr("spirit_stones - ?", cost))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("灵石不足，需要%d", cost)
+	}
+	return nil
+}
+
+// assistDailyKey 今日帮助道友次数键，与其他每日次数一样按中国时区换日
+func assistDailyKey(userID uint) string {
+	return fmt.Sprintf(AssistDailyKeyFormat, userID, cultivation.ChinaToday())
+}
+
+// GetGarden 查看药园，ownerID 为 0 时查看自己的药园
+func (s *GardenService) GetGarden(ownerID uint) (*GardenView, error) {
+	if ownerID == 0 {
+		ownerID = s.userID
+	}
+	if ownerID == s.userID {
+		if err := ensurePlots(db.DB, s.userID); err != nil {
+			return nil, err
+		}
+	}
+
+	var plots []models.GardenPlot
+	if err := db.DB.Where("user_id = ?", ownerID).Order("plot_index").Find(&plots).Error; err != nil {
+		return nil, fmt.Errorf("failed to get garden: %w", err)
+	}
+
+	now := time.Now()
+	view := &GardenView{
+		OwnerID:     ownerID,
+		Plots:       make([]PlotView, 0, len(plots)),
+		UnlockCost:  PlotUnlockCost(len(plots)),
+		Seeds:       seedConfigs,
+		Fertilizers: fertilizerConfigs,
+		AssistLimit: AssistDailyLimit,
+	}
+	for _, plot := range plots {
+		pv := PlotView{GardenPlot: plot, UpgradeCost: PlotUpgradeCost(plot.Level)}
+		if seed := GetSeedConfig(plot.HerbID); seed != nil {
+			pv.HerbName = seed.Name
+			pv.Ready = !now.Before(plot.ReadyAt)
+			if !pv.Ready {
+				pv.RemainingSeconds = int64(plot.ReadyAt.Sub(now).Seconds())
+			}
+		}
+		view.Plots = append(view.Plots, pv)
+	}
+	view.AssistUsed, _ = redis.Client.Get(redis.Ctx, assistDailyKey(s.userID)).Int()
+	return view, nil
+}
+
+// UnlockPlot 消耗灵石开垦下一块灵田
+func (s *GardenService) UnlockPlot() (*models.GardenPlot, int, error) {
+	var plot *models.GardenPlot
+	var cost int
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensurePlots(tx, s.userID); err != nil {
+			return err
+		}
+		// 锁定玩家行，防止并发开垦同一序号
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, s.userID).Error; err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		var count int64
+		if err := tx.Model(&models.GardenPlot{}).Where("user_id = ?", s.userID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count plots: %w", err)
+		}
+		if int(count) >= MaxPlots {
+			return fmt.Errorf("灵田已全部开垦")
+		}
+		cost = PlotUnlockCost(int(count))
+		if err := spendSpiritStones(tx, s.userID, cost); err != nil {
+			return err
+		}
+		plot = &models.GardenPlot{UserID: s.userID, PlotIndex: int(count), Level: 1}
+		return tx.Create(plot).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Printf("[Garden] 玩家 %d 开垦第 %d 块灵田，消耗灵石 %d", s.userID, plot.PlotIndex+1, cost)
+	return plot, cost, nil
+}
+
+// UpgradePlot 消耗灵石提升灵田等级，提高收获品质与数量
+func (s *GardenService) UpgradePlot(plotIndex int) (*models.GardenPlot, int, error) {
+	var plot *models.GardenPlot
+	var cost int
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if plot, err = lockPlot(tx, s.userID, plotIndex); err != nil {
+			return err
+		}
+		cost = PlotUpgradeCost(plot.Level)
+		if cost == 0 {
+			return fmt.Errorf("灵田已达最高等级")
+		}
+		if err := spendSpiritStones(tx, s.userID, cost); err != nil {
+			return err
+		}
+		plot.Level++
+		return tx.Save(plot).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Printf("[Garden] 玩家 %d 将第 %d 块灵田提升至 %d 级，消耗灵石 %d", s.userID, plotIndex+1, plot.Level, cost)
+	return plot, cost, nil
+}
+
+// Plant 购买种子（可选灵肥）种在闲置灵田上
+func (s *GardenService) Plant(plotIndex int, herbID, fertilizerID string) (*models.GardenPlot, int, error) {
+	seed := GetSeedConfig(herbID)
+	if seed == nil {
+		return nil, 0, fmt.Errorf("没有该灵草的种子")
+	}
+	var fertilizer *FertilizerConfig
+	if fertilizerID != "" {
+		if fertilizer = GetFertilizerConfig(fertilizerID); fertilizer == nil {
+			return nil, 0, fmt.Errorf("灵肥不存在")
+		}
+	}
+
+	var plot *models.GardenPlot
+	cost := seed.Price
+	growDuration := seed.GrowDuration
+	if fertilizer != nil {
+		cost += fertilizer.Price
+		growDuration = time.Duration(float64(growDuration) * (1 - fertilizer.SpeedBonus))
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if plot, err = lockPlot(tx, s.userID, plotIndex); err != nil {
+			return err
+		}
+		if plot.HerbID != "" {
+			return fmt.Errorf("灵田中已种有灵草")
+		}
+		if err := spendSpiritStones(tx, s.userID, cost); err != nil {
+			return err
+		}
+		now := time.Now()
+		plot.HerbID = seed.HerbID
+		plot.Fertilizer = fertilizerID
+		plot.PlantedAt = now
+		plot.ReadyAt = now.Add(growDuration)
+		plot.WateredBy = 0
+		plot.ProtectedBy = 0
+		return tx.Save(plot).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Printf("[Garden] 玩家 %d 在第 %d 块灵田种下%s，消耗灵石 %d", s.userID, plotIndex+1, seed.Name, cost)
+	return plot, cost, nil
+}
+
+// Harvest 收获已成熟的灵田，每株灵草独立判定品质
+func (s *GardenService) Harvest(plotIndex int) (*HarvestResult, error) {
+	var result *HarvestResult
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		plot, err := lockPlot(tx, s.userID, plotIndex)
+		if err != nil {
+			return err
+		}
+		seed := GetSeedConfig(plot.HerbID)
+		if seed == nil {
+			return fmt.Errorf("灵田中没有可收获的灵草")
+		}
+		if time.Now().Before(plot.ReadyAt) {
+			return fmt.Errorf("灵草尚未成熟")
+		}
+
+		bonus := qualityBonus(plot)
+		total := seed.Yield + plotYieldBonus(plot.Level)
+		if plot.ProtectedBy != 0 {
+			total += ProtectYieldBonus
+		}
+		result = &HarvestResult{
+			PlotIndex: plotIndex,
+			HerbID:    seed.HerbID,
+			HerbName:  seed.Name,
+			Total:     total,
+			Qualities: map[string]int{},
+		}
+		for i := 0; i < total; i++ {
+			result.Qualities[rollQuality(bonus)]++
+		}
+		for quality, count := range result.Qualities {
+			if err := inventory.AddHerbs(tx, s.userID, seed.HerbID, seed.Name, quality, count); err != nil {
+				return err
+			}
+		}
+
+		plot.HerbID = ""
+		plot.Fertilizer = ""
+		plot.WateredBy = 0
+		plot.ProtectedBy = 0
+		return tx.Save(plot).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[Garden] 玩家 %d 收获%s x%d %v", s.userID, result.HerbName, result.Total, result.Qualities)
+	return result, nil
+}
+
+// Water 为道友的灵田浇灌，缩短剩余生长时间
+func (s *GardenService) Water(ownerID uint, plotIndex int) (*AssistResult, error) {
+	return s.assist(ownerID, plotIndex, func(plot *models.GardenPlot, now time.Time) error {
+		if plot.WateredBy != 0 {
+			return fmt.Errorf("这块灵田本轮已有道友浇灌")
+		}
+		remaining := plot.ReadyAt.Sub(now)
+		plot.ReadyAt = plot.ReadyAt.Add(-time.Duration(float64(remaining) * WaterSpeedup))
+		plot.WateredBy = s.userID
+		return nil
+	})
+}
+
+// Protect 为道友的灵田护法，提升收获品质与数量
+func (s *GardenService) Protect(ownerID uint, plotIndex int) (*AssistResult, error) {
+	return s.assist(ownerID, plotIndex, func(plot *models.GardenPlot, now time.Time) error {
+		if plot.ProtectedBy != 0 {
+			return fmt.Errorf("这块灵田本轮已有道友护法")
+		}
+		plot.ProtectedBy = s.userID
+		return nil
+	})
+}
+
+// assist 帮助道友药园的通用流程：校验、计次、修改灵田并发放灵石奖励
+// 游戏暂无好友关系，帮助对所有玩家开放，仅受每日次数与每块灵田每轮一次的限制
+func (s *GardenService) assist(ownerID uint, plotIndex int, apply func(plot *models.GardenPlot, now time.Time) error) (*AssistResult, error) {
+	if ownerID == s.userID || ownerID == 0 {
+		return nil, fmt.Errorf("只能帮助其他道友的药园")
+	}
+
+	key := assistDailyKey(s.userID)
+	used, err := redis.Client.Incr(redis.Ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count assists: %w", err)
+	}
+	redis.Client.Expire(redis.Ctx, key, 25*time.Hour)
+	if used > AssistDailyLimit {
+		redis.Client.Decr(redis.Ctx, key)
+		return nil, fmt.Errorf("今日帮助道友次数已用完")
+	}
+
+	result := &AssistResult{RewardStones: AssistRewardStones, AssistUsed: int(used)}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		plot, err := lockPlot(tx, ownerID, plotIndex)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if plot.HerbID == "" || !now.Before(plot.ReadyAt) {
+			return fmt.Errorf("这块灵田没有生长中的灵草")
+		}
+		if err := apply(plot, now); err != nil {
+			return err
+		}
+		if err := tx.Save(plot).Error; err != nil {
+			return err
+		}
+		result.Plot = *plot
+		return tx.Model(&models.User{}).Where("id = ?", s.userID).
+			Update("spirit_stones", gorm.Expr("spirit_stones + ?", AssistRewardStones)).Error
+	})
+	if err != nil {
+		// 未成功帮助时退还次数
+		redis.Client.Decr(redis.Ctx, key)
+		return nil, err
+	}
+	log.Printf("[Garden] 玩家 %d 帮助玩家 %d 的第 %d 块灵田", s.userID, ownerID, plotIndex+1)
+	return result, nil
+}
+
+// qualityBonus 灵田等级、灵肥与道友护法提供的品质加成
+func qualityBonus(plot *models.GardenPlot) float64 {
+	bonus := float64(plot.Level-1) * plotQualityBonusPerLevel
+	if fertilizer := GetFertilizerConfig(plot.Fertilizer); fertilizer != nil {
+		bonus += fertilizer.QualityBonus
+	}
+	if plot.ProtectedBy != 0 {
+		bonus += ProtectQualityBonus
+	}
+	return math.Min(maxQualityBonus, bonus)
+}
+
+// rollQuality 按加成把随机数推向高品质区间后判定品质
+func rollQuality(bonus float64) string {
+	r := rand.Float64()
+	return exploration.GetRandomQuality(r + (1-r)*bonus)
+}
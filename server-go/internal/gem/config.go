@@ -0,0 +1,61 @@
+package gem
+
+import (
+	"math"
+
+	"xiuxian/server-go/internal/models"
+)
+
+// 宝石参数
+const (
+	MaxTier           = 5   // 宝石最高阶数
+	CombineCount      = 3   // 合成一颗高一阶宝石所需的同阶宝石数量
+	MaxCombineTimes   = 100 // 单次批量合成的最大次数
+	RemoveCostPerTier = 200 // 拆卸宝石每阶消耗的灵石
+)
+
+// GemConfig 宝石配置
+type GemConfig struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Stat      string  `json:"stat"`      // 提供的属性，键与装备属性一致
+	BaseValue float64 `json:"baseValue"` // 一阶宝石的属性值
+}
+
+// GemConfigs 宝石种类配置
+var GemConfigs = map[string]GemConfig{
+	"ruby":      {ID: "ruby", Name: "赤炎石", Stat: "attack", BaseValue: 30},
+	"emerald":   {ID: "emerald", Name: "青木石", Stat: "health", BaseValue: 200},
+	"sapphire":  {ID: "sapphire", Name: "玄冰石", Stat: "defense", BaseValue: 20},
+	"topaz":     {ID: "topaz", Name: "疾风石", Stat: "speed", BaseValue: 15},
+	"amethyst":  {ID: "amethyst", Name: "紫电石", Stat: "critRate", BaseValue: 0.005},
+	"bloodjade": {ID: "bloodjade", Name: "血玉", Stat: "vampireRate", BaseValue: 0.005},
+}
+
+// tierMultipliers 各阶宝石相对一阶的属性倍率（下标为阶数-1），
+// 略低于 3 倍以保证合成有损耗但依然划算
+var tierMultipliers = []float64{1, 2.5, 6, 14, 30}
+
+// tierNames 阶数名称（下标为阶数-1）
+var tierNames = []string{"一阶", "二阶", "三阶", "四阶", "五阶"}
+
+// GemValue 计算指定宝石某一阶的属性值
+func GemValue(cfg GemConfig, tier int) float64 {
+	if tier < 1 || tier > MaxTier {
+		return 0
+	}
+	return math.Round(cfg.BaseValue*tierMultipliers[tier-1]*1000) / 1000
+}
+
+// GemName 带阶数的宝石名称
+func GemName(cfg GemConfig, tier int) string {
+	if tier < 1 || tier > MaxTier {
+		return cfg.Name
+	}
+	return tierNames[tier-1] + cfg.Name
+}
+
+// SocketCount 装备品质对应的宝石孔数
+func SocketCount(quality string) int {
+	return models.EquipmentQualityConfigs[quality].Sockets
+}
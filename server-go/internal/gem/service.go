@@ -0,0 +1,367 @@
+package gem
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/attributes"
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/inventory"
+	"xiuxian/server-go/internal/models"
+)
+
+// GemService 宝石服务
+type GemService struct {
+	userID uint
+}
+
+// NewGemService 创建宝石服务
+func NewGemService(userID uint) *GemService {
+	return &GemService{userID: userID}
+}
+
+// SocketResult 镶嵌/拆卸结果，装备已穿戴时 Attributes 为调整后的玩家属性
+type SocketResult struct {
+	Equipment  models.Equipment             `json:"equipment"`
+	Sockets    []models.SocketedGem         `json:"sockets"`
+	Attributes *attributes.AttributeManager `json:"-"`
+	Cost       int                          `json:"cost"`
+}
+
+// CombineResult 宝石合成结果
+type CombineResult struct {
+	Consumed int        `json:"consumed"` // 消耗的低阶宝石数量
+	Gem      models.Gem `json:"gem"`      // 合成后的高阶宝石堆叠
+	Produced int        `json:"produced"`
+}
+
+// ParseSockets 解析装备已镶嵌的宝石，按孔位排序
+func ParseSockets(eq *models.Equipment) []models.SocketedGem {
+	var sockets []models.SocketedGem
+	if len(eq.Gems) > 0 {
+		if err := json.Unmarshal(eq.Gems, &sockets); err != nil {
+			return []models.SocketedGem{}
+		}
+	}
+	if sockets == nil {
+		sockets = []models.SocketedGem{}
+	}
+	sort.Slice(sockets, func(i, j int) bool { return sockets[i].Socket < sockets[j].Socket })
+	return sockets
+}
+
+// EffectiveStats 装备的有效属性：基础属性加上已镶嵌宝石的属性
+func EffectiveStats(eq *models.Equipment) map[string]float64 {
+	stats := make(map[string]float64)
+	if len(eq.Stats) > 0 {
+		var base map[string]float64
+		if err := json.Unmarshal(eq.Stats, &base); err == nil {
+			for k, v := range base {
+				stats[k] = v
+			}
+		}
+	}
+	for _, g := range ParseSockets(eq) {
+		stats[g.Stat] += g.Value
+	}
+	return stats
+}
+
+// lockUser 在事务内锁定玩家行，串行化同一玩家的属性变更
+func lockUser(tx *gorm.DB, userID uint) (*models.User, error) {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("用户不存在")
+	}
+	return &user, nil
+}
+
+// applySocketChange 已穿戴装备按镶嵌前后的有效属性调整玩家属性，先移除灵宠加成，替换装备属性后再重新应用
+func applySocketChange(tx *gorm.DB, user *models.User, before, after map[string]float64) (*attributes.AttributeManager, error) {
+	attrMgr := attributes.FromUser(user)
+
+	var activePet models.Pet
+	hasActivePet := tx.Where("user_id = ? AND is_active = ?", user.ID, true).First(&activePet).Error == nil
+	if hasActivePet {
+		attrMgr.RemovePetBonuses(&activePet, attributes.ParseStats(activePet.CombatAttributes))
+	}
+	attrMgr.RemoveEquipmentStats(before)
+	attrMgr.ApplyEquipmentStats(after)
+	if hasActivePet {
+		attrMgr.ApplyPetBonuses(&activePet, attributes.ParseStats(activePet.CombatAttributes))
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(attrMgr.Updates()).Error; err != nil {
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+	return attrMgr, nil
+}
+
+// lockEquipment 在事务内锁定玩家的装备
+func lockEquipment(tx *gorm.DB, userID uint, equipmentID string) (*models.Equipment, error) {
+	var eq models.Equipment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND user_id = ?", equipmentID, userID).First(&eq).Error; err != nil {
+		return nil, fmt.Errorf("装备不存在")
+	}
+	return &eq, nil
+}
+
+// saveSockets 写回装备的镶嵌宝石
+func saveSockets(tx *gorm.DB, eq *models.Equipment, sockets []models.SocketedGem) error {
+	data, _ := json.Marshal(sockets)
+	eq.Gems = data
+	if err := tx.Model(eq).Update("gems", eq.Gems).Error; err != nil {
+		return fmt.Errorf("failed to update equipment: %w", err)
+	}
+	return nil
+}
+
+// spendSpiritStones 扣除灵石，不足时返回错误
+func spendSpiritStones(tx *gorm.DB, userID uint, cost int) error {
+	if cost <= 0 {
+		return nil
+	}
+	result := tx.Model(&models.User{}).
+		Where("id = ? AND spirit_stones >= ?", userID, cost).
+		Update("spirit_stones", gorm.Expr("spirit_stones - ?", cost))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("灵石不足，需要%d", cost)
+	}
+	return nil
+}
+
+// GetGems 获取玩家背包中的宝石
+func (s *GemService) GetGems() ([]models.Gem, error) {
+	var gems []models.Gem
+	if err := db.DB.Where("user_id = ?", s.userID).Order("tier DESC, gem_id").Find(&gems).Error; err != nil {
+		return nil, fmt.Errorf("failed to get gems: %w", err)
+	}
+	return gems, nil
+}
+
+// Insert 将背包中的一颗宝石镶嵌到装备的空孔位
+func (s *GemService) Insert(equipmentID string, socket int, gemStackID uint) (*SocketResult, error) {
+	var result *SocketResult
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, s.userID)
+		if err != nil {
+			return err
+		}
+		eq, err := lockEquipment(tx, s.userID, equipmentID)
+		if err != nil {
+			return err
+		}
+		if count := SocketCount(eq.Quality); socket < 0 || socket >= count {
+			return fmt.Errorf("孔位无效，该装备共有 %d 个孔位", count)
+		}
+		sockets := ParseSockets(eq)
+		for _, g := range sockets {
+			if g.Socket == socket {
+				return fmt.Errorf("该孔位已镶嵌%s，请先拆卸", g.Name)
+			}
+		}
+
+		var stack models.Gem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", gemStackID, s.userID).First(&stack).Error; err != nil {
+			return fmt.Errorf("宝石不存在")
+		}
+		cfg, ok := GemConfigs[stack.GemID]
+		if !ok {
+			return fmt.Errorf("未知宝石: %s", stack.GemID)
+		}
+		if err := inventory.TakeGems(tx, &stack, 1); err != nil {
+			return err
+		}
+
+		before := EffectiveStats(eq)
+		sockets = append(sockets, models.SocketedGem{
+			Socket: socket,
+			GemID:  cfg.ID,
+			Name:   GemName(cfg, stack.Tier),
+			Stat:   cfg.Stat,
+			Tier:   stack.Tier,
+			Value:  GemValue(cfg, stack.Tier),
+		})
+		if err := saveSockets(tx, eq, sockets); err != nil {
+			return err
+		}
+		result = &SocketResult{Equipment: *eq, Sockets: ParseSockets(eq)}
+		if eq.Equipped {
+			if result.Attributes, err = applySocketChange(tx, user, before, EffectiveStats(eq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Remove 拆卸装备孔位中的宝石，按宝石阶数消耗灵石，宝石完整退回背包
+func (s *GemService) Remove(equipmentID string, socket int) (*SocketResult, error) {
+	var result *SocketResult
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, s.userID)
+		if err != nil {
+			return err
+		}
+		eq, err := lockEquipment(tx, s.userID, equipmentID)
+		if err != nil {
+			return err
+		}
+		sockets := ParseSockets(eq)
+		idx := -1
+		for i, g := range sockets {
+			if g.Socket == socket {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return fmt.Errorf("该孔位未镶嵌宝石")
+		}
+		removed := sockets[idx]
+
+		cost := RemoveCostPerTier * removed.Tier
+		if err := spendSpiritStones(tx, s.userID, cost); err != nil {
+			return err
+		}
+		if err := inventory.AddGems(tx, &models.Gem{
+			UserID: s.userID,
+			GemID:  removed.GemID,
+			Name:   removed.Name,
+			Stat:   removed.Stat,
+			Tier:   removed.Tier,
+			Count:  1,
+		}); err != nil {
+			return err
+		}
+
+		before := EffectiveStats(eq)
+		sockets = append(sockets[:idx], sockets[idx+1:]...)
+		if err := saveSockets(tx, eq, sockets); err != nil {
+			return err
+		}
+		result = &SocketResult{Equipment: *eq, Sockets: ParseSockets(eq), Cost: cost}
+		if eq.Equipped {
+			if result.Attributes, err = applySocketChange(tx, user, before, EffectiveStats(eq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Combine 将 CombineCount 颗同阶宝石合成为一颗高一阶宝石，times 为合成次数
+func (s *GemService) Combine(gemID string, tier, times int) (*CombineResult, error) {
+	cfg, ok := GemConfigs[gemID]
+	if !ok {
+		return nil, fmt.Errorf("未知宝石: %s", gemID)
+	}
+	if tier < 1 || tier >= MaxTier {
+		return nil, fmt.Errorf("只能合成 1~%d 阶宝石", MaxTier-1)
+	}
+	if times <= 0 {
+		times = 1
+	}
+	if times > MaxCombineTimes {
+		return nil, fmt.Errorf("单次最多合成 %d 次", MaxCombineTimes)
+	}
+
+	var result *CombineResult
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var stack models.Gem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND gem_id = ? AND tier = ?", s.userID, gemID, tier).First(&stack).Error; err != nil {
+			return fmt.Errorf("没有%s", GemName(cfg, tier))
+		}
+		consumed := CombineCount * times
+		if err := inventory.TakeGems(tx, &stack, consumed); err != nil {
+			return err
+		}
+
+		upgraded := models.Gem{
+			UserID: s.userID,
+			GemID:  gemID,
+			Name:   GemName(cfg, tier+1),
+			Stat:   cfg.Stat,
+			Tier:   tier + 1,
+			Count:  times,
+		}
+		if err := inventory.AddGems(tx, &upgraded); err != nil {
+			return err
+		}
+		// 冲突更新时 Create 回填的仍是本次数量，重新读取堆叠
+		if err := tx.Where("user_id = ? AND gem_id = ? AND tier = ?", s.userID, gemID, tier+1).
+			First(&upgraded).Error; err != nil {
+			return fmt.Errorf("failed to get gem: %w", err)
+		}
+		result = &CombineResult{Consumed: consumed, Gem: upgraded, Produced: times}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GrantRandomGem 随机发放一种指定阶数的宝石，用于探索与历练掉落
+func GrantRandomGem(tx *gorm.DB, userID uint, tier, count int) (*models.Gem, error) {
+	if tier < 1 || tier > MaxTier {
+		return nil, fmt.Errorf("宝石阶数无效: %d", tier)
+	}
+	ids := make([]string, 0, len(GemConfigs))
+	for id := range GemConfigs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	cfg := GemConfigs[ids[rand.Intn(len(ids))]]
+
+	gem := &models.Gem{
+		UserID: userID,
+		GemID:  cfg.ID,
+		Name:   GemName(cfg, tier),
+		Stat:   cfg.Stat,
+		Tier:   tier,
+		Count:  count,
+	}
+	if err := inventory.AddGems(tx, gem); err != nil {
+		return nil, err
+	}
+	return gem, nil
+}
+
+// ReturnSocketedGems 将装备上已镶嵌的宝石退回背包，用于出售装备
+func ReturnSocketedGems(tx *gorm.DB, userID uint, equipment ...models.Equipment) error {
+	for i := range equipment {
+		for _, g := range ParseSockets(&equipment[i]) {
+			if err := inventory.AddGems(tx, &models.Gem{
+				UserID: userID,
+				GemID:  g.GemID,
+				Name:   g.Name,
+				Stat:   g.Stat,
+				Tier:   g.Tier,
+				Count:  1,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ type (
 	BuyFragmentResult    = alchemySvc.BuyFragmentResult
 	AllRecipesResponse   = alchemySvc.AllRecipesResponse
 	RecipeDetailResponse = alchemySvc.RecipeDetailResponse
+	ExperimentRequest    = alchemySvc.ExperimentRequest
 )
 
 // GetAllRecipes 获取所有丹方列表
@@ -156,38 +157,29 @@ func CraftPill(c *gin.Context) {
 		return
 	}
 
+	// 幂等键优先取请求体，其次取 Idempotency-Key 请求头
+	idempotencyKey := req.IdempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = c.GetHeader("Idempotency-Key")
+	}
+
+	// 一键炼制最大数量时交由服务端根据灵草计算次数
+	quantity := req.Quantity
+	if req.Max {
+		quantity = 0
+	} else if quantity <= 0 {
+		quantity = 1
+	}
+
 	zapLogger.Info("CraftPill 入参",
 		zap.Uint("userID", uid),
 		zap.String("recipeID", req.RecipeID),
-		zap.Int("playerLevel", req.PlayerLevel))
+		zap.Int("quantity", quantity),
+		zap.Bool("max", req.Max),
+		zap.String("idempotencyKey", idempotencyKey))
 
 	service := alchemySvc.NewAlchemyService(uid)
-
-	// 从数据库获取用户真实的炼丹数据
-	userAlchemyData, err := service.GetUserAlchemyData()
-	if err != nil {
-		zapLogger.Error("获取用户炼丹数据失败",
-			zap.Uint("userID", uid),
-			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取用户数据失败", "error": err.Error()})
-		return
-	}
-
-	// 默认值处理
-	playerLevel := req.PlayerLevel
-	if playerLevel <= 0 {
-		playerLevel = 1
-	}
-	luck := req.Luck
-	if luck <= 0 {
-		luck = 1.0
-	}
-	alchemyRate := req.AlchemyRate
-	if alchemyRate <= 0 {
-		alchemyRate = 1.0
-	}
-
-	result, err := service.CraftPill(req.RecipeID, playerLevel, userAlchemyData.RecipesUnlocked, req.InventoryHerbs, luck, alchemyRate)
+	result, err := service.CraftPill(req.RecipeID, quantity, req.HerbSelection, idempotencyKey)
 	if err != nil {
 		zapLogger.Error("炼制丹药失败",
 			zap.Uint("userID", uid),
@@ -197,17 +189,12 @@ func CraftPill(c *gin.Context) {
 		return
 	}
 
-	// 更新用户炼丹统计数据
-	if result.Success {
-		userAlchemyData.PillsCrafted++
-		// 这里应该更新数据库中的统计数据
-		// 暂时省略具体实现
-	}
-
 	zapLogger.Info("CraftPill 出参",
 		zap.Uint("userID", uid),
 		zap.String("recipeID", req.RecipeID),
-		zap.Bool("success", result.Success))
+		zap.Int("successCount", result.SuccessCount),
+		zap.Int("failCount", result.FailCount),
+		zap.String("pillQuality", result.PillQuality))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": result.Success,
@@ -275,6 +262,72 @@ func BuyFragment(c *gin.Context) {
 	})
 }
 
+// Experiment 丹方试炼：自由配伍灵草尝试悟出未掌握的丹方
+// POST /api/alchemy/experiment
+func Experiment(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	var req ExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	zapLogger.Info("Experiment 入参",
+		zap.Uint("userID", uid),
+		zap.Any("herbs", req.Herbs))
+
+	result, err := alchemySvc.NewAlchemyService(uid).Experiment(req.Herbs)
+	if err != nil {
+		zapLogger.Error("丹方试炼失败",
+			zap.Uint("userID", uid),
+			zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("Experiment 出参",
+		zap.Uint("userID", uid),
+		zap.String("outcome", result.Outcome),
+		zap.String("recipeID", result.RecipeID),
+		zap.Bool("firstDiscovery", result.FirstDiscovery))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+		"message": result.Message,
+	})
+}
+
+// GetDiscoveries 获取丹方提示、试炼记录与全服首次悟出公告
+// GET /api/alchemy/discoveries
+func GetDiscoveries(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	overview, err := alchemySvc.NewAlchemyService(userID.(uint)).GetDiscoveryOverview()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    overview,
+	})
+}
+
 // GetConfigs 获取炼丹系统配置 (品阶、类型、灵草等)
 // GET /api/alchemy/configs
 func GetConfigs(c *gin.Context) {
@@ -291,13 +344,23 @@ func GetConfigs(c *gin.Context) {
 	zapLogger.Info("GetConfigs 入参",
 		zap.Uint("userID", uid))
 
+	// 未悟出丹方的材料不下发，避免绕过试炼直接得知配伍
+	recipes, err := alchemySvc.NewAlchemyService(uid).GetRecipeConfigs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data": gin.H{
 			"grades":  alchemySvc.GetAllGrades(),
 			"types":   alchemySvc.GetAllTypes(),
-			"recipes": alchemySvc.GetAllRecipeConfigs(),
+			"recipes": recipes,
 			"herbs":   alchemySvc.GetAllHerbs(),
+			// 灵草品质与丹药品质
+			"herbQualities": alchemySvc.GetAllHerbQualities(),
+			"pillQualities": alchemySvc.GetAllPillQualities(),
 		},
 		"message": "获取配置成功",
 	})
@@ -0,0 +1,27 @@
+package announcement
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	announcementSvc "xiuxian/server-go/internal/announcement"
+)
+
+// GetRecent 获取最近的全服公告
+// GET /api/announcements?limit=20
+func GetRecent(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	announcements, err := announcementSvc.GetRecent(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    announcements,
+	})
+}
@@ -2,7 +2,6 @@ package auth
 
 import (
 	"encoding/json"
-	"math"
 	"net/http"
 	"os"
 	"strconv"
@@ -14,7 +13,12 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/datatypes"
 
+	"xiuxian/server-go/internal/alchemy"
+	"xiuxian/server-go/internal/attributes"
+	cultivationSvc "xiuxian/server-go/internal/cultivation"
 	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/gacha"
+	"xiuxian/server-go/internal/gem"
 	playerHandler "xiuxian/server-go/internal/http/handlers/player"
 	"xiuxian/server-go/internal/models"
 	"xiuxian/server-go/internal/redis"
@@ -185,6 +189,14 @@ func Login(c *gin.Context) {
 		// 不中断登录流程，继续处理
 	}
 
+	// 结算离线期间的挂机修为
+	idleSummary, err := cultivationSvc.NewCultivationService(user.ID).SettleOfflineCultivation()
+	if err != nil {
+		zapLogger.Warn("[登录] 结算挂机修为失败",
+			zap.Uint("userID", user.ID),
+			zap.Error(err))
+	}
+
 	token, err := generateToken(user.ID)
 	if err != nil {
 		zapLogger.Error("[登录] 生成令牌失败",
@@ -198,9 +210,10 @@ func Login(c *gin.Context) {
 		zap.Uint("userID", user.ID),
 		zap.String("username", user.Username))
 	c.JSON(http.StatusOK, gin.H{
-		"id":       user.ID,
-		"username": user.Username,
-		"token":    token,
+		"id":          user.ID,
+		"username":    user.Username,
+		"token":       token,
+		"idleSummary": idleSummary, // 离线挂机收益
 	})
 }
 
@@ -230,6 +243,9 @@ func Logout(c *gin.Context) {
 		// 不中断流程，只记录日志
 	}
 
+	// 从登出时刻开始累计离线挂机修为
+	cultivationSvc.MarkIdleOffline(userID, time.Now())
+
 	zapLogger.Info("[登出] 用户登出成功",
 		zap.Uint("userID", userID))
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已登出"})
@@ -284,6 +300,13 @@ func GetUser(c *gin.Context) {
 func initializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger) error {
 	userID := user.ID
 
+	// 境界表调整后同步修为上限
+	if err := cultivationSvc.SyncRealmCap(user); err != nil {
+		zapLogger.Warn("[登录初始化] 同步修为上限失败",
+			zap.Uint("userID", userID),
+			zap.Error(err))
+	}
+
 	// 步骤1：卸下所有装备
 	if err := unequipAllEquipment(userID, zapLogger); err != nil {
 		zapLogger.Error("[登录初始化] 卸下装备失败",
@@ -301,14 +324,22 @@ func initializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 	}
 
 	// 步骤3：计算基础属性
-	baseAttrs := calculateBaseAttributes(user.Level)
-	spiritRate := calculateSpiritRate(user.Level)
+	baseAttrs := cultivationSvc.CalculateBaseAttributesByLevel(user.Level)
+	spiritRate := cultivationSvc.CalculateSpiritRateByLevel(user.Level)
 	cultivationRate := 1.0 // 默认修炼倍率
 
 	// 步骤4：更新BaseAttributes
 	baseAttrs["spiritRate"] = spiritRate
 	baseAttrs["cultivationRate"] = cultivationRate
 
+	// 丹毒与渡劫保底记录在基础属性中，重建时需保留
+	var oldBaseAttrs map[string]interface{}
+	if len(user.BaseAttributes) > 0 {
+		_ = json.Unmarshal(user.BaseAttributes, &oldBaseAttrs)
+	}
+	alchemy.CopyToxicity(baseAttrs, oldBaseAttrs)
+	cultivationSvc.CopyTribulationState(baseAttrs, oldBaseAttrs)
+
 	attrJSON, err := json.Marshal(baseAttrs)
 	if err != nil {
 		return err
@@ -317,6 +348,7 @@ func initializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 
 	// 步骤5：重新穿戴装备（获取玩家已有的装备）
 	var equipments []models.Equipment
+	var reequipped []models.Equipment
 	if err := db.DB.Where("user_id = ?", userID).Find(&equipments).Error; err == nil {
 		for _, equipment := range equipments {
 			// ✅ 只重新穿戴有槽位信息的装备（这说明之前是已装备的）
@@ -326,7 +358,9 @@ func initializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 						zap.Uint("userID", userID),
 						zap.String("equipmentID", equipment.ID),
 						zap.Error(err))
+					continue
 				}
+				reequipped = append(reequipped, equipment)
 			}
 		}
 	}
@@ -334,7 +368,16 @@ func initializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 	// 步骤6：重新出战灵宠（获取玩家已有的灵宠）
 	// ✅ 修改：重新激活灵宠
 	var pets []models.Pet
-	if err := db.DB.Where("user_id = ? AND is_active = ?", userID, true).Find(&pets).Error; err == nil {
+	petsErr := db.DB.Where("user_id = ? AND is_active = ?", userID, true).Find(&pets).Error
+
+	// 出战灵宠同时计入套装件数
+	var setPet *models.Pet
+	if petsErr == nil && len(pets) > 0 {
+		setPet = &pets[0]
+	}
+	applySetBonusAfterLogin(user, reequipped, setPet)
+
+	if petsErr == nil {
 		for _, pet := range pets {
 			if err := reactivatePetAfterLogin(user, &pet, zapLogger); err != nil {
 				zapLogger.Warn("[登录初始化] 重新出战灵宠失败",
@@ -345,6 +388,9 @@ func initializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 		}
 	}
 
+	// 运转功法加成
+	applyTechniquesAfterLogin(user)
+
 	// 步骤7：保存更新后的用户属性到数据库
 	if err := db.DB.Model(user).Updates(map[string]interface{}{
 		"base_attributes":    user.BaseAttributes,
@@ -364,34 +410,6 @@ func initializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 	return nil
 }
 
-// ✅ 新增：calculateSpiritRate 计算灵力倍率
-// 公式：spiritRate = 1.0 * (1.2)^(Level-1)
-// 斗法消耗灵力公式 duelCost = 1440×1.2^(Level−1)
-// 探索消耗灵力公式 exploreCost = 288×1.2^(Level−1)
-func calculateSpiritRate(level int) float64 {
-	if level < 1 {
-		level = 1
-	}
-	spiritRate := 1.0 * math.Pow(1.2, float64(level-1))
-	// 保留两位小数
-	return math.Round(spiritRate*100) / 100
-}
-
-// ✅ 新增：calculateBaseAttributes 计算基础属性
-// 基于等级的公式：
-// speed = 10 * Level
-// attack = 10 * Level
-// health = 100 * Level
-// defense = 5 * Level
-func calculateBaseAttributes(level int) map[string]interface{} {
-	return map[string]interface{}{
-		"speed":   float64(10 * level),
-		"attack":  float64(10 * level),
-		"health":  float64(100 * level),
-		"defense": float64(5 * level),
-	}
-}
-
 // ✅ 新增：unequipAllEquipment 卸下玩家的所有装备
 func unequipAllEquipment(userID uint, zapLogger *zap.Logger) error {
 	var equipments []models.Equipment
@@ -451,10 +469,10 @@ func reequipEquipmentAfterLogin(user *models.User, equipment *models.Equipment,
 	specialAttrs := jsonToFloatMap(user.SpecialAttributes)
 
 	// 解析装备属性
-	equipStats := jsonToFloatMap(equipment.Stats)
+	equipStats := gem.EffectiveStats(equipment)
 
 	// 使用属性管理器应用装备加成
-	attrMgr := playerHandler.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 	attrMgr.ApplyEquipmentStats(equipStats)
 
 	// 更新用户属性
@@ -475,6 +493,42 @@ func reequipEquipmentAfterLogin(user *models.User, equipment *models.Equipment,
 	return nil
 }
 
+// applySetBonusAfterLogin 登录后按重新穿戴的装备与出战灵宠应用套装加成
+func applySetBonusAfterLogin(user *models.User, equipped []models.Equipment, pet *models.Pet) {
+	setStats := gacha.SetBonusStats(equipped, pet)
+	if len(setStats) == 0 {
+		return
+	}
+	attrMgr := attributes.NewAttributeManager(
+		jsonToFloatMap(user.BaseAttributes),
+		jsonToFloatMap(user.CombatAttributes),
+		jsonToFloatMap(user.CombatResistance),
+		jsonToFloatMap(user.SpecialAttributes),
+	)
+	attrMgr.ApplyEquipmentStats(setStats)
+
+	user.BaseAttributes = toJSONInterface(attrMgr.BaseAttrs)
+	user.CombatAttributes = toJSONInterface(attrMgr.CombatAttrs)
+	user.CombatResistance = toJSONInterface(attrMgr.CombatRes)
+	user.SpecialAttributes = toJSONInterface(attrMgr.SpecialAttrs)
+}
+
+// applyTechniquesAfterLogin 登录后重新应用运转功法的属性加成
+func applyTechniquesAfterLogin(user *models.User) {
+	attrMgr := attributes.NewAttributeManager(
+		jsonToFloatMap(user.BaseAttributes),
+		jsonToFloatMap(user.CombatAttributes),
+		jsonToFloatMap(user.CombatResistance),
+		jsonToFloatMap(user.SpecialAttributes),
+	)
+	attrMgr.ApplyEquipmentStats(cultivationSvc.GetTechniqueBonus(user.ID, user.Level).Stats)
+
+	user.BaseAttributes = toJSONInterface(attrMgr.BaseAttrs)
+	user.CombatAttributes = toJSONInterface(attrMgr.CombatAttrs)
+	user.CombatResistance = toJSONInterface(attrMgr.CombatRes)
+	user.SpecialAttributes = toJSONInterface(attrMgr.SpecialAttrs)
+}
+
 // ✅ 新增：reactivatePetAfterLogin 登录后重新出战灵宠
 // 需要重新计算灵宠属性加成
 func reactivatePetAfterLogin(user *models.User, pet *models.Pet, zapLogger *zap.Logger) error {
@@ -488,7 +542,7 @@ func reactivatePetAfterLogin(user *models.User, pet *models.Pet, zapLogger *zap.
 	petCombat := jsonToFloatMap(pet.CombatAttributes)
 
 	// 使用属性管理器应用灵宠加成
-	attrMgr := playerHandler.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 	attrMgr.ApplyPetBonuses(pet, petCombat)
 
 	// 更新用户属性
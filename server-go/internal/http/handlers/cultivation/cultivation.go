@@ -1,9 +1,11 @@
 package cultivation
 
 import (
+	"errors"
 	"net/http"
 
 	cultivationSvc "xiuxian/server-go/internal/cultivation"
+	formationSvc "xiuxian/server-go/internal/formation"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -157,3 +159,655 @@ func BreakthroughJieYing(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
+
+// GetTribulation 获取当前渡劫信息
+// GET /api/cultivation/tribulation
+func GetTribulation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	service := cultivationSvc.NewCultivationService(uid)
+
+	status, err := service.GetTribulationStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取渡劫信息失败", "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "tribulation": status})
+}
+
+// AttemptTribulation 渡劫突破大境界
+// POST /api/cultivation/tribulation
+func AttemptTribulation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("AttemptTribulation 入参",
+		zap.Uint("userID", uid))
+
+	service := cultivationSvc.NewCultivationService(uid)
+
+	result, err := service.AttemptTribulation()
+	if err != nil {
+		zapLogger.Error("attempt tribulation failed",
+			zap.Uint("userID", uid),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "渡劫失败", "error": err.Error()})
+		return
+	}
+
+	zapLogger.Info("AttemptTribulation 出参",
+		zap.Uint("userID", uid),
+		zap.Any("result", result))
+
+	c.JSON(http.StatusOK, result)
+}
+
+// StartTribulationBattle 开始天劫之战
+// POST /api/cultivation/tribulation/battle/start
+func StartTribulationBattle(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	service := cultivationSvc.NewCultivationService(uid)
+
+	battle, err := service.StartTribulationBattle()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "battle": battle})
+}
+
+// UseTribulationAid 雷劫间隙使用丹药或符箓
+// POST /api/cultivation/tribulation/battle/aid
+func UseTribulationAid(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		AidID string `json:"aidId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	service := cultivationSvc.NewCultivationService(uid)
+
+	battle, err := service.UseTribulationAid(req.AidID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "battle": battle})
+}
+
+// FightTribulationWave 抵挡下一波雷劫
+// POST /api/cultivation/tribulation/battle/wave
+func FightTribulationWave(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("FightTribulationWave 入参",
+		zap.Uint("userID", uid))
+
+	service := cultivationSvc.NewCultivationService(uid)
+
+	result, err := service.FightTribulationWave()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("FightTribulationWave 出参",
+		zap.Uint("userID", uid),
+		zap.Int("wave", result.Wave),
+		zap.Bool("victory", result.Victory),
+		zap.Any("result", result.Result))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": result})
+}
+
+// EndTribulationBattle 提前结束天劫之战并结算
+// POST /api/cultivation/tribulation/battle/end
+func EndTribulationBattle(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	service := cultivationSvc.NewCultivationService(uid)
+
+	result, err := service.EndTribulationBattle()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetIdleCultivation 预览挂机修为收益
+// GET /api/cultivation/idle
+func GetIdleCultivation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	service := cultivationSvc.NewCultivationService(uid)
+
+	summary, err := service.PreviewIdleCultivation()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "获取挂机收益失败", "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    summary,
+	})
+}
+
+// ClaimIdleCultivation 领取挂机修为
+// POST /api/cultivation/idle/claim
+func ClaimIdleCultivation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("ClaimIdleCultivation 入参",
+		zap.Uint("userID", uid))
+
+	service := cultivationSvc.NewCultivationService(uid)
+
+	summary, err := service.ClaimIdleCultivation()
+	if errors.Is(err, cultivationSvc.ErrIdleWhileOnline) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	if err != nil {
+		zapLogger.Error("claim idle cultivation failed",
+			zap.Uint("userID", uid),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "领取挂机收益失败", "error": err.Error()})
+		return
+	}
+
+	zapLogger.Info("ClaimIdleCultivation 出参",
+		zap.Uint("userID", uid),
+		zap.Int64("offlineSeconds", summary.OfflineSeconds),
+		zap.Float64("cultivationGain", summary.CultivationGain))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    summary,
+	})
+}
+
+// GetTechniques 获取已习得的功法与当前加成
+// GET /api/cultivation/techniques
+func GetTechniques(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	service := cultivationSvc.NewCultivationService(uid)
+
+	techniques, bonus, err := service.GetTechniques()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "获取功法失败", "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"techniques":  techniques,
+		"bonus":       bonus,
+		"maxAuxSlots": cultivationSvc.MaxAuxTechniques,
+	})
+}
+
+// GetTechniqueShop 获取坊市出售的功法
+// GET /api/cultivation/techniques/shop
+func GetTechniqueShop(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "techniques": cultivationSvc.GetTechniqueShop()})
+}
+
+// BuyTechnique 在坊市购买功法
+// POST /api/cultivation/techniques/buy
+func BuyTechnique(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		TechniqueID string `json:"techniqueId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("BuyTechnique 入参",
+		zap.Uint("userID", uid),
+		zap.String("techniqueId", req.TechniqueID))
+
+	result, err := cultivationSvc.NewCultivationService(uid).BuyTechnique(req.TechniqueID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("BuyTechnique 出参",
+		zap.Uint("userID", uid),
+		zap.Any("result", result))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": result, "message": result.Message})
+}
+
+// EquipTechnique 运转功法
+// POST /api/cultivation/techniques/equip
+func EquipTechnique(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		TechniqueID string `json:"techniqueId" binding:"required"`
+		Slot        string `json:"slot" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	if err := cultivationSvc.NewCultivationService(uid).EquipTechnique(req.TechniqueID, req.Slot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "功法运转成功"})
+}
+
+// UnequipTechnique 停止运转功法
+// POST /api/cultivation/techniques/unequip
+func UnequipTechnique(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		TechniqueID string `json:"techniqueId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	if err := cultivationSvc.NewCultivationService(uid).UnequipTechnique(req.TechniqueID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "已停止运转功法"})
+}
+
+// GetRetreat 获取闭关状态与可选时长
+// GET /api/cultivation/retreat
+func GetRetreat(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	service := cultivationSvc.NewCultivationService(uid)
+
+	status, err := service.GetRetreatStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "获取闭关状态失败", "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": status})
+}
+
+// StartRetreat 开始闭关
+// POST /api/cultivation/retreat/start
+func StartRetreat(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		OptionID string `json:"optionId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("StartRetreat 入参",
+		zap.Uint("userID", uid),
+		zap.String("optionId", req.OptionID))
+
+	session, err := cultivationSvc.NewCultivationService(uid).StartRetreat(req.OptionID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("StartRetreat 出参",
+		zap.Uint("userID", uid),
+		zap.Any("session", session))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "session": session})
+}
+
+// ClaimRetreat 出关领取修为（未满期出关将损失部分修为）
+// POST /api/cultivation/retreat/claim
+func ClaimRetreat(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("ClaimRetreat 入参",
+		zap.Uint("userID", uid))
+
+	result, err := cultivationSvc.NewCultivationService(uid).ClaimRetreat()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("ClaimRetreat 出参",
+		zap.Uint("userID", uid),
+		zap.Any("result", result))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": result, "message": result.Message})
+}
+
+// GetFormations 获取阵法列表与生效状态
+// GET /api/cultivation/formations
+func GetFormations(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	overview, err := formationSvc.NewFormationService(uid).GetFormations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "获取阵法失败", "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": overview})
+}
+
+// UpgradeFormation 提升阵法等级
+// POST /api/cultivation/formations/upgrade
+func UpgradeFormation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		FormationID string `json:"formationId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("UpgradeFormation 入参",
+		zap.Uint("userID", uid),
+		zap.String("formationId", req.FormationID))
+
+	record, cost, err := formationSvc.NewFormationService(uid).UpgradeFormation(req.FormationID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("UpgradeFormation 出参",
+		zap.Uint("userID", uid),
+		zap.Int("level", record.Level),
+		zap.Int("stoneCost", cost))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "level": record.Level, "stoneCost": cost})
+}
+
+// ActivateFormation 布下阵法
+// POST /api/cultivation/formations/activate
+func ActivateFormation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		FormationID string `json:"formationId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("ActivateFormation 入参",
+		zap.Uint("userID", uid),
+		zap.String("formationId", req.FormationID))
+
+	active, cost, err := formationSvc.NewFormationService(uid).ActivateFormation(req.FormationID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("ActivateFormation 出参",
+		zap.Uint("userID", uid),
+		zap.Any("formation", active),
+		zap.Int("stoneCost", cost))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "formation": active, "stoneCost": cost})
+}
+
+// GetDualCultivation 获取双修状态、待处理邀请与羁绊
+// GET /api/cultivation/dual
+func GetDualCultivation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	status, err := cultivationSvc.NewCultivationService(uid).GetDualStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "获取双修状态失败", "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": status})
+}
+
+// InviteDualCultivation 邀请在线玩家双修
+// POST /api/cultivation/dual/invite
+func InviteDualCultivation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		TargetID uint `json:"targetId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("InviteDualCultivation 入参",
+		zap.Uint("userID", uid),
+		zap.Uint("targetId", req.TargetID))
+
+	if err := cultivationSvc.NewCultivationService(uid).InviteDualCultivation(req.TargetID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "双修邀请已发出"})
+}
+
+// AcceptDualCultivation 接受双修邀请
+// POST /api/cultivation/dual/accept
+func AcceptDualCultivation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	var req struct {
+		InviterID uint `json:"inviterId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("AcceptDualCultivation 入参",
+		zap.Uint("userID", uid),
+		zap.Uint("inviterId", req.InviterID))
+
+	session, err := cultivationSvc.NewCultivationService(uid).AcceptDualCultivation(req.InviterID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("AcceptDualCultivation 出参",
+		zap.Uint("userID", uid),
+		zap.Any("session", session))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "session": session})
+}
+
+// ClaimDualCultivation 双修期满结算（双方同时获得修为）
+// POST /api/cultivation/dual/claim
+func ClaimDualCultivation(c *gin.Context) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	uid := userID.(uint)
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+
+	zapLogger.Info("ClaimDualCultivation 入参",
+		zap.Uint("userID", uid))
+
+	result, err := cultivationSvc.NewCultivationService(uid).ClaimDualCultivation()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("ClaimDualCultivation 出参",
+		zap.Uint("userID", uid),
+		zap.Any("result", result))
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": result, "message": result.Message})
+}
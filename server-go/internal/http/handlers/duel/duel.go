@@ -1,6 +1,7 @@
 package duel
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -8,12 +9,15 @@ import (
 	"strconv"
 	"time"
 
+	"xiuxian/server-go/internal/cultivation"
 	"xiuxian/server-go/internal/db"
 	"xiuxian/server-go/internal/duel"
 	"xiuxian/server-go/internal/models"
 	"xiuxian/server-go/internal/redis"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // 中国时区 (UTC+8)
@@ -435,6 +439,10 @@ func StartPvPBattle(c *gin.Context) {
 	userID := userIDInterface.(uint)
 	userIDInt64 := int64(userID)
 
+	if rejectInRetreat(c, userID) {
+		return
+	}
+
 	// 检查每日斗法次数限制
 	if err, remaining := checkDailyDuelLimit(userIDInt64); err != nil {
 		c.JSON(http.StatusTooManyRequests, gin.H{
@@ -616,6 +624,18 @@ func EndPvPBattle(c *gin.Context) {
 
 // ========== 斗法次数限制辅助函数 ==========
 
+// rejectInRetreat 闭关期间禁止斗法，已拒绝时返回 true
+func rejectInRetreat(c *gin.Context, userID uint) bool {
+	if !cultivation.IsInRetreat(userID) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"success": false,
+		"message": "道友正在闭关，出关后方可斗法",
+	})
+	return true
+}
+
 // checkDailyDuelLimit 检查每日斗法次数限制
 // 返回 (error, remaining) - 如果error为nil表示通过检查，remaining为剩余次数
 func checkDailyDuelLimit(userID int64) (error, int) {
@@ -834,25 +854,22 @@ func checkPvESpirit(user *models.User) (bool, float64, string) {
 	return true, pveCost, ""
 }
 
-// deductPvESpirit 扣除玩家PvE战斗灵力
+// errPvESpiritNotEnough 扣除PvE灵力时余额不足
+var errPvESpiritNotEnough = errors.New("灵力不足")
+
+// deductPvESpirit 扣除玩家PvE战斗灵力，余额不足时不扣除并返回 errPvESpiritNotEnough
 // 返回 (更新后的灵力, 错误)
 func deductPvESpirit(userID int64, pveCost float64) (float64, error) {
 	var user models.User
-	if err := db.DB.First(&user, userID).Error; err != nil {
-		return 0, fmt.Errorf("获取玩家信息失败: %w", err)
-	}
-
-	// 扣除灵力
-	user.Spirit -= pveCost
-
-	// 确保灵力不为负
-	if user.Spirit < 0 {
-		user.Spirit = 0
+	result := db.DB.Model(&user).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "spirit"}}}).
+		Where("id = ? AND spirit >= ?", userID, pveCost).
+		Update("spirit", gorm.Expr("spirit - ?", pveCost))
+	if result.Error != nil {
+		return 0, fmt.Errorf("扣除灵力失败: %w", result.Error)
 	}
-
-	// 保存到数据库
-	if err := db.DB.Model(&user).Update("spirit", user.Spirit).Error; err != nil {
-		return 0, fmt.Errorf("扣除灵力失败: %w", err)
+	if result.RowsAffected == 0 {
+		return 0, errPvESpiritNotEnough
 	}
 
 	log.Printf("[PvE] 玩家 %d 消耗灵力 %.0f，剩余灵力: %.0f",
@@ -861,6 +878,14 @@ func deductPvESpirit(userID int64, pveCost float64) (float64, error) {
 	return user.Spirit, nil
 }
 
+// refundPvESpirit 退还已扣除的PvE灵力
+func refundPvESpirit(userID int64, pveCost float64) {
+	if err := db.DB.Model(&models.User{}).Where("id = ?", userID).
+		Update("spirit", gorm.Expr("spirit + ?", pveCost)).Error; err != nil {
+		log.Printf("[PvE] 玩家 %d 退还灵力 %.0f 失败: %v", userID, pveCost, err)
+	}
+}
+
 // ========== PvE 操作 API ==========
 
 // StartPvEBattle 开始 PvE 战斗
@@ -878,6 +903,10 @@ func StartPvEBattle(c *gin.Context) {
 	userID := userIDInterface.(uint)
 	userIDInt64 := int64(userID)
 
+	if rejectInRetreat(c, userID) {
+		return
+	}
+
 	var req struct {
 		MonsterID   int         `json:"monsterId" binding:"required"`
 		PlayerData  interface{} `json:"playerData" binding:"required"`
@@ -947,6 +976,9 @@ func StartPvEBattle(c *gin.Context) {
 
 	// 创建 PvE 战斗服务
 	battleService := duel.NewPvEBattleService(userIDInt64, req.MonsterID, difficulty)
+	if monster != nil && monster.BossScript != nil {
+		battleService.SetBossScript(monster.BossScript)
+	}
 
 	// 开始战斗
 	roundData, err := battleService.StartPvEBattle(req.PlayerData, req.MonsterData)
@@ -1074,3 +1106,239 @@ func EndPvEBattle(c *gin.Context) {
 		"message": "战斗已结束",
 	})
 }
+
+// pveDailyKey 今日PvE挑战次数键与每日上限（101+为除魔卫道20次，其他为降服妖兽100次）
+func pveDailyKey(userID int64, monsterID int) (string, int) {
+	keyPrefix, maxDaily := "pve:daily:", 100
+	if monsterID >= 101 {
+		keyPrefix, maxDaily = "demon-slaying:daily:", 20
+	}
+	return keyPrefix + getTodayInChina() + ":" + strconv.FormatInt(userID, 10), maxDaily
+}
+
+// reservePvECount 原子预占今日PvE挑战次数，超过上限时撤销预占并返回错误
+// 返回预占后的剩余次数
+func reservePvECount(userID int64, monsterID, count int) (int, error) {
+	key, maxDaily := pveDailyKey(userID, monsterID)
+
+	newCount, err := redis.Client.IncrBy(redis.Ctx, key, int64(count)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("更新挑战次数失败: %w", err)
+	}
+	redis.Client.Expire(redis.Ctx, key, getTimeUntilMidnight())
+	if newCount > int64(maxDaily) {
+		redis.Client.DecrBy(redis.Ctx, key, int64(count))
+		remaining := maxDaily - int(newCount) + count
+		if remaining < 0 {
+			remaining = 0
+		}
+		return remaining, fmt.Errorf("今日剩余挑战次数不足，剩余: %d", remaining)
+	}
+	return maxDaily - int(newCount), nil
+}
+
+// releasePvECount 撤销预占的PvE挑战次数
+func releasePvECount(userID int64, monsterID, count int) {
+	key, _ := pveDailyKey(userID, monsterID)
+	redis.Client.DecrBy(redis.Ctx, key, int64(count))
+}
+
+// SweepPvE 扫荡已通关的妖兽
+// 对应 POST /api/duel/sweep-pve
+func SweepPvE(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	userID := userIDInterface.(uint)
+	userIDInt64 := int64(userID)
+
+	if rejectInRetreat(c, userID) {
+		return
+	}
+
+	var req struct {
+		MonsterID int `json:"monsterId" binding:"required"`
+		Count     int `json:"count" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if req.Count <= 0 || req.Count > duel.MaxSweepCount {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("扫荡次数需在1-%d之间", duel.MaxSweepCount),
+		})
+		return
+	}
+
+	monster := GetMonsterByID(req.MonsterID)
+	if monster == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "妖兽不存在",
+		})
+		return
+	}
+
+	battleService := duel.NewPvEBattleService(userIDInt64, req.MonsterID, monster.Difficulty)
+
+	// 检查通关星级
+	if _, err := battleService.CheckSweepable(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取玩家信息失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// 原子预占挑战次数，后续失败时撤销
+	remaining, err := reservePvECount(userIDInt64, req.MonsterID, req.Count)
+	if err != nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":   false,
+			"message":   err.Error(),
+			"remaining": remaining,
+		})
+		return
+	}
+
+	// 按扫荡场数扣除灵力，余额不足时不扣除
+	totalCost := calculatePvESpiritCost(user.Level) * float64(req.Count)
+	newSpirit, err := deductPvESpirit(userIDInt64, totalCost)
+	if err != nil {
+		releasePvECount(userIDInt64, req.MonsterID, req.Count)
+		if errors.Is(err, errPvESpiritNotEnough) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("灵力不足！扫荡消耗: %.0f", totalCost),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "扣除灵力失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	rewardItems, err := battleService.Sweep(req.Count)
+	if err != nil {
+		refundPvESpirit(userIDInt64, totalCost)
+		releasePvECount(userIDInt64, req.MonsterID, req.Count)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "扫荡失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("扫荡%s %d次完成", monster.Name, req.Count),
+		"data": gin.H{
+			"count":     req.Count,
+			"rewards":   rewardItems,
+			"remaining": remaining,
+			"spirit":    newSpirit,
+		},
+	})
+}
+
+// GetPvEChapters 获取章节星级进度与宝箱领取情况
+// 对应 GET /api/duel/chapters
+func GetPvEChapters(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	userID := userIDInterface.(uint)
+
+	chapters, err := duel.GetChapterProgress(int64(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取章节进度失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    chapters,
+	})
+}
+
+// ClaimStarChest 领取章节星级宝箱
+// 对应 POST /api/duel/claim-star-chest
+func ClaimStarChest(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "未授权",
+		})
+		return
+	}
+
+	userID := userIDInterface.(uint)
+
+	var req struct {
+		ChapterID string `json:"chapterId" binding:"required"`
+		Stars     int    `json:"stars" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	reward, err := duel.ClaimStarChest(int64(userID), req.ChapterID, req.Stars)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "星级宝箱领取成功",
+		"data":    reward,
+	})
+}
@@ -6,6 +6,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"gorm.io/datatypes"
+
+	"xiuxian/server-go/internal/duel"
+	"xiuxian/server-go/internal/dungeon/battle"
+	"xiuxian/server-go/internal/models"
 )
 
 // MonsterBaseAttributes 妖兽基础属性
@@ -28,19 +32,20 @@ type MonsterCombatAttributes struct {
 
 // MonsterRewards 妖兽奖励
 type MonsterRewards struct {
-	DropItems string `json:"dropItems"` // 掉落物品描述
+	DropItems string `json:"dropItems"` // 掉落物品描述（实际掉落见 duel.GetLootTable）
 }
 
 // Monster 妖兽配置
 type Monster struct {
-	ID               int            `json:"id"`               // 妖兽ID
-	Name             string         `json:"name"`             // 妖兽名称
-	Difficulty       string         `json:"difficulty"`       // 难度: normal, hard, boss
-	Level            int            `json:"level"`            // 等级
-	Description      string         `json:"description"`      // 妖兽描述
-	BaseAttributes   datatypes.JSON `json:"baseAttributes"`   // 基础属性 (JSON)
-	CombatAttributes datatypes.JSON `json:"combatAttributes"` // 战斗属性 (JSON)
-	Rewards          datatypes.JSON `json:"rewards"`          // 奖励信息 (JSON)
+	ID               int                `json:"id"`                   // 妖兽ID
+	Name             string             `json:"name"`                 // 妖兽名称
+	Difficulty       string             `json:"difficulty"`           // 难度: normal, hard, boss
+	Level            int                `json:"level"`                // 等级
+	Description      string             `json:"description"`          // 妖兽描述
+	BaseAttributes   datatypes.JSON     `json:"baseAttributes"`       // 基础属性 (JSON)
+	CombatAttributes datatypes.JSON     `json:"combatAttributes"`     // 战斗属性 (JSON)
+	Rewards          datatypes.JSON     `json:"rewards"`              // 奖励信息 (JSON)
+	BossScript       *battle.BossScript `json:"bossScript,omitempty"` // 首领脚本（阶段/狂暴）
 }
 
 // GetAllMonsters 获取所有妖兽配置
@@ -114,6 +119,12 @@ var monsterConfigs = []Monster{
 		Rewards: datatypes.JSON([]byte(
 			"{\"dropItems\":\"灵草\"}",
 		)),
+		BossScript: &battle.BossScript{
+			Phases: []battle.BossPhase{
+				{Name: "雷根护体", HealthThreshold: 50, Message: "雷击树妖扎根大地，雷击根泛起电光，防御大增！", Multipliers: map[string]float64{"defense": 2}, Bonuses: map[string]float64{"counterRate": 0.15}},
+			},
+			Enrage: &battle.BossEnrage{Round: 15, Message: "雷击树妖引动天雷，攻势狂暴！", Multipliers: map[string]float64{"attack": 1.5}},
+		},
 	},
 	{
 		ID:          4,
@@ -162,6 +173,13 @@ var monsterConfigs = []Monster{
 		Rewards: datatypes.JSON([]byte(
 			"{\"dropItems\":\"灵草\"}",
 		)),
+		BossScript: &battle.BossScript{
+			Phases: []battle.BossPhase{
+				{Name: "寒毒蔓延", HealthThreshold: 60, Message: "寒晶毒蝎甩出毒尾，寒毒侵体！", Bonuses: map[string]float64{"stunRate": 0.1, "comboRate": 0.1}},
+				{Name: "晶甲", HealthThreshold: 25, Message: "寒晶毒蝎周身凝结冰晶，伤势缓缓愈合！", Multipliers: map[string]float64{"defense": 1.5}, Heal: 0.1},
+			},
+			Enrage: &battle.BossEnrage{Round: 20, Message: "寒晶毒蝎陷入狂暴，毒尾连击不止！", Multipliers: map[string]float64{"attack": 1.5, "speed": 1.2}},
+		},
 	},
 	{
 		ID:          7,
@@ -210,6 +228,14 @@ var monsterConfigs = []Monster{
 		Rewards: datatypes.JSON([]byte(
 			"{\"dropItems\":\"灵草\"}",
 		)),
+		BossScript: &battle.BossScript{
+			Phases: []battle.BossPhase{
+				{Name: "月华引", HealthThreshold: 70, Message: "太阴玉蟾吞吐月华，身形飘忽不定！", Bonuses: map[string]float64{"dodgeRate": 0.1}},
+				{Name: "心魔鸣", HealthThreshold: 40, Message: "太阴玉蟾发出摄魂鸣叫，引动心魔！", Multipliers: map[string]float64{"attack": 1.3}, Bonuses: map[string]float64{"stunRate": 0.15}},
+				{Name: "灵草续命", HealthThreshold: 15, Message: "太阴玉蟾吞下腹中灵草，伤势大为好转！", Heal: 0.2},
+			},
+			Enrage: &battle.BossEnrage{Round: 25, Message: "太阴玉蟾寒潭之力爆发，陷入狂暴！", Multipliers: map[string]float64{"attack": 1.6, "speed": 1.3}},
+		},
 	},
 }
 
@@ -310,6 +336,13 @@ var demonSlayingConfigs = []Monster{
 		Rewards: datatypes.JSON([]byte(
 			"{\"dropItems\":\"灵石,修为,渡劫丹丹方残页\"}",
 		)),
+		BossScript: &battle.BossScript{
+			Phases: []battle.BossPhase{
+				{Name: "回春丹", HealthThreshold: 50, Message: "药王宗长老服下回春丹，气血回涌！", Heal: 0.25, Bonuses: map[string]float64{"vampireRate": 0.1}},
+				{Name: "燃血丹", HealthThreshold: 20, Message: "药王宗长老服下燃血丹，以命搏命！", Multipliers: map[string]float64{"attack": 1.5, "defense": 0.7}},
+			},
+			Enrage: &battle.BossEnrage{Round: 12, Message: "药王宗长老丹力尽数化开，陷入狂暴！", Multipliers: map[string]float64{"attack": 1.4, "speed": 1.2}},
+		},
 	},
 }
 
@@ -329,8 +362,9 @@ func GetMonsterByIDAPI(c *gin.Context) {
 	}
 
 	c.JSON(200, gin.H{
-		"success": true,
-		"data":    monster,
+		"success":   true,
+		"data":      monster,
+		"dropRates": duel.GetLootDisclosure(monster.ID, monster.Difficulty), // 掉落概率公示
 	})
 }
 
@@ -400,6 +434,7 @@ func GetMonsterChallenges(c *gin.Context) {
 		"success": true,
 		"data": gin.H{
 			"monsters":   pageMonsters,
+			"progress":   buildStageProgress(c, pageMonsters),
 			"page":       page,
 			"pageSize":   pageSize,
 			"total":      total,
@@ -474,6 +509,7 @@ func GetDemonSlayingChallenges(c *gin.Context) {
 		"success": true,
 		"data": gin.H{
 			"monsters":   pageMonsters,
+			"progress":   buildStageProgress(c, pageMonsters),
 			"page":       page,
 			"pageSize":   pageSize,
 			"total":      total,
@@ -481,3 +517,20 @@ func GetDemonSlayingChallenges(c *gin.Context) {
 		},
 	})
 }
+
+// buildStageProgress 生成当前玩家在各关卡的进度（星级、通关次数、星级条件），按妖兽ID索引
+// 未登录时仅返回星级条件与首通奖励
+func buildStageProgress(c *gin.Context, monsters []Monster) map[int]duel.StageProgress {
+	clears := map[int]*models.PvEClear{}
+	if userIDInterface, exists := c.Get("userID"); exists {
+		if records, err := duel.GetPvEClears(int64(userIDInterface.(uint))); err == nil {
+			clears = records
+		}
+	}
+
+	progress := make(map[int]duel.StageProgress, len(monsters))
+	for _, monster := range monsters {
+		progress[monster.ID] = duel.BuildStageProgress(clears[monster.ID], monster.ID, monster.Difficulty)
+	}
+	return progress
+}
@@ -0,0 +1,190 @@
+package dungeon
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	dungeonSvc "xiuxian/server-go/internal/dungeon"
+)
+
+// GetCurrentRun 获取当前秘境挑战状态
+// GET /api/dungeon/run
+func GetCurrentRun(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	run, err := dungeonSvc.NewRunService(userID).LoadRun()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取秘境状态失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    run,
+	})
+}
+
+// StartRun 开始秘境挑战
+// POST /api/dungeon/start
+func StartRun(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("StartRun 入参", zap.Uint("userID", userID))
+
+	run, err := dungeonSvc.NewRunService(userID).StartRun()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("StartRun 出参", zap.Uint("userID", userID), zap.String("runID", run.RunID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "秘境挑战已开始",
+		"data":    run,
+	})
+}
+
+// FightFloor 挑战当前层
+// POST /api/dungeon/fight
+func FightFloor(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("FightFloor 入参", zap.Uint("userID", userID))
+
+	result, err := dungeonSvc.NewRunService(userID).FightFloor()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("FightFloor 出参",
+		zap.Uint("userID", userID),
+		zap.Int("floor", result.Floor),
+		zap.Bool("victory", result.Victory),
+		zap.Int("scoreGained", result.ScoreGained))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// SelectBuff 选择增益
+// POST /api/dungeon/select-buff
+func SelectBuff(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	var req struct {
+		BuffID string `json:"buffId" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("SelectBuff 入参", zap.Uint("userID", userID), zap.String("buffID", req.BuffID))
+
+	run, err := dungeonSvc.NewRunService(userID).SelectBuff(req.BuffID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "增益选择成功",
+		"data":    run,
+	})
+}
+
+// AbandonRun 放弃秘境挑战
+// POST /api/dungeon/abandon
+func AbandonRun(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("AbandonRun 入参", zap.Uint("userID", userID))
+
+	run, err := dungeonSvc.NewRunService(userID).AbandonRun()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("AbandonRun 出参", zap.Uint("userID", userID), zap.Int("score", run.Score))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已放弃秘境挑战",
+		"data":    run,
+	})
+}
+
+// GetLeaderboard 获取秘境积分排行榜
+// GET /api/dungeon/leaderboard
+func GetLeaderboard(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 64)
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 100
+	}
+
+	entries, err := dungeonSvc.GetLeaderboard(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取秘境排行榜失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    entries,
+	})
+}
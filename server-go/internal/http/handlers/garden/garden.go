@@ -0,0 +1,267 @@
+package garden
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	gardenSvc "xiuxian/server-go/internal/garden"
+)
+
+// plotRequest 操作自己灵田的请求
+type plotRequest struct {
+	PlotIndex int `json:"plotIndex" binding:"min=0"`
+}
+
+// plantRequest 种植请求
+type plantRequest struct {
+	PlotIndex  int    `json:"plotIndex" binding:"min=0"`
+	HerbID     string `json:"herbId" binding:"required"`
+	Fertilizer string `json:"fertilizer"` // 可选灵肥
+}
+
+// assistRequest 帮助道友药园的请求
+type assistRequest struct {
+	OwnerID   uint `json:"ownerId" binding:"required"`
+	PlotIndex int  `json:"plotIndex" binding:"min=0"`
+}
+
+// GetGarden 获取自己的药园
+// GET /api/garden
+func GetGarden(c *gin.Context) {
+	getGarden(c, 0)
+}
+
+// GetUserGarden 查看道友的药园
+// GET /api/garden/:userId
+func GetUserGarden(c *gin.Context) {
+	ownerID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "无效的玩家ID"})
+		return
+	}
+	getGarden(c, uint(ownerID))
+}
+
+func getGarden(c *gin.Context, ownerID uint) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	garden, err := gardenSvc.NewGardenService(userID).GetGarden(ownerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取药园失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    garden,
+	})
+}
+
+// UnlockPlot 开垦新灵田
+// POST /api/garden/unlock
+func UnlockPlot(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("GardenUnlockPlot 入参", zap.Uint("userID", userID))
+
+	plot, cost, err := gardenSvc.NewGardenService(userID).UnlockPlot()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("GardenUnlockPlot 出参",
+		zap.Uint("userID", userID),
+		zap.Int("plotIndex", plot.PlotIndex),
+		zap.Int("cost", cost))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"plot": plot, "cost": cost},
+	})
+}
+
+// UpgradePlot 提升灵田等级
+// POST /api/garden/upgrade
+func UpgradePlot(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	var req plotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("GardenUpgradePlot 入参", zap.Uint("userID", userID), zap.Int("plotIndex", req.PlotIndex))
+
+	plot, cost, err := gardenSvc.NewGardenService(userID).UpgradePlot(req.PlotIndex)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("GardenUpgradePlot 出参",
+		zap.Uint("userID", userID),
+		zap.Int("level", plot.Level),
+		zap.Int("cost", cost))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"plot": plot, "cost": cost},
+	})
+}
+
+// Plant 在灵田中种植灵草
+// POST /api/garden/plant
+func Plant(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	var req plantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("GardenPlant 入参",
+		zap.Uint("userID", userID),
+		zap.Int("plotIndex", req.PlotIndex),
+		zap.String("herbID", req.HerbID),
+		zap.String("fertilizer", req.Fertilizer))
+
+	plot, cost, err := gardenSvc.NewGardenService(userID).Plant(req.PlotIndex, req.HerbID, req.Fertilizer)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("GardenPlant 出参",
+		zap.Uint("userID", userID),
+		zap.Time("readyAt", plot.ReadyAt),
+		zap.Int("cost", cost))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    gin.H{"plot": plot, "cost": cost},
+	})
+}
+
+// Harvest 收获成熟的灵草
+// POST /api/garden/harvest
+func Harvest(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	var req plotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("GardenHarvest 入参", zap.Uint("userID", userID), zap.Int("plotIndex", req.PlotIndex))
+
+	result, err := gardenSvc.NewGardenService(userID).Harvest(req.PlotIndex)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("GardenHarvest 出参",
+		zap.Uint("userID", userID),
+		zap.String("herbID", result.HerbID),
+		zap.Int("total", result.Total),
+		zap.Any("qualities", result.Qualities))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// Water 为道友的灵田浇灌
+// POST /api/garden/water
+func Water(c *gin.Context) {
+	assist(c, "GardenWater", (*gardenSvc.GardenService).Water)
+}
+
+// Protect 为道友的灵田护法
+// POST /api/garden/protect
+func Protect(c *gin.Context) {
+	assist(c, "GardenProtect", (*gardenSvc.GardenService).Protect)
+}
+
+func assist(c *gin.Context, name string, action func(*gardenSvc.GardenService, uint, int) (*gardenSvc.AssistResult, error)) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	var req assistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误", "error": err.Error()})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info(name+" 入参",
+		zap.Uint("userID", userID),
+		zap.Uint("ownerID", req.OwnerID),
+		zap.Int("plotIndex", req.PlotIndex))
+
+	result, err := action(gardenSvc.NewGardenService(userID), req.OwnerID, req.PlotIndex)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info(name+" 出参",
+		zap.Uint("userID", userID),
+		zap.Int("rewardStones", result.RewardStones),
+		zap.Int("assistUsed", result.AssistUsed))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
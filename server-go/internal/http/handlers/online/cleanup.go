@@ -8,6 +8,7 @@ import (
 
 	"go.uber.org/zap"
 
+	"xiuxian/server-go/internal/cultivation"
 	"xiuxian/server-go/internal/db"
 	"xiuxian/server-go/internal/models"
 	redisc "xiuxian/server-go/internal/redis"
@@ -135,6 +136,9 @@ func checkAndCleanupTimeoutPlayers(logger *zap.Logger) {
 				// 不中断清理流程
 			}
 
+			// 从最后一次心跳开始累计离线挂机修为
+			cultivation.MarkIdleOffline(playerID, time.UnixMilli(lastHeartbeat))
+
 			// 删除在线状态
 			if err := rdb.Del(ctx, key).Err(); err != nil {
 				logger.Error("删除超时玩家在线状态失败",
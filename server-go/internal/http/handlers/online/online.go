@@ -13,6 +13,7 @@ import (
 	redisv9 "github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
+	"xiuxian/server-go/internal/cultivation"
 	"xiuxian/server-go/internal/db"
 	"xiuxian/server-go/internal/models"
 	redisc "xiuxian/server-go/internal/redis"
@@ -88,6 +89,14 @@ func Login(c *gin.Context) {
 		zap.Uint("userID", user.ID),
 		zap.Time("LastSpiritGainTime", now))
 
+	// 结算离线期间的挂机修为并结束本次离线（持有旧令牌直接重新上线时不会经过登录接口）
+	idleSummary, err := cultivation.NewCultivationService(user.ID).SettleOfflineCultivation()
+	if err != nil {
+		zapLogger.Warn("结算挂机修为失败",
+			zap.Uint("userID", user.ID),
+			zap.Error(err))
+	}
+
 	rdb := client()
 
 	// 清理Redis中的灵力计算时间戳，防止下次计算时使用过期的时间戳
@@ -131,7 +140,7 @@ func Login(c *gin.Context) {
 		zap.Uint("userID", user.ID),
 		zap.String("playerID", req.PlayerID))
 
-	c.JSON(http.StatusOK, gin.H{"message": "成功标记为在线", "playerId": req.PlayerID, "loginTime": loginTime})
+	c.JSON(http.StatusOK, gin.H{"message": "成功标记为在线", "playerId": req.PlayerID, "loginTime": loginTime, "idleSummary": idleSummary})
 }
 
 // Heartbeat 更新心跳，对应 POST /api/online/heartbeat
@@ -186,6 +195,15 @@ func Heartbeat(c *gin.Context) {
 		return
 	}
 
+	// 仍有离线挂机记录（上线后的首次心跳）时结算并结束本次离线
+	if playerID, err := strconv.ParseUint(req.PlayerID, 10, 32); err == nil {
+		if _, err := cultivation.NewCultivationService(uint(playerID)).SettleOfflineCultivation(); err != nil {
+			zapLogger.Warn("心跳结算挂机修为失败",
+				zap.String("playerID", req.PlayerID),
+				zap.Error(err))
+		}
+	}
+
 	lastHeartbeat := time.Now().UnixMilli()
 	if err := rdb.HSet(redisc.Ctx, key, "lastHeartbeat", strconv.FormatInt(lastHeartbeat, 10)).Err(); err != nil {
 		zapLogger.Error("更新心跳时间失败",
@@ -285,6 +303,9 @@ func Logout(c *gin.Context) {
 			zap.Error(err))
 	}
 
+	// 从离线时刻开始累计挂机修为
+	cultivation.MarkIdleOffline(playerID, time.Now())
+
 	// ✅ 成功离线
 	zapLogger.Info("玩家离线成功",
 		zap.Uint("userID", playerID),
@@ -9,9 +9,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"xiuxian/server-go/internal/attributes"
 	"xiuxian/server-go/internal/db"
 	"xiuxian/server-go/internal/gacha"
+	"xiuxian/server-go/internal/gem"
 	"xiuxian/server-go/internal/models"
 	redisClient "xiuxian/server-go/internal/redis"
 )
@@ -57,6 +60,35 @@ func GetItemDetails(c *gin.Context) {
 	})
 }
 
+// loadEquippedEquipment 获取玩家当前已穿戴的装备
+func loadEquippedEquipment(userID uint) ([]models.Equipment, error) {
+	var equipped []models.Equipment
+	if err := db.DB.Where("user_id = ? AND equipped = ?", userID, true).Find(&equipped).Error; err != nil {
+		return nil, err
+	}
+	return equipped, nil
+}
+
+// setPiecePet 出战灵宠计入套装件数，没有出战灵宠时返回 nil
+func setPiecePet(hasActivePet bool, activePet *models.Pet) *models.Pet {
+	if !hasActivePet {
+		return nil
+	}
+	return activePet
+}
+
+// replaceSetBonus 已穿戴装备由 before 变为 after 时，替换对应的套装加成，pet 为出战灵宠
+func replaceSetBonus(attrMgr *attributes.AttributeManager, before, after []models.Equipment, pet *models.Pet) {
+	attrMgr.RemoveEquipmentStats(gacha.SetBonusStats(before, pet))
+	attrMgr.ApplyEquipmentStats(gacha.SetBonusStats(after, pet))
+}
+
+// replaceSetPet 出战灵宠由 before 变为 after 时（nil 表示没有出战灵宠），替换对应的套装加成
+func replaceSetPet(attrMgr *attributes.AttributeManager, equipped []models.Equipment, before, after *models.Pet) {
+	attrMgr.RemoveEquipmentStats(gacha.SetBonusStats(equipped, before))
+	attrMgr.ApplyEquipmentStats(gacha.SetBonusStats(equipped, after))
+}
+
 // GetPlayerEquipment 获取玩家装备列表
 // 对应 GET /api/player/equipment
 // 支持查询参数: type/equip_type(装备类型), quality(品质), equipped(是否已装备)
@@ -169,9 +201,18 @@ func GetPlayerEquipment(c *gin.Context) {
 			zap.Any("stats", string(equip.Stats)))
 	}
 
+	// 套装进度按当前已穿戴的全部装备与出战灵宠计算，不受筛选条件影响
+	equippedList, err := loadEquippedEquipment(userID)
+	if err != nil {
+		zapLogger.Error("查询已穿戴装备失败",
+			zap.Uint("userID", userID),
+			zap.Error(err))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success":   true,
 		"equipment": equipment,
+		"sets":      gacha.GetSetProgress(equippedList, gacha.GetActivePet(db.DB, userID)),
 	})
 }
 
@@ -356,13 +397,15 @@ func EnhanceEquipment(c *gin.Context) {
 	}
 
 	// ✅ 新增：第一步 - 如果装备已装备，先卸下
+	// 临时卸下不影响套装件数，套装加成在第四步按最终穿戴状态调整
+	wasEquipped := equipment.Equipped
 	if equipment.Equipped {
 		zapLogger.Info("装备强化前先卸下装备",
 			zap.String("equipmentID", equipment.ID),
 			zap.Bool("equipped", equipment.Equipped))
 
 		// 获取装备属性信息用于卸下
-		equipStats := jsonToFloatMap(equipment.Stats)
+		equipStats := gem.EffectiveStats(&equipment)
 
 		// 解析用户属性
 		baseAttrs := jsonToFloatMap(user.BaseAttributes)
@@ -469,14 +512,14 @@ func EnhanceEquipment(c *gin.Context) {
 		zap.Int("newEnhanceLevel", equipment.EnhanceLevel))
 
 	// 重新穿戴装备 - 改用 AttributeManager 统一处理
-	equipStats := jsonToFloatMap(equipment.Stats)
+	equipStats := gem.EffectiveStats(&equipment)
 	baseAttrs := jsonToFloatMap(userFresh.BaseAttributes)
 	combatAttrs := jsonToFloatMap(userFresh.CombatAttributes)
 	combatRes := jsonToFloatMap(userFresh.CombatResistance)
 	specialAttrs := jsonToFloatMap(userFresh.SpecialAttributes)
 
 	// ✅ 改进：使用 AttributeManager 统一处理属性变更
-	attrMgr := NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 
 	// 处理灵宠属性：先移除旧灵宠加成
 	var activePet models.Pet
@@ -486,6 +529,13 @@ func EnhanceEquipment(c *gin.Context) {
 		attrMgr.RemovePetBonuses(&activePet, petCombat)
 	}
 
+	// 本件之外的已穿戴装备（第一步已将本件标记为未穿戴）
+	otherEquipped, err := loadEquippedEquipment(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
+		return
+	}
+
 	// ✅ 新增：根据 shouldUnequip 决定是穿戴还是卸下装备
 	if shouldUnequip {
 		// 装备需要卸下：移除装备属性加成
@@ -493,8 +543,11 @@ func EnhanceEquipment(c *gin.Context) {
 			zap.String("equipmentID", equipment.ID))
 
 		// 移除装备属性（因为装备已穿戴，需要移除其属性加成）
-		equipStats := jsonToFloatMap(equipment.Stats)
+		equipStats := gem.EffectiveStats(&equipment)
 		attrMgr.RemoveEquipmentStats(equipStats)
+		if wasEquipped {
+			replaceSetBonus(attrMgr, append(otherEquipped, equipment), otherEquipped, setPiecePet(hasActivePet, &activePet))
+		}
 
 		// 更新装备状态为未穿戴
 		equipment.Equipped = false
@@ -503,6 +556,9 @@ func EnhanceEquipment(c *gin.Context) {
 		// 装备满足境界要求：穿戴或继续穿戴装备
 		// 应用新的装备属性
 		attrMgr.ApplyEquipmentStats(equipStats)
+		if !wasEquipped {
+			replaceSetBonus(attrMgr, otherEquipped, append(otherEquipped, equipment), setPiecePet(hasActivePet, &activePet))
+		}
 
 		// 更新装备状态为已穿戴
 		equipment.Equipped = true
@@ -653,10 +709,11 @@ func ReforgeEquipment(c *gin.Context) {
 
 	// ✅ 新增：检查装备是否处于穿戴状态，如果是需要先卸下
 	var userBeforeReforge models.User
-	var attrMgrBeforeReforge *AttributeManager
+	var attrMgrBeforeReforge *attributes.AttributeManager
 	var activePetBeforeReforge models.Pet
 	var hasActivePetBeforeReforge bool
 
+	// 洗练期间临时卸下，确认或取消后都会重新穿戴，套装加成保持不变
 	if equipment.Equipped {
 		// 获取用户信息以保存属性状态
 		if err := db.DB.First(&userBeforeReforge, userID).Error; err != nil {
@@ -670,7 +727,7 @@ func ReforgeEquipment(c *gin.Context) {
 		combatRes := jsonToFloatMap(userBeforeReforge.CombatResistance)
 		specialAttrs := jsonToFloatMap(userBeforeReforge.SpecialAttributes)
 
-		attrMgrBeforeReforge = NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+		attrMgrBeforeReforge = attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 
 		// 检查是否有出战灵宠
 		hasActivePetBeforeReforge = db.DB.Where("user_id = ? AND is_active = ?", userID, true).First(&activePetBeforeReforge).Error == nil
@@ -682,7 +739,7 @@ func ReforgeEquipment(c *gin.Context) {
 		}
 
 		// 移除装备属性
-		equipStatsBeforeReforge := jsonToFloatMap(equipment.Stats)
+		equipStatsBeforeReforge := gem.EffectiveStats(&equipment)
 		attrMgrBeforeReforge.RemoveEquipmentStats(equipStatsBeforeReforge)
 
 		// 重新应用灵宠加成
@@ -862,7 +919,7 @@ func ConfirmReforge(c *gin.Context) {
 		combatRes := jsonToFloatMap(user.CombatResistance)
 		specialAttrs := jsonToFloatMap(user.SpecialAttributes)
 
-		attrMgr := NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+		attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 
 		// 检查是否有出战灵宠
 		var activePet models.Pet
@@ -875,7 +932,7 @@ func ConfirmReforge(c *gin.Context) {
 		}
 
 		// 应用新的装备属性
-		newEquipStats := jsonToFloatMap(equipment.Stats)
+		newEquipStats := gem.EffectiveStats(&equipment)
 		attrMgr.ApplyEquipmentStats(newEquipStats)
 
 		// 重新应用灵宠加成
@@ -949,7 +1006,7 @@ func ConfirmReforge(c *gin.Context) {
 	combatRes := jsonToFloatMap(user.CombatResistance)
 	specialAttrs := jsonToFloatMap(user.SpecialAttributes)
 
-	attrMgr := NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 
 	// 检查是否有出战灵宠
 	var activePet models.Pet
@@ -962,7 +1019,7 @@ func ConfirmReforge(c *gin.Context) {
 	}
 
 	// 重新应用装备属性
-	equipStats := jsonToFloatMap(equipment.Stats)
+	equipStats := gem.EffectiveStats(&equipment)
 	attrMgr.ApplyEquipmentStats(equipStats)
 
 	// 重新应用灵宠加成
@@ -1036,7 +1093,7 @@ func EquipEquipment(c *gin.Context) {
 	}
 
 	// 解析装备属性
-	equipStats := jsonToFloatMap(equipment.Stats)
+	equipStats := gem.EffectiveStats(&equipment)
 
 	// 打印穿戴前装备的属性
 	zapLogger.Info("穿戴装备前的装备属性",
@@ -1084,7 +1141,7 @@ func EquipEquipment(c *gin.Context) {
 		zap.Any("specialAttributes", specialAttrs))
 
 	// ✅ 改进：使用属性管理器统一处理
-	attrMgr := NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 
 	// 处理灵宠属性（如果有出战灵宠）
 	var activePet models.Pet
@@ -1097,12 +1154,19 @@ func EquipEquipment(c *gin.Context) {
 		attrMgr.RemovePetBonuses(&activePet, petCombat)
 	}
 
+	// 穿戴前的已穿戴装备，用于重新计算套装加成
+	equippedBefore, err := loadEquippedEquipment(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
+		return
+	}
+
 	// 查找同类型已装备的装备，移除其属性
 	var oldEquipment []models.Equipment
 	if err := db.DB.Where("user_id = ? AND equip_type = ? AND id != ? AND equipped = ?",
 		userID, equipTypeForQuery, id, true).Find(&oldEquipment).Error; err == nil {
 		for _, old := range oldEquipment {
-			oldEquipStats := jsonToFloatMap(old.Stats)
+			oldEquipStats := gem.EffectiveStats(&old)
 			attrMgr.RemoveEquipmentStats(oldEquipStats)
 		}
 	}
@@ -1133,6 +1197,16 @@ func EquipEquipment(c *gin.Context) {
 	// ✅ 改进：使用属性管理器应用装备属性⭥灵宠加成
 	attrMgr.ApplyEquipmentStats(equipStats)
 
+	// 穿戴后同部位只保留本件装备
+	equippedAfter := make([]models.Equipment, 0, len(equippedBefore)+1)
+	for _, eq := range equippedBefore {
+		if eq.ID != equipment.ID && (eq.EquipType == nil || *eq.EquipType != equipTypeForQuery) {
+			equippedAfter = append(equippedAfter, eq)
+		}
+	}
+	equippedAfter = append(equippedAfter, equipment)
+	replaceSetBonus(attrMgr, equippedBefore, equippedAfter, setPiecePet(hasActivePet, &activePet))
+
 	// 如果有出战灵宠，重新应用灵宠加成
 	if hasActivePet {
 		attrMgr.ApplyPetBonuses(&activePet, petCombat)
@@ -1163,6 +1237,7 @@ func EquipEquipment(c *gin.Context) {
 		"success":   true,
 		"message":   "装备穿戴成功",
 		"equipment": equipment,
+		"sets":      gacha.GetSetProgress(equippedAfter, setPiecePet(hasActivePet, &activePet)),
 		"user": gin.H{
 			"baseAttributes":    attrMgr.BaseAttrs,
 			"combatAttributes":  attrMgr.CombatAttrs,
@@ -1203,7 +1278,20 @@ func UnequipEquipment(c *gin.Context) {
 	}
 
 	// 解析装备属性
-	equipStats := jsonToFloatMap(equipment.Stats)
+	equipStats := gem.EffectiveStats(&equipment)
+
+	// 卸下前后的已穿戴装备，用于重新计算套装加成
+	equippedBefore, err := loadEquippedEquipment(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
+		return
+	}
+	equippedAfter := make([]models.Equipment, 0, len(equippedBefore))
+	for _, eq := range equippedBefore {
+		if eq.ID != equipment.ID {
+			equippedAfter = append(equippedAfter, eq)
+		}
+	}
 
 	// 更新装备状态为未装备
 	equipment.Equipped = false
@@ -1227,7 +1315,7 @@ func UnequipEquipment(c *gin.Context) {
 	specialAttrs := jsonToFloatMap(user.SpecialAttributes)
 
 	// ✅ 改进：使用属性管理器统一处理
-	attrMgr := NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 
 	// 处理灵宠属性（如果有出战灵宠）
 	var activePet models.Pet
@@ -1241,6 +1329,7 @@ func UnequipEquipment(c *gin.Context) {
 
 	// ✅ 改进：使用属性管理器移除装备属性
 	attrMgr.RemoveEquipmentStats(equipStats)
+	replaceSetBonus(attrMgr, equippedBefore, equippedAfter, setPiecePet(hasActivePet, &activePet))
 
 	// ✅ 改进：如果有出战灵宠，重新应用灵宠加成
 	if hasActivePet {
@@ -1265,6 +1354,7 @@ func UnequipEquipment(c *gin.Context) {
 		"success":   true,
 		"message":   "装备卸下成功",
 		"equipment": equipment,
+		"sets":      gacha.GetSetProgress(equippedAfter, setPiecePet(hasActivePet, &activePet)),
 		"user": gin.H{
 			"baseAttributes":    attrMgr.BaseAttrs,
 			"combatAttributes":  attrMgr.CombatAttrs,
@@ -1276,6 +1366,25 @@ func UnequipEquipment(c *gin.Context) {
 	})
 }
 
+// equipmentSellPrice 按装备品质计算出售返还的灵石
+func equipmentSellPrice(quality string) int {
+	qualitySpiritStoneMap := map[string]int{
+		"mythic":    500,
+		"legendary": 200,
+		"epic":      150,
+		"rare":      100,
+		"uncommon":  80,
+		"common":    50,
+	}
+	if v := qualitySpiritStoneMap[quality]; v > 0 {
+		return v
+	}
+	return 50
+}
+
+// errSellEquipped 出售已穿戴的装备
+var errSellEquipped = errors.New("装备穿戴中，请先卸下再出售")
+
 // SellEquipment 出售装备
 // 对应 DELETE /api/player/equipment/:id
 func SellEquipment(c *gin.Context) {
@@ -1291,50 +1400,46 @@ func SellEquipment(c *gin.Context) {
 
 	id := c.Param("id")
 
-	// 查找装备
+	// 锁定并删除装备，只有实际删除成功才退回宝石与灵石，防止并发出售重复返还
 	var equipment models.Equipment
-	if err := db.DB.Where("id = ? AND user_id = ?", id, userID).First(&equipment).Error; err != nil {
+	var spiritStones int
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", id, userID).First(&equipment).Error; err != nil {
+			return err
+		}
+		// 已穿戴装备的属性与套装加成仍计入玩家属性，须先卸下再出售
+		if equipment.Equipped {
+			return errSellEquipped
+		}
+		var deleted []models.Equipment
+		res := tx.Clauses(clause.Returning{}).
+			Where("id = ? AND user_id = ? AND equipped = ?", equipment.ID, userID, false).Delete(&deleted)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := gem.ReturnSocketedGems(tx, userID, deleted...); err != nil {
+			return err
+		}
+		spiritStones = equipmentSellPrice(equipment.Quality)
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Update("spirit_stones", gorm.Expr("spirit_stones + ?", spiritStones)).Error
+	}); err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "装备未找到"})
 			return
 		}
+		if errors.Is(err, errSellEquipped) {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
 		return
 	}
 
-	// 删除装备记录
-	if err := db.DB.Delete(&equipment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
-		return
-	}
-
-	// ✅ 修改：根据装备品质计算返还的灵石数量
-	qualitySpiritStoneMap := map[string]int{
-		"mythic":    500,
-		"legendary": 200,
-		"epic":      150,
-		"rare":      100,
-		"uncommon":  80,
-		"common":    50,
-	}
-	spiritStones := qualitySpiritStoneMap[equipment.Quality]
-	if spiritStones == 0 {
-		spiritStones = 50
-	}
-
-	// 增加用户灵石数量
-	if err := db.DB.Model(&models.User{}).
-		Where("id = ?", userID).
-		Update("spirit_stones", gorm.Expr("spirit_stones + ?", spiritStones)).Error; err != nil {
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"message": "服务器错误",
-			"error":   err.Error(),
-		})
-		return
-	}
-
 	// ✅ 优化：卖装备不使用 Redis 缓存
 	// 原因：卖装备是低频操作，无操作锁保护，容易产生并发竞态
 	// 改为只清理缓存，让后续操作重新加载
@@ -1378,69 +1483,55 @@ func BatchSellEquipment(c *gin.Context) {
 		return
 	}
 
-	// 构建查询条件（只卖未穿戴装备）
-	query := db.DB.Model(&models.Equipment{}).
-		Where("user_id = ?", userID).
-		Where("equipped = ?", false)
-
-	if req.Quality != "" {
-		query = query.Where("quality = ?", req.Quality)
-	}
-	if req.Type != "" {
-		query = query.Where("equip_type = ?", req.Type)
-	}
-
-	// 查询符合条件的装备列表
-	var list []models.Equipment
-	if err := query.Find(&list).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
-		return
-	}
-
-	// 检查是否有符合条件的装备
-	if len(list) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "没有找到符合条件的装备"})
-		return
-	}
-
-	// 定义品质与返还灵石的映射关系（和单个出售装备一致）
-	qualitySpiritStoneMap := map[string]int{
-		"mythic":    500,
-		"legendary": 200,
-		"epic":      150,
-		"rare":      100,
-		"uncommon":  80,
-		"common":    50,
-	}
-
-	// 计算总返还灵石数量
+	// 在事务内锁定符合条件的未穿戴装备并删除，按实际删除的记录返还宝石与灵石
+	var sold []models.Equipment
 	totalSpiritStones := 0
-	ids := make([]string, 0, len(list))
-	for _, eq := range list {
-		ids = append(ids, eq.ID)
-		v := qualitySpiritStoneMap[eq.Quality]
-		if v == 0 {
-			v = 50
+	if err := db.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			Where("equipped = ?", false)
+		if req.Quality != "" {
+			query = query.Where("quality = ?", req.Quality)
+		}
+		if req.Type != "" {
+			query = query.Where("equip_type = ?", req.Type)
 		}
-		totalSpiritStones += v
-	}
-
-	// 删除符合条件的装备
-	if err := db.DB.Where("id IN ?", ids).Delete(&models.Equipment{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
-		return
-	}
 
-	// 增加用户灵石数量（只返还灵石）
-	if err := db.DB.Model(&models.User{}).
-		Where("id = ?", userID).
-		Update("spirit_stones", gorm.Expr("spirit_stones + ?", totalSpiritStones)).Error; err != nil {
+		var list []models.Equipment
+		if err := query.Find(&list).Error; err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		ids := make([]string, 0, len(list))
+		for _, eq := range list {
+			ids = append(ids, eq.ID)
+		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"message": "服务器错误",
-			"error":   err.Error(),
-		})
+		res := tx.Clauses(clause.Returning{}).
+			Where("id IN ? AND user_id = ? AND equipped = ?", ids, userID, false).
+			Delete(&sold)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := gem.ReturnSocketedGems(tx, userID, sold...); err != nil {
+			return err
+		}
+		for _, eq := range sold {
+			totalSpiritStones += equipmentSellPrice(eq.Quality)
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).
+			Update("spirit_stones", gorm.Expr("spirit_stones + ?", totalSpiritStones)).Error
+	}); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"success": false, "message": "没有找到符合条件的装备"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "服务器错误", "error": err.Error()})
 		return
 	}
 
@@ -1460,7 +1551,7 @@ func BatchSellEquipment(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success":       true,
 		"message":       "成功出售装备",
-		"equipmentSold": len(list),
+		"equipmentSold": len(sold),
 		"spiritStones":  totalSpiritStones,
 	})
 }
@@ -0,0 +1,194 @@
+package player
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"xiuxian/server-go/internal/gem"
+	"xiuxian/server-go/internal/models"
+)
+
+// socketRequest 镶嵌/拆卸宝石请求
+type socketRequest struct {
+	Socket int  `json:"socket" binding:"min=0"`
+	GemID  uint `json:"gemId"` // 背包中的宝石堆叠ID，仅镶嵌时需要
+}
+
+// combineGemRequest 宝石合成请求
+type combineGemRequest struct {
+	GemID string `json:"gemId" binding:"required"`
+	Tier  int    `json:"tier" binding:"required"`
+	Times int    `json:"times"` // 合成次数，默认 1
+}
+
+// GetGems 获取背包中的宝石
+// 对应 GET /api/player/gems
+func GetGems(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "用户未授权"})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("GetGems 入参", zap.Uint("userID", userID))
+
+	gems, err := gem.NewGemService(userID).GetGems()
+	if err != nil {
+		zapLogger.Error("获取宝石失败", zap.Uint("userID", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":           true,
+		"gems":              gems,
+		"configs":           gem.GemConfigs,
+		"combineCount":      gem.CombineCount,
+		"maxTier":           gem.MaxTier,
+		"removeCostPerTier": gem.RemoveCostPerTier,
+		"socketsByQuality":  socketsByQuality(),
+	})
+}
+
+// socketsByQuality 各品质装备的宝石孔数
+func socketsByQuality() map[string]int {
+	sockets := make(map[string]int, len(models.EquipmentQualityConfigs))
+	for quality := range models.EquipmentQualityConfigs {
+		sockets[quality] = gem.SocketCount(quality)
+	}
+	return sockets
+}
+
+// SocketGem 将宝石镶嵌到装备孔位
+// 对应 POST /api/player/equipment/:id/socket
+func SocketGem(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "用户未授权"})
+		return
+	}
+
+	var req socketRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.GemID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("SocketGem 入参",
+		zap.Uint("userID", userID),
+		zap.String("equipmentID", c.Param("id")),
+		zap.Int("socket", req.Socket),
+		zap.Uint("gemID", req.GemID))
+
+	result, err := gem.NewGemService(userID).Insert(c.Param("id"), req.Socket, req.GemID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	respondSocketChange(c, zapLogger, userID, result, "宝石镶嵌成功")
+}
+
+// UnsocketGem 拆卸装备孔位中的宝石
+// 对应 POST /api/player/equipment/:id/unsocket
+func UnsocketGem(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "用户未授权"})
+		return
+	}
+
+	var req socketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("UnsocketGem 入参",
+		zap.Uint("userID", userID),
+		zap.String("equipmentID", c.Param("id")),
+		zap.Int("socket", req.Socket))
+
+	result, err := gem.NewGemService(userID).Remove(c.Param("id"), req.Socket)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	respondSocketChange(c, zapLogger, userID, result, "宝石拆卸成功")
+}
+
+// respondSocketChange 返回镶嵌变更结果，装备已穿戴时附带调整后的玩家属性
+func respondSocketChange(c *gin.Context, zapLogger *zap.Logger, userID uint, result *gem.SocketResult, message string) {
+	response := gin.H{
+		"success":   true,
+		"message":   message,
+		"equipment": result.Equipment,
+		"sockets":   result.Sockets,
+		"cost":      result.Cost,
+	}
+
+	if attrMgr := result.Attributes; attrMgr != nil {
+		response["user"] = gin.H{
+			"baseAttributes":    attrMgr.BaseAttrs,
+			"combatAttributes":  attrMgr.CombatAttrs,
+			"combatResistance":  attrMgr.CombatRes,
+			"specialAttributes": attrMgr.SpecialAttrs,
+		}
+	}
+
+	zapLogger.Info("宝石镶嵌变更 出参",
+		zap.Uint("userID", userID),
+		zap.String("equipmentID", result.Equipment.ID),
+		zap.Int("socketCount", len(result.Sockets)),
+		zap.Int("cost", result.Cost))
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CombineGems 合成宝石，三颗同阶合成一颗高一阶
+// 对应 POST /api/player/gems/combine
+func CombineGems(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "用户未授权"})
+		return
+	}
+
+	var req combineGemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "请求参数错误"})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("CombineGems 入参",
+		zap.Uint("userID", userID),
+		zap.String("gemID", req.GemID),
+		zap.Int("tier", req.Tier),
+		zap.Int("times", req.Times))
+
+	result, err := gem.NewGemService(userID).Combine(req.GemID, req.Tier, req.Times)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("CombineGems 出参",
+		zap.Uint("userID", userID),
+		zap.Int("consumed", result.Consumed),
+		zap.Int("produced", result.Produced))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "宝石合成成功",
+		"data":    result,
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 
+	"xiuxian/server-go/internal/inventory"
 	"xiuxian/server-go/internal/models"
 )
 
@@ -450,9 +451,10 @@ func upsertHerb(tx *gorm.DB, userID uint, herb map[string]interface{}) error {
 	}
 
 	model := models.Herb{
-		UserID: userID,
-		HerbID: herbID,
-		Name:   toString(herb["name"]),
+		UserID:  userID,
+		HerbID:  herbID,
+		Name:    toString(herb["name"]),
+		Quality: inventory.DefaultQuality,
 	}
 	if cnt, ok := herb["count"].(float64); ok {
 		model.Count = int(cnt)
@@ -492,6 +494,8 @@ func upsertPill(tx *gorm.DB, userID uint, pill map[string]interface{}) error {
 		Name:        toString(pill["name"]),
 		Description: toString(pill["description"]),
 		Effect:      toJSON(pill["effect"]),
+		Quality:     inventory.DefaultQuality,
+		Count:       1,
 	}
 	return tx.Create(&model).Error
 }
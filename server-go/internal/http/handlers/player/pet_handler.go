@@ -12,6 +12,7 @@ import (
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 
+	"xiuxian/server-go/internal/attributes"
 	"xiuxian/server-go/internal/db"
 	"xiuxian/server-go/internal/models"
 	redisc "xiuxian/server-go/internal/redis"
@@ -78,11 +79,13 @@ func DeployPet(c *gin.Context) {
 		zap.Any("specialAttributes", specialAttrs))
 
 	// ✅ 改进：使用属性管理器来统一处理属性变更
-	attrMgr := NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 
 	// 检查是否已有出战灵宠
 	var activePet models.Pet
+	var previousPet *models.Pet
 	if err := db.DB.Where("user_id = ? AND is_active = ?", userID, true).First(&activePet).Error; err == nil {
+		previousPet = &activePet
 		if activePet.ID == pet.ID {
 			// 已经出战
 			c.JSON(http.StatusOK, gin.H{"success": true, "message": "灵宠已经出战"})
@@ -119,6 +122,14 @@ func DeployPet(c *gin.Context) {
 		return
 	}
 
+	// 出战灵宠计入套装件数，按新旧灵宠替换套装加成
+	equipped, err := loadEquippedEquipment(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "服务器错误", "error": err.Error()})
+		return
+	}
+	replaceSetPet(attrMgr, equipped, previousPet, &pet)
+
 	// ✅ 改进：直接使用 AttributeManager 的统一应用方法
 	// 玩家属性中已包含装备属性，直接应用灵宠加成
 	petCombat = jsonToFloatMap(pet.CombatAttributes)
@@ -206,9 +217,17 @@ func RecallPet(c *gin.Context) {
 	petCombat := jsonToFloatMap(pet.CombatAttributes)
 
 	// ✅ 改进：使用属性管理器统一移除灵宠加成
-	attrMgr := NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
+	attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrs, combatRes, specialAttrs)
 	attrMgr.RemovePetBonuses(&pet, petCombat)
 
+	// 召回的灵宠不再计入套装件数
+	equipped, err := loadEquippedEquipment(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "服务器错误", "error": err.Error()})
+		return
+	}
+	replaceSetPet(attrMgr, equipped, &pet, nil)
+
 	updates := map[string]interface{}{
 		"base_attributes":    toJSON(attrMgr.BaseAttrs),
 		"combat_attributes":  toJSON(attrMgr.CombatAttrs),
@@ -394,7 +413,7 @@ func UpgradePet(c *gin.Context) {
 		specialAttrs := jsonToFloatMap(user.SpecialAttributes)
 
 		// 使用属性管理器重新计算
-		attrMgr := NewAttributeManager(baseAttrs, combatAttrsUser, combatRes, specialAttrs)
+		attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrsUser, combatRes, specialAttrs)
 
 		// 第一步：移除旧属性加成
 		attrMgr.RemovePetBonuses(&pet, oldCombatAttrs)
@@ -589,7 +608,7 @@ func EvolvePet(c *gin.Context) {
 			specialAttrs := jsonToFloatMap(user.SpecialAttributes)
 
 			// 使用属性管理器重新计算
-			attrMgr := NewAttributeManager(baseAttrs, combatAttrsUser, combatRes, specialAttrs)
+			attrMgr := attributes.NewAttributeManager(baseAttrs, combatAttrsUser, combatRes, specialAttrs)
 
 			// 第一步：移除旧的属性加成
 			oldPet := targetPet
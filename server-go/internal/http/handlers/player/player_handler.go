@@ -4,18 +4,27 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"xiuxian/server-go/internal/alchemy"
+	"xiuxian/server-go/internal/attributes"
+	cultivationSvc "xiuxian/server-go/internal/cultivation"
 	"xiuxian/server-go/internal/db"
 	"xiuxian/server-go/internal/exploration"
+	"xiuxian/server-go/internal/gacha"
+	"xiuxian/server-go/internal/gem"
+	"xiuxian/server-go/internal/inventory"
 	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/pillbuff"
 	"xiuxian/server-go/internal/spirit"
 )
 
@@ -89,13 +98,17 @@ func assembleFullPlayerData(userID uint) (gin.H, error) {
 	}
 
 	for _, pill := range pills {
+		// 丹药按品质堆叠，数量合并到效果详情中
+		details := jsonToMap(pill.Effect)
+		details["count"] = pill.Count
+		details["quality"] = pill.Quality
 		items = append(items, models.Item{
 			ID:      fmt.Sprintf("pill_%d", pill.ID),
 			UserID:  pill.UserID,
 			ItemID:  pill.PillID,
 			Name:    pill.Name,
 			Type:    "pill",
-			Details: pill.Effect,
+			Details: toJSON(details),
 			// Description: pill.Description, // 如果需要可以添加额外字段
 		})
 	}
@@ -589,6 +602,13 @@ func InitializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 		zap.Uint("userID", userID),
 		zap.Int("level", level))
 
+	// 境界表调整后同步修为上限
+	if err := cultivationSvc.SyncRealmCap(user); err != nil {
+		zapLogger.Warn("[登录初始化] 同步修为上限失败",
+			zap.Uint("userID", userID),
+			zap.Error(err))
+	}
+
 	// 步骤1：获取玩家当前穿戴的所有装备（保存装备ID）
 	var equippedEquipments []models.Equipment
 	if err := db.DB.Where("user_id = ? AND equipped = ?", userID, true).
@@ -663,10 +683,14 @@ func InitializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 	}
 
 	// 步骤5：计算基础属性（不含装备和灵宠加成）
-	baseAttrs := calculateBaseAttributesByLevel(level)
-	spiritRate := calculateSpiritRateByLevel(level)
+	baseAttrs := cultivationSvc.CalculateBaseAttributesByLevel(level)
+	spiritRate := cultivationSvc.CalculateSpiritRateByLevel(level)
 	baseAttrs["spiritRate"] = spiritRate
 	baseAttrs["cultivationRate"] = 1.0
+	// 丹毒与渡劫保底记录在基础属性中，重建时需保留
+	oldBaseAttrs := jsonToMap(user.BaseAttributes)
+	alchemy.CopyToxicity(baseAttrs, oldBaseAttrs)
+	cultivationSvc.CopyTribulationState(baseAttrs, oldBaseAttrs)
 
 	// 步骤6：更新BaseAttributes到用户对象
 	user.BaseAttributes = toJSON(baseAttrs)
@@ -689,8 +713,8 @@ func InitializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 		}
 
 		// 应用装备属性
-		equipStats := jsonToFloatMap(equipment.Stats)
-		attrMgr := NewAttributeManager(baseAttrsMap, combatAttrsMap, combatResMap, specialAttrsMap)
+		equipStats := gem.EffectiveStats(&equipment)
+		attrMgr := attributes.NewAttributeManager(baseAttrsMap, combatAttrsMap, combatResMap, specialAttrsMap)
 		attrMgr.ApplyEquipmentStats(equipStats)
 
 		// 更新属性映射
@@ -714,6 +738,20 @@ func InitializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 		}
 	}
 
+	// 套装加成按已穿戴装备与出战灵宠整体计算
+	var setPet *models.Pet
+	if len(activePets) > 0 {
+		setPet = &activePets[0]
+	}
+	if setStats := gacha.SetBonusStats(equippedEquipments, setPet); len(setStats) > 0 {
+		attrMgr := attributes.NewAttributeManager(baseAttrsMap, combatAttrsMap, combatResMap, specialAttrsMap)
+		attrMgr.ApplyEquipmentStats(setStats)
+		baseAttrsMap = attrMgr.BaseAttrs
+		combatAttrsMap = attrMgr.CombatAttrs
+		combatResMap = attrMgr.CombatRes
+		specialAttrsMap = attrMgr.SpecialAttrs
+	}
+
 	// 步骤9：重新出战已保存的灵宠
 	for _, petID := range activePetIDs {
 		var pet models.Pet
@@ -727,7 +765,7 @@ func InitializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 
 		// 应用灵宠属性
 		petCombat := jsonToFloatMap(pet.CombatAttributes)
-		attrMgr := NewAttributeManager(baseAttrsMap, combatAttrsMap, combatResMap, specialAttrsMap)
+		attrMgr := attributes.NewAttributeManager(baseAttrsMap, combatAttrsMap, combatResMap, specialAttrsMap)
 		attrMgr.ApplyPetBonuses(&pet, petCombat)
 
 		// 更新属性映射
@@ -751,6 +789,10 @@ func InitializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 		}
 	}
 
+	// 运转功法加成
+	attrMgr := attributes.NewAttributeManager(baseAttrsMap, combatAttrsMap, combatResMap, specialAttrsMap)
+	attrMgr.ApplyEquipmentStats(cultivationSvc.GetTechniqueBonus(userID, level).Stats)
+
 	// 步骤10：保存最终属性到用户对象
 	user.BaseAttributes = toJSON(baseAttrsMap)
 	user.CombatAttributes = toJSON(combatAttrsMap)
@@ -780,34 +822,6 @@ func InitializePlayerAttributesOnLogin(user *models.User, zapLogger *zap.Logger)
 	return nil
 }
 
-// ✅ 新增：calculateSpiritRateByLevel 计算基于等级的灵力倍率
-// 公式：spiritRate = 1.0 * (1.2)^(Level-1)
-// 每突破一次（等级+1），灵力倍率乘以1.2
-func calculateSpiritRateByLevel(level int) float64 {
-	if level < 1 {
-		level = 1
-	}
-	// 基础速度是1.0，每升一级就乘以1.2
-	spiritRate := 1.0 * math.Pow(1.2, float64(level-1))
-	// 保留两位小数
-	return math.Round(spiritRate*100) / 100
-}
-
-// ✅ 新增：calculateBaseAttributesByLevel 计算基于等级的基础属性
-// 公式：
-// speed = 10 * Level
-// attack = 10 * Level
-// health = 100 * Level
-// defense = 5 * Level
-func calculateBaseAttributesByLevel(level int) map[string]interface{} {
-	return map[string]interface{}{
-		"speed":   float64(10 * level),
-		"attack":  float64(10 * level),
-		"health":  float64(100 * level),
-		"defense": float64(5 * level),
-	}
-}
-
 // GetHerbsPaginated 对应 GET /api/player/herbs 获取玩家灵草分页列表
 func GetHerbsPaginated(c *gin.Context) {
 	userID, ok := currentUserID(c)
@@ -968,7 +982,7 @@ func GetPillsPaginated(c *gin.Context) {
 
 	// 验证排序字段，防止SQL注入
 	allowedSortFields := map[string]bool{
-		"id": true, "pill_id": true, "name": true,
+		"id": true, "pill_id": true, "name": true, "count": true,
 	}
 	if !allowedSortFields[sort] {
 		sort = "id"
@@ -1128,7 +1142,13 @@ func GetFormulasPaginated(c *gin.Context) {
 	c.JSON(http.StatusOK, data)
 }
 
+// maxConsumePills 单次最多服用的丹药数量
+const maxConsumePills = 100
+
+var errPillNotFound = errors.New("丹药不存在")
+
 // ConsumePill 对应 POST /api/player/pills/:id/consume 服用丹药
+// 请求体可选 {"quantity": n}，从同一丹药堆叠中连续服用 n 枚，全部效果在一个事务内结算
 func ConsumePill(c *gin.Context) {
 	userID, ok := currentUserID(c)
 	if !ok {
@@ -1140,109 +1160,89 @@ func ConsumePill(c *gin.Context) {
 	logger, _ := c.Get("zap_logger")
 	zapLogger := logger.(*zap.Logger)
 
-	zapLogger.Info("ConsumePill 入参",
-		zap.Uint("userID", userID),
-		zap.String("pillID", pillID))
-
-	// 查询丹药是否存在
-	var pill models.Pill
-	if err := db.DB.Where("id = ? AND user_id = ?", pillID, userID).First(&pill).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"message": "丹药不存在"})
-		} else {
-			zapLogger.Error("查询丹药失败",
-				zap.Uint("userID", userID),
-				zap.String("pillID", pillID),
-				zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "服务器错误"})
-		}
+	var req struct {
+		Quantity int `json:"quantity"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "请求参数错误", "error": err.Error()})
 		return
 	}
-
-	// 查询玩家信息，用于获取等级和计算效果
-	var user models.User
-	if err := db.DB.First(&user, userID).Error; err != nil {
-		zapLogger.Error("查询玩家信息失败",
-			zap.Uint("userID", userID),
-			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "查询玩家信息失败"})
+	quantity := req.Quantity
+	if quantity <= 0 {
+		quantity = 1
+	}
+	if quantity > maxConsumePills {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": fmt.Sprintf("单次最多服用 %d 枚", maxConsumePills)})
 		return
 	}
 
-	// 解析丹药效果
-	var effectData map[string]interface{}
+	zapLogger.Info("ConsumePill 入参",
+		zap.Uint("userID", userID),
+		zap.String("pillID", pillID),
+		zap.Int("quantity", quantity))
+
+	now := time.Now()
+	var pill models.Pill
+	var user models.User
 	var effectType string
-	var baseValue float64
-	if err := json.Unmarshal(pill.Effect, &effectData); err == nil {
-		if t, ok := effectData["type"].(string); ok {
-			effectType = t
+	var actualValue float64
+	var buff *models.ActivePillBuff
+	var buffConfig *pillbuff.BuffConfig
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// 先锁玩家再锁丹药堆叠（与炼丹、渡劫、限时丹药的加锁顺序一致），防止并发服用重复结算
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return fmt.Errorf("查询玩家信息失败: %w", err)
 		}
-		if v, ok := effectData["value"].(float64); ok {
-			baseValue = v
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", pillID, userID).First(&pill).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errPillNotFound
+			}
+			return fmt.Errorf("查询丹药失败: %w", err)
 		}
-	}
 
-	// 根据丹药 ID 查找对应的计算公式，计算效果值
-	actualValue := alchemy.CalculatePillEffect(pill.PillID, baseValue, user.Level)
+		// 废丹没有药效，不可服用
+		if pill.PillID == alchemy.WastePillID {
+			return fmt.Errorf("废丹毫无药效，无法服用")
+		}
+		if pill.Count < quantity {
+			return fmt.Errorf("丹药数量不足: 拥有 %d 枚", pill.Count)
+		}
 
-	zapLogger.Info("丹药效果计算",
-		zap.String("pillID", pill.PillID),
-		zap.String("effectType", effectType),
-		zap.Float64("baseValue", baseValue),
-		zap.Int("playerLevel", user.Level),
-		zap.Float64("actualValue", actualValue))
+		// 限时丹药：按丹方基础值与品质写入生效中的效果，不改变永久属性
+		var err error
+		if buffConfig = pillbuff.GetBuffConfig(pill.PillID); buffConfig != nil {
+			effectType = buffConfig.Effect
+			if actualValue, buff, err = consumeBuffPills(tx, &user, &pill, buffConfig, quantity, now); err != nil {
+				return err
+			}
+		} else {
+			effectType, actualValue = consumeEffectPills(&user, &pill, quantity, now)
+		}
 
-	// 根据效果类型更新对应属性
-	switch effectType {
-	case "spirit":
-		user.Spirit += actualValue
-	case "cultivation":
-		user.Cultivation += actualValue
-	case "attributeAttack":
-		// 更新基础属性中的攻击
-		updateBaseAttributeAttack(&user, actualValue)
-	case "duJieRate":
-		// 更新渡劫成功率 (duJieRate 存储于 BaseAttributes JSON 中)
-		updateBaseAttributeDuJieRate(&user, actualValue)
-	}
-
-	// 保存玩家更新
-	if err := db.DB.Save(&user).Error; err != nil {
-		zapLogger.Error("保存玩家属性失败",
-			zap.Uint("userID", userID),
-			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "保存玩家属性失败"})
-		return
-	}
+		// 更新服用统计（存储于 BaseAttributes JSON 中）
+		// ✅ 使用 jsonToMap 保留所有字段类型（包括 unlockedRealms 数组和 duJieRate 等）
+		baseAttrs := jsonToMap(user.BaseAttributes)
+		consumed, _ := baseAttrs["pillsConsumed"].(float64)
+		baseAttrs["pillsConsumed"] = consumed + float64(quantity)
+		user.BaseAttributes = toJSON(baseAttrs)
+		if err := tx.Save(&user).Error; err != nil {
+			return fmt.Errorf("保存玩家属性失败: %w", err)
+		}
 
-	// 删除丹药记录
-	if err := db.DB.Delete(&pill).Error; err != nil {
-		zapLogger.Error("删除丹药失败",
+		return inventory.TakePills(tx, &pill, quantity)
+	})
+	if err != nil {
+		if errors.Is(err, errPillNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "丹药不存在"})
+			return
+		}
+		zapLogger.Error("服用丹药失败",
 			zap.Uint("userID", userID),
 			zap.String("pillID", pillID),
+			zap.Int("quantity", quantity),
 			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "删除丹药失败"})
-		return
-	}
-
-	// 更新用户统计数据（从 BaseAttributes JSON 中更新 pillsConsumed）
-	// ✅ 使用 jsonToMap 保留所有字段类型（包括 unlockedRealms 数组和 duJieRate 等）
-	baseAttrs := jsonToMap(user.BaseAttributes)
-	if baseAttrs == nil {
-		baseAttrs = make(map[string]interface{})
-	}
-	if v, ok := baseAttrs["pillsConsumed"].(float64); ok {
-		baseAttrs["pillsConsumed"] = v + 1
-	} else {
-		baseAttrs["pillsConsumed"] = float64(1)
-	}
-	user.BaseAttributes = toJSON(baseAttrs)
-
-	if err := db.DB.Model(&user).Update("base_attributes", user.BaseAttributes).Error; err != nil {
-		zapLogger.Error("更新丹药统计失败",
-			zap.Uint("userID", userID),
-			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "更新统计失败"})
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
 		return
 	}
 
@@ -1251,12 +1251,27 @@ func ConsumePill(c *gin.Context) {
 		zap.String("pillID", pillID),
 		zap.String("pillName", pill.Name),
 		zap.String("effectType", effectType),
+		zap.Int("quantity", quantity),
+		zap.Int("remaining", pill.Count),
 		zap.Float64("actualValue", actualValue))
 
 	// 返回丹药信息和效果
+	name := pill.Name
+	if quantity > 1 {
+		name = fmt.Sprintf("%s x%d", pill.Name, quantity)
+	}
+	var message string
+	switch {
+	case buffConfig != nil:
+		message = fmt.Sprintf("成功服用%s，%s提升%.0f%%，持续至%s", name, pillbuff.EffectName(buffConfig.Effect), actualValue*100, buff.ExpiresAt.Format("15:04:05"))
+	case effectType == "detox":
+		message = fmt.Sprintf("成功服用%s，化解丹毒%.0f", name, actualValue)
+	default:
+		message = fmt.Sprintf("成功服用%s，增加%s %.0f", name, effectTypeToName(effectType), actualValue)
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": fmt.Sprintf("成功服用%s，增加%s %.0f", pill.Name, effectTypeToName(effectType), actualValue),
+		"message": message,
 		"data": gin.H{
 			"pillId":            pill.ID,
 			"pillName":          pill.Name,
@@ -1264,8 +1279,122 @@ func ConsumePill(c *gin.Context) {
 			"description":       pill.Description,
 			"effectType":        effectType,
 			"actualValue":       actualValue,
+			"quantity":          quantity,
+			"remaining":         pill.Count,
+			"buff":              buff,
 			"playerSpirit":      user.Spirit,
 			"playerCultivation": user.Cultivation,
+			"toxicity":          alchemy.GetToxicityState(jsonToMap(user.BaseAttributes), now),
 		},
 	})
 }
+
+// consumeEffectPills 逐枚结算永久效果丹药，每枚都按当时的丹毒削弱并累积丹毒，返回效果类型与效果总值
+func consumeEffectPills(user *models.User, pill *models.Pill, quantity int, now time.Time) (string, float64) {
+	// 解析丹药效果
+	var effectData map[string]interface{}
+	var effectType string
+	var baseValue float64
+	if err := json.Unmarshal(pill.Effect, &effectData); err == nil {
+		if t, ok := effectData["type"].(string); ok {
+			effectType = t
+		}
+		if v, ok := effectData["value"].(float64); ok {
+			baseValue = v
+		}
+	}
+
+	total := 0.0
+	for i := 0; i < quantity; i++ {
+		// 根据丹药 ID 查找对应的计算公式，计算效果值，再按丹药品质放大、按丹毒削弱
+		toxicity := alchemy.GetToxicityState(jsonToMap(user.BaseAttributes), now)
+		actualValue := alchemy.CalculatePillEffect(pill.PillID, baseValue, user.Level)
+		switch effectType {
+		case "detox":
+			// 清毒丹按丹方基础值计算，不受丹毒削弱
+			if recipe := alchemy.GetRecipeByID(pill.PillID); recipe != nil {
+				actualValue = recipe.BaseEffect.Value
+			}
+			actualValue *= alchemy.GetPillQuality(pill.Quality).EffectMultiplier
+		case "duJieRate":
+			actualValue *= toxicity.EffectMultiplier
+		default:
+			actualValue *= alchemy.GetPillQuality(pill.Quality).EffectMultiplier * toxicity.EffectMultiplier
+		}
+
+		// 根据效果类型更新对应属性
+		switch effectType {
+		case "spirit":
+			user.Spirit += actualValue
+		case "cultivation":
+			user.Cultivation += actualValue
+		case "attributeAttack":
+			// 更新基础属性中的攻击
+			updateBaseAttributeAttack(user, actualValue)
+		case "duJieRate":
+			// 更新渡劫成功率 (duJieRate 存储于 BaseAttributes JSON 中)
+			updateBaseAttributeDuJieRate(user, actualValue)
+		}
+
+		// 累积丹毒，清毒丹则化解丹毒
+		toxicityDelta := alchemy.PillToxicity(pill.PillID)
+		if effectType == "detox" {
+			toxicityDelta = -actualValue
+		}
+		baseAttrs := jsonToMap(user.BaseAttributes)
+		alchemy.AdjustToxicity(baseAttrs, toxicityDelta, now)
+		user.BaseAttributes = toJSON(baseAttrs)
+		total += actualValue
+	}
+	return effectType, total
+}
+
+// consumeBuffPills 逐枚服用限时丹药，按叠加规则写入生效中的效果并累积丹毒，返回最后一枚的效果值与效果记录
+func consumeBuffPills(tx *gorm.DB, user *models.User, pill *models.Pill, buffConfig *pillbuff.BuffConfig, quantity int, now time.Time) (float64, *models.ActivePillBuff, error) {
+	recipe := alchemy.GetRecipeByID(pill.PillID)
+	if recipe == nil || recipe.BaseEffect.Duration <= 0 {
+		return 0, nil, fmt.Errorf("丹药效果配置错误")
+	}
+	duration := time.Duration(recipe.BaseEffect.Duration) * time.Second
+
+	var value float64
+	var buff *models.ActivePillBuff
+	for i := 0; i < quantity; i++ {
+		baseAttrs := jsonToMap(user.BaseAttributes)
+		toxicity := alchemy.GetToxicityState(baseAttrs, now)
+		value = recipe.BaseEffect.Value * alchemy.GetPillQuality(pill.Quality).EffectMultiplier * toxicity.EffectMultiplier
+		applied, err := pillbuff.ApplyBuff(tx, user.ID, buffConfig, value, duration)
+		if err != nil {
+			return 0, nil, err
+		}
+		buff = applied
+		alchemy.AdjustToxicity(baseAttrs, alchemy.PillToxicity(pill.PillID), now)
+		user.BaseAttributes = toJSON(baseAttrs)
+	}
+	return value, buff, nil
+}
+
+// GetActiveBuffs 对应 GET /api/player/buffs 获取生效中的丹药限时效果
+func GetActiveBuffs(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "用户未授权"})
+		return
+	}
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("GetActiveBuffs 入参", zap.Uint("userID", userID))
+
+	buffs, err := pillbuff.GetActiveBuffs(userID)
+	if err != nil {
+		zapLogger.Error("获取丹药效果失败", zap.Uint("userID", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    buffs,
+	})
+}
@@ -0,0 +1,130 @@
+package worldboss
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	worldbossSvc "xiuxian/server-go/internal/worldboss"
+)
+
+// GetSchedule 获取世界首领活动安排
+// GET /api/worldboss/schedule
+func GetSchedule(c *gin.Context) {
+	now := time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"events":             worldbossSvc.GetSchedule(now),
+			"activeEvent":        worldbossSvc.GetActiveEvent(now),
+			"maxAttacksPerEvent": worldbossSvc.MaxAttacksPerEvent,
+			"maxRoundsPerAttack": worldbossSvc.MaxRoundsPerAttack,
+		},
+	})
+}
+
+// GetStatus 获取当前世界首领状态及玩家个人数据
+// GET /api/worldboss/status
+func GetStatus(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	status, err := worldbossSvc.NewWorldBossService(userID).GetStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取世界首领状态失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    status,
+	})
+}
+
+// Attack 挑战世界首领
+// POST /api/worldboss/attack
+func Attack(c *gin.Context) {
+	userIDInterface, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": "未授权"})
+		return
+	}
+	userID := userIDInterface.(uint)
+
+	logger, _ := c.Get("zap_logger")
+	zapLogger := logger.(*zap.Logger)
+	zapLogger.Info("WorldBossAttack 入参", zap.Uint("userID", userID))
+
+	result, err := worldbossSvc.NewWorldBossService(userID).Attack()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	zapLogger.Info("WorldBossAttack 出参",
+		zap.Uint("userID", userID),
+		zap.String("eventID", result.EventID),
+		zap.Int64("damage", result.Damage),
+		zap.Int64("bossHealth", result.BossHealth),
+		zap.Bool("isLastHit", result.IsLastHit))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    result,
+	})
+}
+
+// GetDamageLeaderboard 获取实时伤害排行
+// GET /api/worldboss/leaderboard?eventId=xxx
+func GetDamageLeaderboard(c *gin.Context) {
+	eventID := c.Query("eventId")
+	if eventID == "" {
+		now := time.Now()
+		event := worldbossSvc.GetActiveEvent(now)
+		if event == nil {
+			event = worldbossSvc.GetLatestEvent(now)
+		}
+		if event == nil {
+			c.JSON(http.StatusOK, gin.H{"success": true, "data": []worldbossSvc.DamageEntry{}})
+			return
+		}
+		eventID = event.ID
+	} else if _, err := worldbossSvc.GetEventByID(eventID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	entries, err := worldbossSvc.GetDamageLeaderboard(eventID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": "获取伤害排行失败",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"eventId": eventID,
+			"entries": entries,
+		},
+	})
+}
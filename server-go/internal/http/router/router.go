@@ -4,13 +4,17 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"xiuxian/server-go/internal/http/handlers/alchemy"
+	"xiuxian/server-go/internal/http/handlers/announcement"
 	"xiuxian/server-go/internal/http/handlers/auth"
 	"xiuxian/server-go/internal/http/handlers/cultivation"
 	"xiuxian/server-go/internal/http/handlers/duel"
+	"xiuxian/server-go/internal/http/handlers/dungeon"
 	"xiuxian/server-go/internal/http/handlers/exploration"
 	"xiuxian/server-go/internal/http/handlers/gacha"
+	"xiuxian/server-go/internal/http/handlers/garden"
 	"xiuxian/server-go/internal/http/handlers/online"
 	"xiuxian/server-go/internal/http/handlers/player"
+	"xiuxian/server-go/internal/http/handlers/worldboss"
 	"xiuxian/server-go/internal/http/middleware"
 )
 
@@ -65,8 +69,15 @@ func RegisterRoutes(r *gin.Engine) {
 		playerGroup.DELETE("/equipment/:id", player.SellEquipment)
 		playerGroup.POST("/equipment/batch-sell", player.BatchSellEquipment)
 
+		// 宝石镶嵌
+		playerGroup.GET("/gems", player.GetGems)
+		playerGroup.POST("/gems/combine", player.CombineGems)
+		playerGroup.POST("/equipment/:id/socket", player.SocketGem)
+		playerGroup.POST("/equipment/:id/unsocket", player.UnsocketGem)
+
 		// 丹药系统
 		playerGroup.POST("/pills/:id/consume", player.ConsumePill)
+		playerGroup.GET("/buffs", player.GetActiveBuffs)
 
 		// 灵宠系统
 		playerGroup.POST("/pets/:id/deploy", player.DeployPet)
@@ -113,10 +124,38 @@ func RegisterRoutes(r *gin.Engine) {
 		cultivationGroup.POST("/single", cultivation.SingleCultivate)
 		// cultivationGroup.POST("/auto", cultivation.AutoCultivate)
 		cultivationGroup.GET("/data", cultivation.GetCultivationData)
+		cultivationGroup.GET("/idle", cultivation.GetIdleCultivation)          // 预览挂机修为
+		cultivationGroup.POST("/idle/claim", cultivation.ClaimIdleCultivation) // 领取挂机修为
 		// ✅ 新增：聚灵阵相关路由
 		cultivationGroup.POST("/formation", cultivation.UseFormation)
 		// ✅ 新增：结婴突破路由
 		cultivationGroup.POST("/breakthrough-jieying", cultivation.BreakthroughJieYing)
+		cultivationGroup.GET("/tribulation", cultivation.GetTribulation)      // 渡劫信息
+		cultivationGroup.POST("/tribulation", cultivation.AttemptTribulation) // 大境界渡劫突破
+		// 天劫之战：逐波抵挡雷劫，抵挡越多成功率越高
+		cultivationGroup.POST("/tribulation/battle/start", cultivation.StartTribulationBattle)
+		cultivationGroup.POST("/tribulation/battle/aid", cultivation.UseTribulationAid)
+		cultivationGroup.POST("/tribulation/battle/wave", cultivation.FightTribulationWave)
+		cultivationGroup.POST("/tribulation/battle/end", cultivation.EndTribulationBattle)
+		// 功法：主修提供修炼速度，辅修提供部分属性
+		cultivationGroup.GET("/techniques", cultivation.GetTechniques)
+		cultivationGroup.GET("/techniques/shop", cultivation.GetTechniqueShop)
+		cultivationGroup.POST("/techniques/buy", cultivation.BuyTechnique)
+		cultivationGroup.POST("/techniques/equip", cultivation.EquipTechnique)
+		cultivationGroup.POST("/techniques/unequip", cultivation.UnequipTechnique)
+		// 闭关：期间无法探索与斗法，期满出关领取修为
+		cultivationGroup.GET("/retreat", cultivation.GetRetreat)
+		cultivationGroup.POST("/retreat/start", cultivation.StartRetreat)
+		cultivationGroup.POST("/retreat/claim", cultivation.ClaimRetreat)
+		// 阵法：消耗灵石布阵，限时提供灵力、修为、炼丹或护体加成
+		cultivationGroup.GET("/formations", cultivation.GetFormations)
+		cultivationGroup.POST("/formations/upgrade", cultivation.UpgradeFormation)
+		cultivationGroup.POST("/formations/activate", cultivation.ActivateFormation)
+		// 双修：两名在线玩家互相邀请，期满后双方同时获得修为
+		cultivationGroup.GET("/dual", cultivation.GetDualCultivation)
+		cultivationGroup.POST("/dual/invite", cultivation.InviteDualCultivation)
+		cultivationGroup.POST("/dual/accept", cultivation.AcceptDualCultivation)
+		cultivationGroup.POST("/dual/claim", cultivation.ClaimDualCultivation)
 	}
 
 	// /api/alchemy 路由
@@ -128,6 +167,8 @@ func RegisterRoutes(r *gin.Engine) {
 		alchemyGroup.GET("/recipes/:recipeId", alchemy.GetRecipeDetail)
 		alchemyGroup.POST("/craft", alchemy.CraftPill)
 		alchemyGroup.POST("/buy-fragment", alchemy.BuyFragment)
+		alchemyGroup.POST("/experiment", alchemy.Experiment)
+		alchemyGroup.GET("/discoveries", alchemy.GetDiscoveries)
 	}
 
 	// /api/duel 路由
@@ -154,8 +195,56 @@ func RegisterRoutes(r *gin.Engine) {
 		duelGroup.POST("/start-pve", duel.StartPvEBattle)
 		duelGroup.POST("/execute-pve-round", duel.ExecutePvERound)
 		duelGroup.POST("/end-pve", duel.EndPvEBattle)
+		duelGroup.POST("/sweep-pve", duel.SweepPvE) // 扫荡已3星通关的妖兽
 		// 除魔卫道相关（新增）
 		duelGroup.GET("/demon-slaying-challenges", duel.GetDemonSlayingChallenges) // 获取除魔卫道挑战列表
+		duelGroup.GET("/chapters", duel.GetPvEChapters)                            // 获取章节星级进度
+		duelGroup.POST("/claim-star-chest", duel.ClaimStarChest)                   // 领取章节星级宝箱
+	}
+
+	// /api/dungeon 路由（秘境挑战）
+	dungeonGroup := r.Group("/api/dungeon")
+	{
+		dungeonGroup.GET("/leaderboard", dungeon.GetLeaderboard) // 秘境积分排行榜公开访问
+
+		dungeonGroup.Use(middleware.Protect())
+		dungeonGroup.GET("/run", dungeon.GetCurrentRun)
+		dungeonGroup.POST("/start", dungeon.StartRun)
+		dungeonGroup.POST("/fight", dungeon.FightFloor)
+		dungeonGroup.POST("/select-buff", dungeon.SelectBuff)
+		dungeonGroup.POST("/abandon", dungeon.AbandonRun)
+	}
+
+	// /api/worldboss 路由（世界首领）
+	worldBossGroup := r.Group("/api/worldboss")
+	{
+		worldBossGroup.GET("/schedule", worldboss.GetSchedule)
+		worldBossGroup.GET("/leaderboard", worldboss.GetDamageLeaderboard) // 实时伤害排行公开访问
+
+		worldBossGroup.Use(middleware.Protect())
+		worldBossGroup.GET("/status", worldboss.GetStatus)
+		worldBossGroup.POST("/attack", worldboss.Attack)
+	}
+
+	// /api/announcements 路由（全服公告）
+	announcementGroup := r.Group("/api/announcements")
+	{
+		announcementGroup.Use(middleware.Protect())
+		announcementGroup.GET("", announcement.GetRecent)
+	}
+
+	// /api/garden 路由（药园）
+	gardenGroup := r.Group("/api/garden")
+	{
+		gardenGroup.Use(middleware.Protect())
+		gardenGroup.GET("", garden.GetGarden)
+		gardenGroup.GET("/:userId", garden.GetUserGarden)
+		gardenGroup.POST("/unlock", garden.UnlockPlot)
+		gardenGroup.POST("/upgrade", garden.UpgradePlot)
+		gardenGroup.POST("/plant", garden.Plant)
+		gardenGroup.POST("/harvest", garden.Harvest)
+		gardenGroup.POST("/water", garden.Water)
+		gardenGroup.POST("/protect", garden.Protect)
 	}
 
 	// 谊测端点 (有效期内不需要认证)
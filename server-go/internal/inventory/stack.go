@@ -0,0 +1,109 @@
+package inventory
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/models"
+)
+
+// DefaultQuality 未指定品质的灵草与丹药按普通品质堆叠
+const DefaultQuality = "common"
+
+func normalizeQuality(quality string) string {
+	if quality == "" {
+		return DefaultQuality
+	}
+	return quality
+}
+
+// stackOnConflict 命中唯一堆叠键时累加数量
+func stackOnConflict(table string, columns ...string) clause.OnConflict {
+	conflict := clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count": gorm.Expr(fmt.Sprintf("%q.count + EXCLUDED.count", table)),
+		}),
+	}
+	for _, column := range columns {
+		conflict.Columns = append(conflict.Columns, clause.Column{Name: column})
+	}
+	return conflict
+}
+
+// AddHerbs 按 (灵草, 品质) 堆叠增加灵草
+func AddHerbs(tx *gorm.DB, userID uint, herbID, name, quality string, count int) error {
+	herb := models.Herb{
+		UserID:  userID,
+		HerbID:  herbID,
+		Name:    name,
+		Count:   count,
+		Quality: normalizeQuality(quality),
+	}
+	if err := tx.Clauses(stackOnConflict("herbs", "user_id", "herb_id", "quality")).Create(&herb).Error; err != nil {
+		return fmt.Errorf("增加灵草失败: %w", err)
+	}
+	return nil
+}
+
+// AddPills 按 (丹药, 品质) 堆叠增加丹药，pill.Count 为增加数量，返回后 pill.ID 为堆叠ID
+func AddPills(tx *gorm.DB, pill *models.Pill) error {
+	pill.Quality = normalizeQuality(pill.Quality)
+	if pill.Count <= 0 {
+		pill.Count = 1
+	}
+	if err := tx.Clauses(stackOnConflict("pills", "user_id", "pill_id", "quality")).Create(pill).Error; err != nil {
+		return fmt.Errorf("增加丹药失败: %w", err)
+	}
+	return nil
+}
+
+// AddPillFragments 增加丹方残页，返回增加后的残页数量
+func AddPillFragments(tx *gorm.DB, userID uint, recipeID string, count int) (int, error) {
+	fragment := models.PillFragment{UserID: userID, RecipeID: recipeID, Count: count}
+	if err := tx.Clauses(stackOnConflict("pill_fragments", "user_id", "recipe_id")).Create(&fragment).Error; err != nil {
+		return 0, fmt.Errorf("增加丹方残页失败: %w", err)
+	}
+	// 冲突更新时 Create 回填的仍是本次数量，重新读取累计值
+	if err := tx.Model(&models.PillFragment{}).Where("user_id = ? AND recipe_id = ?", userID, recipeID).
+		Select("count").Scan(&fragment.Count).Error; err != nil {
+		return 0, fmt.Errorf("获取丹方残页失败: %w", err)
+	}
+	return fragment.Count, nil
+}
+
+// TakePills 从已锁定的丹药堆叠中扣除数量，扣完则删除该堆叠
+func TakePills(tx *gorm.DB, pill *models.Pill, count int) error {
+	if pill.Count < count {
+		return fmt.Errorf("丹药数量不足: 拥有 %d, 需要 %d", pill.Count, count)
+	}
+	pill.Count -= count
+	if pill.Count == 0 {
+		return tx.Delete(pill).Error
+	}
+	return tx.Model(pill).Update("count", pill.Count).Error
+}
+
+// AddGems 按 (宝石, 阶数) 堆叠增加宝石，gem.Count 为增加数量，返回后 gem.ID 为堆叠ID
+func AddGems(tx *gorm.DB, gem *models.Gem) error {
+	if gem.Count <= 0 {
+		gem.Count = 1
+	}
+	if err := tx.Clauses(stackOnConflict("gems", "user_id", "gem_id", "tier")).Create(gem).Error; err != nil {
+		return fmt.Errorf("增加宝石失败: %w", err)
+	}
+	return nil
+}
+
+// TakeGems 从已锁定的宝石堆叠中扣除数量，扣完则删除该堆叠
+func TakeGems(tx *gorm.DB, gem *models.Gem, count int) error {
+	if gem.Count < count {
+		return fmt.Errorf("宝石数量不足: 拥有 %d, 需要 %d", gem.Count, count)
+	}
+	gem.Count -= count
+	if gem.Count == 0 {
+		return tx.Delete(gem).Error
+	}
+	return tx.Model(gem).Update("count", gem.Count).Error
+}
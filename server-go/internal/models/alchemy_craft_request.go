@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// AlchemyCraftRequest 炼制请求幂等记录，与炼制在同一事务内写入，每位玩家每个幂等键仅一条
+type AlchemyCraftRequest struct {
+	ID             uint           `gorm:"primaryKey;column:id" json:"id"`
+	UserID         uint           `gorm:"column:user_id" json:"userId"`
+	IdempotencyKey string         `gorm:"column:idempotency_key" json:"idempotencyKey"`
+	RecipeID       string         `gorm:"column:recipe_id" json:"recipeId"`
+	Result         datatypes.JSON `gorm:"column:result" json:"result"` // 首次炼制结果
+	CreatedAt      time.Time      `gorm:"column:created_at" json:"createdAt"`
+}
+
+func (AlchemyCraftRequest) TableName() string {
+	return "alchemy_craft_requests"
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// AlchemyExperiment 玩家自由配伍试炼丹方的记录
+type AlchemyExperiment struct {
+	ID         uint           `gorm:"primaryKey;column:id" json:"id"`
+	UserID     uint           `gorm:"column:user_id" json:"userId"`
+	Herbs      datatypes.JSON `gorm:"column:herbs" json:"herbs"`             // 投入的灵草 [{herbId, count}]
+	Outcome    string         `gorm:"column:outcome" json:"outcome"`         // discovered, hint, waste
+	RecipeID   string         `gorm:"column:recipe_id" json:"recipeId"`      // 悟出或获得提示的丹方
+	HintHerbID string         `gorm:"column:hint_herb_id" json:"hintHerbId"` // 提示揭示的灵草
+	CreatedAt  time.Time      `gorm:"column:created_at" json:"createdAt"`
+}
+
+func (AlchemyExperiment) TableName() string {
+	return "alchemy_experiments"
+}
+
+// RecipeDiscovery 全服首位通过试炼悟出丹方的玩家
+type RecipeDiscovery struct {
+	RecipeID     string    `gorm:"primaryKey;column:recipe_id" json:"recipeId"`
+	UserID       uint      `gorm:"column:user_id" json:"userId"`
+	PlayerName   string    `gorm:"column:player_name" json:"playerName"`
+	DiscoveredAt time.Time `gorm:"column:discovered_at" json:"discoveredAt"`
+}
+
+func (RecipeDiscovery) TableName() string {
+	return "recipe_discoveries"
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// DualCultivationBond 双修道侣羁绊（UserAID 为较小的玩家ID）
+type DualCultivationBond struct {
+	ID            uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserAID       uint      `gorm:"column:user_a_id" json:"userAId"`
+	UserBID       uint      `gorm:"column:user_b_id" json:"userBId"`
+	Sessions      int       `gorm:"column:sessions" json:"sessions"` // 累计完成的双修次数
+	LastSessionAt time.Time `gorm:"column:last_session_at" json:"lastSessionAt"`
+	CreatedAt     time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt     time.Time `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+func (DualCultivationBond) TableName() string {
+	return "dual_cultivation_bonds"
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// DungeonProgress 秘境进度追踪
+type DungeonProgress struct {
+	ID                 uint           `gorm:"primaryKey;column:id"`
+	UserID             uint           `gorm:"column:user_id"`
+	CurrentFloor       int            `gorm:"column:current_floor"`
+	MaxFloorReached    int            `gorm:"column:max_floor_reached"`
+	CurrentDifficulty  string         `gorm:"column:current_difficulty"`
+	TotalRuns          int            `gorm:"column:total_runs"`
+	TotalVictories     int            `gorm:"column:total_victories"`
+	TotalLosses        int            `gorm:"column:total_losses"`
+	TotalRewardsEarned datatypes.JSON `gorm:"column:total_rewards_earned"`
+	CreatedAt          time.Time      `gorm:"column:created_at"`
+	UpdatedAt          time.Time      `gorm:"column:updated_at"`
+}
+
+func (DungeonProgress) TableName() string {
+	return "dungeon_progress"
+}
+
+// DungeonBuff 秘境增益选择记录
+type DungeonBuff struct {
+	ID           uint           `gorm:"primaryKey;column:id"`
+	UserID       uint           `gorm:"column:user_id"`
+	DungeonRunID string         `gorm:"column:dungeon_run_id"`
+	Floor        int            `gorm:"column:floor"`
+	BuffID       string         `gorm:"column:buff_id"`
+	BuffName     string         `gorm:"column:buff_name"`
+	BuffType     string         `gorm:"column:buff_type"`
+	BuffEffects  datatypes.JSON `gorm:"column:buff_effects"`
+	SelectedAt   time.Time      `gorm:"column:selected_at"`
+}
+
+func (DungeonBuff) TableName() string {
+	return "dungeon_buffs"
+}
@@ -26,6 +26,11 @@ type Equipment struct {
 	Description   *string `gorm:"column:description"`
 	RequiredRealm int     `gorm:"column:required_realm"`
 	Level         int     `gorm:"column:level"`
+
+	// 所属套装ID，空表示非套装部件
+	SetID string `gorm:"column:set_id"`
+	// 已镶嵌的宝石，[]SocketedGem
+	Gems datatypes.JSON `gorm:"column:gems"`
 }
 
 func (Equipment) TableName() string {
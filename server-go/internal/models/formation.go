@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// UserFormation 玩家阵法等级
+type UserFormation struct {
+	ID          uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserID      uint      `gorm:"column:user_id" json:"userId"`
+	FormationID string    `gorm:"column:formation_id" json:"formationId"`
+	Level       int       `gorm:"column:level" json:"level"`
+	CreatedAt   time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt   time.Time `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+func (UserFormation) TableName() string {
+	return "user_formations"
+}
+
+// ActiveFormation 玩家已布下的阵法（到期失效）
+type ActiveFormation struct {
+	ID          uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserID      uint      `gorm:"column:user_id" json:"userId"`
+	FormationID string    `gorm:"column:formation_id" json:"formationId"`
+	Effect      string    `gorm:"column:effect" json:"effect"`
+	Level       int       `gorm:"column:level" json:"level"`
+	Value       float64   `gorm:"column:value" json:"value"` // 布阵时的效果数值
+	ExpiresAt   time.Time `gorm:"column:expires_at" json:"expiresAt"`
+	CreatedAt   time.Time `gorm:"column:created_at" json:"createdAt"`
+}
+
+func (ActiveFormation) TableName() string {
+	return "active_formations"
+}
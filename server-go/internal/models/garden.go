@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// GardenPlot 玩家药园中的一块灵田
+type GardenPlot struct {
+	ID          uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserID      uint      `gorm:"column:user_id" json:"userId"`
+	PlotIndex   int       `gorm:"column:plot_index" json:"plotIndex"`
+	Level       int       `gorm:"column:level" json:"level"`
+	HerbID      string    `gorm:"column:herb_id" json:"herbId"`           // 种植中的灵草，空表示闲置
+	Fertilizer  string    `gorm:"column:fertilizer" json:"fertilizer"`    // 本轮使用的灵肥
+	PlantedAt   time.Time `gorm:"column:planted_at" json:"plantedAt"`     // 种下时间
+	ReadyAt     time.Time `gorm:"column:ready_at" json:"readyAt"`         // 成熟时间
+	WateredBy   uint      `gorm:"column:watered_by" json:"wateredBy"`     // 本轮浇灌的道友
+	ProtectedBy uint      `gorm:"column:protected_by" json:"protectedBy"` // 本轮护法的道友
+	CreatedAt   time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt   time.Time `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+func (GardenPlot) TableName() string {
+	return "garden_plots"
+}
@@ -0,0 +1,26 @@
+package models
+
+// Gem 背包中的宝石，按 (宝石, 阶数) 堆叠
+type Gem struct {
+	ID     uint   `gorm:"primaryKey;column:id" json:"id"`
+	UserID uint   `gorm:"column:user_id" json:"userId"`
+	GemID  string `gorm:"column:gem_id" json:"gemId"`
+	Name   string `gorm:"column:name" json:"name"`
+	Stat   string `gorm:"column:stat" json:"stat"` // 镶嵌后提供的属性
+	Tier   int    `gorm:"column:tier" json:"tier"`
+	Count  int    `gorm:"column:count" json:"count"`
+}
+
+func (Gem) TableName() string {
+	return "gems"
+}
+
+// SocketedGem 镶嵌在装备孔位中的宝石，存于 Equipment.Gems
+type SocketedGem struct {
+	Socket int     `json:"socket"`
+	GemID  string  `json:"gemId"`
+	Name   string  `json:"name"`
+	Stat   string  `json:"stat"`
+	Tier   int     `json:"tier"`
+	Value  float64 `json:"value"`
+}
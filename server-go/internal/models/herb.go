@@ -15,3 +15,15 @@ type Herb struct {
 func (Herb) TableName() string {
 	return "herbs"
 }
+
+// HerbQualities 灵草品质配置，探索产出、背包展示与炼丹共用；value 为品质倍数
+var HerbQualities = map[string]map[string]interface{}{
+	"common":    {"name": "普通", "value": 1.0},
+	"uncommon":  {"name": "优质", "value": 1.5},
+	"rare":      {"name": "稀有", "value": 2.0},
+	"epic":      {"name": "极品", "value": 3.0},
+	"legendary": {"name": "仙品", "value": 5.0},
+}
+
+// HerbQualityOrder 灵草品质从低到高的顺序
+var HerbQualityOrder = []string{"common", "uncommon", "rare", "epic", "legendary"}
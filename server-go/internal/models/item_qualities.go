@@ -5,6 +5,7 @@ type QualityConfig struct {
 	Color      string
 	StatMod    float64
 	MaxStatMod *float64 // 只对装备有效
+	Sockets    int      // 只对装备有效：宝石孔数
 	ExpMod     *float64 // 只对灵宠有效
 }
 
@@ -16,36 +17,42 @@ var (
 			Color:      "#9e9e9e",
 			StatMod:    1.0,
 			MaxStatMod: float64Ptr(1.5),
+			Sockets:    0,
 		},
 		"uncommon": {
 			Name:       "法器",
 			Color:      "#4caf50",
 			StatMod:    1.2,
 			MaxStatMod: float64Ptr(2.0),
+			Sockets:    1,
 		},
 		"rare": {
 			Name:       "灵器",
 			Color:      "#2196f3",
 			StatMod:    1.5,
 			MaxStatMod: float64Ptr(2.5),
+			Sockets:    1,
 		},
 		"epic": {
 			Name:       "极品灵器",
 			Color:      "#9c27b0",
 			StatMod:    2.0,
 			MaxStatMod: float64Ptr(3.0),
+			Sockets:    2,
 		},
 		"legendary": {
 			Name:       "伪仙器",
 			Color:      "#ff9800",
 			StatMod:    2.5,
 			MaxStatMod: float64Ptr(3.5),
+			Sockets:    3,
 		},
 		"mythic": {
 			Name:       "仙器",
 			Color:      "#e91e63",
 			StatMod:    3.0,
 			MaxStatMod: float64Ptr(4.0),
+			Sockets:    4,
 		},
 	}
 
@@ -27,6 +27,9 @@ type Pet struct {
 	AttackBonus  float64 `gorm:"column:attack_bonus"`
 	DefenseBonus float64 `gorm:"column:defense_bonus"`
 	HealthBonus  float64 `gorm:"column:health_bonus"`
+
+	// 所属套装ID，出战时计入套装件数，空表示非套装部件
+	SetID string `gorm:"column:set_id"`
 }
 
 func (Pet) TableName() string {
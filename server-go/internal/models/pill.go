@@ -13,6 +13,8 @@ type Pill struct {
 
 	Description string         `gorm:"column:description"`
 	Effect      datatypes.JSON `gorm:"column:effect"`
+	Quality     string         `gorm:"column:quality"` // 丹药品质: common, uncommon, rare, epic, legendary
+	Count       int            `gorm:"column:count"`   // 堆叠数量，同一丹药同一品质合并为一条记录
 }
 
 func (Pill) TableName() string {
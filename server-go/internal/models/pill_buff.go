@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ActivePillBuff 玩家服用丹药获得的限时增益（到期失效）
+type ActivePillBuff struct {
+	ID        uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserID    uint      `gorm:"column:user_id" json:"userId"`
+	BuffID    string    `gorm:"column:buff_id" json:"buffId"` // 丹药ID，同一丹药按叠加规则处理
+	Effect    string    `gorm:"column:effect" json:"effect"`  // 效果类型
+	Value     float64   `gorm:"column:value" json:"value"`    // 效果数值
+	ExpiresAt time.Time `gorm:"column:expires_at" json:"expiresAt"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"createdAt"`
+}
+
+func (ActivePillBuff) TableName() string {
+	return "active_pill_buffs"
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// PvEClear 玩家妖兽/除魔卫道通关记录
+type PvEClear struct {
+	ID                uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserID            uint      `gorm:"column:user_id" json:"userId"`
+	MonsterID         int       `gorm:"column:monster_id" json:"monsterId"`
+	MonsterName       string    `gorm:"column:monster_name" json:"monsterName"`
+	Difficulty        string    `gorm:"column:difficulty" json:"difficulty"`
+	BestStars         int       `gorm:"column:best_stars" json:"bestStars"`
+	BestRounds        int       `gorm:"column:best_rounds" json:"bestRounds"`
+	BestHealthPercent float64   `gorm:"column:best_health_percent" json:"bestHealthPercent"`
+	ClearCount        int       `gorm:"column:clear_count" json:"clearCount"`
+	FirstClearedAt    time.Time `gorm:"column:first_cleared_at" json:"firstClearedAt"`
+	LastClearedAt     time.Time `gorm:"column:last_cleared_at" json:"lastClearedAt"`
+}
+
+func (PvEClear) TableName() string {
+	return "pve_clears"
+}
+
+// PvEStarChest 章节星级宝箱领取记录
+type PvEStarChest struct {
+	ID        uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserID    uint      `gorm:"column:user_id" json:"userId"`
+	ChapterID string    `gorm:"column:chapter_id" json:"chapterId"`
+	Stars     int       `gorm:"column:stars" json:"stars"`
+	ClaimedAt time.Time `gorm:"column:claimed_at" json:"claimedAt"`
+}
+
+func (PvEStarChest) TableName() string {
+	return "pve_star_chests"
+}
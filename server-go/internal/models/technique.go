@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// UserTechnique 玩家已习得的功法
+type UserTechnique struct {
+	ID          uint      `gorm:"primaryKey;column:id" json:"id"`
+	UserID      uint      `gorm:"column:user_id" json:"userId"`
+	TechniqueID string    `gorm:"column:technique_id" json:"techniqueId"`
+	Level       int       `gorm:"column:level" json:"level"`
+	Exp         int       `gorm:"column:exp" json:"exp"`
+	Slot        string    `gorm:"column:slot" json:"slot"` // main 主修 / aux 辅修 / 空为未运转
+	CreatedAt   time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt   time.Time `gorm:"column:updated_at" json:"updatedAt"`
+}
+
+func (UserTechnique) TableName() string {
+	return "user_techniques"
+}
@@ -8,7 +8,9 @@ type UserAlchemyDataDB struct {
 	PillsCrafted    int     `gorm:"column:pills_crafted"`    // 总炼制次数
 	PillsConsumed   int     `gorm:"column:pills_consumed"`   // 总服用次数
 	AlchemyLevel    int     `gorm:"column:alchemy_level"`    // 炼丹等级
+	AlchemyExp      int     `gorm:"column:alchemy_exp"`      // 当前等级的炼丹经验
 	AlchemyRate     float64 `gorm:"column:alchemy_rate"`     // 炼丹加成率
+	RecipeHints     string  `gorm:"column:recipe_hints"`     // JSON格式存储试炼获得的丹方提示 {recipeId: [herbId]}
 }
 
 func (UserAlchemyDataDB) TableName() string {
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// WorldBossReward 世界首领活动奖励发放记录，每位玩家每场活动仅一条
+type WorldBossReward struct {
+	ID           uint      `gorm:"primaryKey;column:id" json:"id"`
+	EventID      string    `gorm:"column:event_id" json:"eventId"`
+	UserID       uint      `gorm:"column:user_id" json:"userId"`
+	Rank         int64     `gorm:"column:rank" json:"rank"`
+	Damage       int64     `gorm:"column:damage" json:"damage"`
+	LastHit      bool      `gorm:"column:last_hit" json:"lastHit"`
+	SpiritStones int       `gorm:"column:spirit_stones" json:"spiritStones"`
+	Cultivation  int       `gorm:"column:cultivation" json:"cultivation"`
+	CreatedAt    time.Time `gorm:"column:created_at" json:"createdAt"`
+}
+
+func (WorldBossReward) TableName() string {
+	return "world_boss_rewards"
+}
@@ -0,0 +1,59 @@
+package pillbuff
+
+import "time"
+
+// 限时丹药效果类型
+const (
+	EffectCultivationRate = "cultivationRate" // 修炼修为获取提升（比例）
+	EffectExplorationLuck = "explorationLuck" // 历练幸运提升
+	EffectCritRate        = "critRate"        // 战斗暴击率提升
+	EffectAttackBoost     = "attackBoost"     // 战斗攻击提升（比例）
+)
+
+// 叠加规则
+const (
+	StackRefresh = "refresh" // 重复服用刷新持续时间，数值取较高者
+	StackExtend  = "extend"  // 重复服用延长持续时间，不超过上限
+	StackAdd     = "stack"   // 每次服用独立生效，数值叠加，不超过层数上限
+)
+
+// BuffConfig 限时丹药效果配置，按丹药ID配置
+type BuffConfig struct {
+	PillID      string        `json:"pillId"`
+	Name        string        `json:"name"`
+	Effect      string        `json:"effect"`
+	Stacking    string        `json:"stacking"`
+	MaxStacks   int           `json:"maxStacks,omitempty"`   // StackAdd 的层数上限
+	MaxDuration time.Duration `json:"maxDuration,omitempty"` // StackExtend 的剩余时长上限
+}
+
+var buffConfigs = map[string]BuffConfig{
+	"enlightenment_pill": {PillID: "enlightenment_pill", Name: "悟道丹", Effect: EffectCultivationRate, Stacking: StackRefresh},
+	"battle_fury_pill":   {PillID: "battle_fury_pill", Name: "破军丹", Effect: EffectCritRate, Stacking: StackRefresh},
+	"fortune_pill":       {PillID: "fortune_pill", Name: "寻宝丹", Effect: EffectExplorationLuck, Stacking: StackExtend, MaxDuration: 3 * time.Hour},
+	"tiger_might_pill":   {PillID: "tiger_might_pill", Name: "虎威丹", Effect: EffectAttackBoost, Stacking: StackAdd, MaxStacks: 3},
+}
+
+// GetBuffConfig 获取丹药的限时效果配置，非限时丹药返回 nil
+func GetBuffConfig(pillID string) *BuffConfig {
+	if config, ok := buffConfigs[pillID]; ok {
+		return &config
+	}
+	return nil
+}
+
+// EffectName 效果类型的显示名称
+func EffectName(effect string) string {
+	switch effect {
+	case EffectCultivationRate:
+		return "修炼速度"
+	case EffectExplorationLuck:
+		return "历练幸运"
+	case EffectCritRate:
+		return "暴击率"
+	case EffectAttackBoost:
+		return "攻击"
+	default:
+		return "未知效果"
+	}
+}
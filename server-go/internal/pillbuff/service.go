@@ -0,0 +1,129 @@
+package pillbuff
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/models"
+)
+
+// BuffView 生效中的限时效果
+type BuffView struct {
+	models.ActivePillBuff
+	Name             string `json:"name"`             // 丹药名称
+	EffectName       string `json:"effectName"`       // 效果名称
+	RemainingSeconds int64  `json:"remainingSeconds"` // 剩余秒数
+}
+
+// GetActiveEffect 获取玩家某一类限时效果的当前数值
+func GetActiveEffect(userID uint, effect string) float64 {
+	var total float64
+	if err := db.DB.Model(&models.ActivePillBuff{}).
+		Where("user_id = ? AND effect = ? AND expires_at > ?", userID, effect, time.Now()).
+		Select("COALESCE(SUM(value), 0)").Scan(&total).Error; err != nil {
+		log.Printf("[PillBuff] 查询丹药效果失败: %v", err)
+		return 0
+	}
+	return total
+}
+
+// GetActiveBuffs 获取玩家生效中的限时效果及剩余时间
+func GetActiveBuffs(userID uint) ([]BuffView, error) {
+	now := time.Now()
+	var active []models.ActivePillBuff
+	if err := db.DB.Where("user_id = ? AND expires_at > ?", userID, now).
+		Order("expires_at").Find(&active).Error; err != nil {
+		return nil, fmt.Errorf("failed to get active buffs: %w", err)
+	}
+
+	views := make([]BuffView, 0, len(active))
+	for _, a := range active {
+		view := BuffView{
+			ActivePillBuff:   a,
+			EffectName:       EffectName(a.Effect),
+			RemainingSeconds: int64(a.ExpiresAt.Sub(now).Seconds()),
+		}
+		if config := GetBuffConfig(a.BuffID); config != nil {
+			view.Name = config.Name
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// ApplyBuff 在事务内为玩家添加限时效果，按丹药的叠加规则处理
+func ApplyBuff(tx *gorm.DB, userID uint, config *BuffConfig, value float64, duration time.Duration) (*models.ActivePillBuff, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("丹药没有持续时间")
+	}
+	now := time.Now()
+
+	// 先锁定用户行串行化同一玩家的服用：尚无生效记录时 FOR UPDATE 锁不到任何行，
+	// 并发请求会同时通过层数检查或各自新建记录
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	var active []models.ActivePillBuff
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND buff_id = ? AND expires_at > ?", userID, config.PillID, now).
+		Order("expires_at DESC").Find(&active).Error; err != nil {
+		return nil, fmt.Errorf("failed to get active buffs: %w", err)
+	}
+
+	// 顺手清理该丹药已过期的记录
+	if err := tx.Where("user_id = ? AND buff_id = ? AND expires_at <= ?", userID, config.PillID, now).
+		Delete(&models.ActivePillBuff{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to clean expired buffs: %w", err)
+	}
+
+	if config.Stacking == StackAdd || len(active) == 0 {
+		if config.Stacking == StackAdd && config.MaxStacks > 0 && len(active) >= config.MaxStacks {
+			return nil, fmt.Errorf("%s最多叠加 %d 层", config.Name, config.MaxStacks)
+		}
+		buff := &models.ActivePillBuff{
+			UserID:    userID,
+			BuffID:    config.PillID,
+			Effect:    config.Effect,
+			Value:     value,
+			ExpiresAt: now.Add(duration),
+		}
+		if config.Stacking == StackExtend && config.MaxDuration > 0 && duration > config.MaxDuration {
+			buff.ExpiresAt = now.Add(config.MaxDuration)
+		}
+		if err := tx.Create(buff).Error; err != nil {
+			return nil, fmt.Errorf("failed to create buff: %w", err)
+		}
+		return buff, nil
+	}
+
+	buff := &active[0]
+	switch config.Stacking {
+	case StackRefresh:
+		buff.ExpiresAt = now.Add(duration)
+		if value > buff.Value {
+			buff.Value = value
+		}
+	case StackExtend:
+		buff.ExpiresAt = buff.ExpiresAt.Add(duration)
+		if config.MaxDuration > 0 && buff.ExpiresAt.After(now.Add(config.MaxDuration)) {
+			buff.ExpiresAt = now.Add(config.MaxDuration)
+		}
+		if value > buff.Value {
+			buff.Value = value
+		}
+	default:
+		return nil, errors.New("未知的叠加规则")
+	}
+	if err := tx.Save(buff).Error; err != nil {
+		return nil, fmt.Errorf("failed to update buff: %w", err)
+	}
+	return buff, nil
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/formation"
 	"xiuxian/server-go/internal/models"
 	"xiuxian/server-go/internal/redis"
 
@@ -94,7 +95,7 @@ func (m *SpiritGrowManager) processAutoSpiritGrowth() {
 	m.logger.Debug("[灵力自动增长] 开始处理玩家灵力增长",
 		zap.Int("playerCount", len(playerIDStrs)))
 
-	// 处理每个玩家的灵力增长
+	userIDs := make([]uint, 0, len(playerIDStrs))
 	for _, playerIDStr := range playerIDStrs {
 		userID, err := strconv.ParseUint(playerIDStr, 10, 32)
 		if err != nil {
@@ -103,13 +104,20 @@ func (m *SpiritGrowManager) processAutoSpiritGrowth() {
 				zap.Error(err))
 			continue
 		}
+		userIDs = append(userIDs, uint(userID))
+	}
+
+	// 批量读取聚灵阵加成，避免每个玩家单独查询
+	boosts := formation.GetActiveEffectByUsers(userIDs, formation.EffectSpiritBoost)
 
-		m.addAutoSpiritToDatabase(uint(userID))
+	// 处理每个玩家的灵力增长
+	for _, userID := range userIDs {
+		m.addAutoSpiritToDatabase(userID, boosts[userID])
 	}
 }
 
-// addAutoSpiritToDatabase 计算60秒灵力增长并直接写入数据库
-func (m *SpiritGrowManager) addAutoSpiritToDatabase(userID uint) {
+// addAutoSpiritToDatabase 计算60秒灵力增长并直接写入数据库，spiritBoost 为聚灵阵加成
+func (m *SpiritGrowManager) addAutoSpiritToDatabase(userID uint, spiritBoost float64) {
 	// 从数据库获取玩家信息
 	var user models.User
 	if err := db.DB.First(&user, userID).Error; err != nil {
@@ -119,7 +127,7 @@ func (m *SpiritGrowManager) addAutoSpiritToDatabase(userID uint) {
 	}
 
 	// 获取灵力倍率
-	spiritRate := m.getPlayerSpiritRate(&user)
+	spiritRate := m.getPlayerSpiritRate(&user, spiritBoost)
 
 	// 计算60秒灵力增长量（1.0是基础增长速度）
 	spiritGain := 1.0 * spiritRate * 60.0
@@ -157,14 +165,24 @@ func (m *SpiritGrowManager) processOnlinePlayersSpiritGrow() {
 		return
 	}
 
+	// 批量读取聚灵阵加成，避免每个玩家单独查询
+	userIDs := make([]uint, 0, len(onlinePlayerIDs))
+	for _, playerID := range onlinePlayerIDs {
+		if parsedID, err := strconv.ParseUint(playerID, 10, 32); err == nil {
+			userIDs = append(userIDs, uint(parsedID))
+		}
+	}
+	boosts := formation.GetActiveEffectByUsers(userIDs, formation.EffectSpiritBoost)
+
 	// 处理每个在线玩家的灵力增长（直接写入数据库）
 	for _, playerID := range onlinePlayerIDs {
-		m.calculateSpiritGainInDatabase(playerID)
+		parsedID, _ := strconv.ParseUint(playerID, 10, 32)
+		m.calculateSpiritGainInDatabase(playerID, boosts[uint(parsedID)])
 	}
 }
 
 // calculateSpiritGainInDatabase 计算灵力增长并累加到Redis
-func (m *SpiritGrowManager) calculateSpiritGainInDatabase(playerID string) {
+func (m *SpiritGrowManager) calculateSpiritGainInDatabase(playerID string, spiritBoost float64) {
 	// ✅ 检查玩家是否在线
 	exists, err := redis.Client.Exists(redis.Ctx, "player:online:"+playerID).Result()
 	if err != nil || exists == 0 {
@@ -239,7 +257,7 @@ func (m *SpiritGrowManager) calculateSpiritGainInDatabase(playerID string) {
 	}
 
 	// 计算灵力增长
-	spiritRate := m.getPlayerSpiritRate(&user)
+	spiritRate := m.getPlayerSpiritRate(&user, spiritBoost)
 	spiritGain := 1.0 * spiritRate * elapsedSeconds
 
 	// 保留两位小数
@@ -387,8 +405,8 @@ func (m *SpiritGrowManager) SyncPlayerSpiritOnLogout(userID uint) {
 	redis.Client.Del(redis.Ctx, lastGainTimeKey, spiritGainKey)
 }
 
-// getPlayerSpiritRate 获取玩家的灵力倍率
-func (m *SpiritGrowManager) getPlayerSpiritRate(user *models.User) float64 {
+// getPlayerSpiritRate 获取玩家的灵力倍率，spiritBoost 为批量读取的聚灵阵加成
+func (m *SpiritGrowManager) getPlayerSpiritRate(user *models.User, spiritBoost float64) float64 {
 	spiritRate := 1.0
 
 	if user.BaseAttributes != nil && len(user.BaseAttributes) > 0 {
@@ -402,5 +420,8 @@ func (m *SpiritGrowManager) getPlayerSpiritRate(user *models.User) float64 {
 		}
 	}
 
+	// 聚灵阵加成
+	spiritRate *= 1 + spiritBoost
+
 	return spiritRate
 }
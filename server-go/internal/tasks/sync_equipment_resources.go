@@ -28,6 +28,9 @@ func InitTasks(zapLogger *zap.Logger) {
 	// 灵宠资源定期同步任务（同样改为1秒检查频率）
 	StartPetResourcesSyncTask(1 * time.Second)
 
+	// 世界首领活动结束后的奖励结算
+	StartWorldBossSettleTask(30 * time.Second)
+
 	logger.Info("后台同步任务已启动", zap.String("checkInterval", "1秒"), zap.String("syncCondition", "5秒未操作"))
 }
 
@@ -0,0 +1,24 @@
+package tasks
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"xiuxian/server-go/internal/worldboss"
+)
+
+// StartWorldBossSettleTask 启动世界首领结算任务
+// 定期检查已结束的活动，按伤害排名发放奖励（每场活动只结算一次）
+func StartWorldBossSettleTask(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		logger.Info("启动世界首领结算任务", zap.Duration("checkInterval", interval))
+
+		for range ticker.C {
+			worldboss.SettleEndedEvents()
+		}
+	}()
+}
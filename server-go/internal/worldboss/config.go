@@ -0,0 +1,171 @@
+package worldboss
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// 中国时区 (UTC+8)
+var chinaTimezone *time.Location
+
+func init() {
+	var err error
+	chinaTimezone, err = time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		chinaTimezone = time.FixedZone("CST", 8*60*60)
+		log.Printf("[WorldBoss] 使用固定时区 CST (UTC+8)")
+	}
+}
+
+const (
+	// 每场活动每名玩家的挑战次数
+	MaxAttacksPerEvent = 5
+	// 单次挑战的回合上限（限时战斗）
+	MaxRoundsPerAttack = 10
+	// 活动数据在 Redis 中的保留时间
+	EventDataTTL = 48 * time.Hour
+)
+
+// BossConfig 世界首领配置
+type BossConfig struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	MaxHealth   int64   `json:"maxHealth"`
+	Attack      float64 `json:"attack"`
+	Defense     float64 `json:"defense"`
+	Speed       float64 `json:"speed"`
+	CritRate    float64 `json:"critRate"`
+	StunResist  float64 `json:"stunResist"`
+}
+
+// EventSlot 每日活动时段（中国时区）
+type EventSlot struct {
+	Hour     int           `json:"hour"`
+	Minute   int           `json:"minute"`
+	Duration time.Duration `json:"duration"`
+}
+
+// RankReward 排名奖励档位（MaxRank 为 0 表示参与奖）
+type RankReward struct {
+	MaxRank      int64 `json:"maxRank"`
+	SpiritStones int   `json:"spiritStones"`
+	Cultivation  int   `json:"cultivation"`
+}
+
+// bossConfigs 首领按星期轮换（索引为 time.Weekday）
+var bossConfigs = []BossConfig{
+	{ID: "ancient_dragon", Name: "上古蛟龙", Description: "沉睡万年的蛟龙苏醒，掀起滔天巨浪", MaxHealth: 50000000, Attack: 3000, Defense: 800, Speed: 400, CritRate: 0.1, StunResist: 0.5},
+	{ID: "blood_demon_lord", Name: "血魔老祖", Description: "以血炼身的魔道巨擘", MaxHealth: 40000000, Attack: 3500, Defense: 600, Speed: 450, CritRate: 0.15, StunResist: 0.4},
+	{ID: "nine_tailed_fox", Name: "九尾天狐", Description: "修行千年的妖狐，身法飘忽", MaxHealth: 35000000, Attack: 2800, Defense: 500, Speed: 600, CritRate: 0.2, StunResist: 0.3},
+	{ID: "stone_titan", Name: "玄武石巨人", Description: "坚不可摧的远古石灵", MaxHealth: 60000000, Attack: 2200, Defense: 1200, Speed: 200, CritRate: 0.05, StunResist: 0.6},
+	{ID: "thunder_roc", Name: "雷翼鲲鹏", Description: "驭雷而行的上古神禽", MaxHealth: 45000000, Attack: 3200, Defense: 700, Speed: 550, CritRate: 0.12, StunResist: 0.4},
+	{ID: "ghost_king", Name: "幽冥鬼王", Description: "统御万鬼的幽冥之主", MaxHealth: 42000000, Attack: 3000, Defense: 650, Speed: 480, CritRate: 0.15, StunResist: 0.5},
+	{ID: "fire_phoenix", Name: "赤焰火凤", Description: "浴火重生的不死神鸟", MaxHealth: 48000000, Attack: 3300, Defense: 750, Speed: 500, CritRate: 0.12, StunResist: 0.45},
+}
+
+// eventSlots 每日开放时段：午间与夜间各一场
+var eventSlots = []EventSlot{
+	{Hour: 12, Minute: 0, Duration: 30 * time.Minute},
+	{Hour: 20, Minute: 0, Duration: 30 * time.Minute},
+}
+
+// rankRewards 排名奖励（按顺序匹配）
+var rankRewards = []RankReward{
+	{MaxRank: 1, SpiritStones: 30000, Cultivation: 20000},
+	{MaxRank: 3, SpiritStones: 20000, Cultivation: 12000},
+	{MaxRank: 10, SpiritStones: 12000, Cultivation: 8000},
+	{MaxRank: 50, SpiritStones: 6000, Cultivation: 4000},
+	{MaxRank: 0, SpiritStones: 2000, Cultivation: 1000},
+}
+
+// lastHitReward 最后一击奖励
+var lastHitReward = RankReward{SpiritStones: 10000, Cultivation: 5000}
+
+// Event 世界首领活动
+type Event struct {
+	ID      string     `json:"id"`
+	Boss    BossConfig `json:"boss"`
+	StartAt time.Time  `json:"startAt"`
+	EndAt   time.Time  `json:"endAt"`
+}
+
+// IsActive 活动是否进行中
+func (e *Event) IsActive(now time.Time) bool {
+	return !now.Before(e.StartAt) && now.Before(e.EndAt)
+}
+
+// buildEvent 根据日期与时段生成活动
+func buildEvent(day time.Time, slot EventSlot) *Event {
+	start := time.Date(day.Year(), day.Month(), day.Day(), slot.Hour, slot.Minute, 0, 0, chinaTimezone)
+	return &Event{
+		ID:      fmt.Sprintf("%s-%02d%02d", start.Format("20060102"), slot.Hour, slot.Minute),
+		Boss:    bossConfigs[int(start.Weekday())%len(bossConfigs)],
+		StartAt: start,
+		EndAt:   start.Add(slot.Duration),
+	}
+}
+
+// GetSchedule 获取今明两天的活动安排
+func GetSchedule(now time.Time) []*Event {
+	now = now.In(chinaTimezone)
+	events := make([]*Event, 0, len(eventSlots)*2)
+	for d := 0; d < 2; d++ {
+		day := now.AddDate(0, 0, d)
+		for _, slot := range eventSlots {
+			events = append(events, buildEvent(day, slot))
+		}
+	}
+	return events
+}
+
+// GetActiveEvent 获取当前进行中的活动，没有则返回 nil
+func GetActiveEvent(now time.Time) *Event {
+	for _, e := range GetSchedule(now) {
+		if e.IsActive(now) {
+			return e
+		}
+	}
+	return nil
+}
+
+// GetEventByID 根据活动ID还原活动
+func GetEventByID(eventID string) (*Event, error) {
+	start, err := time.ParseInLocation("20060102-1504", eventID, chinaTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("无效的活动ID: %s", eventID)
+	}
+	for _, slot := range eventSlots {
+		if slot.Hour == start.Hour() && slot.Minute == start.Minute() {
+			return buildEvent(start, slot), nil
+		}
+	}
+	return nil, fmt.Errorf("无效的活动ID: %s", eventID)
+}
+
+// GetLatestEvent 获取最近开始的活动（进行中或已结束）
+func GetLatestEvent(now time.Time) *Event {
+	now = now.In(chinaTimezone)
+	var latest *Event
+	for d := -1; d <= 0; d++ {
+		day := now.AddDate(0, 0, d)
+		for _, slot := range eventSlots {
+			e := buildEvent(day, slot)
+			if !now.Before(e.StartAt) {
+				latest = e
+			}
+		}
+	}
+	return latest
+}
+
+// getRankReward 根据排名获取奖励
+func getRankReward(rank int64) RankReward {
+	for _, r := range rankRewards {
+		if r.MaxRank == 0 || rank <= r.MaxRank {
+			return r
+		}
+	}
+	return rankRewards[len(rankRewards)-1]
+}
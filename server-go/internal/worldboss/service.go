@@ -0,0 +1,433 @@
+package worldboss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"xiuxian/server-go/internal/db"
+	"xiuxian/server-go/internal/dungeon"
+	"xiuxian/server-go/internal/dungeon/battle"
+	"xiuxian/server-go/internal/dungeon/battle/engine"
+	"xiuxian/server-go/internal/models"
+	"xiuxian/server-go/internal/redis"
+)
+
+// Redis 键
+const (
+	hpKeyFormat      = "worldboss:hp:%s"         // 首领剩余血量
+	damageKeyFormat  = "worldboss:damage:%s"     // 伤害排行（有序集合）
+	attacksKeyFormat = "worldboss:attacks:%s:%d" // 玩家已挑战次数
+	lastHitKeyFormat = "worldboss:lasthit:%s"    // 最后一击玩家
+	settledKeyFormat = "worldboss:settled:%s"    // 结算标记
+	rewardsKeyFormat = "worldboss:rewards:%s"    // 结算奖励（哈希：玩家ID -> 奖励JSON）
+)
+
+// damageScript 原子扣减首领血量并记录伤害
+// KEYS[1]=血量键 KEYS[2]=伤害排行键 KEYS[3]=最后一击键
+// ARGV[1]=伤害 ARGV[2]=玩家ID
+// 返回 {实际伤害, 剩余血量}
+var damageScript = redisv9.NewScript(`
+local hp = tonumber(redis.call('GET', KEYS[1]) or '0')
+if hp <= 0 then
+	return {0, hp}
+end
+local dmg = tonumber(ARGV[1])
+if dmg > hp then
+	dmg = hp
+end
+local remain = redis.call('DECRBY', KEYS[1], dmg)
+if dmg > 0 then
+	redis.call('ZINCRBY', KEYS[2], dmg, ARGV[2])
+end
+if remain <= 0 then
+	redis.call('SETNX', KEYS[3], ARGV[2])
+end
+return {dmg, remain}
+`)
+
+// AttackResult 单次挑战结果
+type AttackResult struct {
+	EventID          string   `json:"eventId"`
+	Damage           int64    `json:"damage"`
+	BossHealth       int64    `json:"bossHealth"`
+	BossMaxHealth    int64    `json:"bossMaxHealth"`
+	Rounds           int      `json:"rounds"`
+	PlayerDefeated   bool     `json:"playerDefeated"`
+	IsLastHit        bool     `json:"isLastHit"`
+	AttacksRemaining int      `json:"attacksRemaining"`
+	Logs             []string `json:"logs"`
+}
+
+// DamageEntry 伤害排行条目
+type DamageEntry struct {
+	Rank       int64  `json:"rank"`
+	PlayerID   uint   `json:"playerId"`
+	PlayerName string `json:"playerName"`
+	Damage     int64  `json:"damage"`
+}
+
+// EventStatus 活动状态
+type EventStatus struct {
+	Event            *Event       `json:"event"`
+	Active           bool         `json:"active"`
+	BossHealth       int64        `json:"bossHealth"`
+	BossMaxHealth    int64        `json:"bossMaxHealth"`
+	Killed           bool         `json:"killed"`
+	Settled          bool         `json:"settled"`
+	LastHitPlayerID  uint         `json:"lastHitPlayerId,omitempty"`
+	MyDamage         int64        `json:"myDamage"`
+	MyRank           int64        `json:"myRank"`
+	AttacksRemaining int          `json:"attacksRemaining"`
+	MyReward         *EventReward `json:"myReward,omitempty"`
+}
+
+// EventReward 玩家在活动中获得的奖励
+type EventReward struct {
+	Rank         int64 `json:"rank"`
+	Damage       int64 `json:"damage"`
+	LastHit      bool  `json:"lastHit"`
+	SpiritStones int   `json:"spiritStones"`
+	Cultivation  int   `json:"cultivation"`
+}
+
+// WorldBossService 世界首领服务
+type WorldBossService struct {
+	playerID uint
+}
+
+// NewWorldBossService 创建世界首领服务
+func NewWorldBossService(playerID uint) *WorldBossService {
+	return &WorldBossService{playerID: playerID}
+}
+
+// ensureBossHealth 首次访问时初始化首领血量
+func ensureBossHealth(event *Event) error {
+	key := fmt.Sprintf(hpKeyFormat, event.ID)
+	return redis.Client.SetNX(redis.Ctx, key, event.Boss.MaxHealth, EventDataTTL).Err()
+}
+
+// getBossHealth 获取首领剩余血量（未初始化时视为满血）
+func getBossHealth(event *Event) (int64, error) {
+	key := fmt.Sprintf(hpKeyFormat, event.ID)
+	hp, err := redis.Client.Get(redis.Ctx, key).Int64()
+	if errors.Is(err, redisv9.Nil) {
+		return event.Boss.MaxHealth, nil
+	}
+	return hp, err
+}
+
+// Attack 挑战当前世界首领
+func (s *WorldBossService) Attack() (*AttackResult, error) {
+	now := time.Now()
+	event := GetActiveEvent(now)
+	if event == nil {
+		return nil, fmt.Errorf("当前没有进行中的世界首领活动")
+	}
+
+	if err := ensureBossHealth(event); err != nil {
+		return nil, fmt.Errorf("初始化首领血量失败: %w", err)
+	}
+	bossHealth, err := getBossHealth(event)
+	if err != nil {
+		return nil, fmt.Errorf("获取首领血量失败: %w", err)
+	}
+	if bossHealth <= 0 {
+		return nil, fmt.Errorf("%s已被击败", event.Boss.Name)
+	}
+
+	// 扣除挑战次数（超出时回滚）
+	attacksKey := fmt.Sprintf(attacksKeyFormat, event.ID, s.playerID)
+	used, err := redis.Client.Incr(redis.Ctx, attacksKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("记录挑战次数失败: %w", err)
+	}
+	redis.Client.Expire(redis.Ctx, attacksKey, EventDataTTL)
+	if used > MaxAttacksPerEvent {
+		redis.Client.Decr(redis.Ctx, attacksKey)
+		return nil, fmt.Errorf("本场活动挑战次数已用完")
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, s.playerID).Error; err != nil {
+		redis.Client.Decr(redis.Ctx, attacksKey)
+		return nil, fmt.Errorf("玩家不存在: %w", err)
+	}
+
+	// 限时战斗：首领以当前剩余血量参战，最多进行 MaxRoundsPerAttack 回合
+	playerStats := dungeon.BuildPlayerCombatStats(&user)
+	bossStats := buildBossStats(event.Boss, float64(bossHealth))
+	battleEngine := engine.NewBattleEngine(playerStats, bossStats)
+	rounds := 0
+	for rounds < MaxRoundsPerAttack && !battleEngine.IsFinished() {
+		battleEngine.ExecuteRound()
+		rounds++
+	}
+	_, playerHealth, enemyHealth := battleEngine.GetFinalResult()
+	damage := bossHealth - int64(enemyHealth)
+	if damage < 0 {
+		damage = 0
+	}
+
+	// 原子扣减全局血量
+	keys := []string{
+		fmt.Sprintf(hpKeyFormat, event.ID),
+		fmt.Sprintf(damageKeyFormat, event.ID),
+		fmt.Sprintf(lastHitKeyFormat, event.ID),
+	}
+	res, err := damageScript.Run(redis.Ctx, redis.Client, keys, damage, s.playerID).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("更新首领血量失败: %w", err)
+	}
+	actualDamage, remaining := res[0], res[1]
+	redis.Client.Expire(redis.Ctx, keys[1], EventDataTTL)
+
+	result := &AttackResult{
+		EventID:          event.ID,
+		Damage:           actualDamage,
+		BossHealth:       remaining,
+		BossMaxHealth:    event.Boss.MaxHealth,
+		Rounds:           rounds,
+		PlayerDefeated:   playerHealth <= 0,
+		AttacksRemaining: MaxAttacksPerEvent - int(used),
+		Logs:             battleEngine.GetBattleLog(),
+	}
+
+	if remaining <= 0 && actualDamage > 0 {
+		lastHit, _ := redis.Client.Get(redis.Ctx, keys[2]).Result()
+		result.IsLastHit = lastHit == strconv.FormatUint(uint64(s.playerID), 10)
+		if result.IsLastHit {
+			log.Printf("[WorldBoss] 玩家 %d 击杀首领 %s（活动 %s）", s.playerID, event.Boss.Name, event.ID)
+			if err := SettleEvent(event.ID); err != nil {
+				log.Printf("[WorldBoss] 结算活动 %s 失败: %v", event.ID, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// GetStatus 获取活动状态（无进行中活动时返回最近一场）
+func (s *WorldBossService) GetStatus() (*EventStatus, error) {
+	now := time.Now()
+	event := GetActiveEvent(now)
+	if event == nil {
+		event = GetLatestEvent(now)
+	}
+	if event == nil {
+		return &EventStatus{}, nil
+	}
+
+	bossHealth, err := getBossHealth(event)
+	if err != nil {
+		return nil, fmt.Errorf("获取首领血量失败: %w", err)
+	}
+
+	status := &EventStatus{
+		Event:         event,
+		Active:        event.IsActive(now) && bossHealth > 0,
+		BossHealth:    bossHealth,
+		BossMaxHealth: event.Boss.MaxHealth,
+		Killed:        bossHealth <= 0,
+	}
+
+	settled, _ := redis.Client.Exists(redis.Ctx, fmt.Sprintf(settledKeyFormat, event.ID)).Result()
+	status.Settled = settled > 0
+
+	if lastHit, err := redis.Client.Get(redis.Ctx, fmt.Sprintf(lastHitKeyFormat, event.ID)).Result(); err == nil {
+		if id, err := strconv.ParseUint(lastHit, 10, 64); err == nil {
+			status.LastHitPlayerID = uint(id)
+		}
+	}
+
+	member := strconv.FormatUint(uint64(s.playerID), 10)
+	damageKey := fmt.Sprintf(damageKeyFormat, event.ID)
+	if score, err := redis.Client.ZScore(redis.Ctx, damageKey, member).Result(); err == nil {
+		status.MyDamage = int64(score)
+		if rank, err := redis.Client.ZRevRank(redis.Ctx, damageKey, member).Result(); err == nil {
+			status.MyRank = rank + 1
+		}
+	}
+
+	used, _ := redis.Client.Get(redis.Ctx, fmt.Sprintf(attacksKeyFormat, event.ID, s.playerID)).Int()
+	status.AttacksRemaining = MaxAttacksPerEvent - used
+	if status.AttacksRemaining < 0 {
+		status.AttacksRemaining = 0
+	}
+
+	if data, err := redis.Client.HGet(redis.Ctx, fmt.Sprintf(rewardsKeyFormat, event.ID), member).Result(); err == nil {
+		var reward EventReward
+		if err := json.Unmarshal([]byte(data), &reward); err == nil {
+			status.MyReward = &reward
+		}
+	}
+
+	return status, nil
+}
+
+// GetDamageLeaderboard 获取活动伤害排行
+func GetDamageLeaderboard(eventID string, limit int64) ([]DamageEntry, error) {
+	members, err := redis.Client.ZRevRangeWithScores(redis.Ctx, fmt.Sprintf(damageKeyFormat, eventID), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(members))
+	entries := make([]DamageEntry, 0, len(members))
+	for i, m := range members {
+		memberStr, _ := m.Member.(string)
+		parsed, err := strconv.ParseUint(memberStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint(parsed))
+		entries = append(entries, DamageEntry{
+			Rank:     int64(i + 1),
+			PlayerID: uint(parsed),
+			Damage:   int64(m.Score),
+		})
+	}
+
+	if len(ids) > 0 {
+		var users []models.User
+		if err := db.DB.Select("id, player_name").Where("id IN ?", ids).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		names := make(map[uint]string, len(users))
+		for _, u := range users {
+			names[u.ID] = u.PlayerName
+		}
+		for i := range entries {
+			entries[i].PlayerName = names[entries[i].PlayerID]
+		}
+	}
+
+	return entries, nil
+}
+
+// SettleEvent 按伤害排名发放活动奖励，每场活动只结算一次
+// 每位玩家的发放记录与奖励在同一事务中写入数据库，全部发放成功后才设置结算标记，
+// 失败的玩家会在下次结算时补发
+func SettleEvent(eventID string) error {
+	settledKey := fmt.Sprintf(settledKeyFormat, eventID)
+	settled, err := redis.Client.Exists(redis.Ctx, settledKey).Result()
+	if err != nil {
+		return fmt.Errorf("获取结算标记失败: %w", err)
+	}
+	if settled > 0 {
+		return nil
+	}
+
+	members, err := redis.Client.ZRevRangeWithScores(redis.Ctx, fmt.Sprintf(damageKeyFormat, eventID), 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("获取伤害排行失败: %w", err)
+	}
+
+	lastHit, _ := redis.Client.Get(redis.Ctx, fmt.Sprintf(lastHitKeyFormat, eventID)).Result()
+	rewardsKey := fmt.Sprintf(rewardsKeyFormat, eventID)
+
+	failed := 0
+	for i, m := range members {
+		memberStr, _ := m.Member.(string)
+		playerID, err := strconv.ParseUint(memberStr, 10, 64)
+		if err != nil || m.Score <= 0 {
+			continue
+		}
+
+		rank := int64(i + 1)
+		tier := getRankReward(rank)
+		reward := EventReward{
+			Rank:         rank,
+			Damage:       int64(m.Score),
+			SpiritStones: tier.SpiritStones,
+			Cultivation:  tier.Cultivation,
+		}
+		if memberStr == lastHit {
+			reward.LastHit = true
+			reward.SpiritStones += lastHitReward.SpiritStones
+			reward.Cultivation += lastHitReward.Cultivation
+		}
+
+		if err := grantEventReward(eventID, uint(playerID), &reward); err != nil {
+			log.Printf("[WorldBoss] 发放玩家 %d 奖励失败: %v", playerID, err)
+			failed++
+			continue
+		}
+		if data, err := json.Marshal(reward); err == nil {
+			redis.Client.HSet(redis.Ctx, rewardsKey, memberStr, string(data))
+		}
+	}
+	redis.Client.Expire(redis.Ctx, rewardsKey, EventDataTTL)
+
+	if failed > 0 {
+		return fmt.Errorf("活动 %s 有 %d 名玩家奖励发放失败，等待下次结算补发", eventID, failed)
+	}
+	if err := redis.Client.Set(redis.Ctx, settledKey, time.Now().Unix(), EventDataTTL).Err(); err != nil {
+		return fmt.Errorf("设置结算标记失败: %w", err)
+	}
+
+	log.Printf("[WorldBoss] 活动 %s 结算完成，参与人数: %d", eventID, len(members))
+	return nil
+}
+
+// SettleEndedEvents 结算所有已结束但未结算的活动（供后台任务调用）
+func SettleEndedEvents() {
+	now := time.Now().In(chinaTimezone)
+	for d := -1; d <= 0; d++ {
+		day := now.AddDate(0, 0, d)
+		for _, slot := range eventSlots {
+			event := buildEvent(day, slot)
+			if now.Before(event.EndAt) {
+				continue
+			}
+			if err := SettleEvent(event.ID); err != nil {
+				log.Printf("[WorldBoss] 结算活动 %s 失败: %v", event.ID, err)
+			}
+		}
+	}
+}
+
+// grantEventReward 在同一事务中写入发放记录并发放灵石与修为奖励（修为不超过上限），
+// 已有发放记录时跳过，避免重复结算时重复发放
+func grantEventReward(eventID string, playerID uint, reward *EventReward) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		record := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WorldBossReward{
+			EventID:      eventID,
+			UserID:       playerID,
+			Rank:         reward.Rank,
+			Damage:       reward.Damage,
+			LastHit:      reward.LastHit,
+			SpiritStones: reward.SpiritStones,
+			Cultivation:  reward.Cultivation,
+			CreatedAt:    time.Now(),
+		})
+		if record.Error != nil {
+			return fmt.Errorf("记录活动奖励失败: %w", record.Error)
+		}
+		if record.RowsAffected == 0 {
+			return nil
+		}
+
+		return tx.Model(&models.User{}).Where("id = ?", playerID).Updates(map[string]interface{}{
+			"spirit_stones": gorm.Expr("spirit_stones + ?", reward.SpiritStones),
+			"cultivation":   gorm.Expr("LEAST(cultivation + ?, max_cultivation)", reward.Cultivation),
+		}).Error
+	})
+}
+
+// buildBossStats 构建首领战斗属性
+func buildBossStats(boss BossConfig, health float64) *battle.CombatStats {
+	return battle.ToCombatStats(
+		health, boss.Attack, boss.Defense, boss.Speed,
+		boss.CritRate, 0, 0, 0, 0, 0,
+		0, 0, 0, boss.StunResist, 0, 0,
+		0, 0, 0, 0, 0, 0, 0,
+	)
+}
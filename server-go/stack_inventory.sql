@@ -0,0 +1,59 @@
+-- 创建迁移文件：stack_inventory.sql
+-- 这个脚本应该在生产环境中执行，将丹药、灵草、丹方残页合并为按数量堆叠的记录
+
+BEGIN;
+
+-- 丹药增加堆叠数量，原来每条记录即一枚丹药
+ALTER TABLE "pills" ADD COLUMN IF NOT EXISTS count INTEGER DEFAULT 1;
+UPDATE "pills" SET count = 1 WHERE count IS NULL;
+
+-- 品质列可能尚未添加（add_quality_to_pills.sql / add_quality_to_herbs.sql），先确保存在
+ALTER TABLE "pills" ADD COLUMN IF NOT EXISTS quality VARCHAR(50) DEFAULT 'common';
+ALTER TABLE "herbs" ADD COLUMN IF NOT EXISTS quality VARCHAR(50) DEFAULT 'common';
+
+-- 统一空品质，避免同一丹药/灵草因品质为空而无法合并
+UPDATE "pills" SET quality = 'common' WHERE quality IS NULL OR quality = '';
+UPDATE "herbs" SET quality = 'common' WHERE quality IS NULL OR quality = '';
+
+-- 丹药：按 (user_id, pill_id, quality) 合并到 id 最小的记录
+UPDATE "pills" p SET count = s.total
+FROM (
+    SELECT MIN(id) AS keep_id, SUM(count) AS total
+    FROM "pills"
+    GROUP BY user_id, pill_id, quality
+) s
+WHERE p.id = s.keep_id;
+DELETE FROM "pills" p
+USING "pills" keep
+WHERE p.user_id = keep.user_id AND p.pill_id = keep.pill_id AND p.quality = keep.quality AND p.id > keep.id;
+
+-- 灵草：按 (user_id, herb_id, quality) 合并
+UPDATE "herbs" h SET count = s.total
+FROM (
+    SELECT MIN(id) AS keep_id, SUM(COALESCE(count, 0)) AS total
+    FROM "herbs"
+    GROUP BY user_id, herb_id, quality
+) s
+WHERE h.id = s.keep_id;
+DELETE FROM "herbs" h
+USING "herbs" keep
+WHERE h.user_id = keep.user_id AND h.herb_id = keep.herb_id AND h.quality = keep.quality AND h.id > keep.id;
+
+-- 丹方残页：按 (user_id, recipe_id) 合并
+UPDATE "pill_fragments" f SET count = s.total
+FROM (
+    SELECT MIN(id) AS keep_id, SUM(COALESCE(count, 0)) AS total
+    FROM "pill_fragments"
+    GROUP BY user_id, recipe_id
+) s
+WHERE f.id = s.keep_id;
+DELETE FROM "pill_fragments" f
+USING "pill_fragments" keep
+WHERE f.user_id = keep.user_id AND f.recipe_id = keep.recipe_id AND f.id > keep.id;
+
+-- 添加堆叠唯一约束，供写入时按堆叠累加
+ALTER TABLE "pills" ADD CONSTRAINT pills_user_id_pill_id_quality_key UNIQUE (user_id, pill_id, quality);
+ALTER TABLE "herbs" ADD CONSTRAINT herbs_user_id_herb_id_quality_key UNIQUE (user_id, herb_id, quality);
+ALTER TABLE "pill_fragments" ADD CONSTRAINT pill_fragments_user_id_recipe_id_key UNIQUE (user_id, recipe_id);
+
+COMMIT;
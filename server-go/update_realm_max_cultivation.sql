@@ -0,0 +1,8 @@
+-- 创建迁移文件：update_realm_max_cultivation.sql
+-- 这个脚本应该在生产环境中执行以同步境界表调整后的修为上限
+-- 练气期八层上限 5099 -> 5059；散仙期上限改为 910000 ~ 990000，保证境界表单调递增
+
+UPDATE "users" SET max_cultivation = 5059, cultivation = LEAST(cultivation, 5059) WHERE level = 8;
+UPDATE "users" SET max_cultivation = 910000 + (level - 82) * 10000,
+    cultivation = LEAST(cultivation, 910000 + (level - 82) * 10000)
+WHERE level BETWEEN 82 AND 90;